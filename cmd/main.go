@@ -1,100 +1,664 @@
+// Команда wallet - администрирование кошелька из командной строки.
+//
+// Состояние хранится в каталоге дампов (-dir) в формате -dump-format:
+// перед командой оно загружается через Service.Import, после изменяющей
+// команды сохраняется через Service.Export. Суммы задаются в валюте аккаунта вида "12.34",
+// если валюта не указана отдельным аргументом.
+//
+//	wallet [-dir DIR] [-dump-format dump|json|jsonl] [-format table|json] COMMAND ARGS...
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+	"unicode/utf8"
 
+	"github.com/akmalsulaymonov/alif-wallet/pkg/types"
 	"github.com/akmalsulaymonov/alif-wallet/pkg/wallet"
 )
 
+const usage = `usage: wallet [-dir DIR] [-dump-format dump|json|jsonl] [-format table|json] COMMAND ARGS...
+
+commands:
+  account register PHONE [CURRENCY]
+  account show ACCOUNT
+  deposit ACCOUNT AMOUNT [CURRENCY]
+  pay ACCOUNT AMOUNT CATEGORY [CURRENCY]
+  reject PAYMENT
+  confirm PAYMENT
+  refund PAYMENT [AMOUNT [REASON]]
+  refunds PAYMENT
+  expire TIMEOUT
+  hold authorize ACCOUNT AMOUNT CATEGORY [TTL]
+  hold capture HOLD AMOUNT
+  hold void HOLD
+  hold expire
+  hold list ACCOUNT
+  repeat PAYMENT
+  favorite add PAYMENT NAME
+  favorite pay FAVORITE
+  favorite list ACCOUNT
+  favorite rename FAVORITE NAME
+  favorite update FAVORITE AMOUNT [CATEGORY]
+  favorite delete FAVORITE
+  favorite reorder ACCOUNT FAVORITE...
+  schedule add FAVORITE ONCE|DAILY|WEEKLY|MONTHLY [START]
+  schedule add FAVORITE CRON EXPR
+  schedule cancel SCHEDULE
+  schedule list ACCOUNT
+  schedule runs SCHEDULE
+  schedule run
+  history ACCOUNT
+  statement [-locale LOCALE] [-delimiter CHAR] [-bom] ACCOUNT
+  export [-dump-format FORMAT] DIR
+  import [-dump-format FORMAT] [-mode upsert|skip-existing|fail-on-conflict|replace] [-dry-run] DIR
+`
+
+var errUsage = errors.New("invalid arguments")
+
 func main() {
+	err := run(os.Args[1:], os.Stdout, os.Stderr)
+	if errors.Is(err, errUsage) {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "wallet:", err)
+		os.Exit(1)
+	}
+}
+
+// command - подкоманда. Если modifies, состояние сохраняется после выполнения.
+// maxArgs < 0 - число аргументов не ограничено.
+type command struct {
+	minArgs  int
+	maxArgs  int
+	modifies bool
+	run      func(c *cli, args []string) error
+}
+
+var commands = map[string]command{
+	"account register": {1, 2, true, (*cli).registerAccount},
+	"account show":     {1, 1, false, (*cli).showAccount},
+	"deposit":          {2, 3, true, (*cli).deposit},
+	"pay":              {3, 4, true, (*cli).pay},
+	"reject":           {1, 1, true, (*cli).reject},
+	"confirm":          {1, 1, true, (*cli).confirm},
+	"refund":           {1, 3, true, (*cli).refund},
+	"refunds":          {1, 1, false, (*cli).listRefunds},
+	"repeat":           {1, 1, true, (*cli).repeat},
+	"expire":           {1, 1, true, (*cli).expire},
+	"hold authorize":   {3, 4, true, (*cli).authorize},
+	"hold capture":     {2, 2, true, (*cli).capture},
+	"hold void":        {1, 1, true, (*cli).void},
+	"hold expire":      {0, 0, true, (*cli).expireHolds},
+	"hold list":        {1, 1, false, (*cli).listHolds},
+	"favorite add":     {2, 2, true, (*cli).addFavorite},
+	"favorite pay":     {1, 1, true, (*cli).payFromFavorite},
+	"favorite list":    {1, 1, false, (*cli).listFavorites},
+	"favorite rename":  {2, 2, true, (*cli).renameFavorite},
+	"favorite update":  {2, 3, true, (*cli).updateFavorite},
+	"favorite delete":  {1, 1, true, (*cli).deleteFavorite},
+	"favorite reorder": {1, -1, true, (*cli).reorderFavorites},
+	"schedule add":     {2, 3, true, (*cli).addSchedule},
+	"schedule cancel":  {1, 1, true, (*cli).cancelSchedule},
+	"schedule list":    {1, 1, false, (*cli).listSchedules},
+	"schedule runs":    {1, 1, false, (*cli).listScheduleRuns},
+	"schedule run":     {0, 0, true, (*cli).runSchedules},
+	"history":          {1, 1, false, (*cli).history},
+	"statement":        {1, 6, false, (*cli).statement},
+	"export":           {1, 3, false, (*cli).export},
+	"import":           {1, 6, true, (*cli).importDir},
+}
+
+// lookup находит подкоманду из одного или двух слов.
+func lookup(args []string) (command, []string, bool) {
+	if len(args) >= 2 {
+		if cmd, ok := commands[args[0]+" "+args[1]]; ok {
+			return cmd, args[2:], true
+		}
+	}
+	if len(args) >= 1 {
+		if cmd, ok := commands[args[0]]; ok {
+			return cmd, args[1:], true
+		}
+	}
+	return command{}, nil, false
+}
+
+type cli struct {
+	svc    *wallet.Service
+	out    printer
+	format wallet.FileFormat // формат файлов по умолчанию
+}
+
+func run(args []string, stdout, stderr io.Writer) error {
+	flags := flag.NewFlagSet("wallet", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() { fmt.Fprint(stderr, usage) }
+	dir := flags.String("dir", ".", "каталог с дампами кошелька")
+	dumpFormat := flags.String("dump-format", wallet.FormatDump.String(), "формат файлов состояния: dump, json или jsonl")
+	format := flags.String("format", "table", "формат вывода: table или json")
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return errUsage
+	}
+	if *format != "table" && *format != "json" {
+		return errUsage
+	}
+	fileFormat, err := wallet.ParseFileFormat(*dumpFormat)
+	if err != nil {
+		return err
+	}
+
+	cmd, cmdArgs, ok := lookup(flags.Args())
+	if !ok || len(cmdArgs) < cmd.minArgs || cmd.maxArgs >= 0 && len(cmdArgs) > cmd.maxArgs {
+		return errUsage
+	}
+
 	svc := &wallet.Service{}
+	if err := os.MkdirAll(*dir, 0755); err != nil {
+		return err
+	}
+	if _, err := svc.ImportWithOptions(*dir, wallet.ImportOptions{Format: fileFormat}); err != nil {
+		return fmt.Errorf("load %s: %w", *dir, err)
+	}
+
+	c := &cli{svc: svc, out: printer{w: stdout, json: *format == "json"}, format: fileFormat}
+	if err := cmd.run(c, cmdArgs); err != nil {
+		return err
+	}
+	if cmd.modifies {
+		return svc.ExportWithOptions(*dir, wallet.ExportOptions{Format: fileFormat})
+	}
+	return nil
+}
+
+func (c *cli) registerAccount(args []string) error {
+	currency := types.DefaultCurrency
+	if len(args) > 1 {
+		currency = types.Currency(args[1])
+	}
+
+	account, err := c.svc.RegisterAccountWithCurrency(types.Phone(args[0]), currency)
+	if err != nil {
+		return err
+	}
+	return c.showAccountByID(account.ID)
+}
+
+func (c *cli) showAccount(args []string) error {
+	accountID, err := parseID(args[0])
+	if err != nil {
+		return err
+	}
+	return c.showAccountByID(accountID)
+}
+
+func (c *cli) showAccountByID(accountID int64) error {
+	account, err := c.svc.FindAccountByID(accountID)
+	if err != nil {
+		return err
+	}
+	return c.out.accounts(*account)
+}
+
+// amount разбирает сумму в валюте currency или, если она не задана, в валюте аккаунта.
+func (c *cli) amount(accountID int64, value string, currency []string) (types.Amount, error) {
+	var amount types.Amount
+	if len(currency) > 0 {
+		amount.Currency = types.Currency(currency[0])
+	} else {
+		account, err := c.svc.FindAccountByID(accountID)
+		if err != nil {
+			return amount, err
+		}
+		amount.Currency = account.Currency
+	}
+
+	val, err := amount.Currency.Parse(value)
+	if err != nil {
+		return amount, err
+	}
+	amount.Value = val
+	return amount, nil
+}
+
+func (c *cli) deposit(args []string) error {
+	accountID, err := parseID(args[0])
+	if err != nil {
+		return err
+	}
+	amount, err := c.amount(accountID, args[1], args[2:])
+	if err != nil {
+		return err
+	}
+
+	if err := c.svc.DepositAmount(accountID, amount); err != nil {
+		return err
+	}
+	return c.showAccountByID(accountID)
+}
 
-	// Register account
-	account, err := svc.RegisterAccount("+992918246924")
+func (c *cli) pay(args []string) error {
+	accountID, err := parseID(args[0])
+	if err != nil {
+		return err
+	}
+	amount, err := c.amount(accountID, args[1], args[3:])
 	if err != nil {
-		fmt.Println(err)
-		return
+		return err
 	}
-	fmt.Println("Аккаунт пользователя", account)
 
-	// Deposit to account
-	err = svc.Deposit(account.ID, 10)
+	payment, err := c.svc.PayAmount(accountID, amount, types.PaymentCategory(args[2]))
 	if err != nil {
-		switch err {
-		case wallet.ErrAmountMustBePositive:
-			fmt.Println("Сумма дожлна быть положительной")
-		case wallet.ErrAccountNotFound:
-			fmt.Println("Аккаунт пользователя не найден")
+		return err
+	}
+	return c.showPayment(payment.ID)
+}
+
+func (c *cli) reject(args []string) error {
+	if err := c.svc.Reject(args[0]); err != nil {
+		return err
+	}
+	return c.showPayment(args[0])
+}
+
+func (c *cli) confirm(args []string) error {
+	if err := c.svc.Confirm(args[0]); err != nil {
+		return err
+	}
+	return c.showPayment(args[0])
+}
+
+// refund возвращает AMOUNT в валюте платежа или, без AMOUNT, остаток
+// платежа и выводит созданный возврат.
+func (c *cli) refund(args []string) error {
+	if len(args) == 1 {
+		if err := c.svc.Refund(args[0]); err != nil {
+			return err
+		}
+		refunds, err := c.svc.PaymentRefunds(args[0])
+		if err != nil {
+			return err
 		}
-		return
+		return c.out.refunds(refunds[len(refunds)-1])
+	}
+
+	payment, err := c.svc.FindPaymentByID(args[0])
+	if err != nil {
+		return err
+	}
+	amount, err := payment.Currency.Parse(args[1])
+	if err != nil {
+		return err
+	}
+	reason := ""
+	if len(args) > 2 {
+		reason = args[2]
 	}
 
-	fmt.Println("account after deposit:", account)
+	refund, err := c.svc.RefundAmount(payment.ID, amount, reason)
+	if err != nil {
+		return err
+	}
+	return c.out.refunds(*refund)
+}
+
+func (c *cli) listRefunds(args []string) error {
+	refunds, err := c.svc.PaymentRefunds(args[0])
+	if err != nil {
+		return err
+	}
+	return c.out.refunds(refunds...)
+}
 
-	// Make a payment
-	payment, err := svc.Pay(account.ID, 5, "food")
+// expire переводит в EXPIRED платежи, находящиеся в обработке дольше
+// TIMEOUT (например, "30m" или "24h"), и выводит их.
+func (c *cli) expire(args []string) error {
+	timeout, err := time.ParseDuration(args[0])
 	if err != nil {
-		switch err {
-		case wallet.ErrAmountMustBePositive:
-			fmt.Println("Сумма дожлна быть положительной")
-		case wallet.ErrAccountNotFound:
-			fmt.Println("Аккаунт пользователя не найден")
-		case wallet.ErrNotEnoughBalance:
-			fmt.Println("Недостаточно баланса")
+		return fmt.Errorf("invalid timeout %q", args[0])
+	}
+	report, err := c.svc.ExpirePayments(timeout)
+	if err != nil {
+		return err
+	}
+	return c.out.payments(report.Expired...)
+}
+
+// authorize блокирует сумму на аккаунте на срок TTL (например, "2h"),
+// по умолчанию wallet.DefaultHoldTTL.
+func (c *cli) authorize(args []string) error {
+	accountID, err := parseID(args[0])
+	if err != nil {
+		return err
+	}
+	amount, err := c.amount(accountID, args[1], nil)
+	if err != nil {
+		return err
+	}
+	var ttl time.Duration
+	if len(args) > 3 {
+		if ttl, err = time.ParseDuration(args[3]); err != nil || ttl <= 0 {
+			return fmt.Errorf("invalid ttl %q", args[3])
 		}
-		return
 	}
 
-	fmt.Println("Payment:", payment)
-	fmt.Println("balance after payment:", account.Balance)
+	hold, err := c.svc.Authorize(accountID, amount.Value, types.PaymentCategory(args[2]), ttl)
+	if err != nil {
+		return err
+	}
+	return c.out.holds(*hold)
+}
 
-	// Find account by ID
-	account, err = svc.FindAccountByID(1)
+func (c *cli) capture(args []string) error {
+	hold, err := c.svc.FindHoldByID(args[0])
+	if err != nil {
+		return err
+	}
+	amount, err := hold.Currency.Parse(args[1])
 	if err != nil {
-		fmt.Println(err)
-		return
+		return err
 	}
-	fmt.Println("Account:", account)
 
-	// Find payment by ID
-	payment, err = svc.FindPaymentByID(payment.ID)
+	payment, err := c.svc.Capture(hold.ID, amount)
 	if err != nil {
-		fmt.Println(err)
-		return
+		return err
 	}
-	fmt.Println("Payment:", payment)
+	return c.showPayment(payment.ID)
+}
 
-	// reject payment
-	err = svc.Reject(payment.ID)
+func (c *cli) void(args []string) error {
+	if err := c.svc.Void(args[0]); err != nil {
+		return err
+	}
+	hold, err := c.svc.FindHoldByID(args[0])
 	if err != nil {
-		fmt.Println(err)
-		return
+		return err
 	}
-	fmt.Println("Account after rejection:", account)
-	fmt.Println("Payment after rejection:", payment)
+	return c.out.holds(*hold)
+}
 
-	// repeat a paeyment
-	pt, err := svc.Repeat(payment.ID)
+func (c *cli) expireHolds(args []string) error {
+	expired, err := c.svc.ExpireHolds()
 	if err != nil {
-		fmt.Println(err)
-		return
+		return err
 	}
-	fmt.Println("Repeated payment:", pt)
+	return c.out.holds(expired...)
+}
 
-	// favorites
-	favorite, err := svc.FavoritePayment(payment.ID, "FooD")
+func (c *cli) listHolds(args []string) error {
+	accountID, err := parseID(args[0])
 	if err != nil {
-		fmt.Println(err)
-		return
+		return err
 	}
-	fmt.Println("Favorite added:", favorite)
 
-	// pay from favorite
-	pf, err := svc.PayFromFavorite(favorite.ID)
+	holds, err := c.svc.AccountHolds(accountID)
 	if err != nil {
-		fmt.Println(err)
-		return
+		return err
 	}
-	fmt.Println("Paid from Favorite:", pf)
+	return c.out.holds(holds...)
+}
 
+func (c *cli) repeat(args []string) error {
+	payment, err := c.svc.Repeat(args[0])
+	if err != nil {
+		return err
+	}
+	return c.showPayment(payment.ID)
+}
+
+func (c *cli) showPayment(paymentID string) error {
+	payment, err := c.svc.FindPaymentByID(paymentID)
+	if err != nil {
+		return err
+	}
+	return c.out.payments(*payment)
+}
+
+func (c *cli) addFavorite(args []string) error {
+	favorite, err := c.svc.FavoritePayment(args[0], args[1])
+	if err != nil {
+		return err
+	}
+	return c.out.favorites(*favorite)
+}
+
+func (c *cli) payFromFavorite(args []string) error {
+	payment, err := c.svc.PayFromFavorite(args[0])
+	if err != nil {
+		return err
+	}
+	return c.showPayment(payment.ID)
+}
+
+func (c *cli) listFavorites(args []string) error {
+	accountID, err := parseID(args[0])
+	if err != nil {
+		return err
+	}
+
+	favorites, err := c.svc.AccountFavorites(accountID)
+	if err != nil {
+		return err
+	}
+	return c.out.favorites(favorites...)
+}
+
+func (c *cli) renameFavorite(args []string) error {
+	favorite, err := c.svc.RenameFavorite(args[0], args[1])
+	if err != nil {
+		return err
+	}
+	return c.out.favorites(*favorite)
+}
+
+// updateFavorite меняет сумму избранного (в его валюте) и, если задана, категорию.
+func (c *cli) updateFavorite(args []string) error {
+	favorite, err := c.svc.FindFavoriteByID(args[0])
+	if err != nil {
+		return err
+	}
+	amount, err := favorite.Currency.Parse(args[1])
+	if err != nil {
+		return err
+	}
+	changes := wallet.FavoriteChanges{Amount: &amount}
+	if len(args) > 2 {
+		category := types.PaymentCategory(args[2])
+		changes.Category = &category
+	}
+
+	updated, err := c.svc.UpdateFavorite(favorite.ID, changes)
+	if err != nil {
+		return err
+	}
+	return c.out.favorites(*updated)
+}
+
+func (c *cli) deleteFavorite(args []string) error {
+	return c.svc.DeleteFavorite(args[0])
+}
+
+// reorderFavorites задаёт порядок избранного аккаунта: перечисляются
+// все его избранные платежи в новом порядке.
+func (c *cli) reorderFavorites(args []string) error {
+	accountID, err := parseID(args[0])
+	if err != nil {
+		return err
+	}
+
+	favorites, err := c.svc.ReorderFavorites(accountID, args[1:])
+	if err != nil {
+		return err
+	}
+	return c.out.favorites(favorites...)
+}
+
+// addSchedule создаёт расписание платежей по избранному. START - время
+// первого платежа в RFC 3339, по умолчанию сейчас; для CRON вместо него
+// выражение cron одним аргументом, например "0 9 5 * *".
+func (c *cli) addSchedule(args []string) error {
+	kind := types.ScheduleKind(args[1])
+	var start time.Time
+	cron := ""
+	if len(args) > 2 {
+		if kind == types.ScheduleCron {
+			cron = args[2]
+		} else {
+			var err error
+			if start, err = time.Parse(time.RFC3339, args[2]); err != nil {
+				return fmt.Errorf("invalid start %q", args[2])
+			}
+		}
+	}
+
+	schedule, err := c.svc.ScheduleFavorite(args[0], kind, start, cron)
+	if err != nil {
+		return err
+	}
+	return c.out.schedules(*schedule)
+}
+
+func (c *cli) cancelSchedule(args []string) error {
+	if err := c.svc.CancelSchedule(args[0]); err != nil {
+		return err
+	}
+	schedule, err := c.svc.FindScheduleByID(args[0])
+	if err != nil {
+		return err
+	}
+	return c.out.schedules(*schedule)
+}
+
+func (c *cli) listSchedules(args []string) error {
+	accountID, err := parseID(args[0])
+	if err != nil {
+		return err
+	}
+
+	schedules, err := c.svc.AccountSchedules(accountID)
+	if err != nil {
+		return err
+	}
+	return c.out.schedules(schedules...)
+}
+
+func (c *cli) listScheduleRuns(args []string) error {
+	runs, err := c.svc.ScheduleRuns(args[0])
+	if err != nil {
+		return err
+	}
+	return c.out.scheduleRuns(runs...)
+}
+
+// runSchedules выполняет платежи по расписаниям, время которых наступило,
+// и выводит результаты. Команду можно запускать из системного cron.
+func (c *cli) runSchedules(args []string) error {
+	runs, err := c.svc.RunSchedules(wallet.DefaultRetryPolicy)
+	if err != nil {
+		return err
+	}
+	return c.out.scheduleRuns(runs...)
+}
+
+func (c *cli) history(args []string) error {
+	accountID, err := parseID(args[0])
+	if err != nil {
+		return err
+	}
+
+	history, err := c.svc.ExportAccountHistory(accountID)
+	if err != nil {
+		return err
+	}
+	return c.out.payments(history...)
+}
+
+// statement выводит выписку в CSV независимо от -format.
+func (c *cli) statement(args []string) error {
+	flags := flag.NewFlagSet("statement", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	locale := flags.String("locale", "", "язык выписки, например ru-RU")
+	delimiter := flags.String("delimiter", "", "разделитель полей вместо разделителя локали")
+	bom := flags.Bool("bom", false, "добавить метку UTF-8 для Excel")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 1 || utf8.RuneCountInString(*delimiter) > 1 {
+		return errUsage
+	}
+	accountID, err := parseID(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	statement, err := c.svc.Statement(accountID)
+	if err != nil {
+		return err
+	}
+	options := wallet.StatementOptions{Locale: *locale, BOM: *bom}
+	if *delimiter != "" {
+		options.Comma, _ = utf8.DecodeRuneInString(*delimiter)
+	}
+	return statement.WriteCSV(c.out.w, options)
+}
+
+func (c *cli) export(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	format := flags.String("dump-format", c.format.String(), "формат файлов")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 1 {
+		return errUsage
+	}
+	fileFormat, err := wallet.ParseFileFormat(*format)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(flags.Arg(0), 0755); err != nil {
+		return err
+	}
+	return c.svc.ExportWithOptions(flags.Arg(0), wallet.ExportOptions{Format: fileFormat})
+}
+
+// importDir загружает дампы в выбранном режиме и выводит, что сделано
+// с каждой записью. С -dry-run состояние не меняется.
+func (c *cli) importDir(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	format := flags.String("dump-format", c.format.String(), "формат файлов")
+	mode := flags.String("mode", wallet.ImportUpsert.String(), "режим слияния с текущим состоянием")
+	dryRun := flags.Bool("dry-run", false, "только показать изменения")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 1 {
+		return errUsage
+	}
+	fileFormat, err := wallet.ParseFileFormat(*format)
+	if err != nil {
+		return err
+	}
+	importMode, err := wallet.ParseImportMode(*mode)
+	if err != nil {
+		return err
+	}
+
+	options := wallet.ImportOptions{Mode: importMode, Format: fileFormat, DryRun: *dryRun}
+	report, err := c.svc.ImportWithOptions(flags.Arg(0), options)
+	if err != nil {
+		return err
+	}
+	return c.out.importChanges(report.Changes...)
+}
+
+func parseID(s string) (int64, error) {
+	id, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid account id %q", s)
+	}
+	return id, nil
 }
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/akmalsulaymonov/alif-wallet/pkg/wallet"
+)
+
+// walletCmd выполняет команду над каталогом dir и возвращает её вывод.
+func walletCmd(t *testing.T, dir string, args ...string) (string, error) {
+	t.Helper()
+	var stdout bytes.Buffer
+	err := run(append([]string{"-dir", dir}, args...), &stdout, io.Discard)
+	return stdout.String(), err
+}
+
+// walletJSON выполняет команду с выводом в JSON и декодирует результат в out.
+func walletJSON(t *testing.T, dir string, out interface{}, args ...string) {
+	t.Helper()
+	output, err := walletCmd(t, dir, append([]string{"-format", "json"}, args...)...)
+	if err != nil {
+		t.Fatalf("wallet %v: %v", args, err)
+	}
+	if err := json.Unmarshal([]byte(output), out); err != nil {
+		t.Fatalf("failed to decode output %q: %v", output, err)
+	}
+}
+
+func TestRun_StatePersistsBetweenCommands(t *testing.T) {
+	dir := t.TempDir()
+
+	var accounts []accountView
+	walletJSON(t, dir, &accounts, "account", "register", "+992000000001")
+	if len(accounts) != 1 || accounts[0].ID != 1 {
+		t.Fatalf("unexpected accounts %+v", accounts)
+	}
+
+	walletJSON(t, dir, &accounts, "deposit", "1", "10.50")
+	if accounts[0].Balance != 1050 {
+		t.Errorf("expected balance 1050, got %v", accounts[0].Balance)
+	}
+
+	var payments []paymentView
+	walletJSON(t, dir, &payments, "pay", "1", "3", "food")
+	if len(payments) != 1 || payments[0].Amount != 300 || payments[0].Category != "food" {
+		t.Fatalf("unexpected payments %+v", payments)
+	}
+	paymentID := payments[0].ID
+
+	var favorites []favoriteView
+	walletJSON(t, dir, &favorites, "favorite", "add", paymentID, "обед")
+	walletJSON(t, dir, &payments, "favorite", "pay", favorites[0].ID)
+	walletJSON(t, dir, &payments, "reject", paymentID)
+	if payments[0].Status != "FAIL" {
+		t.Errorf("expected status FAIL, got %v", payments[0].Status)
+	}
+
+	walletJSON(t, dir, &favorites, "favorite", "list", "1")
+	if len(favorites) != 1 || favorites[0].Name != "обед" {
+		t.Errorf("unexpected favorites %+v", favorites)
+	}
+	walletJSON(t, dir, &payments, "history", "1")
+	if len(payments) != 2 {
+		t.Errorf("expected 2 payments in history, got %d", len(payments))
+	}
+	walletJSON(t, dir, &accounts, "account", "show", "1")
+	if accounts[0].Balance != 750 {
+		t.Errorf("expected balance 750, got %v", accounts[0].Balance)
+	}
+}
+
+func TestRun_ExportImport(t *testing.T) {
+	source := t.TempDir()
+	target := t.TempDir()
+	backup := t.TempDir()
+
+	walletCmd(t, source, "account", "register", "+992000000001")
+	walletCmd(t, source, "deposit", "1", "5")
+	if _, err := walletCmd(t, source, "export", backup); err != nil {
+		t.Fatalf("failed to export: %v", err)
+	}
+	if _, err := walletCmd(t, target, "import", backup); err != nil {
+		t.Fatalf("failed to import: %v", err)
+	}
+
+	var accounts []accountView
+	walletJSON(t, target, &accounts, "account", "show", "1")
+	if accounts[0].Balance != 500 {
+		t.Errorf("expected balance 500, got %v", accounts[0].Balance)
+	}
+}
+
+func TestRun_ExportImport_JSONL(t *testing.T) {
+	source := t.TempDir()
+	target := t.TempDir()
+	backup := t.TempDir()
+
+	walletCmd(t, source, "-dump-format", "json", "account", "register", "+992000000001")
+	walletCmd(t, source, "-dump-format", "json", "deposit", "1", "5")
+	if _, err := walletCmd(t, source, "-dump-format", "json", "export", "-dump-format", "jsonl", backup); err != nil {
+		t.Fatalf("failed to export: %v", err)
+	}
+	if _, err := walletCmd(t, target, "import", "-dump-format", "jsonl", backup); err != nil {
+		t.Fatalf("failed to import: %v", err)
+	}
+
+	var accounts []accountView
+	walletJSON(t, target, &accounts, "account", "show", "1")
+	if accounts[0].Balance != 500 {
+		t.Errorf("expected balance 500, got %v", accounts[0].Balance)
+	}
+}
+
+func TestRun_ImportDryRun(t *testing.T) {
+	source := t.TempDir()
+	target := t.TempDir()
+	backup := t.TempDir()
+
+	walletCmd(t, source, "account", "register", "+992000000001")
+	walletCmd(t, source, "deposit", "1", "5")
+	walletCmd(t, source, "export", backup)
+	walletCmd(t, target, "account", "register", "+992000000001")
+
+	var changes []importChangeView
+	walletJSON(t, target, &changes, "import", "-mode", "replace", "-dry-run", backup)
+	if len(changes) != 1 || changes[0].Kind != "accounts" || changes[0].Action != wallet.ImportUpdate {
+		t.Fatalf("unexpected changes %+v", changes)
+	}
+
+	var accounts []accountView
+	walletJSON(t, target, &accounts, "account", "show", "1")
+	if accounts[0].Balance != 0 {
+		t.Errorf("dry run changed balance to %v", accounts[0].Balance)
+	}
+
+	if _, err := walletCmd(t, target, "import", "-mode", "merge", backup); !errors.Is(err, wallet.ErrInvalidImportMode) {
+		t.Errorf("expected error %v, got %v", wallet.ErrInvalidImportMode, err)
+	}
+}
+
+func TestRun_Statement(t *testing.T) {
+	dir := t.TempDir()
+	walletCmd(t, dir, "account", "register", "+992000000001")
+	walletCmd(t, dir, "deposit", "1", "10")
+	walletCmd(t, dir, "pay", "1", "2.5", "food")
+
+	output, err := walletCmd(t, dir, "-format", "json", "statement", "-locale", "ru", "1")
+	if err != nil {
+		t.Fatalf("failed to build statement: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	if len(lines) != 4 || lines[3] != "Closing balance;;;;TJS;7,50" {
+		t.Errorf("unexpected statement %q", output)
+	}
+}
+
+func TestRun_Expire(t *testing.T) {
+	dir := t.TempDir()
+	walletCmd(t, dir, "account", "register", "+992000000001")
+	walletCmd(t, dir, "deposit", "1", "10")
+	walletCmd(t, dir, "pay", "1", "2.5", "taxi")
+
+	var payments []paymentView
+	walletJSON(t, dir, &payments, "expire", "24h")
+	if len(payments) != 0 {
+		t.Errorf("expected nothing expired, got %+v", payments)
+	}
+	walletJSON(t, dir, &payments, "expire", "1ns")
+	if len(payments) != 1 || payments[0].Status != "EXPIRED" {
+		t.Fatalf("unexpected payments %+v", payments)
+	}
+
+	var accounts []accountView
+	walletJSON(t, dir, &accounts, "account", "show", "1")
+	if accounts[0].Balance != 1000 {
+		t.Errorf("expected balance 1000, got %v", accounts[0].Balance)
+	}
+}
+
+func TestRun_Refund(t *testing.T) {
+	dir := t.TempDir()
+	walletCmd(t, dir, "account", "register", "+992000000001")
+	walletCmd(t, dir, "deposit", "1", "10")
+	var payments []paymentView
+	walletJSON(t, dir, &payments, "pay", "1", "3", "hotel")
+	paymentID := payments[0].ID
+	walletJSON(t, dir, &payments, "confirm", paymentID)
+
+	var refunds []refundView
+	walletJSON(t, dir, &refunds, "refund", paymentID, "1", "мини-бар")
+	if len(refunds) != 1 || refunds[0].Amount != 100 || refunds[0].Reason != "мини-бар" {
+		t.Fatalf("unexpected refunds %+v", refunds)
+	}
+	walletJSON(t, dir, &refunds, "refund", paymentID)
+	if len(refunds) != 1 || refunds[0].Amount != 200 {
+		t.Fatalf("unexpected refunds %+v", refunds)
+	}
+	walletJSON(t, dir, &refunds, "refunds", paymentID)
+	if len(refunds) != 2 {
+		t.Errorf("expected 2 refunds, got %+v", refunds)
+	}
+
+	var accounts []accountView
+	walletJSON(t, dir, &accounts, "account", "show", "1")
+	if accounts[0].Balance != 1000 {
+		t.Errorf("expected balance 1000, got %v", accounts[0].Balance)
+	}
+}
+
+func TestRun_FavoritesManagement(t *testing.T) {
+	dir := t.TempDir()
+	walletCmd(t, dir, "account", "register", "+992000000001")
+	walletCmd(t, dir, "deposit", "1", "10")
+	var payments []paymentView
+	var favorites []favoriteView
+	walletJSON(t, dir, &payments, "pay", "1", "3", "food")
+	walletJSON(t, dir, &favorites, "favorite", "add", payments[0].ID, "обед")
+	lunchID := favorites[0].ID
+	walletJSON(t, dir, &payments, "pay", "1", "1", "taxi")
+	walletJSON(t, dir, &favorites, "favorite", "add", payments[0].ID, "такси")
+	taxiID := favorites[0].ID
+
+	walletJSON(t, dir, &favorites, "favorite", "rename", lunchID, "ужин")
+	walletJSON(t, dir, &favorites, "favorite", "update", lunchID, "4.50", "restaurant")
+	if favorites[0].Name != "ужин" || favorites[0].Amount != 450 || favorites[0].Category != "restaurant" {
+		t.Errorf("unexpected favorite %+v", favorites[0])
+	}
+
+	walletJSON(t, dir, &favorites, "favorite", "reorder", "1", taxiID, lunchID)
+	walletJSON(t, dir, &favorites, "favorite", "list", "1")
+	if len(favorites) != 2 || favorites[0].ID != taxiID || favorites[1].Position != 2 {
+		t.Errorf("unexpected favorites %+v", favorites)
+	}
+
+	walletCmd(t, dir, "favorite", "delete", taxiID)
+	walletJSON(t, dir, &favorites, "favorite", "list", "1")
+	if len(favorites) != 1 || favorites[0].ID != lunchID || favorites[0].Position != 1 {
+		t.Errorf("unexpected favorites %+v", favorites)
+	}
+}
+
+func TestRun_Schedules(t *testing.T) {
+	dir := t.TempDir()
+	walletCmd(t, dir, "account", "register", "+992000000001")
+	walletCmd(t, dir, "deposit", "1", "10")
+	var payments []paymentView
+	walletJSON(t, dir, &payments, "pay", "1", "3", "internet")
+	var favorites []favoriteView
+	walletJSON(t, dir, &favorites, "favorite", "add", payments[0].ID, "интернет")
+
+	var schedules []scheduleView
+	walletJSON(t, dir, &schedules, "schedule", "add", favorites[0].ID, "ONCE")
+	if len(schedules) != 1 || schedules[0].Kind != "ONCE" || !schedules[0].Active {
+		t.Fatalf("unexpected schedules %+v", schedules)
+	}
+	walletJSON(t, dir, &schedules, "schedule", "add", favorites[0].ID, "CRON", "0 9 5 * *")
+	cronID := schedules[0].ID
+
+	var runs []scheduleRunView
+	walletJSON(t, dir, &runs, "schedule", "run")
+	if len(runs) != 1 || runs[0].Status != "OK" || runs[0].PaymentID == "" {
+		t.Fatalf("unexpected runs %+v", runs)
+	}
+	walletJSON(t, dir, &runs, "schedule", "runs", runs[0].ScheduleID)
+	if len(runs) != 1 {
+		t.Errorf("expected 1 run, got %+v", runs)
+	}
+
+	walletJSON(t, dir, &schedules, "schedule", "cancel", cronID)
+	walletJSON(t, dir, &schedules, "schedule", "list", "1")
+	if len(schedules) != 2 || schedules[0].Active || schedules[1].Active {
+		t.Errorf("expected both schedules inactive, got %+v", schedules)
+	}
+
+	var accounts []accountView
+	walletJSON(t, dir, &accounts, "account", "show", "1")
+	if accounts[0].Balance != 400 {
+		t.Errorf("expected balance 400, got %v", accounts[0].Balance)
+	}
+}
+
+func TestRun_Holds(t *testing.T) {
+	dir := t.TempDir()
+	walletCmd(t, dir, "account", "register", "+992000000001")
+	walletCmd(t, dir, "deposit", "1", "10")
+
+	var holds []holdView
+	walletJSON(t, dir, &holds, "hold", "authorize", "1", "6", "hotel", "2h")
+	if len(holds) != 1 || holds[0].Amount != 600 || holds[0].Status != "ACTIVE" {
+		t.Fatalf("unexpected holds %+v", holds)
+	}
+	var accounts []accountView
+	walletJSON(t, dir, &accounts, "account", "show", "1")
+	if accounts[0].Balance != 1000 || accounts[0].Available != 400 {
+		t.Errorf("expected balance 1000 and available 400, got %+v", accounts[0])
+	}
+
+	var payments []paymentView
+	walletJSON(t, dir, &payments, "hold", "capture", holds[0].ID, "4.5")
+	if len(payments) != 1 || payments[0].Amount != 450 || payments[0].Status != "OK" {
+		t.Fatalf("unexpected payments %+v", payments)
+	}
+	walletJSON(t, dir, &accounts, "account", "show", "1")
+	if accounts[0].Balance != 550 || accounts[0].Available != 550 {
+		t.Errorf("expected balance and available 550, got %+v", accounts[0])
+	}
+	walletJSON(t, dir, &holds, "hold", "list", "1")
+	if len(holds) != 1 || holds[0].Status != "CAPTURED" {
+		t.Errorf("unexpected holds %+v", holds)
+	}
+}
+
+func TestRun_Table(t *testing.T) {
+	dir := t.TempDir()
+	walletCmd(t, dir, "account", "register", "+992000000001", "USD")
+
+	output, err := walletCmd(t, dir, "deposit", "1", "12.3")
+	if err != nil {
+		t.Fatalf("failed to deposit: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "ID") {
+		t.Fatalf("unexpected table %q", output)
+	}
+	if fields := strings.Fields(lines[1]); len(fields) != 5 || fields[2] != "12.30" || fields[3] != "12.30" || fields[4] != "USD" {
+		t.Errorf("unexpected row %q", lines[1])
+	}
+}
+
+func TestRun_Errors(t *testing.T) {
+	dir := t.TempDir()
+	walletCmd(t, dir, "account", "register", "+992000000001")
+
+	tests := []struct {
+		args []string
+		want error
+	}{
+		{[]string{"account", "show", "42"}, wallet.ErrAccountNotFound},
+		{[]string{"account", "register", "+992000000001"}, wallet.ErrPhoneRegistered},
+		{[]string{"pay", "1", "100", "food"}, wallet.ErrNotEnoughBalance},
+		{[]string{"reject", "unknown"}, wallet.ErrPaymentNotFound},
+		{[]string{"account"}, errUsage},
+		{[]string{"deposit", "1"}, errUsage},
+		{[]string{"expire", "0s"}, wallet.ErrInvalidTimeout},
+		{[]string{"-format", "xml", "history", "1"}, errUsage},
+	}
+
+	for _, tt := range tests {
+		if _, err := walletCmd(t, dir, tt.args...); !errors.Is(err, tt.want) {
+			t.Errorf("wallet %v: expected error %v, got %v", tt.args, tt.want, err)
+		}
+	}
+}
@@ -0,0 +1,406 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/akmalsulaymonov/alif-wallet/pkg/types"
+	"github.com/akmalsulaymonov/alif-wallet/pkg/wallet"
+)
+
+// printer выводит записи таблицей или JSON-массивом.
+// В таблице суммы форматируются по валюте, в JSON - в минимальных единицах.
+type printer struct {
+	w    io.Writer
+	json bool
+}
+
+type accountView struct {
+	ID        int64          `json:"id"`
+	Phone     types.Phone    `json:"phone"`
+	Balance   types.Money    `json:"balance"`
+	Held      types.Money    `json:"held"`
+	Available types.Money    `json:"available"`
+	Currency  types.Currency `json:"currency"`
+	CreatedAt *time.Time     `json:"created_at,omitempty"`
+	UpdatedAt *time.Time     `json:"updated_at,omitempty"`
+}
+
+type paymentView struct {
+	ID              string                `json:"id"`
+	AccountID       int64                 `json:"account_id"`
+	Amount          types.Money           `json:"amount"`
+	Currency        types.Currency        `json:"currency"`
+	Category        types.PaymentCategory `json:"category"`
+	Status          types.PaymentStatus   `json:"status"`
+	TargetAmount    types.Money           `json:"target_amount,omitempty"`
+	TargetCurrency  types.Currency        `json:"target_currency,omitempty"`
+	Rate            string                `json:"rate,omitempty"`
+	CreatedAt       *time.Time            `json:"created_at,omitempty"`
+	UpdatedAt       *time.Time            `json:"updated_at,omitempty"`
+	StatusChangedAt *time.Time            `json:"status_changed_at,omitempty"`
+}
+
+type favoriteView struct {
+	ID        string                `json:"id"`
+	AccountID int64                 `json:"account_id"`
+	Name      string                `json:"name"`
+	Amount    types.Money           `json:"amount"`
+	Currency  types.Currency        `json:"currency"`
+	Category  types.PaymentCategory `json:"category"`
+	Position  int                   `json:"position"`
+	CreatedAt *time.Time            `json:"created_at,omitempty"`
+	UpdatedAt *time.Time            `json:"updated_at,omitempty"`
+}
+
+type refundView struct {
+	ID             string         `json:"id"`
+	PaymentID      string         `json:"payment_id"`
+	AccountID      int64          `json:"account_id"`
+	Amount         types.Money    `json:"amount"`
+	Currency       types.Currency `json:"currency"`
+	TargetAmount   types.Money    `json:"target_amount,omitempty"`
+	TargetCurrency types.Currency `json:"target_currency,omitempty"`
+	Reason         string         `json:"reason,omitempty"`
+	CreatedAt      *time.Time     `json:"created_at,omitempty"`
+}
+
+type holdView struct {
+	ID             string                `json:"id"`
+	AccountID      int64                 `json:"account_id"`
+	Amount         types.Money           `json:"amount"`
+	Currency       types.Currency        `json:"currency"`
+	Category       types.PaymentCategory `json:"category"`
+	Status         types.HoldStatus      `json:"status"`
+	CapturedAmount types.Money           `json:"captured_amount,omitempty"`
+	PaymentID      string                `json:"payment_id,omitempty"`
+	ExpiresAt      time.Time             `json:"expires_at"`
+	CreatedAt      *time.Time            `json:"created_at,omitempty"`
+	UpdatedAt      *time.Time            `json:"updated_at,omitempty"`
+}
+
+type scheduleView struct {
+	ID         string             `json:"id"`
+	FavoriteID string             `json:"favorite_id"`
+	AccountID  int64              `json:"account_id"`
+	Kind       types.ScheduleKind `json:"kind"`
+	Start      time.Time          `json:"start"`
+	Cron       string             `json:"cron,omitempty"`
+	Active     bool               `json:"active"`
+	DueAt      *time.Time         `json:"due_at,omitempty"`
+	RetryAt    *time.Time         `json:"retry_at,omitempty"`
+	Attempts   int                `json:"attempts,omitempty"`
+	CreatedAt  *time.Time         `json:"created_at,omitempty"`
+	UpdatedAt  *time.Time         `json:"updated_at,omitempty"`
+}
+
+type scheduleRunView struct {
+	ID         string                  `json:"id"`
+	ScheduleID string                  `json:"schedule_id"`
+	AccountID  int64                   `json:"account_id"`
+	DueAt      time.Time               `json:"due_at"`
+	Attempt    int                     `json:"attempt"`
+	Status     types.ScheduleRunStatus `json:"status"`
+	PaymentID  string                  `json:"payment_id,omitempty"`
+	Error      string                  `json:"error,omitempty"`
+	At         time.Time               `json:"at"`
+}
+
+type importChangeView struct {
+	Kind   string              `json:"kind"`
+	ID     string              `json:"id"`
+	Action wallet.ImportAction `json:"action"`
+}
+
+func (p printer) accounts(accounts ...types.Account) error {
+	if p.json {
+		views := make([]accountView, 0, len(accounts))
+		for _, account := range accounts {
+			views = append(views, accountView{
+				ID:        account.ID,
+				Phone:     account.Phone,
+				Balance:   account.Balance,
+				Held:      account.Held,
+				Available: account.Available(),
+				Currency:  account.Currency,
+				CreatedAt: timestamp(account.CreatedAt),
+				UpdatedAt: timestamp(account.UpdatedAt),
+			})
+		}
+		return p.encode(views)
+	}
+
+	rows := make([][]string, 0, len(accounts))
+	for _, account := range accounts {
+		rows = append(rows, []string{
+			fmt.Sprint(account.ID),
+			string(account.Phone),
+			account.Currency.Format(account.Balance),
+			account.Currency.Format(account.Available()),
+			string(account.Currency),
+		})
+	}
+	return p.table([]string{"ID", "PHONE", "BALANCE", "AVAILABLE", "CURRENCY"}, rows)
+}
+
+func (p printer) payments(payments ...types.Payment) error {
+	if p.json {
+		views := make([]paymentView, 0, len(payments))
+		for _, payment := range payments {
+			views = append(views, paymentView{
+				ID:              payment.ID,
+				AccountID:       payment.AccountID,
+				Amount:          payment.Amount,
+				Currency:        payment.Currency,
+				Category:        payment.Category,
+				Status:          payment.Status,
+				TargetAmount:    payment.TargetAmount,
+				TargetCurrency:  payment.TargetCurrency,
+				Rate:            payment.Rate,
+				CreatedAt:       timestamp(payment.CreatedAt),
+				UpdatedAt:       timestamp(payment.UpdatedAt),
+				StatusChangedAt: timestamp(payment.StatusChangedAt),
+			})
+		}
+		return p.encode(views)
+	}
+
+	rows := make([][]string, 0, len(payments))
+	for _, payment := range payments {
+		target := ""
+		if payment.TargetCurrency != "" {
+			target = payment.TargetCurrency.Format(payment.TargetAmount) + " " + string(payment.TargetCurrency)
+		}
+		rows = append(rows, []string{
+			payment.ID,
+			fmt.Sprint(payment.AccountID),
+			payment.Currency.Format(payment.Amount) + " " + string(payment.Currency),
+			string(payment.Category),
+			string(payment.Status),
+			target,
+		})
+	}
+	return p.table([]string{"ID", "ACCOUNT", "AMOUNT", "CATEGORY", "STATUS", "TARGET"}, rows)
+}
+
+func (p printer) favorites(favorites ...types.Favorite) error {
+	if p.json {
+		views := make([]favoriteView, 0, len(favorites))
+		for _, favorite := range favorites {
+			views = append(views, favoriteView{
+				ID:        favorite.ID,
+				AccountID: favorite.AccountID,
+				Name:      favorite.Name,
+				Amount:    favorite.Amount,
+				Currency:  favorite.Currency,
+				Category:  favorite.Category,
+				Position:  favorite.Position,
+				CreatedAt: timestamp(favorite.CreatedAt),
+				UpdatedAt: timestamp(favorite.UpdatedAt),
+			})
+		}
+		return p.encode(views)
+	}
+
+	rows := make([][]string, 0, len(favorites))
+	for _, favorite := range favorites {
+		rows = append(rows, []string{
+			fmt.Sprint(favorite.Position),
+			favorite.ID,
+			fmt.Sprint(favorite.AccountID),
+			favorite.Name,
+			favorite.Currency.Format(favorite.Amount) + " " + string(favorite.Currency),
+			string(favorite.Category),
+		})
+	}
+	return p.table([]string{"#", "ID", "ACCOUNT", "NAME", "AMOUNT", "CATEGORY"}, rows)
+}
+
+func (p printer) refunds(refunds ...types.Refund) error {
+	if p.json {
+		views := make([]refundView, 0, len(refunds))
+		for _, refund := range refunds {
+			views = append(views, refundView{
+				ID:             refund.ID,
+				PaymentID:      refund.PaymentID,
+				AccountID:      refund.AccountID,
+				Amount:         refund.Amount,
+				Currency:       refund.Currency,
+				TargetAmount:   refund.TargetAmount,
+				TargetCurrency: refund.TargetCurrency,
+				Reason:         refund.Reason,
+				CreatedAt:      timestamp(refund.CreatedAt),
+			})
+		}
+		return p.encode(views)
+	}
+
+	rows := make([][]string, 0, len(refunds))
+	for _, refund := range refunds {
+		rows = append(rows, []string{
+			refund.ID,
+			refund.PaymentID,
+			refund.Currency.Format(refund.Amount) + " " + string(refund.Currency),
+			refund.Reason,
+		})
+	}
+	return p.table([]string{"ID", "PAYMENT", "AMOUNT", "REASON"}, rows)
+}
+
+func (p printer) holds(holds ...types.Hold) error {
+	if p.json {
+		views := make([]holdView, 0, len(holds))
+		for _, hold := range holds {
+			views = append(views, holdView{
+				ID:             hold.ID,
+				AccountID:      hold.AccountID,
+				Amount:         hold.Amount,
+				Currency:       hold.Currency,
+				Category:       hold.Category,
+				Status:         hold.Status,
+				CapturedAmount: hold.CapturedAmount,
+				PaymentID:      hold.PaymentID,
+				ExpiresAt:      hold.ExpiresAt,
+				CreatedAt:      timestamp(hold.CreatedAt),
+				UpdatedAt:      timestamp(hold.UpdatedAt),
+			})
+		}
+		return p.encode(views)
+	}
+
+	rows := make([][]string, 0, len(holds))
+	for _, hold := range holds {
+		rows = append(rows, []string{
+			hold.ID,
+			fmt.Sprint(hold.AccountID),
+			hold.Currency.Format(hold.Amount) + " " + string(hold.Currency),
+			string(hold.Category),
+			string(hold.Status),
+			hold.ExpiresAt.Format(time.RFC3339),
+		})
+	}
+	return p.table([]string{"ID", "ACCOUNT", "AMOUNT", "CATEGORY", "STATUS", "EXPIRES"}, rows)
+}
+
+func (p printer) schedules(schedules ...types.Schedule) error {
+	if p.json {
+		views := make([]scheduleView, 0, len(schedules))
+		for _, schedule := range schedules {
+			views = append(views, scheduleView{
+				ID:         schedule.ID,
+				FavoriteID: schedule.FavoriteID,
+				AccountID:  schedule.AccountID,
+				Kind:       schedule.Kind,
+				Start:      schedule.Start,
+				Cron:       schedule.Cron,
+				Active:     schedule.Active,
+				DueAt:      timestamp(schedule.DueAt),
+				RetryAt:    timestamp(schedule.RetryAt),
+				Attempts:   schedule.Attempts,
+				CreatedAt:  timestamp(schedule.CreatedAt),
+				UpdatedAt:  timestamp(schedule.UpdatedAt),
+			})
+		}
+		return p.encode(views)
+	}
+
+	rows := make([][]string, 0, len(schedules))
+	for _, schedule := range schedules {
+		kind := string(schedule.Kind)
+		if schedule.Cron != "" {
+			kind += " " + schedule.Cron
+		}
+		due := ""
+		if schedule.Active {
+			due = schedule.DueAt.Format(time.RFC3339)
+			if !schedule.RetryAt.IsZero() {
+				due = schedule.RetryAt.Format(time.RFC3339)
+			}
+		}
+		rows = append(rows, []string{
+			schedule.ID,
+			schedule.FavoriteID,
+			kind,
+			fmt.Sprint(schedule.Active),
+			due,
+		})
+	}
+	return p.table([]string{"ID", "FAVORITE", "KIND", "ACTIVE", "NEXT"}, rows)
+}
+
+func (p printer) scheduleRuns(runs ...types.ScheduleRun) error {
+	if p.json {
+		views := make([]scheduleRunView, 0, len(runs))
+		for _, run := range runs {
+			views = append(views, scheduleRunView{
+				ID:         run.ID,
+				ScheduleID: run.ScheduleID,
+				AccountID:  run.AccountID,
+				DueAt:      run.DueAt,
+				Attempt:    run.Attempt,
+				Status:     run.Status,
+				PaymentID:  run.PaymentID,
+				Error:      run.Error,
+				At:         run.At,
+			})
+		}
+		return p.encode(views)
+	}
+
+	rows := make([][]string, 0, len(runs))
+	for _, run := range runs {
+		rows = append(rows, []string{
+			run.ScheduleID,
+			run.DueAt.Format(time.RFC3339),
+			fmt.Sprint(run.Attempt),
+			string(run.Status),
+			run.PaymentID,
+			run.Error,
+		})
+	}
+	return p.table([]string{"SCHEDULE", "DUE", "ATTEMPT", "STATUS", "PAYMENT", "ERROR"}, rows)
+}
+
+func (p printer) importChanges(changes ...wallet.ImportChange) error {
+	if p.json {
+		views := make([]importChangeView, 0, len(changes))
+		for _, change := range changes {
+			views = append(views, importChangeView{Kind: change.Kind, ID: change.ID, Action: change.Action})
+		}
+		return p.encode(views)
+	}
+
+	rows := make([][]string, 0, len(changes))
+	for _, change := range changes {
+		rows = append(rows, []string{change.Kind, change.ID, string(change.Action)})
+	}
+	return p.table([]string{"KIND", "ID", "ACTION"}, rows)
+}
+
+// timestamp возвращает nil для неизвестного (нулевого) времени,
+// чтобы поле не попало в JSON.
+func timestamp(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+func (p printer) encode(v interface{}) error {
+	encoder := json.NewEncoder(p.w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+func (p printer) table(header []string, rows [][]string) error {
+	w := tabwriter.NewWriter(p.w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(header, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	return w.Flush()
+}
@@ -0,0 +1,162 @@
+// Команда walletd - HTTP-сервер кошелька. С -grpc он также
+// обслуживает gRPC API на отдельном адресе.
+//
+// Без -data состояние хранится в памяти и теряется при остановке.
+// С -data состояние хранится в JournalStore в указанном каталоге.
+// С -expire-after платежи, находящиеся в обработке дольше заданного
+// времени, в фоне переводятся в EXPIRED с возвратом денег; заодно
+// освобождаются истёкшие блокировки.
+// Платежи по расписаниям выполняются в фоне каждые -schedule-interval.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"google.golang.org/grpc"
+
+	"github.com/akmalsulaymonov/alif-wallet/pkg/grpcapi"
+	"github.com/akmalsulaymonov/alif-wallet/pkg/rest"
+	"github.com/akmalsulaymonov/alif-wallet/pkg/types"
+	"github.com/akmalsulaymonov/alif-wallet/pkg/wallet"
+)
+
+func main() {
+	addr := flag.String("addr", ":8080", "адрес HTTP-сервера")
+	grpcAddr := flag.String("grpc", "", "адрес gRPC-сервера; пусто - не запускать")
+	data := flag.String("data", "", "каталог журнала; пусто - хранить в памяти")
+	snapshotEvery := flag.Int("snapshot-every", 1000, "делать снимок каждые N транзакций")
+	rates := flag.String("rates", "", "файл курсов валют со строками FROM;TO;RATE")
+	expireAfter := flag.Duration("expire-after", 0, "срок платежей в обработке; 0 - не истекают")
+	scheduleInterval := flag.Duration("schedule-interval", wallet.DefaultScheduleInterval, "период проверки расписаний; 0 - не выполнять")
+	flag.Parse()
+
+	if err := run(*addr, *grpcAddr, *data, *snapshotEvery, *rates, *expireAfter, *scheduleInterval); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(addr, grpcAddr, data string, snapshotEvery int, rates string, expireAfter, scheduleInterval time.Duration) error {
+	svc := &wallet.Service{}
+	if data != "" {
+		if err := os.MkdirAll(data, 0755); err != nil {
+			return err
+		}
+		store, err := wallet.OpenJournalStore(data, snapshotEvery)
+		if err != nil {
+			return err
+		}
+		defer store.Close()
+		svc = wallet.NewService(store)
+	}
+
+	if rates != "" {
+		provider, err := wallet.LoadRates(rates)
+		if err != nil {
+			return err
+		}
+		svc.SetExchangeRateProvider(provider)
+	}
+
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           rest.NewHandler(svc),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if expireAfter > 0 {
+		expirer, err := svc.NewExpirer(wallet.ExpirerOptions{
+			Timeout: expireAfter,
+			OnExpire: func(report *wallet.ExpiryReport) {
+				for _, payment := range report.Expired {
+					log.Printf("payment %s expired, %s %s refunded", payment.ID, payment.Currency.Format(payment.Amount), payment.Currency)
+				}
+				for _, hold := range report.Holds {
+					log.Printf("hold %s expired, %s %s released", hold.ID, hold.Currency.Format(hold.Amount), hold.Currency)
+				}
+			},
+			OnError: func(err error) { log.Printf("expire: %v", err) },
+		})
+		if err != nil {
+			return err
+		}
+		if err := expirer.Start(ctx); err != nil {
+			return err
+		}
+		// Останавливается до закрытия журнала
+		defer expirer.Stop()
+	}
+
+	if scheduleInterval > 0 {
+		scheduler := svc.NewScheduler(wallet.SchedulerOptions{
+			Interval: scheduleInterval,
+			OnRun: func(runs []types.ScheduleRun) {
+				for _, run := range runs {
+					if run.Status == types.ScheduleRunOk {
+						log.Printf("schedule %s: payment %s", run.ScheduleID, run.PaymentID)
+					} else {
+						log.Printf("schedule %s: attempt %d %s: %s", run.ScheduleID, run.Attempt, run.Status, run.Error)
+					}
+				}
+			},
+			OnError: func(err error) { log.Printf("schedule: %v", err) },
+		})
+		if err := scheduler.Start(ctx); err != nil {
+			return err
+		}
+		defer scheduler.Stop()
+	}
+
+	var grpcListener net.Listener
+	if grpcAddr != "" {
+		listener, err := net.Listen("tcp", grpcAddr)
+		if err != nil {
+			return err
+		}
+		grpcListener = listener
+	}
+
+	errs := make(chan error, 2)
+	go func() {
+		log.Printf("walletd listening on %s", addr)
+		errs <- server.ListenAndServe()
+	}()
+
+	var grpcServer *grpc.Server
+	if grpcListener != nil {
+		grpcServer = grpc.NewServer()
+		grpcapi.Register(grpcServer, svc)
+		go func() {
+			log.Printf("walletd gRPC listening on %s", grpcAddr)
+			errs <- grpcServer.Serve(grpcListener)
+		}()
+	}
+
+	var serveErr error
+	select {
+	case serveErr = <-errs:
+	case <-ctx.Done():
+	}
+
+	// Дожидаемся текущих запросов, чтобы журнал закрылся после них
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if grpcServer != nil {
+		grpcServer.GracefulStop()
+	}
+	if err := server.Shutdown(shutdownCtx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return serveErr
+}
@@ -0,0 +1,264 @@
+// Package grpcapi - gRPC API над wallet.Service, протокол в пакете walletpb.
+//
+// Ошибки сервиса возвращаются статусами gRPC, см. codeOf.
+package grpcapi
+
+import (
+	"context"
+	"errors"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/akmalsulaymonov/alif-wallet/pkg/types"
+	"github.com/akmalsulaymonov/alif-wallet/pkg/wallet"
+	"github.com/akmalsulaymonov/alif-wallet/pkg/walletpb"
+)
+
+type server struct {
+	walletpb.UnimplementedWalletServer
+	svc *wallet.Service
+}
+
+// NewServer возвращает реализацию walletpb.WalletServer для сервиса svc.
+func NewServer(svc *wallet.Service) walletpb.WalletServer {
+	return &server{svc: svc}
+}
+
+// Register регистрирует API сервиса svc на gRPC-сервере s.
+func Register(s *grpc.Server, svc *wallet.Service) {
+	walletpb.RegisterWalletServer(s, NewServer(svc))
+}
+
+func newAccount(account types.Account) *walletpb.Account {
+	return &walletpb.Account{
+		Id:        account.ID,
+		Phone:     string(account.Phone),
+		Balance:   int64(account.Balance),
+		Currency:  string(account.Currency),
+		Held:      int64(account.Held),
+		Available: int64(account.Available()),
+	}
+}
+
+func newPayment(payment types.Payment) *walletpb.Payment {
+	return &walletpb.Payment{
+		Id:             payment.ID,
+		AccountId:      payment.AccountID,
+		Amount:         int64(payment.Amount),
+		Currency:       string(payment.Currency),
+		Category:       string(payment.Category),
+		Status:         string(payment.Status),
+		TargetAmount:   int64(payment.TargetAmount),
+		TargetCurrency: string(payment.TargetCurrency),
+		Rate:           payment.Rate,
+	}
+}
+
+func newFavorite(favorite types.Favorite) *walletpb.Favorite {
+	return &walletpb.Favorite{
+		Id:        favorite.ID,
+		AccountId: favorite.AccountID,
+		Name:      favorite.Name,
+		Amount:    int64(favorite.Amount),
+		Currency:  string(favorite.Currency),
+		Category:  string(favorite.Category),
+	}
+}
+
+func (s *server) RegisterAccount(ctx context.Context, req *walletpb.RegisterAccountRequest) (*walletpb.Account, error) {
+	currency := types.Currency(req.GetCurrency())
+	if currency == "" {
+		currency = types.DefaultCurrency
+	}
+
+	account, err := s.svc.RegisterAccountWithCurrency(types.Phone(req.GetPhone()), currency)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return s.account(account.ID)
+}
+
+func (s *server) GetAccount(ctx context.Context, req *walletpb.GetAccountRequest) (*walletpb.Account, error) {
+	return s.account(req.GetAccountId())
+}
+
+// amount возвращает сумму запроса, подставляя валюту аккаунта.
+func (s *server) amount(accountID int64, value int64, currency string) (types.Amount, error) {
+	amount := types.Amount{Value: types.Money(value), Currency: types.Currency(currency)}
+	if amount.Currency == "" {
+		account, err := s.svc.FindAccountByID(accountID)
+		if err != nil {
+			return types.Amount{}, err
+		}
+		amount.Currency = account.Currency
+	}
+	return amount, nil
+}
+
+func (s *server) Deposit(ctx context.Context, req *walletpb.DepositRequest) (*walletpb.Account, error) {
+	amount, err := s.amount(req.GetAccountId(), req.GetAmount(), req.GetCurrency())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	if err := s.svc.DepositWithKey(req.GetIdempotencyKey(), req.GetAccountId(), amount); err != nil {
+		return nil, toStatus(err)
+	}
+	return s.account(req.GetAccountId())
+}
+
+func (s *server) Pay(ctx context.Context, req *walletpb.PayRequest) (*walletpb.Payment, error) {
+	amount, err := s.amount(req.GetAccountId(), req.GetAmount(), req.GetCurrency())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	payment, err := s.svc.PayWithKey(req.GetIdempotencyKey(), req.GetAccountId(), amount, types.PaymentCategory(req.GetCategory()))
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return s.payment(payment.ID)
+}
+
+func (s *server) GetPayment(ctx context.Context, req *walletpb.PaymentRequest) (*walletpb.Payment, error) {
+	return s.payment(req.GetPaymentId())
+}
+
+func (s *server) RejectPayment(ctx context.Context, req *walletpb.PaymentRequest) (*walletpb.Payment, error) {
+	return s.changeStatus(req.GetPaymentId(), (*wallet.Service).Reject)
+}
+
+func (s *server) ConfirmPayment(ctx context.Context, req *walletpb.PaymentRequest) (*walletpb.Payment, error) {
+	return s.changeStatus(req.GetPaymentId(), (*wallet.Service).Confirm)
+}
+
+func (s *server) CancelPayment(ctx context.Context, req *walletpb.PaymentRequest) (*walletpb.Payment, error) {
+	return s.changeStatus(req.GetPaymentId(), (*wallet.Service).Cancel)
+}
+
+func (s *server) RefundPayment(ctx context.Context, req *walletpb.PaymentRequest) (*walletpb.Payment, error) {
+	return s.changeStatus(req.GetPaymentId(), (*wallet.Service).Refund)
+}
+
+// changeStatus меняет статус платежа методом change и возвращает платёж.
+func (s *server) changeStatus(paymentID string, change func(*wallet.Service, string) error) (*walletpb.Payment, error) {
+	if err := change(s.svc, paymentID); err != nil {
+		return nil, toStatus(err)
+	}
+	return s.payment(paymentID)
+}
+
+func (s *server) RepeatPayment(ctx context.Context, req *walletpb.PaymentRequest) (*walletpb.Payment, error) {
+	payment, err := s.svc.Repeat(req.GetPaymentId())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return s.payment(payment.ID)
+}
+
+func (s *server) FavoritePayment(ctx context.Context, req *walletpb.FavoritePaymentRequest) (*walletpb.Favorite, error) {
+	favorite, err := s.svc.FavoritePayment(req.GetPaymentId(), req.GetName())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return newFavorite(*favorite), nil
+}
+
+func (s *server) PayFromFavorite(ctx context.Context, req *walletpb.PayFromFavoriteRequest) (*walletpb.Payment, error) {
+	payment, err := s.svc.PayFromFavoriteWithKey(req.GetIdempotencyKey(), req.GetFavoriteId())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return s.payment(payment.ID)
+}
+
+func (s *server) ListFavorites(ctx context.Context, req *walletpb.ListFavoritesRequest) (*walletpb.ListFavoritesResponse, error) {
+	favorites, err := s.svc.AccountFavorites(req.GetAccountId())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	response := &walletpb.ListFavoritesResponse{}
+	for _, favorite := range favorites {
+		response.Favorites = append(response.Favorites, newFavorite(favorite))
+	}
+	return response, nil
+}
+
+func (s *server) AccountHistory(req *walletpb.AccountHistoryRequest, stream grpc.ServerStreamingServer[walletpb.Payment]) error {
+	history, err := s.svc.ExportAccountHistory(req.GetAccountId())
+	if err != nil {
+		return toStatus(err)
+	}
+
+	for _, payment := range history {
+		if err := stream.Context().Err(); err != nil {
+			return status.FromContextError(err).Err()
+		}
+		if err := stream.Send(newPayment(payment)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *server) account(accountID int64) (*walletpb.Account, error) {
+	account, err := s.svc.FindAccountByID(accountID)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return newAccount(*account), nil
+}
+
+func (s *server) payment(paymentID string) (*walletpb.Payment, error) {
+	payment, err := s.svc.FindPaymentByID(paymentID)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return newPayment(*payment), nil
+}
+
+// toStatus превращает ошибку сервиса в статус gRPC.
+func toStatus(err error) error {
+	code := codeOf(err)
+	if code == codes.Internal {
+		// Детали внутренних ошибок клиенту не показываем
+		return status.Error(code, "internal error")
+	}
+	return status.Error(code, err.Error())
+}
+
+// codeOf возвращает код gRPC для ошибки сервиса.
+func codeOf(err error) codes.Code {
+	switch {
+	case errors.Is(err, wallet.ErrAmountMustBePositive),
+		errors.Is(err, wallet.ErrUnknownCurrency),
+		errors.Is(err, wallet.ErrSameAccount),
+		errors.Is(err, wallet.ErrCaptureExceedsHold),
+		errors.Is(err, wallet.ErrRefundExceedsPayment):
+		return codes.InvalidArgument
+	case errors.Is(err, wallet.ErrAccountNotFound),
+		errors.Is(err, wallet.ErrPaymentNotFound),
+		errors.Is(err, wallet.ErrFavoriteNotFound),
+		errors.Is(err, wallet.ErrTransferNotFound),
+		errors.Is(err, wallet.ErrHoldNotFound),
+		errors.Is(err, wallet.ErrRefundNotFound),
+		errors.Is(err, wallet.ErrScheduleNotFound):
+		return codes.NotFound
+	case errors.Is(err, wallet.ErrPhoneRegistered),
+		errors.Is(err, wallet.ErrFavoriteNameTaken):
+		return codes.AlreadyExists
+	case errors.Is(err, wallet.ErrNotEnoughBalance),
+		errors.Is(err, wallet.ErrCurrencyMismatch),
+		errors.Is(err, wallet.ErrRateNotFound),
+		errors.Is(err, wallet.ErrIdempotencyConflict),
+		errors.Is(err, wallet.ErrIllegalTransition),
+		errors.Is(err, wallet.ErrInvalidPaymentStatus),
+		errors.Is(err, wallet.ErrHoldNotActive),
+		errors.Is(err, wallet.ErrHoldExpired):
+		return codes.FailedPrecondition
+	}
+	return codes.Internal
+}
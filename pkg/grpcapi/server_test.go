@@ -0,0 +1,229 @@
+package grpcapi
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/akmalsulaymonov/alif-wallet/pkg/types"
+	"github.com/akmalsulaymonov/alif-wallet/pkg/wallet"
+	"github.com/akmalsulaymonov/alif-wallet/pkg/walletpb"
+)
+
+// newClient запускает сервер поверх соединения в памяти и возвращает клиента.
+func newClient(t *testing.T) walletpb.WalletClient {
+	t.Helper()
+
+	listener := bufconn.Listen(1 << 20)
+	s := grpc.NewServer()
+	Register(s, &wallet.Service{})
+	go s.Serve(listener)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return walletpb.NewWalletClient(conn)
+}
+
+// funded регистрирует аккаунт и пополняет его на amount.
+func funded(t *testing.T, client walletpb.WalletClient, amount int64) *walletpb.Account {
+	t.Helper()
+	ctx := context.Background()
+
+	account, err := client.RegisterAccount(ctx, &walletpb.RegisterAccountRequest{Phone: "+992000000001"})
+	if err != nil {
+		t.Fatalf("failed to register account: %v", err)
+	}
+	account, err = client.Deposit(ctx, &walletpb.DepositRequest{AccountId: account.Id, Amount: amount})
+	if err != nil {
+		t.Fatalf("failed to deposit: %v", err)
+	}
+	return account
+}
+
+func TestServer_Payments(t *testing.T) {
+	client := newClient(t)
+	ctx := context.Background()
+	account := funded(t, client, 1000)
+	if account.Balance != 1000 || account.Currency != string(types.DefaultCurrency) {
+		t.Errorf("unexpected account %v", account)
+	}
+
+	payment, err := client.Pay(ctx, &walletpb.PayRequest{AccountId: account.Id, Amount: 300, Category: "food"})
+	if err != nil {
+		t.Fatalf("failed to pay: %v", err)
+	}
+	if payment.Amount != 300 || payment.Status != string(types.PaymentStatusInProgress) {
+		t.Errorf("unexpected payment %v", payment)
+	}
+
+	rejected, err := client.RejectPayment(ctx, &walletpb.PaymentRequest{PaymentId: payment.Id})
+	if err != nil {
+		t.Fatalf("failed to reject: %v", err)
+	}
+	if rejected.Status != string(types.PaymentStatusFail) {
+		t.Errorf("expected status FAIL, got %v", rejected.Status)
+	}
+
+	repeated, err := client.RepeatPayment(ctx, &walletpb.PaymentRequest{PaymentId: payment.Id})
+	if err != nil {
+		t.Fatalf("failed to repeat: %v", err)
+	}
+	if repeated.Id == payment.Id || repeated.Amount != 300 {
+		t.Errorf("unexpected repeated payment %v", repeated)
+	}
+
+	got, err := client.GetAccount(ctx, &walletpb.GetAccountRequest{AccountId: account.Id})
+	if err != nil {
+		t.Fatalf("failed to get account: %v", err)
+	}
+	if got.Balance != 700 {
+		t.Errorf("expected balance 700, got %v", got.Balance)
+	}
+}
+
+func TestServer_Favorites(t *testing.T) {
+	client := newClient(t)
+	ctx := context.Background()
+	account := funded(t, client, 1000)
+	payment, _ := client.Pay(ctx, &walletpb.PayRequest{AccountId: account.Id, Amount: 300, Category: "food"})
+
+	favorite, err := client.FavoritePayment(ctx, &walletpb.FavoritePaymentRequest{PaymentId: payment.Id, Name: "обед"})
+	if err != nil {
+		t.Fatalf("failed to add favorite: %v", err)
+	}
+
+	request := &walletpb.PayFromFavoriteRequest{FavoriteId: favorite.Id, IdempotencyKey: "key-1"}
+	paid, err := client.PayFromFavorite(ctx, request)
+	if err != nil {
+		t.Fatalf("failed to pay from favorite: %v", err)
+	}
+	retried, err := client.PayFromFavorite(ctx, request)
+	if err != nil || retried.Id != paid.Id {
+		t.Errorf("expected original payment %v, got %v %v", paid.Id, retried, err)
+	}
+
+	list, err := client.ListFavorites(ctx, &walletpb.ListFavoritesRequest{AccountId: account.Id})
+	if err != nil {
+		t.Fatalf("failed to list favorites: %v", err)
+	}
+	if len(list.Favorites) != 1 || list.Favorites[0].Name != "обед" {
+		t.Errorf("unexpected favorites %v", list.Favorites)
+	}
+}
+
+func TestServer_AccountHistory(t *testing.T) {
+	client := newClient(t)
+	ctx := context.Background()
+	account := funded(t, client, 1000)
+	for i := 0; i < 3; i++ {
+		client.Pay(ctx, &walletpb.PayRequest{AccountId: account.Id, Amount: 100, Category: "food"})
+	}
+
+	stream, err := client.AccountHistory(ctx, &walletpb.AccountHistoryRequest{AccountId: account.Id})
+	if err != nil {
+		t.Fatalf("failed to open stream: %v", err)
+	}
+	count := 0
+	for {
+		payment, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("failed to receive payment: %v", err)
+		}
+		if payment.AccountId != account.Id {
+			t.Errorf("unexpected payment %v", payment)
+		}
+		count++
+	}
+	if count != 3 {
+		t.Errorf("expected 3 payments, got %d", count)
+	}
+
+	stream, _ = client.AccountHistory(ctx, &walletpb.AccountHistoryRequest{AccountId: 42})
+	if _, err := stream.Recv(); status.Code(err) != codes.NotFound {
+		t.Errorf("expected code NotFound, got %v", err)
+	}
+}
+
+func TestServer_AccountHeld(t *testing.T) {
+	svc := &wallet.Service{}
+	account, _ := svc.RegisterAccount("+992000000001")
+	svc.Deposit(account.ID, 1000)
+	if _, err := svc.Authorize(account.ID, 300, "hotel", time.Hour); err != nil {
+		t.Fatalf("failed to authorize: %v", err)
+	}
+
+	got, err := NewServer(svc).GetAccount(context.Background(), &walletpb.GetAccountRequest{AccountId: account.ID})
+	if err != nil {
+		t.Fatalf("failed to get account: %v", err)
+	}
+	if got.Balance != 1000 || got.Held != 300 || got.Available != 700 {
+		t.Errorf("expected balance 1000, held 300, available 700, got %v", got)
+	}
+}
+
+func TestServer_Errors(t *testing.T) {
+	client := newClient(t)
+	ctx := context.Background()
+	account := funded(t, client, 100)
+
+	_, err := client.RegisterAccount(ctx, &walletpb.RegisterAccountRequest{Phone: "+992000000001"})
+	if status.Code(err) != codes.AlreadyExists {
+		t.Errorf("expected code AlreadyExists, got %v", err)
+	}
+	_, err = client.Pay(ctx, &walletpb.PayRequest{AccountId: account.Id, Amount: 500})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("expected code FailedPrecondition, got %v", err)
+	}
+	_, err = client.Deposit(ctx, &walletpb.DepositRequest{AccountId: account.Id, Amount: -1})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected code InvalidArgument, got %v", err)
+	}
+	_, err = client.GetPayment(ctx, &walletpb.PaymentRequest{PaymentId: "unknown"})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("expected code NotFound, got %v", err)
+	}
+}
+
+func TestCodeOf(t *testing.T) {
+	tests := []struct {
+		err  error
+		want codes.Code
+	}{
+		{wallet.ErrAccountNotFound, codes.NotFound},
+		{wallet.ErrFavoriteNotFound, codes.NotFound},
+		{wallet.ErrNotEnoughBalance, codes.FailedPrecondition},
+		{wallet.ErrPhoneRegistered, codes.AlreadyExists},
+		{wallet.ErrAmountMustBePositive, codes.InvalidArgument},
+		{wallet.ErrHoldNotFound, codes.NotFound},
+		{wallet.ErrHoldNotActive, codes.FailedPrecondition},
+		{wallet.ErrRefundExceedsPayment, codes.InvalidArgument},
+		{&wallet.TransitionError{From: types.PaymentStatusFail, To: types.PaymentStatusOk}, codes.FailedPrecondition},
+		{errors.New("disk full"), codes.Internal},
+	}
+
+	for _, tt := range tests {
+		if got := codeOf(tt.err); got != tt.want {
+			t.Errorf("expected code %v for %v, got %v", tt.want, tt.err, got)
+		}
+	}
+}
@@ -0,0 +1,953 @@
+// Package rest - HTTP JSON API над wallet.Service.
+//
+// Суммы передаются целыми числами в минимальных единицах валюты (дирамы,
+// центы). Ошибки возвращаются телом {"error": "..."} со статусом, который
+// зависит от ошибки сервиса, см. statusOf.
+package rest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/akmalsulaymonov/alif-wallet/pkg/types"
+	"github.com/akmalsulaymonov/alif-wallet/pkg/wallet"
+)
+
+// Максимальный размер тела запроса.
+const maxBodySize = 1 << 20
+
+// Заголовок с ключом идемпотентности для пополнений и платежей.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+var errInvalidID = errors.New("invalid id")
+var errInvalidBody = errors.New("invalid request body")
+var errInvalidDelimiter = errors.New("invalid delimiter")
+
+type handler struct {
+	svc *wallet.Service
+}
+
+// NewHandler возвращает обработчик HTTP API для сервиса svc.
+func NewHandler(svc *wallet.Service) http.Handler {
+	h := &handler{svc: svc}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /accounts", h.registerAccount)
+	mux.HandleFunc("GET /accounts/{id}", h.getAccount)
+	mux.HandleFunc("POST /accounts/{id}/deposits", h.deposit)
+	mux.HandleFunc("POST /accounts/{id}/payments", h.pay)
+	mux.HandleFunc("GET /accounts/{id}/history", h.history)
+	mux.HandleFunc("GET /accounts/{id}/statement", h.statement)
+	mux.HandleFunc("POST /accounts/{id}/holds", h.authorize)
+	mux.HandleFunc("GET /accounts/{id}/schedules", h.accountSchedules)
+	mux.HandleFunc("GET /accounts/{id}/favorites", h.accountFavorites)
+	mux.HandleFunc("PUT /accounts/{id}/favorites/order", h.reorderFavorites)
+
+	mux.HandleFunc("GET /payments/{id}", h.getPayment)
+	mux.HandleFunc("POST /payments/{id}/reject", h.changeStatus((*wallet.Service).Reject))
+	mux.HandleFunc("POST /payments/{id}/confirm", h.changeStatus((*wallet.Service).Confirm))
+	mux.HandleFunc("POST /payments/{id}/cancel", h.changeStatus((*wallet.Service).Cancel))
+	mux.HandleFunc("POST /payments/{id}/refund", h.changeStatus((*wallet.Service).Refund))
+	mux.HandleFunc("POST /payments/{id}/refunds", h.refund)
+	mux.HandleFunc("GET /payments/{id}/refunds", h.refunds)
+	mux.HandleFunc("POST /payments/{id}/repeat", h.repeat)
+	mux.HandleFunc("POST /payments/{id}/favorites", h.addFavorite)
+
+	mux.HandleFunc("GET /favorites/{id}", h.getFavorite)
+	mux.HandleFunc("PATCH /favorites/{id}", h.updateFavorite)
+	mux.HandleFunc("DELETE /favorites/{id}", h.deleteFavorite)
+	mux.HandleFunc("POST /favorites/{id}/payments", h.payFromFavorite)
+	mux.HandleFunc("POST /favorites/{id}/schedules", h.scheduleFavorite)
+
+	mux.HandleFunc("POST /transfers", h.transfer)
+	mux.HandleFunc("GET /transfers/{id}", h.getTransfer)
+	mux.HandleFunc("POST /transfers/{id}/reverse", h.reverseTransfer)
+
+	mux.HandleFunc("GET /holds/{id}", h.getHold)
+	mux.HandleFunc("POST /holds/{id}/capture", h.capture)
+	mux.HandleFunc("POST /holds/{id}/void", h.void)
+
+	mux.HandleFunc("GET /schedules/{id}", h.getSchedule)
+	mux.HandleFunc("POST /schedules/{id}/cancel", h.cancelSchedule)
+	mux.HandleFunc("GET /schedules/{id}/runs", h.scheduleRuns)
+	return mux
+}
+
+type accountResponse struct {
+	ID        int64          `json:"id"`
+	Phone     types.Phone    `json:"phone"`
+	Balance   types.Money    `json:"balance"`
+	Held      types.Money    `json:"held"`
+	Available types.Money    `json:"available"`
+	Currency  types.Currency `json:"currency"`
+	CreatedAt *time.Time     `json:"created_at,omitempty"`
+	UpdatedAt *time.Time     `json:"updated_at,omitempty"`
+}
+
+type paymentResponse struct {
+	ID              string                `json:"id"`
+	AccountID       int64                 `json:"account_id"`
+	Amount          types.Money           `json:"amount"`
+	Currency        types.Currency        `json:"currency"`
+	Category        types.PaymentCategory `json:"category"`
+	Status          types.PaymentStatus   `json:"status"`
+	TargetAmount    types.Money           `json:"target_amount,omitempty"`
+	TargetCurrency  types.Currency        `json:"target_currency,omitempty"`
+	Rate            string                `json:"rate,omitempty"`
+	CreatedAt       *time.Time            `json:"created_at,omitempty"`
+	UpdatedAt       *time.Time            `json:"updated_at,omitempty"`
+	StatusChangedAt *time.Time            `json:"status_changed_at,omitempty"`
+}
+
+type favoriteResponse struct {
+	ID        string                `json:"id"`
+	AccountID int64                 `json:"account_id"`
+	Name      string                `json:"name"`
+	Amount    types.Money           `json:"amount"`
+	Currency  types.Currency        `json:"currency"`
+	Category  types.PaymentCategory `json:"category"`
+	Position  int                   `json:"position"`
+	CreatedAt *time.Time            `json:"created_at,omitempty"`
+	UpdatedAt *time.Time            `json:"updated_at,omitempty"`
+}
+
+type transferResponse struct {
+	ID             string              `json:"id"`
+	FromAccountID  int64               `json:"from_account_id"`
+	ToAccountID    int64               `json:"to_account_id"`
+	Amount         types.Money         `json:"amount"`
+	Currency       types.Currency      `json:"currency"`
+	Comment        string              `json:"comment,omitempty"`
+	Status         types.PaymentStatus `json:"status"`
+	TargetAmount   types.Money         `json:"target_amount,omitempty"`
+	TargetCurrency types.Currency      `json:"target_currency,omitempty"`
+	Rate           string              `json:"rate,omitempty"`
+	CreatedAt      *time.Time          `json:"created_at,omitempty"`
+	UpdatedAt      *time.Time          `json:"updated_at,omitempty"`
+}
+
+type refundResponse struct {
+	ID             string         `json:"id"`
+	PaymentID      string         `json:"payment_id"`
+	AccountID      int64          `json:"account_id"`
+	Amount         types.Money    `json:"amount"`
+	Currency       types.Currency `json:"currency"`
+	TargetAmount   types.Money    `json:"target_amount,omitempty"`
+	TargetCurrency types.Currency `json:"target_currency,omitempty"`
+	Reason         string         `json:"reason,omitempty"`
+	CreatedAt      *time.Time     `json:"created_at,omitempty"`
+}
+
+type holdResponse struct {
+	ID             string                `json:"id"`
+	AccountID      int64                 `json:"account_id"`
+	Amount         types.Money           `json:"amount"`
+	Currency       types.Currency        `json:"currency"`
+	Category       types.PaymentCategory `json:"category"`
+	Status         types.HoldStatus      `json:"status"`
+	CapturedAmount types.Money           `json:"captured_amount,omitempty"`
+	PaymentID      string                `json:"payment_id,omitempty"`
+	ExpiresAt      time.Time             `json:"expires_at"`
+	CreatedAt      *time.Time            `json:"created_at,omitempty"`
+	UpdatedAt      *time.Time            `json:"updated_at,omitempty"`
+}
+
+type scheduleResponse struct {
+	ID         string             `json:"id"`
+	FavoriteID string             `json:"favorite_id"`
+	AccountID  int64              `json:"account_id"`
+	Kind       types.ScheduleKind `json:"kind"`
+	Start      time.Time          `json:"start"`
+	Cron       string             `json:"cron,omitempty"`
+	Active     bool               `json:"active"`
+	DueAt      *time.Time         `json:"due_at,omitempty"`
+	RetryAt    *time.Time         `json:"retry_at,omitempty"`
+	Attempts   int                `json:"attempts,omitempty"`
+	CreatedAt  *time.Time         `json:"created_at,omitempty"`
+	UpdatedAt  *time.Time         `json:"updated_at,omitempty"`
+}
+
+type scheduleRunResponse struct {
+	ID         string                  `json:"id"`
+	ScheduleID string                  `json:"schedule_id"`
+	AccountID  int64                   `json:"account_id"`
+	DueAt      time.Time               `json:"due_at"`
+	Attempt    int                     `json:"attempt"`
+	Status     types.ScheduleRunStatus `json:"status"`
+	PaymentID  string                  `json:"payment_id,omitempty"`
+	Error      string                  `json:"error,omitempty"`
+	At         time.Time               `json:"at"`
+}
+
+// timestamp возвращает nil для неизвестного (нулевого) времени,
+// чтобы поле не попало в ответ.
+func timestamp(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+func newAccountResponse(account types.Account) accountResponse {
+	return accountResponse{
+		ID:        account.ID,
+		Phone:     account.Phone,
+		Balance:   account.Balance,
+		Held:      account.Held,
+		Available: account.Available(),
+		Currency:  account.Currency,
+		CreatedAt: timestamp(account.CreatedAt),
+		UpdatedAt: timestamp(account.UpdatedAt),
+	}
+}
+
+func newPaymentResponse(payment types.Payment) paymentResponse {
+	return paymentResponse{
+		ID:              payment.ID,
+		AccountID:       payment.AccountID,
+		Amount:          payment.Amount,
+		Currency:        payment.Currency,
+		Category:        payment.Category,
+		Status:          payment.Status,
+		TargetAmount:    payment.TargetAmount,
+		TargetCurrency:  payment.TargetCurrency,
+		Rate:            payment.Rate,
+		CreatedAt:       timestamp(payment.CreatedAt),
+		UpdatedAt:       timestamp(payment.UpdatedAt),
+		StatusChangedAt: timestamp(payment.StatusChangedAt),
+	}
+}
+
+func newFavoriteResponse(favorite types.Favorite) favoriteResponse {
+	return favoriteResponse{
+		ID:        favorite.ID,
+		AccountID: favorite.AccountID,
+		Name:      favorite.Name,
+		Amount:    favorite.Amount,
+		Currency:  favorite.Currency,
+		Category:  favorite.Category,
+		Position:  favorite.Position,
+		CreatedAt: timestamp(favorite.CreatedAt),
+		UpdatedAt: timestamp(favorite.UpdatedAt),
+	}
+}
+
+func newTransferResponse(transfer types.Transfer) transferResponse {
+	return transferResponse{
+		ID:             transfer.ID,
+		FromAccountID:  transfer.FromAccountID,
+		ToAccountID:    transfer.ToAccountID,
+		Amount:         transfer.Amount,
+		Currency:       transfer.Currency,
+		Comment:        transfer.Comment,
+		Status:         transfer.Status,
+		TargetAmount:   transfer.TargetAmount,
+		TargetCurrency: transfer.TargetCurrency,
+		Rate:           transfer.Rate,
+		CreatedAt:      timestamp(transfer.CreatedAt),
+		UpdatedAt:      timestamp(transfer.UpdatedAt),
+	}
+}
+
+func newRefundResponse(refund types.Refund) refundResponse {
+	return refundResponse{
+		ID:             refund.ID,
+		PaymentID:      refund.PaymentID,
+		AccountID:      refund.AccountID,
+		Amount:         refund.Amount,
+		Currency:       refund.Currency,
+		TargetAmount:   refund.TargetAmount,
+		TargetCurrency: refund.TargetCurrency,
+		Reason:         refund.Reason,
+		CreatedAt:      timestamp(refund.CreatedAt),
+	}
+}
+
+func newHoldResponse(hold types.Hold) holdResponse {
+	return holdResponse{
+		ID:             hold.ID,
+		AccountID:      hold.AccountID,
+		Amount:         hold.Amount,
+		Currency:       hold.Currency,
+		Category:       hold.Category,
+		Status:         hold.Status,
+		CapturedAmount: hold.CapturedAmount,
+		PaymentID:      hold.PaymentID,
+		ExpiresAt:      hold.ExpiresAt,
+		CreatedAt:      timestamp(hold.CreatedAt),
+		UpdatedAt:      timestamp(hold.UpdatedAt),
+	}
+}
+
+func newScheduleResponse(schedule types.Schedule) scheduleResponse {
+	return scheduleResponse{
+		ID:         schedule.ID,
+		FavoriteID: schedule.FavoriteID,
+		AccountID:  schedule.AccountID,
+		Kind:       schedule.Kind,
+		Start:      schedule.Start,
+		Cron:       schedule.Cron,
+		Active:     schedule.Active,
+		DueAt:      timestamp(schedule.DueAt),
+		RetryAt:    timestamp(schedule.RetryAt),
+		Attempts:   schedule.Attempts,
+		CreatedAt:  timestamp(schedule.CreatedAt),
+		UpdatedAt:  timestamp(schedule.UpdatedAt),
+	}
+}
+
+func newScheduleRunResponse(run types.ScheduleRun) scheduleRunResponse {
+	return scheduleRunResponse{
+		ID:         run.ID,
+		ScheduleID: run.ScheduleID,
+		AccountID:  run.AccountID,
+		DueAt:      run.DueAt,
+		Attempt:    run.Attempt,
+		Status:     run.Status,
+		PaymentID:  run.PaymentID,
+		Error:      run.Error,
+		At:         run.At,
+	}
+}
+
+type registerRequest struct {
+	Phone    types.Phone    `json:"phone"`
+	Currency types.Currency `json:"currency"`
+}
+
+func (h *handler) registerAccount(w http.ResponseWriter, r *http.Request) {
+	var req registerRequest
+	if err := decode(w, r, &req); err != nil {
+		writeError(w, err)
+		return
+	}
+	if req.Currency == "" {
+		req.Currency = types.DefaultCurrency
+	}
+
+	account, err := h.svc.RegisterAccountWithCurrency(req.Phone, req.Currency)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	h.writeAccount(w, http.StatusCreated, account.ID)
+}
+
+func (h *handler) getAccount(w http.ResponseWriter, r *http.Request) {
+	accountID, err := pathID(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	h.writeAccount(w, http.StatusOK, accountID)
+}
+
+// amountRequest - сумма без валюты означает валюту аккаунта.
+type amountRequest struct {
+	Amount   types.Money           `json:"amount"`
+	Currency types.Currency        `json:"currency"`
+	Category types.PaymentCategory `json:"category"`
+}
+
+// amount возвращает сумму запроса, подставляя валюту аккаунта.
+func (h *handler) amount(accountID int64, req amountRequest) (types.Amount, error) {
+	if req.Currency == "" {
+		account, err := h.svc.FindAccountByID(accountID)
+		if err != nil {
+			return types.Amount{}, err
+		}
+		req.Currency = account.Currency
+	}
+	return types.Amount{Value: req.Amount, Currency: req.Currency}, nil
+}
+
+func (h *handler) deposit(w http.ResponseWriter, r *http.Request) {
+	accountID, err := pathID(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	var req amountRequest
+	if err := decode(w, r, &req); err != nil {
+		writeError(w, err)
+		return
+	}
+	amount, err := h.amount(accountID, req)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := h.svc.DepositWithKey(r.Header.Get(IdempotencyKeyHeader), accountID, amount); err != nil {
+		writeError(w, err)
+		return
+	}
+	h.writeAccount(w, http.StatusOK, accountID)
+}
+
+func (h *handler) pay(w http.ResponseWriter, r *http.Request) {
+	accountID, err := pathID(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	var req amountRequest
+	if err := decode(w, r, &req); err != nil {
+		writeError(w, err)
+		return
+	}
+	amount, err := h.amount(accountID, req)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	payment, err := h.svc.PayWithKey(r.Header.Get(IdempotencyKeyHeader), accountID, amount, req.Category)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	h.writePayment(w, http.StatusCreated, payment.ID)
+}
+
+func (h *handler) history(w http.ResponseWriter, r *http.Request) {
+	accountID, err := pathID(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	history, err := h.svc.ExportAccountHistory(accountID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	response := make([]paymentResponse, 0, len(history))
+	for _, payment := range history {
+		response = append(response, newPaymentResponse(payment))
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// statement отдаёт выписку в CSV. Параметры запроса: locale (например ru-RU)
+// и delimiter - один символ вместо разделителя локали.
+func (h *handler) statement(w http.ResponseWriter, r *http.Request) {
+	accountID, err := pathID(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	options := wallet.StatementOptions{Locale: r.URL.Query().Get("locale"), BOM: true}
+	if delimiter := []rune(r.URL.Query().Get("delimiter")); len(delimiter) > 1 || strings.ContainsAny(string(delimiter), "\"\r\n") {
+		writeError(w, errInvalidDelimiter)
+		return
+	} else if len(delimiter) == 1 {
+		options.Comma = delimiter[0]
+	}
+
+	statement, err := h.svc.Statement(accountID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	// Выписка собирается целиком, чтобы ошибка не пришла после статуса 200
+	var body bytes.Buffer
+	if err := statement.WriteCSV(&body, options); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="statement-%d.csv"`, accountID))
+	w.WriteHeader(http.StatusOK)
+	w.Write(body.Bytes())
+}
+
+func (h *handler) getPayment(w http.ResponseWriter, r *http.Request) {
+	h.writePayment(w, http.StatusOK, r.PathValue("id"))
+}
+
+// changeStatus возвращает обработчик, меняющий статус платежа методом change.
+func (h *handler) changeStatus(change func(*wallet.Service, string) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		paymentID := r.PathValue("id")
+		if err := change(h.svc, paymentID); err != nil {
+			writeError(w, err)
+			return
+		}
+		h.writePayment(w, http.StatusOK, paymentID)
+	}
+}
+
+func (h *handler) repeat(w http.ResponseWriter, r *http.Request) {
+	payment, err := h.svc.Repeat(r.PathValue("id"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	h.writePayment(w, http.StatusCreated, payment.ID)
+}
+
+// refundRequest - сумма в валюте платежа.
+type refundRequest struct {
+	Amount types.Money `json:"amount"`
+	Reason string      `json:"reason"`
+}
+
+// refund возвращает часть суммы платежа.
+func (h *handler) refund(w http.ResponseWriter, r *http.Request) {
+	var req refundRequest
+	if err := decode(w, r, &req); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	refund, err := h.svc.RefundAmount(r.PathValue("id"), req.Amount, req.Reason)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, newRefundResponse(*refund))
+}
+
+func (h *handler) refunds(w http.ResponseWriter, r *http.Request) {
+	refunds, err := h.svc.PaymentRefunds(r.PathValue("id"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	response := make([]refundResponse, 0, len(refunds))
+	for _, refund := range refunds {
+		response = append(response, newRefundResponse(refund))
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+type favoriteRequest struct {
+	Name string `json:"name"`
+}
+
+func (h *handler) addFavorite(w http.ResponseWriter, r *http.Request) {
+	var req favoriteRequest
+	if err := decode(w, r, &req); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	favorite, err := h.svc.FavoritePayment(r.PathValue("id"), req.Name)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, newFavoriteResponse(*favorite))
+}
+
+func (h *handler) getFavorite(w http.ResponseWriter, r *http.Request) {
+	h.writeFavorite(w, http.StatusOK, r.PathValue("id"))
+}
+
+// favoriteUpdateRequest - отсутствующие поля не меняются.
+type favoriteUpdateRequest struct {
+	Name     *string                `json:"name"`
+	Amount   *types.Money           `json:"amount"`
+	Category *types.PaymentCategory `json:"category"`
+}
+
+func (h *handler) updateFavorite(w http.ResponseWriter, r *http.Request) {
+	var req favoriteUpdateRequest
+	if err := decode(w, r, &req); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	favorite, err := h.svc.UpdateFavorite(r.PathValue("id"), wallet.FavoriteChanges{
+		Name:     req.Name,
+		Amount:   req.Amount,
+		Category: req.Category,
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	h.writeFavorite(w, http.StatusOK, favorite.ID)
+}
+
+func (h *handler) deleteFavorite(w http.ResponseWriter, r *http.Request) {
+	if err := h.svc.DeleteFavorite(r.PathValue("id")); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *handler) accountFavorites(w http.ResponseWriter, r *http.Request) {
+	accountID, err := pathID(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	favorites, err := h.svc.AccountFavorites(accountID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeFavorites(w, favorites)
+}
+
+// favoriteOrderRequest - ID всего избранного аккаунта в новом порядке.
+type favoriteOrderRequest struct {
+	IDs []string `json:"ids"`
+}
+
+func (h *handler) reorderFavorites(w http.ResponseWriter, r *http.Request) {
+	accountID, err := pathID(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	var req favoriteOrderRequest
+	if err := decode(w, r, &req); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	favorites, err := h.svc.ReorderFavorites(accountID, req.IDs)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeFavorites(w, favorites)
+}
+
+func writeFavorites(w http.ResponseWriter, favorites []types.Favorite) {
+	response := make([]favoriteResponse, 0, len(favorites))
+	for _, favorite := range favorites {
+		response = append(response, newFavoriteResponse(favorite))
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+func (h *handler) payFromFavorite(w http.ResponseWriter, r *http.Request) {
+	payment, err := h.svc.PayFromFavoriteWithKey(r.Header.Get(IdempotencyKeyHeader), r.PathValue("id"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	h.writePayment(w, http.StatusCreated, payment.ID)
+}
+
+// transferRequest - получатель задаётся ID аккаунта или телефоном.
+type transferRequest struct {
+	FromAccountID int64       `json:"from_account_id"`
+	ToAccountID   int64       `json:"to_account_id"`
+	ToPhone       types.Phone `json:"to_phone"`
+	Amount        types.Money `json:"amount"`
+	Comment       string      `json:"comment"`
+}
+
+func (h *handler) transfer(w http.ResponseWriter, r *http.Request) {
+	var req transferRequest
+	if err := decode(w, r, &req); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	var transfer *types.Transfer
+	var err error
+	if req.ToPhone != "" {
+		transfer, err = h.svc.TransferByPhone(req.FromAccountID, req.ToPhone, req.Amount, req.Comment)
+	} else {
+		transfer, err = h.svc.Transfer(req.FromAccountID, req.ToAccountID, req.Amount, req.Comment)
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	h.writeTransfer(w, http.StatusCreated, transfer.ID)
+}
+
+func (h *handler) getTransfer(w http.ResponseWriter, r *http.Request) {
+	h.writeTransfer(w, http.StatusOK, r.PathValue("id"))
+}
+
+func (h *handler) reverseTransfer(w http.ResponseWriter, r *http.Request) {
+	transferID := r.PathValue("id")
+	if err := h.svc.ReverseTransfer(transferID); err != nil {
+		writeError(w, err)
+		return
+	}
+	h.writeTransfer(w, http.StatusOK, transferID)
+}
+
+// holdRequest - ttl в формате time.ParseDuration, например "2h",
+// пустой - срок по умолчанию.
+type holdRequest struct {
+	Amount   types.Money           `json:"amount"`
+	Category types.PaymentCategory `json:"category"`
+	TTL      string                `json:"ttl"`
+}
+
+func (h *handler) authorize(w http.ResponseWriter, r *http.Request) {
+	accountID, err := pathID(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	var req holdRequest
+	if err := decode(w, r, &req); err != nil {
+		writeError(w, err)
+		return
+	}
+	var ttl time.Duration
+	if req.TTL != "" {
+		if ttl, err = time.ParseDuration(req.TTL); err != nil || ttl <= 0 {
+			writeError(w, errInvalidBody)
+			return
+		}
+	}
+
+	hold, err := h.svc.Authorize(accountID, req.Amount, req.Category, ttl)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	h.writeHold(w, http.StatusCreated, hold.ID)
+}
+
+func (h *handler) getHold(w http.ResponseWriter, r *http.Request) {
+	h.writeHold(w, http.StatusOK, r.PathValue("id"))
+}
+
+type captureRequest struct {
+	Amount types.Money `json:"amount"`
+}
+
+// capture списывает по блокировке и возвращает созданный платёж.
+func (h *handler) capture(w http.ResponseWriter, r *http.Request) {
+	var req captureRequest
+	if err := decode(w, r, &req); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	payment, err := h.svc.Capture(r.PathValue("id"), req.Amount)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	h.writePayment(w, http.StatusCreated, payment.ID)
+}
+
+func (h *handler) void(w http.ResponseWriter, r *http.Request) {
+	holdID := r.PathValue("id")
+	if err := h.svc.Void(holdID); err != nil {
+		writeError(w, err)
+		return
+	}
+	h.writeHold(w, http.StatusOK, holdID)
+}
+
+// scheduleRequest - start в RFC 3339, пустой - сейчас;
+// cron - только для вида CRON.
+type scheduleRequest struct {
+	Kind  types.ScheduleKind `json:"kind"`
+	Start time.Time          `json:"start"`
+	Cron  string             `json:"cron"`
+}
+
+func (h *handler) scheduleFavorite(w http.ResponseWriter, r *http.Request) {
+	var req scheduleRequest
+	if err := decode(w, r, &req); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	schedule, err := h.svc.ScheduleFavorite(r.PathValue("id"), req.Kind, req.Start, req.Cron)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	h.writeSchedule(w, http.StatusCreated, schedule.ID)
+}
+
+func (h *handler) getSchedule(w http.ResponseWriter, r *http.Request) {
+	h.writeSchedule(w, http.StatusOK, r.PathValue("id"))
+}
+
+func (h *handler) cancelSchedule(w http.ResponseWriter, r *http.Request) {
+	scheduleID := r.PathValue("id")
+	if err := h.svc.CancelSchedule(scheduleID); err != nil {
+		writeError(w, err)
+		return
+	}
+	h.writeSchedule(w, http.StatusOK, scheduleID)
+}
+
+func (h *handler) accountSchedules(w http.ResponseWriter, r *http.Request) {
+	accountID, err := pathID(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	schedules, err := h.svc.AccountSchedules(accountID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	response := make([]scheduleResponse, 0, len(schedules))
+	for _, schedule := range schedules {
+		response = append(response, newScheduleResponse(schedule))
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+func (h *handler) scheduleRuns(w http.ResponseWriter, r *http.Request) {
+	runs, err := h.svc.ScheduleRuns(r.PathValue("id"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	response := make([]scheduleRunResponse, 0, len(runs))
+	for _, run := range runs {
+		response = append(response, newScheduleRunResponse(run))
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+func (h *handler) writeAccount(w http.ResponseWriter, status int, accountID int64) {
+	account, err := h.svc.FindAccountByID(accountID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, status, newAccountResponse(*account))
+}
+
+func (h *handler) writePayment(w http.ResponseWriter, status int, paymentID string) {
+	payment, err := h.svc.FindPaymentByID(paymentID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, status, newPaymentResponse(*payment))
+}
+
+func (h *handler) writeTransfer(w http.ResponseWriter, status int, transferID string) {
+	transfer, err := h.svc.FindTransferByID(transferID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, status, newTransferResponse(*transfer))
+}
+
+func (h *handler) writeHold(w http.ResponseWriter, status int, holdID string) {
+	hold, err := h.svc.FindHoldByID(holdID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, status, newHoldResponse(*hold))
+}
+
+func (h *handler) writeFavorite(w http.ResponseWriter, status int, favoriteID string) {
+	favorite, err := h.svc.FindFavoriteByID(favoriteID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, status, newFavoriteResponse(*favorite))
+}
+
+func (h *handler) writeSchedule(w http.ResponseWriter, status int, scheduleID string) {
+	schedule, err := h.svc.FindScheduleByID(scheduleID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, status, newScheduleResponse(*schedule))
+}
+
+func pathID(r *http.Request) (int64, error) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		return 0, errInvalidID
+	}
+	return id, nil
+}
+
+func decode(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return errInvalidBody
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	status := statusOf(err)
+	message := err.Error()
+	if status == http.StatusInternalServerError {
+		// Детали внутренних ошибок клиенту не показываем
+		message = http.StatusText(status)
+	}
+	writeJSON(w, status, errorResponse{Error: message})
+}
+
+// statusOf возвращает HTTP-статус для ошибки сервиса.
+func statusOf(err error) int {
+	switch {
+	case errors.Is(err, errInvalidID),
+		errors.Is(err, errInvalidBody),
+		errors.Is(err, errInvalidDelimiter),
+		errors.Is(err, wallet.ErrUnknownLocale),
+		errors.Is(err, wallet.ErrAmountMustBePositive),
+		errors.Is(err, wallet.ErrUnknownCurrency),
+		errors.Is(err, wallet.ErrSameAccount),
+		errors.Is(err, wallet.ErrInvalidSchedule),
+		errors.Is(err, wallet.ErrInvalidCron),
+		errors.Is(err, wallet.ErrInvalidFavoriteOrder):
+		return http.StatusBadRequest
+	case errors.Is(err, wallet.ErrAccountNotFound),
+		errors.Is(err, wallet.ErrPaymentNotFound),
+		errors.Is(err, wallet.ErrFavoriteNotFound),
+		errors.Is(err, wallet.ErrTransferNotFound),
+		errors.Is(err, wallet.ErrHoldNotFound),
+		errors.Is(err, wallet.ErrRefundNotFound),
+		errors.Is(err, wallet.ErrScheduleNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, wallet.ErrPhoneRegistered),
+		errors.Is(err, wallet.ErrFavoriteNameTaken),
+		errors.Is(err, wallet.ErrIdempotencyConflict),
+		errors.Is(err, wallet.ErrIllegalTransition),
+		errors.Is(err, wallet.ErrInvalidPaymentStatus),
+		errors.Is(err, wallet.ErrHoldNotActive),
+		errors.Is(err, wallet.ErrHoldExpired):
+		return http.StatusConflict
+	case errors.Is(err, wallet.ErrNotEnoughBalance),
+		errors.Is(err, wallet.ErrCurrencyMismatch),
+		errors.Is(err, wallet.ErrRateNotFound),
+		errors.Is(err, wallet.ErrCaptureExceedsHold),
+		errors.Is(err, wallet.ErrRefundExceedsPayment):
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusInternalServerError
+}
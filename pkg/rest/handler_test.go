@@ -0,0 +1,516 @@
+package rest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/akmalsulaymonov/alif-wallet/pkg/types"
+	"github.com/akmalsulaymonov/alif-wallet/pkg/wallet"
+)
+
+type client struct {
+	t      *testing.T
+	server *httptest.Server
+}
+
+func newClient(t *testing.T) *client {
+	t.Helper()
+	server := httptest.NewServer(NewHandler(&wallet.Service{}))
+	t.Cleanup(server.Close)
+	return &client{t: t, server: server}
+}
+
+// do выполняет запрос и декодирует тело ответа в out, если out не nil.
+func (c *client) do(method, path string, body interface{}, headers map[string]string, out interface{}) int {
+	c.t.Helper()
+
+	var reader bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&reader).Encode(body); err != nil {
+			c.t.Fatalf("failed to encode body: %v", err)
+		}
+	}
+	req, err := http.NewRequest(method, c.server.URL+path, &reader)
+	if err != nil {
+		c.t.Fatalf("failed to create request: %v", err)
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := c.server.Client().Do(req)
+	if err != nil {
+		c.t.Fatalf("failed to do request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			c.t.Fatalf("failed to decode response: %v", err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func (c *client) register(phone string) accountResponse {
+	c.t.Helper()
+	var account accountResponse
+	if status := c.do("POST", "/accounts", registerRequest{Phone: types.Phone(phone)}, nil, &account); status != http.StatusCreated {
+		c.t.Fatalf("expected status 201, got %d", status)
+	}
+	return account
+}
+
+func (c *client) deposit(accountID int64, amount types.Money) {
+	c.t.Helper()
+	path := fmt.Sprintf("/accounts/%d/deposits", accountID)
+	if status := c.do("POST", path, amountRequest{Amount: amount}, nil, nil); status != http.StatusOK {
+		c.t.Fatalf("expected status 200, got %d", status)
+	}
+}
+
+func (c *client) pay(accountID int64, amount types.Money, key string) (paymentResponse, int) {
+	c.t.Helper()
+	var headers map[string]string
+	if key != "" {
+		headers = map[string]string{IdempotencyKeyHeader: key}
+	}
+	var payment paymentResponse
+	path := fmt.Sprintf("/accounts/%d/payments", accountID)
+	status := c.do("POST", path, amountRequest{Amount: amount, Category: "food"}, headers, &payment)
+	return payment, status
+}
+
+func TestHandler_Accounts(t *testing.T) {
+	c := newClient(t)
+
+	account := c.register("+992000000001")
+	if account.ID != 1 || account.Balance != 0 || account.Currency != types.DefaultCurrency {
+		t.Errorf("unexpected account %+v", account)
+	}
+
+	var got accountResponse
+	if status := c.do("GET", "/accounts/1", nil, nil, &got); status != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", status)
+	}
+	if !reflect.DeepEqual(got, account) || account.CreatedAt == nil {
+		t.Errorf("expected account %+v, got %+v", account, got)
+	}
+
+	var failure errorResponse
+	status := c.do("POST", "/accounts", registerRequest{Phone: "+992000000001"}, nil, &failure)
+	if status != http.StatusConflict || failure.Error != wallet.ErrPhoneRegistered.Error() {
+		t.Errorf("expected 409 %q, got %d %q", wallet.ErrPhoneRegistered, status, failure.Error)
+	}
+	if status := c.do("GET", "/accounts/42", nil, nil, nil); status != http.StatusNotFound {
+		t.Errorf("expected status 404, got %d", status)
+	}
+	if status := c.do("GET", "/accounts/abc", nil, nil, nil); status != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d", status)
+	}
+	status = c.do("POST", "/accounts", map[string]string{"phone": "+992000000002", "currency": "XXX"}, nil, nil)
+	if status != http.StatusBadRequest {
+		t.Errorf("expected status 400 for unknown currency, got %d", status)
+	}
+	status = c.do("POST", "/accounts", map[string]string{"telephone": "+992000000002"}, nil, nil)
+	if status != http.StatusBadRequest {
+		t.Errorf("expected status 400 for unknown field, got %d", status)
+	}
+}
+
+func TestHandler_Payments(t *testing.T) {
+	c := newClient(t)
+	account := c.register("+992000000001")
+	c.deposit(account.ID, 1000)
+
+	payment, status := c.pay(account.ID, 300, "")
+	if status != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d", status)
+	}
+	if payment.Amount != 300 || payment.Status != types.PaymentStatusInProgress {
+		t.Errorf("unexpected payment %+v", payment)
+	}
+
+	if _, status := c.pay(account.ID, 5000, ""); status != http.StatusUnprocessableEntity {
+		t.Errorf("expected status 422 for not enough balance, got %d", status)
+	}
+	if _, status := c.pay(account.ID, 0, ""); status != http.StatusBadRequest {
+		t.Errorf("expected status 400 for zero amount, got %d", status)
+	}
+	if _, status := c.pay(42, 100, ""); status != http.StatusNotFound {
+		t.Errorf("expected status 404 for unknown account, got %d", status)
+	}
+
+	var rejected paymentResponse
+	if status := c.do("POST", "/payments/"+payment.ID+"/reject", nil, nil, &rejected); status != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", status)
+	}
+	if rejected.Status != types.PaymentStatusFail {
+		t.Errorf("expected status FAIL, got %v", rejected.Status)
+	}
+	if status := c.do("POST", "/payments/"+payment.ID+"/reject", nil, nil, nil); status != http.StatusConflict {
+		t.Errorf("expected status 409 for second reject, got %d", status)
+	}
+	if status := c.do("POST", "/payments/unknown/reject", nil, nil, nil); status != http.StatusNotFound {
+		t.Errorf("expected status 404, got %d", status)
+	}
+
+	var repeated paymentResponse
+	if status := c.do("POST", "/payments/"+payment.ID+"/repeat", nil, nil, &repeated); status != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d", status)
+	}
+	if repeated.ID == payment.ID || repeated.Amount != 300 {
+		t.Errorf("unexpected repeated payment %+v", repeated)
+	}
+
+	var history []paymentResponse
+	if status := c.do("GET", fmt.Sprintf("/accounts/%d/history", account.ID), nil, nil, &history); status != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", status)
+	}
+	if len(history) != 2 {
+		t.Errorf("expected 2 history entries, got %d", len(history))
+	}
+
+	var got accountResponse
+	c.do("GET", fmt.Sprintf("/accounts/%d", account.ID), nil, nil, &got)
+	if got.Balance != 700 {
+		t.Errorf("expected balance 700, got %v", got.Balance)
+	}
+}
+
+func TestHandler_IdempotencyKey(t *testing.T) {
+	c := newClient(t)
+	account := c.register("+992000000001")
+	c.deposit(account.ID, 1000)
+
+	first, _ := c.pay(account.ID, 300, "key-1")
+	retried, status := c.pay(account.ID, 300, "key-1")
+	if status != http.StatusCreated || retried.ID != first.ID {
+		t.Errorf("expected original payment %v, got %d %v", first.ID, status, retried.ID)
+	}
+	if _, status := c.pay(account.ID, 400, "key-1"); status != http.StatusConflict {
+		t.Errorf("expected status 409 for reused key, got %d", status)
+	}
+
+	var got accountResponse
+	c.do("GET", fmt.Sprintf("/accounts/%d", account.ID), nil, nil, &got)
+	if got.Balance != 700 {
+		t.Errorf("expected balance 700, got %v", got.Balance)
+	}
+}
+
+func TestHandler_Favorites(t *testing.T) {
+	c := newClient(t)
+	account := c.register("+992000000001")
+	c.deposit(account.ID, 1000)
+	payment, _ := c.pay(account.ID, 300, "")
+
+	var favorite favoriteResponse
+	status := c.do("POST", "/payments/"+payment.ID+"/favorites", favoriteRequest{Name: "обед"}, nil, &favorite)
+	if status != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d", status)
+	}
+	if favorite.Name != "обед" || favorite.Amount != 300 {
+		t.Errorf("unexpected favorite %+v", favorite)
+	}
+
+	var paid paymentResponse
+	if status := c.do("POST", "/favorites/"+favorite.ID+"/payments", nil, nil, &paid); status != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d", status)
+	}
+	if paid.Amount != 300 || paid.Category != "food" {
+		t.Errorf("unexpected payment %+v", paid)
+	}
+	if status := c.do("POST", "/favorites/unknown/payments", nil, nil, nil); status != http.StatusNotFound {
+		t.Errorf("expected status 404, got %d", status)
+	}
+}
+
+func TestHandler_FavoritesManagement(t *testing.T) {
+	c := newClient(t)
+	account := c.register("+992000000001")
+	c.deposit(account.ID, 1000)
+	var lunch, taxi favoriteResponse
+	payment, _ := c.pay(account.ID, 300, "")
+	c.do("POST", "/payments/"+payment.ID+"/favorites", favoriteRequest{Name: "обед"}, nil, &lunch)
+	payment, _ = c.pay(account.ID, 100, "")
+	c.do("POST", "/payments/"+payment.ID+"/favorites", favoriteRequest{Name: "такси"}, nil, &taxi)
+	if status := c.do("POST", "/payments/"+payment.ID+"/favorites", favoriteRequest{Name: "Обед"}, nil, nil); status != http.StatusConflict {
+		t.Errorf("expected status 409, got %d", status)
+	}
+
+	name, amount := "ужин", types.Money(500)
+	var updated favoriteResponse
+	if status := c.do("PATCH", "/favorites/"+lunch.ID, favoriteUpdateRequest{Name: &name, Amount: &amount}, nil, &updated); status != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", status)
+	}
+	if updated.Name != "ужин" || updated.Amount != 500 || updated.Category != lunch.Category {
+		t.Errorf("unexpected favorite %+v", updated)
+	}
+
+	order := fmt.Sprintf("/accounts/%d/favorites/order", account.ID)
+	var favorites []favoriteResponse
+	if status := c.do("PUT", order, favoriteOrderRequest{IDs: []string{taxi.ID, lunch.ID}}, nil, &favorites); status != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", status)
+	}
+	if len(favorites) != 2 || favorites[0].ID != taxi.ID || favorites[0].Position != 1 || favorites[1].Position != 2 {
+		t.Errorf("unexpected favorites %+v", favorites)
+	}
+	if status := c.do("PUT", order, favoriteOrderRequest{IDs: []string{taxi.ID}}, nil, nil); status != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d", status)
+	}
+
+	if status := c.do("DELETE", "/favorites/"+taxi.ID, nil, nil, nil); status != http.StatusNoContent {
+		t.Fatalf("expected status 204, got %d", status)
+	}
+	c.do("GET", fmt.Sprintf("/accounts/%d/favorites", account.ID), nil, nil, &favorites)
+	if len(favorites) != 1 || favorites[0].ID != lunch.ID || favorites[0].Position != 1 {
+		t.Errorf("unexpected favorites %+v", favorites)
+	}
+	if status := c.do("GET", "/favorites/"+taxi.ID, nil, nil, nil); status != http.StatusNotFound {
+		t.Errorf("expected status 404, got %d", status)
+	}
+}
+
+func TestHandler_Transfers(t *testing.T) {
+	c := newClient(t)
+	from := c.register("+992000000001")
+	to := c.register("+992000000002")
+	c.deposit(from.ID, 1000)
+
+	var transfer transferResponse
+	req := transferRequest{FromAccountID: from.ID, ToPhone: to.Phone, Amount: 400, Comment: "долг"}
+	if status := c.do("POST", "/transfers", req, nil, &transfer); status != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d", status)
+	}
+	if transfer.ToAccountID != to.ID || transfer.Status != types.PaymentStatusOk {
+		t.Errorf("unexpected transfer %+v", transfer)
+	}
+
+	req = transferRequest{FromAccountID: from.ID, ToAccountID: from.ID, Amount: 100}
+	if status := c.do("POST", "/transfers", req, nil, nil); status != http.StatusBadRequest {
+		t.Errorf("expected status 400 for transfer to self, got %d", status)
+	}
+
+	var reversed transferResponse
+	if status := c.do("POST", "/transfers/"+transfer.ID+"/reverse", nil, nil, &reversed); status != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", status)
+	}
+	if reversed.Status != types.PaymentStatusRefunded {
+		t.Errorf("expected status REFUNDED, got %v", reversed.Status)
+	}
+}
+
+func TestHandler_Refunds(t *testing.T) {
+	c := newClient(t)
+	account := c.register("+992000000001")
+	c.deposit(account.ID, 1000)
+	payment, _ := c.pay(account.ID, 300, "")
+	c.do("POST", "/payments/"+payment.ID+"/confirm", nil, nil, nil)
+
+	var refund refundResponse
+	path := "/payments/" + payment.ID + "/refunds"
+	if status := c.do("POST", path, refundRequest{Amount: 100, Reason: "опоздание"}, nil, &refund); status != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d", status)
+	}
+	if refund.PaymentID != payment.ID || refund.Amount != 100 || refund.Reason != "опоздание" {
+		t.Errorf("unexpected refund %+v", refund)
+	}
+	if status := c.do("POST", path, refundRequest{Amount: 500}, nil, nil); status != http.StatusUnprocessableEntity {
+		t.Errorf("expected status 422, got %d", status)
+	}
+
+	var refunds []refundResponse
+	if status := c.do("GET", path, nil, nil, &refunds); status != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", status)
+	}
+	if !reflect.DeepEqual(refunds, []refundResponse{refund}) {
+		t.Errorf("expected refunds %+v, got %+v", []refundResponse{refund}, refunds)
+	}
+	var got accountResponse
+	c.do("GET", fmt.Sprintf("/accounts/%d", account.ID), nil, nil, &got)
+	if got.Balance != 800 {
+		t.Errorf("expected balance 800, got %v", got.Balance)
+	}
+	if status := c.do("GET", "/payments/unknown/refunds", nil, nil, nil); status != http.StatusNotFound {
+		t.Errorf("expected status 404, got %d", status)
+	}
+}
+
+func TestHandler_Holds(t *testing.T) {
+	c := newClient(t)
+	account := c.register("+992000000001")
+	c.deposit(account.ID, 1000)
+
+	var hold holdResponse
+	path := fmt.Sprintf("/accounts/%d/holds", account.ID)
+	if status := c.do("POST", path, holdRequest{Amount: 600, Category: "taxi", TTL: "2h"}, nil, &hold); status != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d", status)
+	}
+	if hold.Status != types.HoldStatusActive || hold.Amount != 600 {
+		t.Errorf("unexpected hold %+v", hold)
+	}
+	var got accountResponse
+	c.do("GET", fmt.Sprintf("/accounts/%d", account.ID), nil, nil, &got)
+	if got.Balance != 1000 || got.Held != 600 || got.Available != 400 {
+		t.Errorf("unexpected account %+v", got)
+	}
+	if status := c.do("POST", path, holdRequest{Amount: 100, TTL: "soon"}, nil, nil); status != http.StatusBadRequest {
+		t.Errorf("expected status 400 for invalid ttl, got %d", status)
+	}
+
+	if status := c.do("POST", "/holds/"+hold.ID+"/capture", captureRequest{Amount: 700}, nil, nil); status != http.StatusUnprocessableEntity {
+		t.Errorf("expected status 422, got %d", status)
+	}
+	var payment paymentResponse
+	if status := c.do("POST", "/holds/"+hold.ID+"/capture", captureRequest{Amount: 450}, nil, &payment); status != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d", status)
+	}
+	if payment.Amount != 450 || payment.Status != types.PaymentStatusOk {
+		t.Errorf("unexpected payment %+v", payment)
+	}
+	c.do("GET", "/holds/"+hold.ID, nil, nil, &hold)
+	if hold.Status != types.HoldStatusCaptured || hold.PaymentID != payment.ID {
+		t.Errorf("unexpected hold %+v", hold)
+	}
+
+	if status := c.do("POST", "/holds/"+hold.ID+"/void", nil, nil, nil); status != http.StatusConflict {
+		t.Errorf("expected status 409, got %d", status)
+	}
+	if status := c.do("GET", "/holds/unknown", nil, nil, nil); status != http.StatusNotFound {
+		t.Errorf("expected status 404, got %d", status)
+	}
+}
+
+func TestHandler_Schedules(t *testing.T) {
+	c := newClient(t)
+	account := c.register("+992000000001")
+	c.deposit(account.ID, 1000)
+	payment, _ := c.pay(account.ID, 300, "")
+	var favorite favoriteResponse
+	c.do("POST", "/payments/"+payment.ID+"/favorites", favoriteRequest{Name: "аренда"}, nil, &favorite)
+
+	var schedule scheduleResponse
+	path := "/favorites/" + favorite.ID + "/schedules"
+	if status := c.do("POST", path, scheduleRequest{Kind: types.ScheduleCron, Cron: "0 9 5 * *"}, nil, &schedule); status != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d", status)
+	}
+	if schedule.FavoriteID != favorite.ID || !schedule.Active || schedule.DueAt == nil || schedule.DueAt.Day() != 5 {
+		t.Errorf("unexpected schedule %+v", schedule)
+	}
+	if status := c.do("POST", path, scheduleRequest{Kind: types.ScheduleCron, Cron: "0 9 32 * *"}, nil, nil); status != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d", status)
+	}
+	if status := c.do("POST", "/favorites/unknown/schedules", scheduleRequest{Kind: types.ScheduleDaily}, nil, nil); status != http.StatusNotFound {
+		t.Errorf("expected status 404, got %d", status)
+	}
+
+	var canceled scheduleResponse
+	if status := c.do("POST", "/schedules/"+schedule.ID+"/cancel", nil, nil, &canceled); status != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", status)
+	}
+	if canceled.Active {
+		t.Errorf("expected schedule inactive, got %+v", canceled)
+	}
+	var schedules []scheduleResponse
+	if status := c.do("GET", fmt.Sprintf("/accounts/%d/schedules", account.ID), nil, nil, &schedules); status != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", status)
+	}
+	if !reflect.DeepEqual(schedules, []scheduleResponse{canceled}) {
+		t.Errorf("expected schedules %+v, got %+v", []scheduleResponse{canceled}, schedules)
+	}
+	var runs []scheduleRunResponse
+	if status := c.do("GET", "/schedules/"+schedule.ID+"/runs", nil, nil, &runs); status != http.StatusOK || len(runs) != 0 {
+		t.Errorf("expected no runs, got %d %+v", status, runs)
+	}
+	if status := c.do("GET", "/schedules/unknown", nil, nil, nil); status != http.StatusNotFound {
+		t.Errorf("expected status 404, got %d", status)
+	}
+}
+
+func TestHandler_Concurrent(t *testing.T) {
+	c := newClient(t)
+	account := c.register("+992000000001")
+	c.deposit(account.ID, 10_000)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			payment, status := c.pay(account.ID, 100, "")
+			if status != http.StatusCreated {
+				t.Errorf("expected status 201, got %d", status)
+				return
+			}
+			c.do("POST", "/payments/"+payment.ID+"/reject", nil, nil, nil)
+		}()
+	}
+	wg.Wait()
+
+	var got accountResponse
+	c.do("GET", fmt.Sprintf("/accounts/%d", account.ID), nil, nil, &got)
+	if got.Balance != 10_000 {
+		t.Errorf("expected balance 10000, got %v", got.Balance)
+	}
+}
+
+func TestStatusOf(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{wallet.ErrAccountNotFound, http.StatusNotFound},
+		{wallet.ErrPaymentNotFound, http.StatusNotFound},
+		{wallet.ErrNotEnoughBalance, http.StatusUnprocessableEntity},
+		{wallet.ErrPhoneRegistered, http.StatusConflict},
+		{wallet.ErrAmountMustBePositive, http.StatusBadRequest},
+		{&wallet.TransitionError{From: types.PaymentStatusFail, To: types.PaymentStatusOk}, http.StatusConflict},
+		{errors.New("disk full"), http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		if got := statusOf(tt.err); got != tt.want {
+			t.Errorf("expected status %d for %v, got %d", tt.want, tt.err, got)
+		}
+	}
+}
+
+func TestHandler_Statement(t *testing.T) {
+	c := newClient(t)
+	account := c.register("+992000000001")
+	c.deposit(account.ID, 1050)
+	c.pay(account.ID, 300, "")
+
+	resp, err := c.server.Client().Get(fmt.Sprintf("%s/accounts/%d/statement?locale=ru-RU", c.server.URL, account.ID))
+	if err != nil {
+		t.Fatalf("failed to do request: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/csv") {
+		t.Fatalf("unexpected response %d %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	if !strings.Contains(string(body), "Closing balance;;;;TJS;7,50") {
+		t.Errorf("expected closing balance 7,50, got %q", body)
+	}
+
+	for _, query := range []string{"locale=xx", "delimiter=ab", "delimiter=%22"} {
+		path := fmt.Sprintf("/accounts/%d/statement?%s", account.ID, query)
+		if status := c.do("GET", path, nil, nil, nil); status != http.StatusBadRequest {
+			t.Errorf("expected status 400 for %s, got %d", query, status)
+		}
+	}
+	if status := c.do("GET", "/accounts/42/statement", nil, nil, nil); status != http.StatusNotFound {
+		t.Errorf("expected status 404, got %d", status)
+	}
+}
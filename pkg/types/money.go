@@ -0,0 +1,155 @@
+package types
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+var ErrCurrencyMismatch = errors.New("currency mismatch")
+var ErrUnknownCurrency = errors.New("unknown currency")
+var ErrInvalidAmount = errors.New("invalid amount")
+
+// Код валюты ISO 4217
+type Currency string
+
+const (
+	TJS Currency = "TJS"
+	USD Currency = "USD"
+	RUB Currency = "RUB"
+	EUR Currency = "EUR"
+	JPY Currency = "JPY"
+	KWD Currency = "KWD"
+)
+
+// Валюта аккаунтов, созданных без явного указания валюты.
+const DefaultCurrency = TJS
+
+// Количество знаков после запятой (minor units) по ISO 4217.
+var currencyMinorUnits = map[Currency]int{
+	TJS: 2,
+	USD: 2,
+	RUB: 2,
+	EUR: 2,
+	JPY: 0,
+	KWD: 3,
+}
+
+// Valid сообщает, известна ли валюта.
+func (c Currency) Valid() bool {
+	_, ok := currencyMinorUnits[c]
+	return ok
+}
+
+// MinorUnits возвращает количество знаков после запятой.
+func (c Currency) MinorUnits() int {
+	return currencyMinorUnits[c]
+}
+
+// Format форматирует сумму в минимальных единицах валюты: 1234 TJS -> "12.34".
+func (c Currency) Format(m Money) string {
+	units := c.MinorUnits()
+	sign := ""
+	value := int64(m)
+	if value < 0 {
+		sign = "-"
+		value = -value
+	}
+
+	digits := strconv.FormatInt(value, 10)
+	if units == 0 {
+		return sign + digits
+	}
+	if len(digits) <= units {
+		digits = strings.Repeat("0", units-len(digits)+1) + digits
+	}
+	return sign + digits[:len(digits)-units] + "." + digits[len(digits)-units:]
+}
+
+// Parse разбирает сумму вида "12.34" в минимальные единицы валюты.
+// Знаков после точки не может быть больше, чем minor units валюты.
+func (c Currency) Parse(s string) (Money, error) {
+	if !c.Valid() {
+		return 0, ErrUnknownCurrency
+	}
+
+	negative := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(s, "-")
+
+	whole, fraction, hasPoint := strings.Cut(s, ".")
+	units := c.MinorUnits()
+	if whole == "" || (hasPoint && (fraction == "" || len(fraction) > units)) {
+		return 0, ErrInvalidAmount
+	}
+	fraction += strings.Repeat("0", units-len(fraction))
+
+	for _, r := range whole + fraction {
+		if r < '0' || r > '9' {
+			return 0, ErrInvalidAmount
+		}
+	}
+	value, err := strconv.ParseInt(whole+fraction, 10, 64)
+	if err != nil {
+		return 0, ErrInvalidAmount
+	}
+
+	if negative {
+		value = -value
+	}
+	return Money(value), nil
+}
+
+// Сумма в конкретной валюте. Арифметика над суммами в разных валютах
+// возвращает ErrCurrencyMismatch.
+type Amount struct {
+	Value    Money
+	Currency Currency
+}
+
+func (a Amount) Add(b Amount) (Amount, error) {
+	if a.Currency != b.Currency {
+		return Amount{}, ErrCurrencyMismatch
+	}
+	return Amount{Value: a.Value + b.Value, Currency: a.Currency}, nil
+}
+
+func (a Amount) Sub(b Amount) (Amount, error) {
+	if a.Currency != b.Currency {
+		return Amount{}, ErrCurrencyMismatch
+	}
+	return Amount{Value: a.Value - b.Value, Currency: a.Currency}, nil
+}
+
+// Cmp возвращает -1, 0 или 1, если a меньше, равна или больше b.
+func (a Amount) Cmp(b Amount) (int, error) {
+	if a.Currency != b.Currency {
+		return 0, ErrCurrencyMismatch
+	}
+	switch {
+	case a.Value < b.Value:
+		return -1, nil
+	case a.Value > b.Value:
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// String возвращает сумму вида "12.34 TJS".
+func (a Amount) String() string {
+	return a.Currency.Format(a.Value) + " " + string(a.Currency)
+}
+
+// ParseAmount разбирает сумму вида "12.34 TJS".
+func ParseAmount(s string) (Amount, error) {
+	value, currency, ok := strings.Cut(strings.TrimSpace(s), " ")
+	if !ok {
+		return Amount{}, ErrInvalidAmount
+	}
+
+	c := Currency(strings.TrimSpace(currency))
+	m, err := c.Parse(value)
+	if err != nil {
+		return Amount{}, err
+	}
+	return Amount{Value: m, Currency: c}, nil
+}
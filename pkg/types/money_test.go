@@ -0,0 +1,89 @@
+package types
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCurrency_Format(t *testing.T) {
+	tests := []struct {
+		currency Currency
+		value    Money
+		want     string
+	}{
+		{TJS, 1234, "12.34"},
+		{TJS, 5, "0.05"},
+		{TJS, -150, "-1.50"},
+		{JPY, 1234, "1234"},
+		{KWD, 1234, "1.234"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.currency.Format(tt.value); got != tt.want {
+			t.Errorf("expected %s for %d %s, got %s", tt.want, tt.value, tt.currency, got)
+		}
+	}
+}
+
+func TestCurrency_Parse(t *testing.T) {
+	tests := []struct {
+		currency Currency
+		input    string
+		want     Money
+	}{
+		{TJS, "12.34", 1234},
+		{TJS, "12.3", 1230},
+		{TJS, "12", 1200},
+		{TJS, "-0.05", -5},
+		{JPY, "1234", 1234},
+		{KWD, "1.234", 1234},
+	}
+
+	for _, tt := range tests {
+		got, err := tt.currency.Parse(tt.input)
+		if err != nil {
+			t.Fatalf("failed to parse %q: %v", tt.input, err)
+		}
+		if got != tt.want {
+			t.Errorf("expected %d for %q, got %d", tt.want, tt.input, got)
+		}
+	}
+
+	for _, input := range []string{"", "1.", ".5", "1.234", "1,5", "abc"} {
+		if _, err := TJS.Parse(input); !errors.Is(err, ErrInvalidAmount) {
+			t.Errorf("expected ErrInvalidAmount for %q, got %v", input, err)
+		}
+	}
+	if _, err := Currency("XXX").Parse("1"); !errors.Is(err, ErrUnknownCurrency) {
+		t.Errorf("expected ErrUnknownCurrency, got %v", err)
+	}
+}
+
+func TestAmount(t *testing.T) {
+	a := Amount{Value: 1000, Currency: USD}
+	b := Amount{Value: 250, Currency: USD}
+
+	sum, err := a.Add(b)
+	if err != nil || sum.Value != 1250 {
+		t.Errorf("expected 1250, got %v (%v)", sum, err)
+	}
+	diff, err := a.Sub(b)
+	if err != nil || diff.Value != 750 {
+		t.Errorf("expected 750, got %v (%v)", diff, err)
+	}
+	if cmp, _ := b.Cmp(a); cmp != -1 {
+		t.Errorf("expected -1, got %d", cmp)
+	}
+
+	if _, err := a.Add(Amount{Value: 1, Currency: EUR}); !errors.Is(err, ErrCurrencyMismatch) {
+		t.Errorf("expected ErrCurrencyMismatch, got %v", err)
+	}
+
+	parsed, err := ParseAmount(a.String())
+	if err != nil {
+		t.Fatalf("failed to parse amount: %v", err)
+	}
+	if parsed != a {
+		t.Errorf("expected %v, got %v", a, parsed)
+	}
+}
@@ -1,5 +1,7 @@
 package types
 
+import "time"
+
 // Money type
 type Money int64
 
@@ -14,28 +16,275 @@ const (
 	PaymentStatusOk         PaymentStatus = "OK"
 	PaymentStatusFail       PaymentStatus = "FAIL"
 	PaymentStatusInProgress PaymentStatus = "INPROGRESS"
+	PaymentStatusCancelled  PaymentStatus = "CANCELLED"
+	PaymentStatusRefunded   PaymentStatus = "REFUNDED"
+	PaymentStatusExpired    PaymentStatus = "EXPIRED"
 )
 
+// Разрешённые переходы между статусами платежа.
+// FAIL, CANCELLED, REFUNDED и EXPIRED - конечные статусы.
+var paymentTransitions = map[PaymentStatus][]PaymentStatus{
+	PaymentStatusInProgress: {PaymentStatusOk, PaymentStatusFail, PaymentStatusCancelled, PaymentStatusExpired},
+	PaymentStatusOk:         {PaymentStatusRefunded},
+	PaymentStatusFail:       {},
+	PaymentStatusCancelled:  {},
+	PaymentStatusRefunded:   {},
+	PaymentStatusExpired:    {},
+}
+
+// Valid сообщает, известен ли статус.
+func (s PaymentStatus) Valid() bool {
+	_, ok := paymentTransitions[s]
+	return ok
+}
+
+// CanTransitionTo сообщает, разрешён ли переход из статуса s в статус to.
+func (s PaymentStatus) CanTransitionTo(to PaymentStatus) bool {
+	for _, allowed := range paymentTransitions[s] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Amount и Currency - сумма, списанная с аккаунта. Если получатель принимает
+// другую валюту, TargetAmount и TargetCurrency - сумма, которую он получил,
+// а Rate - курс Currency -> TargetCurrency. Без конвертации эти поля пусты.
+//
+// Время записей хранится в UTC. Нулевое время - неизвестно, например
+// у записей, импортированных из дампов без времени.
 type Payment struct {
-	ID        string
-	AccountID int64
-	Amount    Money
-	Category  PaymentCategory
-	Status    PaymentStatus
+	ID             string
+	AccountID      int64
+	Amount         Money
+	Category       PaymentCategory
+	Status         PaymentStatus
+	Currency       Currency
+	TargetAmount   Money
+	TargetCurrency Currency
+	Rate           string
+
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+	StatusChangedAt time.Time // время перехода в текущий Status
 }
 
 type Phone string
 
+// Balance - общий баланс аккаунта, он же баланс по проводкам. Held - сумма
+// активных блокировок (Hold): эти деньги ещё на аккаунте, но потратить их нельзя.
 type Account struct {
-	ID      int64
-	Phone   Phone
-	Balance Money
+	ID       int64
+	Phone    Phone
+	Balance  Money
+	Currency Currency
+	Held     Money
+
+	CreatedAt time.Time
+	UpdatedAt time.Time // время последнего изменения, в том числе баланса
+}
+
+// Available возвращает баланс, доступный для платежей и переводов.
+func (a Account) Available() Money {
+	return a.Balance - a.Held
 }
 
+// Избранный платёж. Name уникально среди избранного аккаунта без учёта
+// регистра. Position - место в списке избранного аккаунта, начиная с 1.
 type Favorite struct {
 	ID        string
 	AccountID int64
 	Name      string
 	Amount    Money
 	Category  PaymentCategory
+	Currency  Currency
+	Position  int
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// Счёт двойной записи: customer:<id>, cash:in, merchant:clearing
+type LedgerAccount string
+
+// Сторона проводки
+type EntrySide string
+
+const (
+	EntryDebit  EntrySide = "DEBIT"
+	EntryCredit EntrySide = "CREDIT"
+)
+
+// Проводка. Проводки одной операции (TransactionID) сбалансированы:
+// сумма дебетов равна сумме кредитов.
+type LedgerEntry struct {
+	ID            string
+	TransactionID string
+	Account       LedgerAccount
+	Side          EntrySide
+	Amount        Money
+	Currency      Currency
+	Reference     string // ID платежа, если операция с ним связана
+}
+
+// Перевод между аккаунтами кошелька. Статус: OK после выполнения,
+// REFUNDED после отмены.
+//
+// Amount и Currency - сумма, списанная с отправителя. Если валюта получателя
+// другая, TargetAmount, TargetCurrency и Rate описывают конвертацию, как у Payment.
+type Transfer struct {
+	ID             string
+	FromAccountID  int64
+	ToAccountID    int64
+	Amount         Money
+	Currency       Currency
+	Comment        string
+	Status         PaymentStatus
+	TargetAmount   Money
+	TargetCurrency Currency
+	Rate           string
+
+	CreatedAt time.Time
+	UpdatedAt time.Time // при отмене - время отмены
+}
+
+// Статус блокировки
+type HoldStatus string
+
+const (
+	HoldStatusActive   HoldStatus = "ACTIVE"
+	HoldStatusCaptured HoldStatus = "CAPTURED"
+	HoldStatusVoided   HoldStatus = "VOIDED"
+	HoldStatusExpired  HoldStatus = "EXPIRED"
+)
+
+// Valid сообщает, известен ли статус.
+func (s HoldStatus) Valid() bool {
+	switch s {
+	case HoldStatusActive, HoldStatusCaptured, HoldStatusVoided, HoldStatusExpired:
+		return true
+	}
+	return false
+}
+
+// Блокировка суммы на аккаунте до того, как известна окончательная сумма
+// платежа (такси, отель). Активная блокировка уменьшает доступный баланс
+// аккаунта, но не его баланс по проводкам. Из ACTIVE блокировка переходит
+// в CAPTURED (списание CapturedAmount платежом PaymentID), VOIDED или
+// EXPIRED после ExpiresAt; остаток суммы при этом освобождается.
+type Hold struct {
+	ID             string
+	AccountID      int64
+	Amount         Money
+	Currency       Currency
+	Category       PaymentCategory
+	Status         HoldStatus
+	CapturedAmount Money
+	PaymentID      string
+	ExpiresAt      time.Time
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// Возврат части или всей суммы платежа PaymentID. Amount - в валюте
+// платежа; если платёж был с конвертацией, TargetAmount - доля суммы
+// получателя, которая к нему возвращается. Возврат не меняется после создания.
+type Refund struct {
+	ID             string
+	PaymentID      string
+	AccountID      int64
+	Amount         Money
+	Currency       Currency
+	TargetAmount   Money
+	TargetCurrency Currency
+	Reason         string
+
+	CreatedAt time.Time
+}
+
+// Вид расписания
+type ScheduleKind string
+
+const (
+	ScheduleOnce    ScheduleKind = "ONCE"
+	ScheduleDaily   ScheduleKind = "DAILY"
+	ScheduleWeekly  ScheduleKind = "WEEKLY"
+	ScheduleMonthly ScheduleKind = "MONTHLY"
+	ScheduleCron    ScheduleKind = "CRON"
+)
+
+// Valid сообщает, известен ли вид расписания.
+func (k ScheduleKind) Valid() bool {
+	switch k {
+	case ScheduleOnce, ScheduleDaily, ScheduleWeekly, ScheduleMonthly, ScheduleCron:
+		return true
+	}
+	return false
+}
+
+// Расписание платежей по избранному FavoriteID. Start - время первого
+// платежа; у DAILY, WEEKLY и MONTHLY от него же отсчитываются время суток,
+// день недели и число месяца следующих. У CRON время задаёт выражение Cron.
+// DueAt - время очередного платежа, RetryAt - время повтора, если платёж
+// не прошёл (Attempts неудачных попыток). Время - в UTC. Расписание
+// без следующего платежа или отменённое неактивно.
+type Schedule struct {
+	ID         string
+	FavoriteID string
+	AccountID  int64
+	Kind       ScheduleKind
+	Start      time.Time
+	Cron       string
+	Active     bool
+	DueAt      time.Time
+	RetryAt    time.Time
+	Attempts   int
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// Результат выполнения расписания
+type ScheduleRunStatus string
+
+const (
+	ScheduleRunOk     ScheduleRunStatus = "OK"
+	ScheduleRunRetry  ScheduleRunStatus = "RETRY"  // не прошёл, будет повтор
+	ScheduleRunFailed ScheduleRunStatus = "FAILED" // не прошёл, платёж пропущен
+)
+
+// Valid сообщает, известен ли результат.
+func (s ScheduleRunStatus) Valid() bool {
+	switch s {
+	case ScheduleRunOk, ScheduleRunRetry, ScheduleRunFailed:
+		return true
+	}
+	return false
+}
+
+// Выполнение расписания ScheduleID: попытка Attempt платежа, назначенного
+// на DueAt, сделанная в At. При успехе PaymentID - созданный платёж,
+// иначе Error - текст ошибки.
+type ScheduleRun struct {
+	ID         string
+	ScheduleID string
+	AccountID  int64
+	DueAt      time.Time
+	Attempt    int
+	Status     ScheduleRunStatus
+	PaymentID  string
+	Error      string
+	At         time.Time
+}
+
+// Ключ идемпотентности: запрос, выполненный с ключом Key от имени аккаунта,
+// и его результат. Повтор запроса с тем же ключом возвращает этот результат.
+type IdempotencyKey struct {
+	AccountID int64
+	Key       string
+	Request   string // операция и её параметры
+	PaymentID string // ID созданного платежа, пусто для пополнения
+	CreatedAt time.Time
 }
@@ -0,0 +1,219 @@
+package wallet
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/akmalsulaymonov/alif-wallet/pkg/types"
+)
+
+// Эти тесты имеют смысл прежде всего под go test -race.
+
+func TestService_Concurrent_RegisterAccount(t *testing.T) {
+	s := &Service{}
+	wg := sync.WaitGroup{}
+
+	// Каждый телефон регистрируют сразу несколько горутин
+	for i := 0; i < 50; i++ {
+		for j := 0; j < 4; j++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				s.RegisterAccount(types.Phone(fmt.Sprintf("+992%09d", i)))
+			}(i)
+		}
+	}
+	wg.Wait()
+
+	if len(serviceAccounts(s)) != 50 {
+		t.Errorf("expected 50 accounts, got %d", len(serviceAccounts(s)))
+	}
+
+	ids := map[int64]bool{}
+	for _, account := range serviceAccounts(s) {
+		if ids[account.ID] {
+			t.Errorf("duplicate account ID %d", account.ID)
+		}
+		ids[account.ID] = true
+	}
+}
+
+func TestService_Concurrent_DepositPay(t *testing.T) {
+	s := &Service{}
+	account, err := s.RegisterAccount("+992000000001")
+	if err != nil {
+		t.Fatalf("failed to register account: %v", err)
+	}
+	accountID := account.ID
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 100; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if err := s.Deposit(accountID, 10); err != nil {
+				t.Errorf("failed to deposit money: %v", err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			s.Pay(accountID, 5, "food")
+		}()
+	}
+	wg.Wait()
+
+	// Баланс должен совпадать с суммой депозитов минус успешные платежи
+	got, err := s.FindAccountByID(account.ID)
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	want := types.Money(100*10) - s.SumPayments(1)[types.TJS]
+	if got.Balance != want {
+		t.Errorf("expected balance %v, got %v", want, got.Balance)
+	}
+}
+
+func TestService_Concurrent_NoOverdraft(t *testing.T) {
+	s := &Service{}
+	account, _ := s.RegisterAccount("+992000000001")
+	s.Deposit(account.ID, 100)
+
+	accountID := account.ID
+	wg := sync.WaitGroup{}
+	mu := sync.Mutex{}
+	succeeded := 0
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := s.Pay(accountID, 10, "food"); err == nil {
+				mu.Lock()
+				succeeded++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if succeeded != 10 {
+		t.Errorf("expected 10 successful payments, got %d", succeeded)
+	}
+	if balance := accountBalance(s, account.ID); balance != 0 {
+		t.Errorf("expected balance 0, got %v", balance)
+	}
+}
+
+func TestService_Concurrent_AllMethods(t *testing.T) {
+	dir := t.TempDir()
+	s := &Service{}
+
+	var accounts []*types.Account
+	for i := 0; i < 4; i++ {
+		account, err := s.RegisterAccount(types.Phone(fmt.Sprintf("+992%09d", i)))
+		if err != nil {
+			t.Fatalf("failed to register account: %v", err)
+		}
+		s.Deposit(account.ID, 1_000_000)
+		accounts = append(accounts, account)
+	}
+
+	wg := sync.WaitGroup{}
+	for _, account := range accounts {
+		for g := 0; g < 4; g++ {
+			wg.Add(1)
+			go func(accountID int64) {
+				defer wg.Done()
+				for i := 0; i < 50; i++ {
+					payment, err := s.Pay(accountID, 10, "food")
+					if err != nil {
+						t.Errorf("failed to create payment: %v", err)
+						return
+					}
+					s.Deposit(accountID, 5)
+					s.Repeat(payment.ID)
+					favorite, err := s.FavoritePayment(payment.ID, "fav")
+					if err == nil {
+						s.PayFromFavorite(favorite.ID)
+					}
+					if i%10 == 0 {
+						s.Reject(payment.ID)
+					}
+					s.ExportAccountHistory(accountID)
+					s.FilterPayments(accountID, 2)
+				}
+			}(account.ID)
+		}
+	}
+
+	// Операции над всем сервисом параллельно с операциями над аккаунтами
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			s.SumPayments(3)
+			if err := s.Export(dir); err != nil {
+				t.Errorf("Export failed: %v", err)
+			}
+			if err := s.ExportToFile(dir + string(os.PathSeparator) + "export.txt"); err != nil {
+				t.Errorf("ExportToFile failed: %v", err)
+			}
+		}
+	}()
+	wg.Wait()
+
+	// Баланс каждого аккаунта сходится с его платежами
+	for _, account := range accounts {
+		history, err := s.ExportAccountHistory(account.ID)
+		if err != nil {
+			t.Fatalf("ExportAccountHistory failed: %v", err)
+		}
+		want := types.Money(1_000_000 + 4*50*5)
+		for _, payment := range history {
+			if payment.Status != types.PaymentStatusFail {
+				want -= payment.Amount
+			}
+		}
+		if balance := accountBalance(s, account.ID); balance != want {
+			t.Errorf("account %d: expected balance %v, got %v", account.ID, want, balance)
+		}
+	}
+	if report := s.CheckLedger(); !report.OK() {
+		t.Errorf("expected consistent ledger, got %+v", report)
+	}
+}
+
+func TestService_Concurrent_Import(t *testing.T) {
+	dir := t.TempDir()
+	source := &Service{}
+	account, _ := source.RegisterAccount("+992000000001")
+	source.Deposit(account.ID, 1000)
+	source.Pay(account.ID, 100, "food")
+	if err := source.Export(dir); err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+
+	s := &Service{}
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if err := s.Import(dir); err != nil {
+				t.Errorf("Import failed: %v", err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			s.Deposit(account.ID, 1)
+			s.FindAccountByID(account.ID)
+			s.SumPayments(2)
+		}()
+	}
+	wg.Wait()
+
+	if len(serviceAccounts(s)) != 1 {
+		t.Errorf("expected 1 account, got %d", len(serviceAccounts(s)))
+	}
+}
@@ -0,0 +1,130 @@
+package wallet
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidCron = errors.New("invalid cron expression")
+
+// cronSchedule - разобранное выражение cron из пяти полей: минута, час,
+// день месяца, месяц и день недели (0 или 7 - воскресенье). Поле - "*",
+// число, диапазон "a-b" или их список через запятую, к "*" и диапазону
+// можно добавить шаг "/n". Если ограничены и день месяца, и день недели,
+// подходит любой из них, как в cron. Поле, начинающееся с "*" (в том числе
+// "*/n"), не считается ограниченным: тогда день должен подходить под оба.
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64 // битовые маски допустимых значений
+	domAny, dowAny                bool
+}
+
+func parseCron(expr string) (*cronSchedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("%w %q: expected 5 fields", ErrInvalidCron, expr)
+	}
+
+	cron := &cronSchedule{
+		domAny: strings.HasPrefix(fields[2], "*"),
+		dowAny: strings.HasPrefix(fields[4], "*"),
+	}
+	limits := []struct {
+		bits     *uint64
+		min, max int
+	}{
+		{&cron.minute, 0, 59},
+		{&cron.hour, 0, 23},
+		{&cron.dom, 1, 31},
+		{&cron.month, 1, 12},
+		{&cron.dow, 0, 7},
+	}
+	for i, limit := range limits {
+		bits, err := parseCronField(fields[i], limit.min, limit.max)
+		if err != nil {
+			return nil, fmt.Errorf("%w %q: %v", ErrInvalidCron, expr, err)
+		}
+		*limit.bits = bits
+	}
+	if cron.dow&(1<<7) != 0 {
+		cron.dow |= 1
+	}
+	return cron, nil
+}
+
+func parseCronField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		values, stepValue, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepValue); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step %q", part)
+			}
+		}
+
+		lo, hi := min, max
+		if values != "*" {
+			first, last, isRange := strings.Cut(values, "-")
+			var err error
+			if lo, err = strconv.Atoi(first); err != nil {
+				return 0, fmt.Errorf("invalid value %q", part)
+			}
+			hi = lo
+			if isRange {
+				if hi, err = strconv.Atoi(last); err != nil {
+					return 0, fmt.Errorf("invalid value %q", part)
+				}
+			} else if hasStep {
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("value %q out of range %d-%d", part, min, max)
+		}
+
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+func (c *cronSchedule) dayMatches(t time.Time) bool {
+	dom := c.dom&(1<<t.Day()) != 0
+	dow := c.dow&(1<<t.Weekday()) != 0
+	if c.domAny || c.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// next возвращает первую подходящую минуту после after или нулевое время,
+// если её нет в ближайшие пять лет (например, "0 0 31 2 *").
+func (c *cronSchedule) next(after time.Time) time.Time {
+	t := after.UTC().Truncate(time.Minute).Add(time.Minute)
+	end := t.AddDate(5, 0, 0)
+	for t.Before(end) {
+		if c.month&(1<<t.Month()) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+		if !c.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+		if c.hour&(1<<t.Hour()) == 0 {
+			t = t.Truncate(time.Hour).Add(time.Hour)
+			continue
+		}
+		if c.minute&(1<<t.Minute()) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
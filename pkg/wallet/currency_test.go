@@ -0,0 +1,160 @@
+package wallet
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/akmalsulaymonov/alif-wallet/pkg/types"
+)
+
+func TestService_RegisterAccountWithCurrency(t *testing.T) {
+	s := &Service{}
+
+	account, err := s.RegisterAccountWithCurrency("+992000000001", types.USD)
+	if err != nil {
+		t.Fatalf("failed to register account: %v", err)
+	}
+	if account.Currency != types.USD {
+		t.Errorf("expected currency %v, got %v", types.USD, account.Currency)
+	}
+
+	_, err = s.RegisterAccountWithCurrency("+992000000002", "XXX")
+	if !errors.Is(err, ErrUnknownCurrency) {
+		t.Errorf("expected ErrUnknownCurrency, got %v", err)
+	}
+
+	// По умолчанию аккаунт в сомони
+	account, _ = s.RegisterAccount("+992000000003")
+	if account.Currency != types.DefaultCurrency {
+		t.Errorf("expected currency %v, got %v", types.DefaultCurrency, account.Currency)
+	}
+}
+
+func TestService_CurrencyMismatch(t *testing.T) {
+	s := &Service{}
+	usd, _ := s.RegisterAccountWithCurrency("+992000000001", types.USD)
+	tjs, _ := s.RegisterAccount("+992000000002")
+
+	if err := s.DepositAmount(usd.ID, types.Amount{Value: 1000, Currency: types.USD}); err != nil {
+		t.Fatalf("failed to deposit: %v", err)
+	}
+	s.Deposit(tjs.ID, 1000)
+
+	err := s.DepositAmount(usd.ID, types.Amount{Value: 1000, Currency: types.EUR})
+	if !errors.Is(err, ErrCurrencyMismatch) {
+		t.Errorf("expected ErrCurrencyMismatch on deposit, got %v", err)
+	}
+	_, err = s.PayAmount(usd.ID, types.Amount{Value: 100, Currency: types.TJS}, "food")
+	if !errors.Is(err, ErrCurrencyMismatch) {
+		t.Errorf("expected ErrCurrencyMismatch on pay, got %v", err)
+	}
+	_, err = s.Transfer(usd.ID, tjs.ID, 100, "")
+	if !errors.Is(err, ErrCurrencyMismatch) {
+		t.Errorf("expected ErrCurrencyMismatch on transfer, got %v", err)
+	}
+
+	// Неудачные операции не меняют балансы
+	if accountBalance(s, usd.ID) != 1000 || accountBalance(s, tjs.ID) != 1000 {
+		t.Errorf("expected balances 1000/1000, got %v/%v", accountBalance(s, usd.ID), accountBalance(s, tjs.ID))
+	}
+
+	payment, err := s.PayAmount(usd.ID, types.Amount{Value: 250, Currency: types.USD}, "food")
+	if err != nil {
+		t.Fatalf("failed to pay: %v", err)
+	}
+	if payment.Currency != types.USD || accountBalance(s, usd.ID) != 750 {
+		t.Errorf("unexpected payment %+v with balance %v", payment, accountBalance(s, usd.ID))
+	}
+
+	if report := s.CheckLedger(); !report.OK() {
+		t.Errorf("expected consistent ledger, got %+v", report)
+	}
+}
+
+func TestService_SumPayments_Currencies(t *testing.T) {
+	s := &Service{}
+	usd, _ := s.RegisterAccountWithCurrency("+992000000001", types.USD)
+	tjs, _ := s.RegisterAccount("+992000000002")
+	s.DepositAmount(usd.ID, types.Amount{Value: 1000, Currency: types.USD})
+	s.Deposit(tjs.ID, 1000)
+
+	s.PayAmount(usd.ID, types.Amount{Value: 100, Currency: types.USD}, "food")
+	s.PayAmount(usd.ID, types.Amount{Value: 50, Currency: types.USD}, "food")
+	s.Pay(tjs.ID, 300, "food")
+
+	want := map[types.Currency]types.Money{types.USD: 150, types.TJS: 300}
+	for _, goroutines := range []int{0, 1, 2, 5} {
+		if got := s.SumPayments(goroutines); !reflect.DeepEqual(got, want) {
+			t.Errorf("goroutines %d: expected %v, got %v", goroutines, want, got)
+		}
+	}
+}
+
+func TestService_ExportImport_Currency(t *testing.T) {
+	dir := t.TempDir()
+
+	source := &Service{}
+	account, _ := source.RegisterAccountWithCurrency("+992000000001", types.JPY)
+	source.DepositAmount(account.ID, types.Amount{Value: 5000, Currency: types.JPY})
+	payment, _ := source.Pay(account.ID, 1200, "food")
+	source.FavoritePayment(payment.ID, "обед")
+
+	if err := source.Export(dir); err != nil {
+		t.Fatalf("failed to export: %v", err)
+	}
+
+	target := &Service{}
+	if err := target.Import(dir); err != nil {
+		t.Fatalf("failed to import: %v", err)
+	}
+
+	if !reflect.DeepEqual(serviceAccounts(source), serviceAccounts(target)) {
+		t.Errorf("accounts differ after import: %v, %v", serviceAccounts(source), serviceAccounts(target))
+	}
+	if !reflect.DeepEqual(servicePayments(source), servicePayments(target)) {
+		t.Errorf("payments differ after import: %v, %v", servicePayments(source), servicePayments(target))
+	}
+	if !reflect.DeepEqual(serviceFavorites(source), serviceFavorites(target)) {
+		t.Errorf("favorites differ after import: %v, %v", serviceFavorites(source), serviceFavorites(target))
+	}
+	if report := target.CheckLedger(); !report.OK() {
+		t.Errorf("expected consistent ledger, got %+v", report)
+	}
+}
+
+func TestService_Import_LegacyDump(t *testing.T) {
+	dir := t.TempDir()
+
+	// Дамп без колонки валюты
+	err := os.WriteFile(filepath.Join(dir, "accounts.dump"), []byte("1;+992000000001;1000\n"), 0666)
+	if err != nil {
+		t.Fatalf("failed to write dump: %v", err)
+	}
+	err = os.WriteFile(filepath.Join(dir, "payments.dump"), []byte("p1;1;100;food;OK\n"), 0666)
+	if err != nil {
+		t.Fatalf("failed to write dump: %v", err)
+	}
+
+	s := &Service{}
+	if err := s.Import(dir); err != nil {
+		t.Fatalf("failed to import: %v", err)
+	}
+
+	account, err := s.FindAccountByID(1)
+	if err != nil {
+		t.Fatalf("failed to find account: %v", err)
+	}
+	if account.Currency != types.DefaultCurrency || account.Balance != 1000 {
+		t.Errorf("unexpected account %+v", account)
+	}
+	payment, err := s.FindPaymentByID("p1")
+	if err != nil {
+		t.Fatalf("failed to find payment: %v", err)
+	}
+	if payment.Currency != types.DefaultCurrency {
+		t.Errorf("expected currency %v, got %v", types.DefaultCurrency, payment.Currency)
+	}
+}
@@ -0,0 +1,507 @@
+package wallet
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/akmalsulaymonov/alif-wallet/pkg/types"
+)
+
+// Версия формата дампов, которую пишет Export. Каждый файл дампа
+// начинается с заголовка
+//
+//	#wallet-dump;<вид>;<версия>;<число записей>
+//
+// Файлы без заголовка считаются версией 1. Записи старых версий
+// переводятся в текущую: поля, которых в версии записи ещё не было,
+// остаются пустыми, остальные изменения делают миграции из dumpMigrations.
+//
+// До версии 3 поля писались как есть и не могли содержать ';' и перевод
+// строки. С версии 3 записи кодируются encodeRecord. В версии 4 в конец
+// записей добавлено время (RFC 3339 в UTC, пустое - неизвестно). В версии 5
+// у избранного появился порядковый номер в списке аккаунта. В версии 6
+// появились файлы блокировок, возвратов, расписаний и их выполнений.
+//
+// Новый файл или новое поле требуют новой версии: поле добавляется в конец
+// записи с номером версии, в которой оно появилось.
+const dumpVersion = 6
+
+const dumpQuotedVersion = 3
+
+const dumpHeaderPrefix = "#wallet-dump"
+
+// ErrDumpVersion возвращается для дампов версии новее dumpVersion.
+var ErrDumpVersion = errors.New("unsupported dump version")
+
+// dumpKind описывает вид дампа: версию, в которой появился файл,
+// и поля записи в текущей версии.
+type dumpKind struct {
+	name    string
+	since   int
+	columns []dumpColumn
+}
+
+// dumpColumn - поле записи. Имя используется в JSON, number - целое число,
+// since - версия, в которой поле появилось.
+type dumpColumn struct {
+	name   string
+	number bool
+	since  int
+}
+
+var (
+	accountsDump = dumpKind{"accounts", 1, []dumpColumn{
+		{"id", true, 1}, {"phone", false, 1}, {"balance", true, 1}, {"currency", false, 1},
+		{"created_at", false, 4}, {"updated_at", false, 4},
+	}}
+	paymentsDump = dumpKind{"payments", 1, []dumpColumn{
+		{"id", false, 1}, {"account_id", true, 1}, {"amount", true, 1}, {"category", false, 1}, {"status", false, 1},
+		{"currency", false, 1}, {"target_amount", true, 1}, {"target_currency", false, 1}, {"rate", false, 1},
+		{"created_at", false, 4}, {"updated_at", false, 4}, {"status_changed_at", false, 4},
+	}}
+	favoritesDump = dumpKind{"favorites", 1, []dumpColumn{
+		{"id", false, 1}, {"account_id", true, 1}, {"name", false, 1}, {"amount", true, 1}, {"category", false, 1},
+		{"currency", false, 1},
+		{"created_at", false, 4}, {"updated_at", false, 4},
+		{"position", true, 5},
+	}}
+	transfersDump = dumpKind{"transfers", 1, []dumpColumn{
+		{"id", false, 1}, {"from_account_id", true, 1}, {"to_account_id", true, 1}, {"amount", true, 1}, {"status", false, 1},
+		{"currency", false, 1}, {"target_amount", true, 1}, {"target_currency", false, 1}, {"rate", false, 1},
+		{"comment", false, 1},
+		{"created_at", false, 4}, {"updated_at", false, 4},
+	}}
+	// Файлы блокировок, возвратов и расписаний прежние сборки писали
+	// с версиями 4 и 5 в том же формате, см. readDump.
+	holdsDump = dumpKind{"holds", 6, []dumpColumn{
+		{"id", false, 6}, {"account_id", true, 6}, {"amount", true, 6}, {"currency", false, 6}, {"category", false, 6},
+		{"status", false, 6}, {"captured_amount", true, 6}, {"payment_id", false, 6}, {"expires_at", false, 6},
+		{"created_at", false, 6}, {"updated_at", false, 6},
+	}}
+	refundsDump = dumpKind{"refunds", 6, []dumpColumn{
+		{"id", false, 6}, {"payment_id", false, 6}, {"account_id", true, 6}, {"amount", true, 6}, {"currency", false, 6},
+		{"target_amount", true, 6}, {"target_currency", false, 6}, {"reason", false, 6},
+		{"created_at", false, 6},
+	}}
+	schedulesDump = dumpKind{"schedules", 6, []dumpColumn{
+		{"id", false, 6}, {"favorite_id", false, 6}, {"account_id", true, 6}, {"kind", false, 6}, {"start", false, 6},
+		{"cron", false, 6}, {"active", false, 6}, {"due_at", false, 6}, {"retry_at", false, 6}, {"attempts", true, 6},
+		{"created_at", false, 6}, {"updated_at", false, 6},
+	}}
+	scheduleRunsDump = dumpKind{"schedule_runs", 6, []dumpColumn{
+		{"id", false, 6}, {"schedule_id", false, 6}, {"account_id", true, 6}, {"due_at", false, 6}, {"attempt", true, 6},
+		{"status", false, 6}, {"payment_id", false, 6}, {"error", false, 6}, {"at", false, 6},
+	}}
+)
+
+// file возвращает имя файла записей вида k в формате format.
+func (k dumpKind) file(format FileFormat) string {
+	return k.name + "." + format.String()
+}
+
+// dumpMigration переводит запись дампа из версии N в версию N+1.
+type dumpMigration func(fields []string) ([]string, error)
+
+// dumpMigrations[вид][N] - миграция записей из версии N в N+1, кроме
+// добавления новых полей: их добавляет migrateRecord.
+var dumpMigrations = map[string]map[int]dumpMigration{
+	accountsDump.name:  {1: migrateAccountV1},
+	paymentsDump.name:  {1: migratePaymentV1},
+	favoritesDump.name: {1: migrateFavoriteV1},
+}
+
+// width возвращает число полей записи в версии version.
+func (k dumpKind) width(version int) int {
+	width := 0
+	for _, column := range k.columns {
+		if column.since <= version {
+			width++
+		}
+	}
+	return width
+}
+
+// В версии 1 колонки валюты могло не быть: такие записи в DefaultCurrency.
+
+func migrateAccountV1(fields []string) ([]string, error) {
+	switch len(fields) {
+	case 3:
+		return append(fields, string(types.DefaultCurrency)), nil
+	case 4:
+		return fields, nil
+	}
+	return nil, errors.New("invalid accounts file format")
+}
+
+// Платежи без конвертации версии 1 могли не иметь пустых полей
+// targetAmount;targetCurrency;rate.
+func migratePaymentV1(fields []string) ([]string, error) {
+	switch len(fields) {
+	case 5:
+		return append(fields, string(types.DefaultCurrency), "", "", ""), nil
+	case 6:
+		return append(fields, "", "", ""), nil
+	case 9:
+		return fields, nil
+	}
+	return nil, errors.New("invalid payments file format")
+}
+
+func migrateFavoriteV1(fields []string) ([]string, error) {
+	switch len(fields) {
+	case 5:
+		return append(fields, string(types.DefaultCurrency)), nil
+	case 6:
+		return fields, nil
+	}
+	return nil, errors.New("invalid favorites file format")
+}
+
+// writeDump записывает заголовок и записи дампа в файл path.
+func writeDump(path string, kind dumpKind, records [][]string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+	fmt.Fprintf(writer, "%s;%s;%d;%d\n", dumpHeaderPrefix, kind.name, dumpVersion, len(records))
+	for _, record := range records {
+		writer.WriteString(encodeRecord(record))
+		writer.WriteByte('\n')
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// dumpRecord - поля записи и номер строки файла, с которой она начинается.
+type dumpRecord struct {
+	line   int
+	fields []string
+}
+
+// readDump читает файл дампа и возвращает записи в текущей версии
+// и найденные в файле ошибки. Если файла нет, возвращает nil.
+func readDump(path string, kind dumpKind) ([]dumpRecord, []ImportProblem) {
+	name := filepath.Base(path)
+	fail := func(line int, err error) []ImportProblem {
+		return []ImportProblem{{File: name, Line: line, Err: err}}
+	}
+
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fail(0, err)
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	version, count, line := 1, -1, 1
+	first, err := reader.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return nil, fail(line, err)
+	}
+	if strings.HasPrefix(first, dumpHeaderPrefix+";") {
+		version, count, err = parseDumpHeader(strings.TrimSuffix(first, "\n"), kind)
+		if err != nil {
+			return nil, fail(line, err)
+		}
+		first = ""
+		line++
+	}
+	if version < kind.since {
+		// Файл вошёл в формат позже, чем его начали писать:
+		// записи ранних версий уже в формате kind.since
+		version = kind.since
+	}
+
+	var raw []dumpRecord
+	if version >= dumpQuotedVersion {
+		for {
+			fields, err := readRecord(reader, '\n')
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				// После ошибки в кавычках границы записей не определить
+				return nil, fail(line, err)
+			}
+			raw = append(raw, dumpRecord{line: line, fields: fields})
+			line++
+			for _, field := range fields {
+				line += strings.Count(field, "\n")
+			}
+		}
+	} else {
+		// SplitN по числу полей версии 3 сохраняет ';' только
+		// в последнем поле, как и при записи.
+		width := kind.width(dumpQuotedVersion)
+		lines := strings.NewReader(first)
+		scanner := bufio.NewScanner(io.MultiReader(lines, reader))
+		for ; scanner.Scan(); line++ {
+			raw = append(raw, dumpRecord{line: line, fields: strings.SplitN(scanner.Text(), ";", width)})
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fail(line, err)
+		}
+	}
+
+	var records []dumpRecord
+	var problems []ImportProblem
+	for _, record := range raw {
+		fields, err := migrateRecord(kind, version, record.fields)
+		if err != nil {
+			problems = append(problems, ImportProblem{File: name, Line: record.line, Err: err})
+			continue
+		}
+		records = append(records, dumpRecord{line: record.line, fields: fields})
+	}
+
+	if count >= 0 && count != len(raw) {
+		problems = append(problems, ImportProblem{File: name, Line: 1, Err: fmt.Errorf("header declares %d records, found %d", count, len(raw))})
+	}
+	return records, problems
+}
+
+// migrateRecord переводит поля записи из версии version в текущую:
+// применяет миграции и добавляет пустые поля, появившиеся позже version.
+func migrateRecord(kind dumpKind, version int, fields []string) ([]string, error) {
+	for v := version; v < dumpVersion; v++ {
+		if migrate, ok := dumpMigrations[kind.name][v]; ok {
+			var err error
+			fields, err = migrate(fields)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if added := kind.width(v+1) - kind.width(v); added > 0 {
+			if len(fields) != kind.width(v) {
+				return nil, fmt.Errorf("invalid %s file format", kind.name)
+			}
+			fields = append(fields, make([]string, added)...)
+		}
+	}
+	if len(fields) != len(kind.columns) {
+		return nil, fmt.Errorf("invalid %s file format", kind.name)
+	}
+	return fields, nil
+}
+
+// parseDumpHeader разбирает заголовок дампа и возвращает версию и число записей.
+func parseDumpHeader(header string, kind dumpKind) (int, int, error) {
+	parts := strings.Split(header, ";")
+	if len(parts) != 4 {
+		return 0, 0, fmt.Errorf("invalid header %q", header)
+	}
+	if parts[1] != kind.name {
+		return 0, 0, fmt.Errorf("header declares %s dump, expected %s", parts[1], kind.name)
+	}
+	version, err := strconv.Atoi(parts[2])
+	if err != nil || version < 1 {
+		return 0, 0, fmt.Errorf("invalid version %q", parts[2])
+	}
+	if version > dumpVersion {
+		return 0, 0, fmt.Errorf("%w: dump has version %d, supported up to %d", ErrDumpVersion, version, dumpVersion)
+	}
+	count, err := strconv.Atoi(parts[3])
+	if err != nil || count < 0 {
+		return 0, 0, fmt.Errorf("invalid record count %q", parts[3])
+	}
+	return version, count, nil
+}
+
+// exportDir пишет в каталог dir файлы всех видов дампа. Файл пишется
+// и без записей: иначе запись, удалённая после прошлого экспорта (или
+// в Import с ImportReplace), вернулась бы из старого файла.
+func exportDir(tx Tx, dir string, format FileFormat) error {
+	if err := exportRecords(dir, format, accountsDump, tx.Accounts(), accountRecord); err != nil {
+		return err
+	}
+	if err := exportRecords(dir, format, paymentsDump, tx.Payments(), paymentRecord); err != nil {
+		return err
+	}
+	if err := exportRecords(dir, format, favoritesDump, tx.Favorites(), favoriteRecord); err != nil {
+		return err
+	}
+	if err := exportRecords(dir, format, transfersDump, tx.Transfers(), transferRecord); err != nil {
+		return err
+	}
+	if err := exportRecords(dir, format, holdsDump, tx.Holds(), holdRecord); err != nil {
+		return err
+	}
+	if err := exportRecords(dir, format, refundsDump, tx.Refunds(), refundRecord); err != nil {
+		return err
+	}
+	if err := exportRecords(dir, format, schedulesDump, tx.Schedules(), scheduleRecord); err != nil {
+		return err
+	}
+	return exportRecords(dir, format, scheduleRunsDump, tx.ScheduleRuns(), runRecord)
+}
+
+// accountRecord возвращает поля записи в порядке accountsDump.
+func accountRecord(account *types.Account) []string {
+	return []string{
+		strconv.FormatInt(account.ID, 10),
+		string(account.Phone),
+		strconv.FormatInt(int64(account.Balance), 10),
+		string(account.Currency),
+		formatTime(account.CreatedAt),
+		formatTime(account.UpdatedAt),
+	}
+}
+
+// transferRecord возвращает поля записи в порядке transfersDump.
+func transferRecord(transfer *types.Transfer) []string {
+	return []string{
+		transfer.ID,
+		strconv.FormatInt(transfer.FromAccountID, 10),
+		strconv.FormatInt(transfer.ToAccountID, 10),
+		strconv.FormatInt(int64(transfer.Amount), 10),
+		string(transfer.Status),
+		string(transfer.Currency),
+		formatTargetAmount(transfer.TargetAmount, transfer.TargetCurrency),
+		string(transfer.TargetCurrency),
+		transfer.Rate,
+		transfer.Comment,
+		formatTime(transfer.CreatedAt),
+		formatTime(transfer.UpdatedAt),
+	}
+}
+
+// holdRecord возвращает поля записи в порядке holdsDump.
+func holdRecord(hold *types.Hold) []string {
+	return []string{
+		hold.ID,
+		strconv.FormatInt(hold.AccountID, 10),
+		strconv.FormatInt(int64(hold.Amount), 10),
+		string(hold.Currency),
+		string(hold.Category),
+		string(hold.Status),
+		strconv.FormatInt(int64(hold.CapturedAmount), 10),
+		hold.PaymentID,
+		formatTime(hold.ExpiresAt),
+		formatTime(hold.CreatedAt),
+		formatTime(hold.UpdatedAt),
+	}
+}
+
+// refundRecord возвращает поля записи в порядке refundsDump.
+func refundRecord(refund *types.Refund) []string {
+	return []string{
+		refund.ID,
+		refund.PaymentID,
+		strconv.FormatInt(refund.AccountID, 10),
+		strconv.FormatInt(int64(refund.Amount), 10),
+		string(refund.Currency),
+		formatTargetAmount(refund.TargetAmount, refund.TargetCurrency),
+		string(refund.TargetCurrency),
+		refund.Reason,
+		formatTime(refund.CreatedAt),
+	}
+}
+
+// scheduleRecord возвращает поля записи в порядке schedulesDump.
+func scheduleRecord(schedule *types.Schedule) []string {
+	return []string{
+		schedule.ID,
+		schedule.FavoriteID,
+		strconv.FormatInt(schedule.AccountID, 10),
+		string(schedule.Kind),
+		formatTime(schedule.Start),
+		schedule.Cron,
+		strconv.FormatBool(schedule.Active),
+		formatTime(schedule.DueAt),
+		formatTime(schedule.RetryAt),
+		strconv.Itoa(schedule.Attempts),
+		formatTime(schedule.CreatedAt),
+		formatTime(schedule.UpdatedAt),
+	}
+}
+
+// runRecord возвращает поля записи в порядке scheduleRunsDump.
+func runRecord(run *types.ScheduleRun) []string {
+	return []string{
+		run.ID,
+		run.ScheduleID,
+		strconv.FormatInt(run.AccountID, 10),
+		formatTime(run.DueAt),
+		strconv.Itoa(run.Attempt),
+		string(run.Status),
+		run.PaymentID,
+		run.Error,
+		formatTime(run.At),
+	}
+}
+
+// paymentRecord возвращает поля записи payments.dump:
+// id;accountID;amount;category;status;currency;targetAmount;targetCurrency;rate;
+// createdAt;updatedAt;statusChangedAt. У платежей без конвертации
+// поля targetAmount, targetCurrency и rate пусты.
+func paymentRecord(payment *types.Payment) []string {
+	return []string{
+		payment.ID,
+		strconv.FormatInt(payment.AccountID, 10),
+		strconv.FormatInt(int64(payment.Amount), 10),
+		string(payment.Category),
+		string(payment.Status),
+		string(payment.Currency),
+		formatTargetAmount(payment.TargetAmount, payment.TargetCurrency),
+		string(payment.TargetCurrency),
+		payment.Rate,
+		formatTime(payment.CreatedAt),
+		formatTime(payment.UpdatedAt),
+		formatTime(payment.StatusChangedAt),
+	}
+}
+
+// exportRecords пишет в каталог dir файл вида kind с записями records.
+func exportRecords[T any](dir string, format FileFormat, kind dumpKind, records []*T, record func(*T) []string) error {
+	fields := make([][]string, 0, len(records))
+	for _, r := range records {
+		fields = append(fields, record(r))
+	}
+	return writeFile(filepath.Join(dir, kind.file(format)), kind, format, fields)
+}
+
+// favoriteRecord возвращает поля записи в порядке favoritesDump.
+func favoriteRecord(favorite *types.Favorite) []string {
+	return []string{
+		favorite.ID,
+		strconv.FormatInt(favorite.AccountID, 10),
+		favorite.Name,
+		strconv.FormatInt(int64(favorite.Amount), 10),
+		string(favorite.Category),
+		string(favorite.Currency),
+		formatTime(favorite.CreatedAt),
+		formatTime(favorite.UpdatedAt),
+		strconv.Itoa(favorite.Position),
+	}
+}
+
+func formatTargetAmount(amount types.Money, currency types.Currency) string {
+	if currency == "" {
+		return ""
+	}
+	return strconv.FormatInt(int64(amount), 10)
+}
+
+// formatTime записывает время в RFC 3339 в UTC, нулевое время - пустой строкой.
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
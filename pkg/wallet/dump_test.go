@@ -0,0 +1,216 @@
+package wallet
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/akmalsulaymonov/alif-wallet/pkg/types"
+)
+
+func writeDumpFile(t *testing.T, dir, name, content string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0666); err != nil {
+		t.Fatalf("failed to write dump: %v", err)
+	}
+}
+
+func TestService_Export_Header(t *testing.T) {
+	dir := t.TempDir()
+	s := &Service{}
+	acc, _ := s.RegisterAccount("+992000000001")
+	s.Deposit(acc.ID, 1000)
+	s.Pay(acc.ID, 100, "food")
+	s.Pay(acc.ID, 200, "food")
+
+	if err := s.Export(dir); err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+
+	file, err := os.Open(filepath.Join(dir, "payments.dump"))
+	if err != nil {
+		t.Fatalf("failed to open dump: %v", err)
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Scan()
+	if got, want := scanner.Text(), fmt.Sprintf("#wallet-dump;payments;%d;2", dumpVersion); got != want {
+		t.Errorf("expected header %q, got %q", want, got)
+	}
+}
+
+func TestService_Import_MigratesVersion1(t *testing.T) {
+	dir := t.TempDir()
+	writeDumpFile(t, dir, "accounts.dump", "1;+992000000001;1000\n2;+992000000002;500;USD\n")
+	writeDumpFile(t, dir, "payments.dump", "p1;1;100;food;OK\np2;2;50;car;FAIL;USD\n")
+	writeDumpFile(t, dir, "favorites.dump", "f1;1;Обед;100;food\n")
+
+	s := &Service{}
+	if err := s.Import(dir); err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+
+	p1, _ := s.FindPaymentByID("p1")
+	p2, _ := s.FindPaymentByID("p2")
+	if p1.Currency != types.DefaultCurrency || p2.Currency != types.USD {
+		t.Errorf("unexpected currencies %v, %v", p1.Currency, p2.Currency)
+	}
+	favorites, _ := s.AccountFavorites(1)
+	if len(favorites) != 1 || favorites[0].Currency != types.DefaultCurrency {
+		t.Errorf("unexpected favorites %v", favorites)
+	}
+
+	// Повторный экспорт пишет текущую версию, которая читается обратно
+	out := t.TempDir()
+	if err := s.Export(out); err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	if err := (&Service{}).Import(out); err != nil {
+		t.Errorf("Import of migrated dump failed: %v", err)
+	}
+}
+
+func TestService_Import_NewerVersion(t *testing.T) {
+	dir := t.TempDir()
+	writeDumpFile(t, dir, "accounts.dump", "#wallet-dump;accounts;2;1\n1;+992000000001;1000;TJS\n")
+	writeDumpFile(t, dir, "payments.dump", "#wallet-dump;payments;99;1\np1;1;100;food;OK;TJS;;;;extra\n")
+
+	s := &Service{}
+	err := s.Import(dir)
+	if !errors.Is(err, ErrDumpVersion) {
+		t.Fatalf("expected error %v, got %v", ErrDumpVersion, err)
+	}
+	if !strings.Contains(err.Error(), "version 99") {
+		t.Errorf("expected error to name the version, got %v", err)
+	}
+	if len(serviceAccounts(s)) != 0 {
+		t.Errorf("expected nothing imported, got %v", serviceAccounts(s))
+	}
+}
+
+func TestService_Import_InvalidHeader(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"count mismatch", "#wallet-dump;accounts;2;2\n1;+992000000001;1000;TJS\n"},
+		{"wrong kind", "#wallet-dump;payments;2;1\n1;+992000000001;1000;TJS\n"},
+		{"bad version", "#wallet-dump;accounts;v2;1\n1;+992000000001;1000;TJS\n"},
+		{"missing count", "#wallet-dump;accounts;2\n1;+992000000001;1000;TJS\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeDumpFile(t, dir, "accounts.dump", tt.content)
+			if err := (&Service{}).Import(dir); err == nil {
+				t.Error("expected error, got nil")
+			}
+		})
+	}
+}
+
+func TestService_Import_Version2(t *testing.T) {
+	dir := t.TempDir()
+	// До версии 3 поля не кодировались: кавычки - часть имени
+	writeDumpFile(t, dir, "accounts.dump", "#wallet-dump;accounts;2;1\n1;+992000000001;1000;TJS\n")
+	writeDumpFile(t, dir, "favorites.dump", "#wallet-dump;favorites;2;1\nf1;1;\"Дом\";100;food;TJS\n")
+
+	s := &Service{}
+	if err := s.Import(dir); err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+	favorites, _ := s.AccountFavorites(1)
+	if len(favorites) != 1 || favorites[0].Name != `"Дом"` {
+		t.Errorf("unexpected favorites %v", favorites)
+	}
+}
+
+func TestService_Import_Version3(t *testing.T) {
+	dir := t.TempDir()
+	// В версии 3 времени нет: оно остаётся неизвестным
+	writeDumpFile(t, dir, "accounts.dump", "#wallet-dump;accounts;3;2\n1;+992000000001;1000;TJS\n2;+992000000002;0;TJS\n")
+	writeDumpFile(t, dir, "transfers.dump", "#wallet-dump;transfers;3;1\nt1;1;2;100;OK;TJS;;;;\"долг; обед\"\n")
+
+	s := &Service{}
+	if err := s.Import(dir); err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+	account, _ := s.FindAccountByID(1)
+	transfer, _ := s.FindTransferByID("t1")
+	if !account.CreatedAt.IsZero() || !transfer.UpdatedAt.IsZero() || transfer.Comment != "долг; обед" {
+		t.Errorf("unexpected records %+v, %+v", account, transfer)
+	}
+}
+
+func TestService_Import_Version4(t *testing.T) {
+	dir := t.TempDir()
+	// До версии 5 места в списке нет: избранное нумеруется в порядке файла
+	writeDumpFile(t, dir, "accounts.dump", "#wallet-dump;accounts;4;1\n1;+992000000001;1000;TJS;;\n")
+	writeDumpFile(t, dir, "favorites.dump", "#wallet-dump;favorites;4;2\nf1;1;Дом;100;food;TJS;;\nf2;1;Обед;50;food;TJS;;\n")
+
+	s := &Service{}
+	if err := s.Import(dir); err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+	favorites, _ := s.AccountFavorites(1)
+	if len(favorites) != 2 || favorites[0].ID != "f1" || favorites[0].Position != 1 || favorites[1].Position != 2 {
+		t.Errorf("unexpected favorites %+v", favorites)
+	}
+}
+
+func TestService_Import_HoldsVersion4(t *testing.T) {
+	dir := t.TempDir()
+	// Файлы блокировок до версии 6 писались с версией 4 в том же формате
+	writeDumpFile(t, dir, "accounts.dump", "#wallet-dump;accounts;4;1\n1;+992000000001;1000;TJS;;\n")
+	writeDumpFile(t, dir, "holds.dump", "#wallet-dump;holds;4;1\nh1;1;100;TJS;food;VOIDED;0;;;;\n")
+
+	s := &Service{}
+	if err := s.Import(dir); err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+	if hold, err := s.FindHoldByID("h1"); err != nil || hold.Status != types.HoldStatusVoided {
+		t.Errorf("unexpected hold %+v (%v)", hold, err)
+	}
+}
+
+func TestDumpKinds_Versions(t *testing.T) {
+	kinds := []dumpKind{
+		accountsDump, paymentsDump, favoritesDump, transfersDump,
+		holdsDump, refundsDump, schedulesDump, scheduleRunsDump,
+	}
+	latest := false
+	for _, kind := range kinds {
+		if kind.since < 1 || kind.since > dumpVersion {
+			t.Errorf("%s: version %d outside 1..%d", kind.name, kind.since, dumpVersion)
+		}
+		latest = latest || kind.since == dumpVersion
+		since := kind.since
+		for _, column := range kind.columns {
+			// Новые поля добавляются в конец записи
+			if column.since < since || column.since > dumpVersion {
+				t.Errorf("%s.%s: version %d out of order", kind.name, column.name, column.since)
+			}
+			since = column.since
+			latest = latest || column.since == dumpVersion
+		}
+	}
+	if !latest {
+		t.Errorf("nothing was added in version %d", dumpVersion)
+	}
+}
+
+func TestService_Import_InvalidTime(t *testing.T) {
+	dir := t.TempDir()
+	writeDumpFile(t, dir, "accounts.dump", "#wallet-dump;accounts;4;1\n1;+992000000001;1000;TJS;2024-01-01;\n")
+
+	err := (&Service{}).Import(dir)
+	var importErr *ImportError
+	if !errors.As(err, &importErr) || len(importErr.Problems) != 1 || importErr.Problems[0].Line != 2 {
+		t.Errorf("expected problem on line 2, got %v", err)
+	}
+}
@@ -0,0 +1,231 @@
+package wallet
+
+import (
+	"bufio"
+	"errors"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/akmalsulaymonov/alif-wallet/pkg/types"
+)
+
+var ErrRateNotFound = errors.New("exchange rate not found")
+var ErrInvalidRate = errors.New("invalid exchange rate")
+
+// ExchangeRateProvider - источник курсов обмена валют.
+type ExchangeRateProvider interface {
+	// Rate возвращает курс from -> to: сколько единиц валюты to стоит
+	// одна единица валюты from. Если курса нет, возвращает ErrRateNotFound.
+	Rate(from, to types.Currency) (*big.Rat, error)
+}
+
+type currencyPair struct {
+	from, to types.Currency
+}
+
+// StaticRates - курсы, заданные через Set или загруженные из файла.
+// Если курс from -> to не задан, используется величина, обратная курсу to -> from.
+type StaticRates struct {
+	mu    sync.RWMutex
+	rates map[currencyPair]*big.Rat
+}
+
+func NewStaticRates() *StaticRates {
+	return &StaticRates{rates: map[currencyPair]*big.Rat{}}
+}
+
+// LoadRates загружает курсы из файла со строками вида "USD;TJS;10.95".
+// Пустые строки и строки, начинающиеся с '#', пропускаются.
+func LoadRates(path string) (*StaticRates, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	rates := NewStaticRates()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		parts := strings.Split(line, ";")
+		if len(parts) != 3 {
+			return nil, errors.New("invalid rates file format")
+		}
+		if err := rates.Set(types.Currency(parts[0]), types.Currency(parts[1]), parts[2]); err != nil {
+			return nil, err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return rates, nil
+}
+
+// Set задаёт курс from -> to десятичной строкой, например "10.95".
+func (r *StaticRates) Set(from, to types.Currency, rate string) error {
+	if !from.Valid() || !to.Valid() {
+		return ErrUnknownCurrency
+	}
+	value, err := parseRate(rate)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.rates == nil {
+		r.rates = map[currencyPair]*big.Rat{}
+	}
+	r.rates[currencyPair{from, to}] = value
+	return nil
+}
+
+func (r *StaticRates) Rate(from, to types.Currency) (*big.Rat, error) {
+	if from == to {
+		return big.NewRat(1, 1), nil
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if rate, ok := r.rates[currencyPair{from, to}]; ok {
+		return new(big.Rat).Set(rate), nil
+	}
+	if rate, ok := r.rates[currencyPair{to, from}]; ok {
+		return new(big.Rat).Inv(rate), nil
+	}
+	return nil, ErrRateNotFound
+}
+
+// SetExchangeRateProvider подключает источник курсов. Без него платежи
+// и переводы в чужой валюте возвращают ErrCurrencyMismatch.
+func (s *Service) SetExchangeRateProvider(provider ExchangeRateProvider) {
+	s.global.Lock()
+	defer s.global.Unlock()
+
+	s.rates = provider
+}
+
+// exchange - результат конвертации: source списывается, target зачисляется.
+// Без конвертации source и target совпадают, а rate равен nil.
+type exchange struct {
+	source types.Amount
+	target types.Amount
+	rate   *big.Rat
+}
+
+// recorded возвращает поля конвертации для Payment и Transfer:
+// пустые значения, если конвертации не было.
+func (e exchange) recorded() (types.Money, types.Currency, string) {
+	if e.rate == nil {
+		return 0, "", ""
+	}
+	return e.target.Value, e.target.Currency, formatRate(e.rate)
+}
+
+// rate возвращает курс from -> to. Вызывается под блокировкой аккаунта,
+// то есть при захваченной на чтение global.
+func (s *Service) rate(from, to types.Currency) (*big.Rat, error) {
+	if s.rates == nil {
+		return nil, ErrCurrencyMismatch
+	}
+	rate, err := s.rates.Rate(from, to)
+	if err != nil {
+		return nil, err
+	}
+	if rate.Sign() <= 0 {
+		return nil, ErrInvalidRate
+	}
+	return rate, nil
+}
+
+// quoteTarget считает, сколько списать в валюте from, чтобы получатель
+// получил ровно target. Сумма списания округляется вверх.
+func (s *Service) quoteTarget(from types.Currency, target types.Amount) (exchange, error) {
+	if from == target.Currency {
+		return exchange{source: target, target: target}, nil
+	}
+	rate, err := s.rate(from, target.Currency)
+	if err != nil {
+		return exchange{}, err
+	}
+
+	value, err := convert(target, from, new(big.Rat).Inv(rate), true)
+	if err != nil {
+		return exchange{}, err
+	}
+	return exchange{source: types.Amount{Value: value, Currency: from}, target: target, rate: rate}, nil
+}
+
+// quoteSource считает, сколько получит получатель в валюте to при списании
+// ровно source. Сумма зачисления округляется вниз.
+func (s *Service) quoteSource(source types.Amount, to types.Currency) (exchange, error) {
+	if source.Currency == to {
+		return exchange{source: source, target: source}, nil
+	}
+	rate, err := s.rate(source.Currency, to)
+	if err != nil {
+		return exchange{}, err
+	}
+
+	value, err := convert(source, to, rate, false)
+	if err != nil {
+		return exchange{}, err
+	}
+	if value <= 0 {
+		return exchange{}, ErrAmountMustBePositive
+	}
+	return exchange{source: source, target: types.Amount{Value: value, Currency: to}, rate: rate}, nil
+}
+
+// convert переводит amount в минимальные единицы валюты to по курсу rate
+// с округлением вверх или вниз. Обе стороны конвертации хранятся целыми
+// минимальными единицами, поэтому округление не создаёт и не теряет денег:
+// дробная часть просто не списывается (вверх) или не зачисляется (вниз).
+func convert(amount types.Amount, to types.Currency, rate *big.Rat, up bool) (types.Money, error) {
+	exact := new(big.Rat).SetInt64(int64(amount.Value))
+	exact.Mul(exact, rate)
+	exact.Mul(exact, new(big.Rat).SetFrac(pow10(to.MinorUnits()), pow10(amount.Currency.MinorUnits())))
+
+	quo, rem := new(big.Int).QuoRem(exact.Num(), exact.Denom(), new(big.Int))
+	if up && rem.Sign() > 0 {
+		quo.Add(quo, big.NewInt(1))
+	}
+	if !quo.IsInt64() {
+		return 0, types.ErrInvalidAmount
+	}
+	return types.Money(quo.Int64()), nil
+}
+
+func pow10(n int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
+
+// parseRate разбирает положительный курс в виде "10.95" или "219/20".
+func parseRate(s string) (*big.Rat, error) {
+	rate, ok := new(big.Rat).SetString(s)
+	if !ok || rate.Sign() <= 0 {
+		return nil, ErrInvalidRate
+	}
+	return rate, nil
+}
+
+// formatRate записывает курс десятичной дробью, если она конечна,
+// иначе обыкновенной дробью: "10.95", "20/219".
+func formatRate(rate *big.Rat) string {
+	for prec := 0; prec <= 18; prec++ {
+		s := rate.FloatString(prec)
+		if back, ok := new(big.Rat).SetString(s); ok && back.Cmp(rate) == 0 {
+			return s
+		}
+	}
+	return rate.RatString()
+}
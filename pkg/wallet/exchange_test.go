@@ -0,0 +1,288 @@
+package wallet
+
+import (
+	"errors"
+	"math/big"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/akmalsulaymonov/alif-wallet/pkg/types"
+)
+
+func newExchangeService(t *testing.T) (*Service, *types.Account, *types.Account) {
+	t.Helper()
+	rates := NewStaticRates()
+	if err := rates.Set(types.USD, types.TJS, "10.95"); err != nil {
+		t.Fatalf("failed to set rate: %v", err)
+	}
+
+	s := &Service{}
+	s.SetExchangeRateProvider(rates)
+	tjs, err := s.RegisterAccount("+992000000001")
+	if err != nil {
+		t.Fatalf("failed to register account: %v", err)
+	}
+	usd, err := s.RegisterAccountWithCurrency("+992000000002", types.USD)
+	if err != nil {
+		t.Fatalf("failed to register account: %v", err)
+	}
+	s.Deposit(tjs.ID, 100_000)
+	return s, tjs, usd
+}
+
+func TestStaticRates_Rate(t *testing.T) {
+	rates := NewStaticRates()
+	rates.Set(types.USD, types.TJS, "10.95")
+
+	rate, err := rates.Rate(types.USD, types.TJS)
+	if err != nil || rate.Cmp(big.NewRat(1095, 100)) != 0 {
+		t.Errorf("expected 10.95, got %v (%v)", rate, err)
+	}
+	// Обратный курс
+	rate, err = rates.Rate(types.TJS, types.USD)
+	if err != nil || rate.Cmp(big.NewRat(100, 1095)) != 0 {
+		t.Errorf("expected 100/1095, got %v (%v)", rate, err)
+	}
+	if _, err := rates.Rate(types.EUR, types.TJS); !errors.Is(err, ErrRateNotFound) {
+		t.Errorf("expected ErrRateNotFound, got %v", err)
+	}
+
+	if err := rates.Set(types.USD, types.TJS, "-1"); !errors.Is(err, ErrInvalidRate) {
+		t.Errorf("expected ErrInvalidRate, got %v", err)
+	}
+	if err := rates.Set("XXX", types.TJS, "1"); !errors.Is(err, ErrUnknownCurrency) {
+		t.Errorf("expected ErrUnknownCurrency, got %v", err)
+	}
+}
+
+func TestLoadRates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rates.txt")
+	err := os.WriteFile(path, []byte("# курсы НБТ\nUSD;TJS;10.95\n\nEUR;TJS;11.9\n"), 0666)
+	if err != nil {
+		t.Fatalf("failed to write rates: %v", err)
+	}
+
+	rates, err := LoadRates(path)
+	if err != nil {
+		t.Fatalf("failed to load rates: %v", err)
+	}
+	rate, err := rates.Rate(types.EUR, types.TJS)
+	if err != nil || rate.Cmp(big.NewRat(119, 10)) != 0 {
+		t.Errorf("expected 11.9, got %v (%v)", rate, err)
+	}
+
+	os.WriteFile(path, []byte("USD;TJS\n"), 0666)
+	if _, err := LoadRates(path); err == nil {
+		t.Error("expected error for invalid rates file")
+	}
+}
+
+func TestService_PayAmount_Conversion(t *testing.T) {
+	s, account, _ := newExchangeService(t)
+
+	// 10.01 USD = 109.6095 TJS, списывается 109.61
+	payment, err := s.PayAmount(account.ID, types.Amount{Value: 1001, Currency: types.USD}, "shop")
+	if err != nil {
+		t.Fatalf("failed to pay: %v", err)
+	}
+	want := types.Payment{
+		ID:             payment.ID,
+		AccountID:      account.ID,
+		Amount:         10961,
+		Category:       "shop",
+		Status:         types.PaymentStatusInProgress,
+		Currency:       types.TJS,
+		TargetAmount:   1001,
+		TargetCurrency: types.USD,
+		Rate:           "20/219",
+
+		CreatedAt:       payment.CreatedAt,
+		UpdatedAt:       payment.CreatedAt,
+		StatusChangedAt: payment.CreatedAt,
+	}
+	if *payment != want || payment.CreatedAt.IsZero() {
+		t.Errorf("expected payment %+v, got %+v", want, *payment)
+	}
+	if accountBalance(s, account.ID) != 100_000-10961 {
+		t.Errorf("expected balance %v, got %v", 100_000-10961, accountBalance(s, account.ID))
+	}
+
+	// Возврат отдаёт ровно списанную сумму
+	if err := s.Reject(payment.ID); err != nil {
+		t.Fatalf("failed to reject: %v", err)
+	}
+	if accountBalance(s, account.ID) != 100_000 {
+		t.Errorf("expected balance 100000 after reject, got %v", accountBalance(s, account.ID))
+	}
+	if report := s.CheckLedger(); !report.OK() {
+		t.Errorf("expected consistent ledger, got %+v", report)
+	}
+}
+
+func TestService_PayAmount_ConversionErrors(t *testing.T) {
+	s, account, _ := newExchangeService(t)
+
+	_, err := s.PayAmount(account.ID, types.Amount{Value: 100, Currency: types.EUR}, "shop")
+	if !errors.Is(err, ErrRateNotFound) {
+		t.Errorf("expected ErrRateNotFound, got %v", err)
+	}
+	_, err = s.PayAmount(account.ID, types.Amount{Value: 100_000, Currency: types.USD}, "shop")
+	if !errors.Is(err, ErrNotEnoughBalance) {
+		t.Errorf("expected ErrNotEnoughBalance, got %v", err)
+	}
+	if accountBalance(s, account.ID) != 100_000 {
+		t.Errorf("expected balance 100000, got %v", accountBalance(s, account.ID))
+	}
+}
+
+func TestService_RepeatAndFavorite_Conversion(t *testing.T) {
+	s, account, _ := newExchangeService(t)
+
+	payment, _ := s.PayAmount(account.ID, types.Amount{Value: 500, Currency: types.USD}, "shop")
+
+	repeated, err := s.Repeat(payment.ID)
+	if err != nil {
+		t.Fatalf("failed to repeat: %v", err)
+	}
+	if repeated.TargetAmount != 500 || repeated.TargetCurrency != types.USD {
+		t.Errorf("expected repeat of 500 USD, got %+v", repeated)
+	}
+
+	favorite, err := s.FavoritePayment(payment.ID, "магазин")
+	if err != nil {
+		t.Fatalf("failed to add favorite: %v", err)
+	}
+	if favorite.Amount != 500 || favorite.Currency != types.USD {
+		t.Errorf("expected favorite for 500 USD, got %+v", favorite)
+	}
+	fromFavorite, err := s.PayFromFavorite(favorite.ID)
+	if err != nil {
+		t.Fatalf("failed to pay from favorite: %v", err)
+	}
+	if fromFavorite.Amount != 5475 || fromFavorite.Currency != types.TJS {
+		t.Errorf("expected 5475 TJS debited, got %+v", fromFavorite)
+	}
+}
+
+func TestService_Transfer_Conversion(t *testing.T) {
+	s, from, to := newExchangeService(t)
+
+	// 100.00 TJS = 9.1324... USD, зачисляется 9.13
+	transfer, err := s.Transfer(from.ID, to.ID, 10_000, "")
+	if err != nil {
+		t.Fatalf("failed to transfer: %v", err)
+	}
+	if transfer.Amount != 10_000 || transfer.TargetAmount != 913 || transfer.TargetCurrency != types.USD {
+		t.Errorf("unexpected transfer %+v", transfer)
+	}
+	if accountBalance(s, from.ID) != 90_000 || accountBalance(s, to.ID) != 913 {
+		t.Errorf("expected balances 90000/913, got %v/%v", accountBalance(s, from.ID), accountBalance(s, to.ID))
+	}
+
+	history, err := s.ExportAccountHistory(to.ID)
+	if err != nil {
+		t.Fatalf("failed to get history: %v", err)
+	}
+	if len(history) != 1 || history[0].Amount != 913 || history[0].Currency != types.USD {
+		t.Errorf("unexpected history %+v", history)
+	}
+
+	// Отмена возвращает суммы перевода независимо от текущего курса
+	rates := NewStaticRates()
+	rates.Set(types.USD, types.TJS, "12")
+	s.SetExchangeRateProvider(rates)
+	if err := s.ReverseTransfer(transfer.ID); err != nil {
+		t.Fatalf("failed to reverse transfer: %v", err)
+	}
+	if accountBalance(s, from.ID) != 100_000 || accountBalance(s, to.ID) != 0 {
+		t.Errorf("expected balances 100000/0, got %v/%v", accountBalance(s, from.ID), accountBalance(s, to.ID))
+	}
+	if report := s.CheckLedger(); !report.OK() {
+		t.Errorf("expected consistent ledger, got %+v", report)
+	}
+
+	// Сумма, которая меньше минимальной единицы валюты получателя
+	if _, err := s.Transfer(from.ID, to.ID, 1, ""); !errors.Is(err, ErrAmountMustBePositive) {
+		t.Errorf("expected ErrAmountMustBePositive, got %v", err)
+	}
+}
+
+func TestService_Conversion_WithoutProvider(t *testing.T) {
+	s := &Service{}
+	tjs, _ := s.RegisterAccount("+992000000001")
+	usd, _ := s.RegisterAccountWithCurrency("+992000000002", types.USD)
+	s.Deposit(tjs.ID, 1000)
+
+	if _, err := s.PayAmount(tjs.ID, types.Amount{Value: 10, Currency: types.USD}, "shop"); !errors.Is(err, ErrCurrencyMismatch) {
+		t.Errorf("expected ErrCurrencyMismatch, got %v", err)
+	}
+	if _, err := s.Transfer(tjs.ID, usd.ID, 10, ""); !errors.Is(err, ErrCurrencyMismatch) {
+		t.Errorf("expected ErrCurrencyMismatch, got %v", err)
+	}
+}
+
+func TestConvert_Rounding(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	pairs := []struct {
+		from, to types.Currency
+	}{
+		{types.TJS, types.USD},
+		{types.USD, types.JPY},
+		{types.JPY, types.KWD},
+		{types.KWD, types.TJS},
+	}
+
+	for i := 0; i < 1000; i++ {
+		pair := pairs[i%len(pairs)]
+		rate := big.NewRat(rng.Int63n(1_000_000)+1, rng.Int63n(1_000_000)+1)
+		amount := types.Amount{Value: types.Money(rng.Int63n(1_000_000_000) + 1), Currency: pair.from}
+
+		exact := new(big.Rat).SetInt64(int64(amount.Value))
+		exact.Mul(exact, rate)
+		exact.Mul(exact, new(big.Rat).SetFrac(pow10(pair.to.MinorUnits()), pow10(pair.from.MinorUnits())))
+
+		down, err := convert(amount, pair.to, rate, false)
+		if err != nil {
+			t.Fatalf("failed to convert: %v", err)
+		}
+		up, _ := convert(amount, pair.to, rate, true)
+
+		// Округление вниз не зачисляет больше точной суммы,
+		// округление вверх не списывает меньше, и оба отличаются меньше чем на единицу
+		if new(big.Rat).SetInt64(int64(down)).Cmp(exact) > 0 {
+			t.Fatalf("rounded down %v exceeds exact %v", down, exact)
+		}
+		if new(big.Rat).SetInt64(int64(up)).Cmp(exact) < 0 {
+			t.Fatalf("rounded up %v is less than exact %v", up, exact)
+		}
+		if up-down > 1 {
+			t.Fatalf("rounding error over one minor unit: %v..%v", down, up)
+		}
+	}
+}
+
+func TestService_ExportImport_Conversion(t *testing.T) {
+	dir := t.TempDir()
+
+	source, tjs, usd := newExchangeService(t)
+	source.PayAmount(tjs.ID, types.Amount{Value: 1001, Currency: types.USD}, "shop")
+	source.Transfer(tjs.ID, usd.ID, 10_000, "курс;10.95")
+
+	if err := source.Export(dir); err != nil {
+		t.Fatalf("failed to export: %v", err)
+	}
+	target := &Service{}
+	if err := target.Import(dir); err != nil {
+		t.Fatalf("failed to import: %v", err)
+	}
+
+	if !reflect.DeepEqual(servicePayments(source), servicePayments(target)) {
+		t.Errorf("payments differ after import: %v, %v", servicePayments(source), servicePayments(target))
+	}
+	if !reflect.DeepEqual(serviceTransfers(source), serviceTransfers(target)) {
+		t.Errorf("transfers differ after import: %v, %v", serviceTransfers(source), serviceTransfers(target))
+	}
+}
@@ -0,0 +1,189 @@
+package wallet
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/akmalsulaymonov/alif-wallet/pkg/types"
+)
+
+var ErrExpirerRunning = errors.New("expirer already running")
+var ErrInvalidTimeout = errors.New("timeout must be > 0")
+
+// DefaultExpiryInterval - период проверок Expirer по умолчанию.
+const DefaultExpiryInterval = time.Minute
+
+// ExpiryReport - результат одного прохода ExpirePayments или Expirer.
+type ExpiryReport struct {
+	At      time.Time       // время прохода по часам Service
+	Expired []types.Payment // платежи, переведённые в EXPIRED, после возврата
+	Holds   []types.Hold    // истёкшие блокировки, только у Expirer
+}
+
+// ExpirePayments переводит в EXPIRED платежи, которые находятся в статусе
+// INPROGRESS дольше timeout, и возвращает их сумму на аккаунт. Платёж
+// переводится через ту же проверку переходов, что Reject и Confirm, поэтому
+// деньги возвращаются ровно один раз, даже если платёж одновременно
+// подтверждают или ExpirePayments вызывают из нескольких горутин.
+//
+// Платежи с неизвестным временем (импортированные без него) не истекают.
+func (s *Service) ExpirePayments(timeout time.Duration) (*ExpiryReport, error) {
+	if timeout <= 0 {
+		return nil, ErrInvalidTimeout
+	}
+
+	report := &ExpiryReport{At: s.stamp()}
+	deadline := report.At.Add(-timeout)
+
+	var stale []types.Payment
+	for _, payment := range s.snapshotPayments() {
+		if payment.Status == types.PaymentStatusInProgress && !payment.StatusChangedAt.IsZero() && !payment.StatusChangedAt.After(deadline) {
+			stale = append(stale, payment)
+		}
+	}
+	sort.Slice(stale, func(i, j int) bool { return stale[i].StatusChangedAt.Before(stale[j].StatusChangedAt) })
+
+	for _, payment := range stale {
+		err := s.changeStatus(payment.ID, types.PaymentStatusExpired)
+		if errors.Is(err, ErrIllegalTransition) || errors.Is(err, ErrPaymentNotFound) {
+			// Платёж успели подтвердить, отменить или удалить
+			continue
+		}
+		if err != nil {
+			return report, err
+		}
+		if expired, err := s.FindPaymentByID(payment.ID); err == nil {
+			payment = *expired
+		} else {
+			payment.Status = types.PaymentStatusExpired
+		}
+		report.Expired = append(report.Expired, payment)
+	}
+	return report, nil
+}
+
+// ExpirerOptions - параметры Expirer.
+type ExpirerOptions struct {
+	// Timeout - сколько платёж может находиться в обработке.
+	Timeout time.Duration
+	// Interval - период проверок, 0 - DefaultExpiryInterval.
+	Interval time.Duration
+	// OnExpire вызывается после прохода, в котором истёк хотя бы один
+	// платёж или блокировка.
+	OnExpire func(*ExpiryReport)
+	// OnError вызывается, если проход завершился ошибкой.
+	// Expirer при этом продолжает работу.
+	OnError func(error)
+}
+
+// Expirer в фоне периодически вызывает ExpirePayments и ExpireHolds.
+// Время платежей сравнивается с часами Service (см. SetClock),
+// период проверок отсчитывается по реальному времени.
+type Expirer struct {
+	svc     *Service
+	options ExpirerOptions
+	loop    loop
+}
+
+// NewExpirer создаёт Expirer для сервиса. Проверки начинаются после Start.
+func (s *Service) NewExpirer(options ExpirerOptions) (*Expirer, error) {
+	if options.Timeout <= 0 {
+		return nil, ErrInvalidTimeout
+	}
+	if options.Interval <= 0 {
+		options.Interval = DefaultExpiryInterval
+	}
+	return &Expirer{svc: s, options: options}, nil
+}
+
+// Start запускает проверки: первую сразу, следующие через Interval.
+// Проверки прекращаются при отмене ctx или вызове Stop. Повторный Start
+// до остановки возвращает ErrExpirerRunning.
+func (e *Expirer) Start(ctx context.Context) error {
+	if !e.loop.start(ctx, e.options.Interval, e.check) {
+		return ErrExpirerRunning
+	}
+	return nil
+}
+
+// Stop останавливает проверки и дожидается завершения текущей.
+// Stop без Start ничего не делает.
+func (e *Expirer) Stop() {
+	e.loop.stop()
+}
+
+func (e *Expirer) check() {
+	report, err := e.svc.ExpirePayments(e.options.Timeout)
+	if err != nil && e.options.OnError != nil {
+		e.options.OnError(err)
+	}
+	if report == nil {
+		return
+	}
+	report.Holds, err = e.svc.ExpireHolds()
+	if err != nil && e.options.OnError != nil {
+		e.options.OnError(err)
+	}
+	if len(report.Expired)+len(report.Holds) > 0 && e.options.OnExpire != nil {
+		e.options.OnExpire(report)
+	}
+}
+
+// loop вызывает check в отдельной горутине: первый раз сразу, следующие
+// через interval. Общая часть Expirer и Scheduler.
+type loop struct {
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// start запускает проверки и возвращает false, если они уже идут.
+func (l *loop) start(ctx context.Context, interval time.Duration, check func()) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.done != nil {
+		select {
+		case <-l.done:
+		default:
+			return false
+		}
+	}
+
+	ctx, l.cancel = context.WithCancel(ctx)
+	l.done = make(chan struct{})
+	go l.run(ctx, l.done, interval, check)
+	return true
+}
+
+// stop останавливает проверки и дожидается завершения текущей.
+func (l *loop) stop() {
+	l.mu.Lock()
+	cancel, done := l.cancel, l.done
+	l.mu.Unlock()
+
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-done
+}
+
+func (l *loop) run(ctx context.Context, done chan struct{}, interval time.Duration, check func()) {
+	defer close(done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		check()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
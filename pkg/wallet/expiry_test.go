@@ -0,0 +1,155 @@
+package wallet
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/akmalsulaymonov/alif-wallet/pkg/types"
+)
+
+func TestService_ExpirePayments(t *testing.T) {
+	clock := newTestClock()
+	s := &Service{}
+	s.SetClock(clock.Now)
+	account, _ := s.RegisterAccount("+992000000001")
+	s.Deposit(account.ID, 1000)
+
+	stale, _ := s.Pay(account.ID, 100, "taxi")
+	confirmed, _ := s.Pay(account.ID, 200, "food")
+	s.Confirm(confirmed.ID)
+	clock.Advance(30 * time.Minute)
+	fresh, _ := s.Pay(account.ID, 300, "hotel")
+	clock.Advance(30 * time.Minute)
+
+	report, err := s.ExpirePayments(time.Hour)
+	if err != nil {
+		t.Fatalf("ExpirePayments failed: %v", err)
+	}
+	if len(report.Expired) != 1 || report.Expired[0].ID != stale.ID || report.Expired[0].Status != types.PaymentStatusExpired {
+		t.Fatalf("expected %s expired, got %+v", stale.ID, report.Expired)
+	}
+	if !report.Expired[0].StatusChangedAt.Equal(clock.Now()) {
+		t.Errorf("expected status change at %v, got %v", clock.Now(), report.Expired[0].StatusChangedAt)
+	}
+	if got, _ := s.FindAccountByID(account.ID); got.Balance != 500 {
+		t.Errorf("expected balance 500, got %v", got.Balance)
+	}
+
+	// Повторный проход ничего не возвращает повторно
+	report, _ = s.ExpirePayments(time.Hour)
+	if len(report.Expired) != 0 {
+		t.Errorf("expected nothing expired, got %+v", report.Expired)
+	}
+	if payment, _ := s.FindPaymentByID(fresh.ID); payment.Status != types.PaymentStatusInProgress {
+		t.Errorf("expected fresh payment in progress, got %v", payment.Status)
+	}
+	if report := s.CheckLedger(); !report.OK() {
+		t.Errorf("ledger is inconsistent: %+v", report)
+	}
+
+	if _, err := s.ExpirePayments(0); !errors.Is(err, ErrInvalidTimeout) {
+		t.Errorf("expected error %v, got %v", ErrInvalidTimeout, err)
+	}
+}
+
+func TestService_ExpirePayments_Concurrent(t *testing.T) {
+	clock := newTestClock()
+	s := &Service{}
+	s.SetClock(clock.Now)
+	account, _ := s.RegisterAccount("+992000000001")
+	s.Deposit(account.ID, 10_000)
+	for i := 0; i < 50; i++ {
+		s.Pay(account.ID, 100, "taxi")
+	}
+	clock.Advance(2 * time.Hour)
+
+	var wg sync.WaitGroup
+	expired := make([]int, 4)
+	for i := range expired {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			report, err := s.ExpirePayments(time.Hour)
+			if err != nil {
+				t.Errorf("ExpirePayments failed: %v", err)
+				return
+			}
+			expired[i] = len(report.Expired)
+		}(i)
+	}
+	wg.Wait()
+
+	total := 0
+	for _, n := range expired {
+		total += n
+	}
+	if total != 50 {
+		t.Errorf("expected 50 payments expired once, got %d", total)
+	}
+	if got, _ := s.FindAccountByID(account.ID); got.Balance != 10_000 {
+		t.Errorf("expected balance 10000, got %v", got.Balance)
+	}
+}
+
+func TestExpirer(t *testing.T) {
+	clock := newTestClock()
+	s := &Service{}
+	s.SetClock(clock.Now)
+	account, _ := s.RegisterAccount("+992000000001")
+	s.Deposit(account.ID, 1000)
+	payment, _ := s.Pay(account.ID, 100, "taxi")
+	clock.Advance(2 * time.Hour)
+
+	reports := make(chan *ExpiryReport, 1)
+	expirer, err := s.NewExpirer(ExpirerOptions{
+		Timeout:  time.Hour,
+		Interval: time.Millisecond,
+		OnExpire: func(report *ExpiryReport) { reports <- report },
+	})
+	if err != nil {
+		t.Fatalf("NewExpirer failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := expirer.Start(ctx); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	if err := expirer.Start(ctx); !errors.Is(err, ErrExpirerRunning) {
+		t.Errorf("expected error %v, got %v", ErrExpirerRunning, err)
+	}
+
+	select {
+	case report := <-reports:
+		if len(report.Expired) != 1 || report.Expired[0].ID != payment.ID {
+			t.Errorf("unexpected report %+v", report)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("payment did not expire")
+	}
+	expirer.Stop()
+
+	// После остановки новые платежи не истекают
+	later, _ := s.Pay(account.ID, 100, "taxi")
+	clock.Advance(2 * time.Hour)
+	time.Sleep(10 * time.Millisecond)
+	if got, _ := s.FindPaymentByID(later.ID); got.Status != types.PaymentStatusInProgress {
+		t.Errorf("expected payment in progress after Stop, got %v", got.Status)
+	}
+
+	// Отмена контекста тоже останавливает проверки, после чего можно запустить снова
+	ctx, cancel = context.WithCancel(context.Background())
+	if err := expirer.Start(ctx); err != nil {
+		t.Fatalf("Start after Stop failed: %v", err)
+	}
+	<-reports
+	cancel()
+	expirer.Stop()
+
+	if _, err := s.NewExpirer(ExpirerOptions{}); !errors.Is(err, ErrInvalidTimeout) {
+		t.Errorf("expected error %v, got %v", ErrInvalidTimeout, err)
+	}
+}
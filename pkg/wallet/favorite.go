@@ -0,0 +1,268 @@
+package wallet
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/akmalsulaymonov/alif-wallet/pkg/types"
+	"github.com/google/uuid"
+)
+
+var ErrFavoriteNotFound = errors.New("favorite not found")
+var ErrFavoriteNameTaken = errors.New("favorite name already taken")
+var ErrInvalidFavoriteOrder = errors.New("invalid favorites order")
+
+// FavoritePayment добавляет платёж в конец списка избранного аккаунта
+// под именем name. Имя должно отличаться от имён остального избранного
+// аккаунта без учёта регистра, иначе возвращается ErrFavoriteNameTaken.
+func (s *Service) FavoritePayment(paymentID string, name string) (*types.Favorite, error) {
+	accountID, err := s.paymentAccountID(paymentID)
+	if err != nil {
+		return nil, ErrPaymentNotFound
+	}
+
+	unlock, err := s.lockAccount(accountID)
+	if err != nil {
+		return nil, ErrAccountNotFound
+	}
+	defer unlock()
+
+	var favorite *types.Favorite
+	err = s.repo().Update(func(tx Tx) error {
+		// Находим существующий платёж
+		payment, err := tx.Payment(paymentID)
+		if err != nil {
+			return ErrPaymentNotFound
+		}
+		favorites := accountFavorites(tx, payment.AccountID)
+		if err := checkFavoriteName(favorites, "", name); err != nil {
+			return err
+		}
+
+		// Создаём новый элемент избранного на сумму, полученную получателем
+		target := paymentTarget(payment)
+		now := s.stamp()
+		favorite = &types.Favorite{
+			ID:        uuid.New().String(),
+			AccountID: payment.AccountID,
+			Name:      name,
+			Amount:    target.Value,
+			Category:  payment.Category,
+			Currency:  target.Currency,
+			Position:  len(favorites) + 1,
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+
+		// Добавляем в список избранного
+		tx.PutFavorite(favorite)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return favorite, nil
+}
+
+// accountFavorites возвращает избранное аккаунта в порядке списка.
+// Записи без места (из дампов до версии 5) идут в конце.
+func accountFavorites(tx Tx, accountID int64) []*types.Favorite {
+	favorites := tx.AccountFavorites(accountID)
+	sort.SliceStable(favorites, func(i, j int) bool {
+		a, b := favorites[i].Position, favorites[j].Position
+		return a != 0 && (b == 0 || a < b)
+	})
+	return favorites
+}
+
+// renumberFavorites нумерует избранное аккаунта подряд с 1
+// в порядке favorites и сохраняет изменившиеся записи.
+func renumberFavorites(tx Tx, favorites []*types.Favorite) {
+	for i, favorite := range favorites {
+		if favorite.Position != i+1 {
+			favorite.Position = i + 1
+			tx.PutFavorite(favorite)
+		}
+	}
+}
+
+// checkFavoriteName проверяет, что имя name не занято другим избранным,
+// кроме записи favoriteID.
+func checkFavoriteName(favorites []*types.Favorite, favoriteID string, name string) error {
+	for _, favorite := range favorites {
+		if favorite.ID != favoriteID && strings.EqualFold(favorite.Name, name) {
+			return fmt.Errorf("%w: %q", ErrFavoriteNameTaken, name)
+		}
+	}
+	return nil
+}
+
+// AccountFavorites возвращает копии избранного аккаунта в порядке списка.
+func (s *Service) AccountFavorites(accountID int64) ([]types.Favorite, error) {
+	var favorites []types.Favorite
+	err := s.repo().View(func(tx Tx) error {
+		if _, err := tx.Account(accountID); err != nil {
+			return err
+		}
+		for _, favorite := range accountFavorites(tx, accountID) {
+			favorites = append(favorites, *favorite)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return favorites, nil
+}
+
+// FindFavoriteByID возвращает копию избранного, см. FindAccountByID.
+func (s *Service) FindFavoriteByID(favoriteID string) (*types.Favorite, error) {
+	return findCopy(s, func(tx Tx) (*types.Favorite, error) { return tx.Favorite(favoriteID) })
+}
+
+// FavoriteChanges - изменения избранного для UpdateFavorite.
+// Поля nil не меняются.
+type FavoriteChanges struct {
+	Name     *string
+	Amount   *types.Money
+	Category *types.PaymentCategory
+}
+
+// UpdateFavorite меняет имя, сумму или категорию избранного. Сумма
+// задаётся в валюте избранного; платежи, уже сделанные по нему, не меняются.
+func (s *Service) UpdateFavorite(favoriteID string, changes FavoriteChanges) (*types.Favorite, error) {
+	if changes.Amount != nil && *changes.Amount <= 0 {
+		return nil, ErrAmountMustBePositive
+	}
+
+	var favorite *types.Favorite
+	err := s.updateFavorites(favoriteID, func(tx Tx, favorites []*types.Favorite) error {
+		var err error
+		if favorite, err = tx.Favorite(favoriteID); err != nil {
+			return err
+		}
+		if changes.Name != nil {
+			if err := checkFavoriteName(favorites, favorite.ID, *changes.Name); err != nil {
+				return err
+			}
+			favorite.Name = *changes.Name
+		}
+		if changes.Amount != nil {
+			favorite.Amount = *changes.Amount
+		}
+		if changes.Category != nil {
+			favorite.Category = *changes.Category
+		}
+		favorite.UpdatedAt = s.stamp()
+		tx.PutFavorite(favorite)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return favorite, nil
+}
+
+// RenameFavorite меняет имя избранного, см. UpdateFavorite.
+func (s *Service) RenameFavorite(favoriteID string, name string) (*types.Favorite, error) {
+	return s.UpdateFavorite(favoriteID, FavoriteChanges{Name: &name})
+}
+
+// DeleteFavorite удаляет избранное; остальное избранное аккаунта
+// сдвигается вверх по списку. Расписания по избранному становятся
+// неактивными, их выполнения остаются в истории.
+func (s *Service) DeleteFavorite(favoriteID string) error {
+	return s.updateFavorites(favoriteID, func(tx Tx, favorites []*types.Favorite) error {
+		now := s.stamp()
+		for _, schedule := range tx.Schedules() {
+			if schedule.FavoriteID == favoriteID && schedule.Active {
+				schedule.Active = false
+				schedule.RetryAt = time.Time{}
+				schedule.UpdatedAt = now
+				tx.PutSchedule(schedule)
+			}
+		}
+		tx.DeleteFavorite(favoriteID)
+
+		rest := favorites[:0]
+		for _, favorite := range favorites {
+			if favorite.ID != favoriteID {
+				rest = append(rest, favorite)
+			}
+		}
+		renumberFavorites(tx, rest)
+		return nil
+	})
+}
+
+// ReorderFavorites задаёт порядок списка избранного аккаунта: favoriteIDs
+// должен содержать всё избранное аккаунта ровно по одному разу,
+// иначе возвращается ErrInvalidFavoriteOrder.
+func (s *Service) ReorderFavorites(accountID int64, favoriteIDs []string) ([]types.Favorite, error) {
+	unlock, err := s.lockAccount(accountID)
+	if err != nil {
+		return nil, ErrAccountNotFound
+	}
+	defer unlock()
+
+	var favorites []types.Favorite
+	err = s.repo().Update(func(tx Tx) error {
+		current := accountFavorites(tx, accountID)
+		byID := make(map[string]*types.Favorite, len(current))
+		for _, favorite := range current {
+			byID[favorite.ID] = favorite
+		}
+		if len(favoriteIDs) != len(current) {
+			return fmt.Errorf("%w: account %d has %d favorites, got %d", ErrInvalidFavoriteOrder, accountID, len(current), len(favoriteIDs))
+		}
+
+		ordered := make([]*types.Favorite, 0, len(favoriteIDs))
+		for _, id := range favoriteIDs {
+			favorite, ok := byID[id]
+			if !ok {
+				return fmt.Errorf("%w: unknown or repeated favorite %s", ErrInvalidFavoriteOrder, id)
+			}
+			delete(byID, id)
+			ordered = append(ordered, favorite)
+		}
+		renumberFavorites(tx, ordered)
+
+		for _, favorite := range ordered {
+			favorites = append(favorites, *favorite)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return favorites, nil
+}
+
+// updateFavorites выполняет fn под блокировкой аккаунта избранного
+// favoriteID; fn получает избранное аккаунта в порядке списка.
+func (s *Service) updateFavorites(favoriteID string, fn func(tx Tx, favorites []*types.Favorite) error) error {
+	favorite, err := s.FindFavoriteByID(favoriteID)
+	if err != nil {
+		return err
+	}
+
+	unlock, err := s.lockAccount(favorite.AccountID)
+	if err != nil {
+		return ErrAccountNotFound
+	}
+	defer unlock()
+
+	return s.repo().Update(func(tx Tx) error {
+		if _, err := tx.Favorite(favoriteID); err != nil {
+			return err
+		}
+		return fn(tx, accountFavorites(tx, favorite.AccountID))
+	})
+}
@@ -0,0 +1,202 @@
+package wallet
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/akmalsulaymonov/alif-wallet/pkg/types"
+)
+
+// newFavoritesService возвращает сервис с аккаунтом и избранным
+// с именами names в этом порядке.
+func newFavoritesService(t *testing.T, names ...string) (*Service, *types.Account, []*types.Favorite) {
+	s := &Service{}
+	account, _ := s.RegisterAccount("+992000000001")
+	s.Deposit(account.ID, 10_000)
+	var favorites []*types.Favorite
+	for _, name := range names {
+		payment, _ := s.Pay(account.ID, 100, "food")
+		favorite, err := s.FavoritePayment(payment.ID, name)
+		if err != nil {
+			t.Fatalf("FavoritePayment failed: %v", err)
+		}
+		favorites = append(favorites, favorite)
+	}
+	return s, account, favorites
+}
+
+func favoriteNames(favorites []types.Favorite) []string {
+	names := make([]string, 0, len(favorites))
+	for _, favorite := range favorites {
+		names = append(names, favorite.Name)
+	}
+	return names
+}
+
+func TestService_FavoritePayment_NameTaken(t *testing.T) {
+	s, account, favorites := newFavoritesService(t, "Обед")
+	payment, _ := s.Pay(account.ID, 200, "taxi")
+
+	if _, err := s.FavoritePayment(payment.ID, "обед"); !errors.Is(err, ErrFavoriteNameTaken) {
+		t.Errorf("expected error %v, got %v", ErrFavoriteNameTaken, err)
+	}
+	if _, err := s.FavoritePayment("unknown", "Такси"); !errors.Is(err, ErrPaymentNotFound) {
+		t.Errorf("expected error %v, got %v", ErrPaymentNotFound, err)
+	}
+
+	// У другого аккаунта имя свободно
+	other, _ := s.RegisterAccount("+992000000002")
+	s.Deposit(other.ID, 1000)
+	otherPayment, _ := s.Pay(other.ID, 100, "food")
+	if _, err := s.FavoritePayment(otherPayment.ID, "Обед"); err != nil {
+		t.Errorf("FavoritePayment for another account failed: %v", err)
+	}
+
+	taxi, err := s.FavoritePayment(payment.ID, "Такси")
+	if err != nil {
+		t.Fatalf("FavoritePayment failed: %v", err)
+	}
+	if favorites[0].Position != 1 || taxi.Position != 2 {
+		t.Errorf("expected positions 1 and 2, got %v and %v", favorites[0].Position, taxi.Position)
+	}
+	if _, err := s.RenameFavorite(taxi.ID, "ОБЕД"); !errors.Is(err, ErrFavoriteNameTaken) {
+		t.Errorf("expected error %v, got %v", ErrFavoriteNameTaken, err)
+	}
+	// Своё имя можно сменить на то же в другом регистре
+	if _, err := s.RenameFavorite(taxi.ID, "такси"); err != nil {
+		t.Errorf("RenameFavorite failed: %v", err)
+	}
+}
+
+func TestService_UpdateFavorite(t *testing.T) {
+	s, account, favorites := newFavoritesService(t, "Обед")
+	amount := types.Money(250)
+	category := types.PaymentCategory("restaurant")
+
+	favorite, err := s.UpdateFavorite(favorites[0].ID, FavoriteChanges{Amount: &amount, Category: &category})
+	if err != nil {
+		t.Fatalf("UpdateFavorite failed: %v", err)
+	}
+	if favorite.Name != "Обед" || favorite.Amount != 250 || favorite.Category != category {
+		t.Errorf("unexpected favorite %+v", favorite)
+	}
+	payment, _ := s.PayFromFavorite(favorite.ID)
+	if payment.Amount != 250 || payment.Category != category {
+		t.Errorf("unexpected payment %+v", payment)
+	}
+
+	zero := types.Money(0)
+	if _, err := s.UpdateFavorite(favorite.ID, FavoriteChanges{Amount: &zero}); !errors.Is(err, ErrAmountMustBePositive) {
+		t.Errorf("expected error %v, got %v", ErrAmountMustBePositive, err)
+	}
+	if _, err := s.RenameFavorite("unknown", "Ужин"); !errors.Is(err, ErrFavoriteNotFound) {
+		t.Errorf("expected error %v, got %v", ErrFavoriteNotFound, err)
+	}
+	if got, _ := s.AccountFavorites(account.ID); len(got) != 1 || got[0].Amount != 250 {
+		t.Errorf("unexpected favorites %+v", got)
+	}
+}
+
+func TestService_ReorderFavorites(t *testing.T) {
+	s, account, favorites := newFavoritesService(t, "Обед", "Такси", "Интернет")
+	first, second, third := favorites[0].ID, favorites[1].ID, favorites[2].ID
+
+	reordered, err := s.ReorderFavorites(account.ID, []string{third, first, second})
+	if err != nil {
+		t.Fatalf("ReorderFavorites failed: %v", err)
+	}
+	if names := favoriteNames(reordered); names[0] != "Интернет" || names[1] != "Обед" || names[2] != "Такси" {
+		t.Errorf("unexpected order %v", names)
+	}
+
+	for _, ids := range [][]string{{third, first}, {third, first, first}, {third, first, "unknown"}} {
+		if _, err := s.ReorderFavorites(account.ID, ids); !errors.Is(err, ErrInvalidFavoriteOrder) {
+			t.Errorf("ReorderFavorites(%v): expected error %v, got %v", ids, ErrInvalidFavoriteOrder, err)
+		}
+	}
+
+	// Удаление сдвигает остальное избранное, новое добавляется в конец
+	if err := s.DeleteFavorite(first); err != nil {
+		t.Fatalf("DeleteFavorite failed: %v", err)
+	}
+	payment, _ := s.Pay(account.ID, 100, "food")
+	s.FavoritePayment(payment.ID, "Обед")
+	got, _ := s.AccountFavorites(account.ID)
+	if names := favoriteNames(got); len(names) != 3 || names[0] != "Интернет" || names[1] != "Такси" || names[2] != "Обед" {
+		t.Errorf("unexpected order %v", names)
+	}
+	for i, favorite := range got {
+		if favorite.Position != i+1 {
+			t.Errorf("expected position %d, got %+v", i+1, favorite)
+		}
+	}
+
+	// Порядок сохраняется при экспорте и импорте
+	dir := t.TempDir()
+	if err := s.Export(dir); err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	imported := &Service{}
+	if err := imported.Import(dir); err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+	got, _ = imported.AccountFavorites(account.ID)
+	if names := favoriteNames(got); len(names) != 3 || names[0] != "Интернет" || names[2] != "Обед" {
+		t.Errorf("unexpected order after import %v", names)
+	}
+}
+
+func TestService_DeleteFavorite(t *testing.T) {
+	s, _, favorites := newFavoritesService(t, "Обед")
+	schedule, _ := s.ScheduleFavorite(favorites[0].ID, types.ScheduleDaily, time.Time{}, "")
+
+	if err := s.DeleteFavorite(favorites[0].ID); err != nil {
+		t.Fatalf("DeleteFavorite failed: %v", err)
+	}
+	if _, err := s.FindFavoriteByID(favorites[0].ID); !errors.Is(err, ErrFavoriteNotFound) {
+		t.Errorf("expected error %v, got %v", ErrFavoriteNotFound, err)
+	}
+	if err := s.DeleteFavorite(favorites[0].ID); !errors.Is(err, ErrFavoriteNotFound) {
+		t.Errorf("expected error %v, got %v", ErrFavoriteNotFound, err)
+	}
+	if got, _ := s.FindScheduleByID(schedule.ID); got.Active {
+		t.Errorf("expected schedule inactive, got %+v", got)
+	}
+	if runs, _ := s.RunSchedules(RetryPolicy{}); len(runs) != 0 {
+		t.Errorf("expected nothing run, got %+v", runs)
+	}
+}
+
+func TestService_DeleteFavorite_Export(t *testing.T) {
+	for _, format := range []FileFormat{FormatDump, FormatJSON, FormatJSONL} {
+		t.Run(format.String(), func(t *testing.T) {
+			s := &Service{}
+			account, _ := s.RegisterAccount("+992000000001")
+			s.Deposit(account.ID, 1000)
+			payment, _ := s.Pay(account.ID, 100, "food")
+			favorite, _ := s.FavoritePayment(payment.ID, "обед")
+
+			dir := t.TempDir()
+			options := ExportOptions{Format: format}
+			if err := s.ExportWithOptions(dir, options); err != nil {
+				t.Fatalf("Export failed: %v", err)
+			}
+			// Удаление последнего избранного сохраняется поверх прежнего экспорта
+			if err := s.DeleteFavorite(favorite.ID); err != nil {
+				t.Fatalf("DeleteFavorite failed: %v", err)
+			}
+			if err := s.ExportWithOptions(dir, options); err != nil {
+				t.Fatalf("Export failed: %v", err)
+			}
+
+			loaded := &Service{}
+			if _, err := loaded.ImportWithOptions(dir, ImportOptions{Format: format}); err != nil {
+				t.Fatalf("Import failed: %v", err)
+			}
+			if favorites, _ := loaded.AccountFavorites(account.ID); len(favorites) != 0 {
+				t.Errorf("expected no favorites, got %+v", favorites)
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package wallet
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// FileStore хранит состояние в памяти и после каждой транзакции
+// целиком перезаписывает его в JSON-файл. Файл заменяется атомарно
+// (запись во временный файл и rename), поэтому после сбоя на диске
+// остаётся состояние до или после транзакции, но не их смесь.
+type FileStore struct {
+	*MemoryStore
+	path string
+}
+
+// OpenFileStore открывает хранилище в файле path, загружая его содержимое,
+// если файл уже существует.
+func OpenFileStore(path string) (*FileStore, error) {
+	store := &FileStore{MemoryStore: NewMemoryStore(), path: path}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		var snapshot changeSet
+		if err := json.Unmarshal(data, &snapshot); err != nil {
+			return nil, err
+		}
+		store.load(&snapshot)
+	}
+
+	store.commitHook = func(tx *memTx) error {
+		return writeFileAtomic(store.path, tx.snapshotLocked())
+	}
+
+	return store, nil
+}
+
+func writeFileAtomic(path string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := os.Rename(file.Name(), path); err != nil {
+		return err
+	}
+
+	// fsync каталога, чтобы сам rename пережил падение
+	if dir, err := os.Open(filepath.Dir(path)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	return nil
+}
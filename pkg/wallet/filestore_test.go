@@ -0,0 +1,91 @@
+package wallet
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFileStore_Reopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wallet.json")
+
+	store, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatalf("OpenFileStore failed: %v", err)
+	}
+	service := NewService(store)
+	acc, _ := service.RegisterAccount("+123456789")
+	service.Deposit(acc.ID, 1000)
+	pay, _ := service.Pay(acc.ID, 100, "Food")
+	service.FavoritePayment(pay.ID, "Lunch")
+	service.Reject(pay.ID)
+
+	reopened, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatalf("OpenFileStore failed: %v", err)
+	}
+	newService := NewService(reopened)
+
+	if !reflect.DeepEqual(serviceAccounts(service), serviceAccounts(newService)) {
+		t.Errorf("Accounts mismatch")
+	}
+	if !reflect.DeepEqual(servicePayments(service), servicePayments(newService)) {
+		t.Errorf("Payments mismatch")
+	}
+	if !reflect.DeepEqual(serviceFavorites(service), serviceFavorites(newService)) {
+		t.Errorf("Favorites mismatch")
+	}
+
+	next, err := newService.RegisterAccount("+987654321")
+	if err != nil {
+		t.Fatalf("failed to register account: %v", err)
+	}
+	if next.ID != acc.ID+1 {
+		t.Errorf("expected account ID %v, got %v", acc.ID+1, next.ID)
+	}
+}
+
+func TestFileStore_FailedTransactionNotPersisted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wallet.json")
+
+	store, _ := OpenFileStore(path)
+	service := NewService(store)
+	acc, _ := service.RegisterAccount("+123456789")
+
+	if _, err := service.Pay(acc.ID, 100, "Food"); err != ErrNotEnoughBalance {
+		t.Fatalf("expected error %v, got %v", ErrNotEnoughBalance, err)
+	}
+
+	reopened, _ := OpenFileStore(path)
+	if payments := servicePayments(NewService(reopened)); len(payments) != 0 {
+		t.Errorf("expected no payments, got %d", len(payments))
+	}
+}
+
+func TestFileStore_WriteError(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "wallet.json")
+
+	store, _ := OpenFileStore(path)
+	service := NewService(store)
+	acc, _ := service.RegisterAccount("+123456789")
+
+	// Каталог пропал - транзакция должна завершиться ошибкой и не примениться
+	os.RemoveAll(dir)
+	if err := service.Deposit(acc.ID, 100); err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	if acc.Balance != 0 {
+		t.Errorf("expected balance %v, got %v", 0, acc.Balance)
+	}
+}
+
+func TestOpenFileStore_Corrupted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wallet.json")
+	os.WriteFile(path, []byte("{broken"), 0666)
+
+	if _, err := OpenFileStore(path); err == nil {
+		t.Fatal("expected error, got nil")
+	}
+}
@@ -0,0 +1,328 @@
+package wallet
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+var ErrInvalidFileFormat = errors.New("invalid file format")
+
+// FileFormat - формат файлов Export, Import и HistoryToFiles.
+// Во всех форматах записи одинаковы и проходят одни и те же проверки.
+type FileFormat int
+
+const (
+	// FormatDump - файлы .dump с заголовком версии, поля через ';'.
+	FormatDump FileFormat = iota
+	// FormatJSON - файлы .json, один документ на вид записей.
+	FormatJSON
+	// FormatJSONL - файлы .jsonl, по одной записи в строке.
+	FormatJSONL
+)
+
+var fileFormatNames = []string{
+	FormatDump:  "dump",
+	FormatJSON:  "json",
+	FormatJSONL: "jsonl",
+}
+
+// String возвращает имя формата, оно же расширение файлов.
+func (f FileFormat) String() string {
+	if f < 0 || int(f) >= len(fileFormatNames) {
+		return fmt.Sprintf("FileFormat(%d)", int(f))
+	}
+	return fileFormatNames[f]
+}
+
+// ParseFileFormat возвращает формат по имени: dump, json или jsonl.
+func ParseFileFormat(name string) (FileFormat, error) {
+	for format, formatName := range fileFormatNames {
+		if formatName == name {
+			return FileFormat(format), nil
+		}
+	}
+	return 0, fmt.Errorf("%w %q", ErrInvalidFileFormat, name)
+}
+
+// writeFile записывает записи вида kind в файл path в формате format.
+func writeFile(path string, kind dumpKind, format FileFormat, records [][]string) error {
+	switch format {
+	case FormatJSON:
+		return writeJSON(path, kind, records)
+	case FormatJSONL:
+		return writeJSONL(path, kind, records)
+	}
+	return writeDump(path, kind, records)
+}
+
+// readFile читает записи вида kind из файла path в формате format.
+// Если файла нет, возвращает nil.
+func readFile(path string, kind dumpKind, format FileFormat) ([]dumpRecord, []ImportProblem) {
+	switch format {
+	case FormatJSON:
+		return readJSON(path, kind)
+	case FormatJSONL:
+		return readJSONL(path, kind)
+	}
+	return readDump(path, kind)
+}
+
+// В JSON запись - объект с полями kind.columns в том же порядке. Числовые
+// поля пишутся числами, пустые поля опускаются. Файл .json - документ
+//
+//	{"kind":"accounts","version":1,"records":[
+//	{"id":1,"phone":"+992000000001","balance":1000,"currency":"TJS"}
+//	]}
+//
+// Файл .jsonl заголовка не имеет, чтобы его можно было читать
+// и дописывать построчно.
+const jsonVersion = 1
+
+// encodeJSONRecord кодирует поля записи в JSON-объект.
+func encodeJSONRecord(kind dumpKind, fields []string) []byte {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, column := range kind.columns {
+		if fields[i] == "" {
+			continue
+		}
+		if b.Len() > 1 {
+			b.WriteByte(',')
+		}
+		name, _ := json.Marshal(column.name)
+		b.Write(name)
+		b.WriteByte(':')
+		if column.number {
+			b.WriteString(fields[i])
+			continue
+		}
+		value, _ := json.Marshal(fields[i])
+		b.Write(value)
+	}
+	b.WriteByte('}')
+	return b.Bytes()
+}
+
+// decodeJSONRecord разбирает JSON-объект в поля записи. Отсутствующие поля
+// и null становятся пустыми строками, неизвестные поля - ошибка.
+func decodeJSONRecord(kind dumpKind, data []byte) ([]string, error) {
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(data, &object); err != nil {
+		return nil, err
+	}
+	if object == nil {
+		return nil, errors.New("record is not an object")
+	}
+
+	fields := make([]string, len(kind.columns))
+	for i, column := range kind.columns {
+		raw, ok := object[column.name]
+		delete(object, column.name)
+		if !ok || string(raw) == "null" {
+			continue
+		}
+		if column.number {
+			var number json.Number
+			if err := json.Unmarshal(raw, &number); err != nil {
+				return nil, fmt.Errorf("field %s: expected number, got %s", column.name, raw)
+			}
+			fields[i] = number.String()
+			continue
+		}
+		if err := json.Unmarshal(raw, &fields[i]); err != nil {
+			return nil, fmt.Errorf("field %s: expected string, got %s", column.name, raw)
+		}
+	}
+
+	if len(object) > 0 {
+		unknown := make([]string, 0, len(object))
+		for name := range object {
+			unknown = append(unknown, name)
+		}
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("unknown field %q", unknown[0])
+	}
+	return fields, nil
+}
+
+func writeJSON(path string, kind dumpKind, records [][]string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+	fmt.Fprintf(writer, `{"kind":%q,"version":%d,"records":[`, kind.name, jsonVersion)
+	for i, record := range records {
+		if i > 0 {
+			writer.WriteByte(',')
+		}
+		writer.WriteByte('\n')
+		writer.Write(encodeJSONRecord(kind, record))
+	}
+	writer.WriteString("\n]}\n")
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+func writeJSONL(path string, kind dumpKind, records [][]string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+	for _, record := range records {
+		writer.Write(encodeJSONRecord(kind, record))
+		writer.WriteByte('\n')
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// readJSONL читает записи по одной в строке, пустые строки пропускаются.
+func readJSONL(path string, kind dumpKind) ([]dumpRecord, []ImportProblem) {
+	name := filepath.Base(path)
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, []ImportProblem{{File: name, Err: err}}
+	}
+	defer file.Close()
+
+	var records []dumpRecord
+	var problems []ImportProblem
+	reader := bufio.NewReader(file)
+	for line := 1; ; line++ {
+		data, err := reader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return nil, append(problems, ImportProblem{File: name, Line: line, Err: err})
+		}
+		if len(bytes.TrimSpace(data)) > 0 {
+			fields, decodeErr := decodeJSONRecord(kind, data)
+			if decodeErr != nil {
+				problems = append(problems, ImportProblem{File: name, Line: line, Err: decodeErr})
+			} else {
+				records = append(records, dumpRecord{line: line, fields: fields})
+			}
+		}
+		if err == io.EOF {
+			return records, problems
+		}
+	}
+}
+
+// readJSON читает документ .json. Номер строки записи - строка,
+// на которой начинается её объект.
+func readJSON(path string, kind dumpKind) ([]dumpRecord, []ImportProblem) {
+	name := filepath.Base(path)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, []ImportProblem{{File: name, Err: err}}
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	// lineAt возвращает строку первого значимого символа после offset
+	lineAt := func(offset int64) int {
+		for offset < int64(len(data)) && bytes.IndexByte([]byte(" \t\r\n,:"), data[offset]) >= 0 {
+			offset++
+		}
+		return 1 + bytes.Count(data[:offset], []byte("\n"))
+	}
+	fail := func(err error) ([]dumpRecord, []ImportProblem) {
+		line := lineAt(decoder.InputOffset())
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			line = 1 + bytes.Count(data[:syntaxErr.Offset], []byte("\n"))
+		}
+		return nil, []ImportProblem{{File: name, Line: line, Err: err}}
+	}
+	delim := func(want json.Delim) error {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		if token != want {
+			return fmt.Errorf("expected %v, got %v", want, token)
+		}
+		return nil
+	}
+
+	if err := delim('{'); err != nil {
+		return fail(err)
+	}
+	var records []dumpRecord
+	var problems []ImportProblem
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return fail(err)
+		}
+		switch token {
+		case "kind":
+			var value string
+			if err := decoder.Decode(&value); err != nil {
+				return fail(err)
+			}
+			if value != kind.name {
+				return fail(fmt.Errorf("document declares %s, expected %s", value, kind.name))
+			}
+		case "version":
+			var version int
+			if err := decoder.Decode(&version); err != nil {
+				return fail(err)
+			}
+			if version < 1 {
+				return fail(fmt.Errorf("invalid version %d", version))
+			}
+			if version > jsonVersion {
+				return fail(fmt.Errorf("%w: document has version %d, supported up to %d", ErrDumpVersion, version, jsonVersion))
+			}
+		case "records":
+			if err := delim('['); err != nil {
+				return fail(err)
+			}
+			for decoder.More() {
+				line := lineAt(decoder.InputOffset())
+				var raw json.RawMessage
+				if err := decoder.Decode(&raw); err != nil {
+					return fail(err)
+				}
+				fields, err := decodeJSONRecord(kind, raw)
+				if err != nil {
+					problems = append(problems, ImportProblem{File: name, Line: line, Err: err})
+					continue
+				}
+				records = append(records, dumpRecord{line: line, fields: fields})
+			}
+			if err := delim(']'); err != nil {
+				return fail(err)
+			}
+		default:
+			return fail(fmt.Errorf("unknown field %q", token))
+		}
+	}
+	if err := delim('}'); err != nil {
+		return fail(err)
+	}
+	return records, problems
+}
@@ -0,0 +1,207 @@
+package wallet
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/akmalsulaymonov/alif-wallet/pkg/types"
+)
+
+func TestService_ExportImport_Formats(t *testing.T) {
+	s := &Service{}
+	account, _ := s.RegisterAccount("+992000000001")
+	other, _ := s.RegisterAccount("+992000000002")
+	s.Deposit(account.ID, 1000)
+	payment, _ := s.Pay(account.ID, 100, `Аренда; "кв. 2"`)
+	s.FavoritePayment(payment.ID, "Обед\nна работе")
+	s.Pay(account.ID, 50, "")
+	if _, err := s.Transfer(account.ID, other.ID, 10, "долг\tза обед"); err != nil {
+		t.Fatalf("failed to transfer: %v", err)
+	}
+	s.Confirm(payment.ID)
+	s.RefundAmount(payment.ID, 30, "недолив;\n\"чай\"")
+	s.Authorize(account.ID, 200, "hotel", 0)
+	voided, _ := s.Authorize(other.ID, 5, "taxi", 0)
+	s.Void(voided.ID)
+	favorite, _ := s.FavoritePayment(payment.ID, "Аренда")
+	s.ScheduleFavorite(favorite.ID, types.ScheduleCron, time.Time{}, "0 9 5 * *")
+	s.ScheduleFavorite(favorite.ID, types.ScheduleOnce, time.Time{}, "")
+	s.RunSchedules(RetryPolicy{})
+
+	for _, format := range []FileFormat{FormatDump, FormatJSON, FormatJSONL} {
+		t.Run(format.String(), func(t *testing.T) {
+			dir := t.TempDir()
+			if err := s.ExportWithOptions(dir, ExportOptions{Format: format}); err != nil {
+				t.Fatalf("ExportWithOptions failed: %v", err)
+			}
+			imported := &Service{}
+			if _, err := imported.ImportWithOptions(dir, ImportOptions{Format: format}); err != nil {
+				t.Fatalf("ImportWithOptions failed: %v", err)
+			}
+
+			if !reflect.DeepEqual(serviceAccounts(s), serviceAccounts(imported)) {
+				t.Errorf("accounts differ: %v, %v", serviceAccounts(s), serviceAccounts(imported))
+			}
+			if !reflect.DeepEqual(servicePayments(s), servicePayments(imported)) {
+				t.Errorf("payments differ: %v, %v", servicePayments(s), servicePayments(imported))
+			}
+			if !reflect.DeepEqual(serviceFavorites(s), serviceFavorites(imported)) {
+				t.Errorf("favorites differ: %v, %v", serviceFavorites(s), serviceFavorites(imported))
+			}
+			if !reflect.DeepEqual(serviceTransfers(s), serviceTransfers(imported)) {
+				t.Errorf("transfers differ: %v, %v", serviceTransfers(s), serviceTransfers(imported))
+			}
+			if !reflect.DeepEqual(serviceHolds(s), serviceHolds(imported)) {
+				t.Errorf("holds differ: %v, %v", serviceHolds(s), serviceHolds(imported))
+			}
+			if !reflect.DeepEqual(serviceRefunds(s), serviceRefunds(imported)) {
+				t.Errorf("refunds differ: %v, %v", serviceRefunds(s), serviceRefunds(imported))
+			}
+			schedules, runs := serviceSchedules(s)
+			importedSchedules, importedRuns := serviceSchedules(imported)
+			if len(runs) == 0 || !reflect.DeepEqual(schedules, importedSchedules) || !reflect.DeepEqual(runs, importedRuns) {
+				t.Errorf("schedules differ: %v %v, %v %v", schedules, runs, importedSchedules, importedRuns)
+			}
+
+			// Файлы других форматов Import не читает
+			for _, another := range []FileFormat{FormatDump, FormatJSON, FormatJSONL} {
+				if another == format {
+					continue
+				}
+				report, err := (&Service{}).ImportWithOptions(dir, ImportOptions{Format: another})
+				if err != nil || len(report.Changes) != 0 {
+					t.Errorf("expected nothing imported as %v, got %v, %v", another, report, err)
+				}
+			}
+		})
+	}
+}
+
+func TestService_Import_JSONProblems(t *testing.T) {
+	dir := t.TempDir()
+	writeDumpFile(t, dir, "accounts.json", `{"kind":"accounts","version":1,"records":[
+{"id":1,"phone":"+992000000001","balance":1000,"currency":"TJS"},
+{"id":"x","phone":"+992000000002","balance":1000,"currency":"TJS"},
+{"id":3,"phone":"+992000000003","balance":1000,"currency":"TJS","bonus":5},
+{
+  "id": 4, "phone": "+992000000004", "balance": -5, "currency": "TJS"
+}
+]}
+`)
+	writeDumpFile(t, dir, "payments.json", `{"kind":"payments","version":1,"records":[
+{"id":"p1","account_id":1,"amount":100,"category":"food","status":"DONE","currency":"TJS"},
+{"id":"p2","account_id":42,"amount":100,"category":"food","status":"OK","currency":"TJS"}
+]}
+`)
+
+	_, err := (&Service{}).ImportWithOptions(dir, ImportOptions{Format: FormatJSON})
+	var importErr *ImportError
+	if !errors.As(err, &importErr) {
+		t.Fatalf("expected *ImportError, got %v", err)
+	}
+	want := []struct {
+		file string
+		line int
+		err  error
+	}{
+		{"accounts.json", 3, nil},
+		{"accounts.json", 4, nil},
+		{"payments.json", 2, ErrInvalidPaymentStatus},
+		{"accounts.json", 5, ErrNegativeBalance},
+		{"payments.json", 3, ErrAccountNotFound},
+	}
+	if len(importErr.Problems) != len(want) {
+		t.Fatalf("expected %d problems, got %v", len(want), importErr.Problems)
+	}
+	for i, w := range want {
+		got := importErr.Problems[i]
+		if got.File != w.file || got.Line != w.line || (w.err != nil && !errors.Is(got, w.err)) {
+			t.Errorf("expected problem %s:%d %v, got %v", w.file, w.line, w.err, got)
+		}
+	}
+}
+
+func TestService_Import_JSONLProblems(t *testing.T) {
+	dir := t.TempDir()
+	writeDumpFile(t, dir, "accounts.jsonl", `{"id":1,"phone":"+992000000001","balance":1000,"currency":"TJS"}
+
+{"id":1,"phone":"+992000000002","balance":1000,"currency":"XXX"}
+not json
+`)
+
+	_, err := (&Service{}).ImportWithOptions(dir, ImportOptions{Format: FormatJSONL})
+	var importErr *ImportError
+	if !errors.As(err, &importErr) {
+		t.Fatalf("expected *ImportError, got %v", err)
+	}
+	// Ошибки разбора JSON находятся при чтении файла, раньше проверки полей
+	if len(importErr.Problems) != 2 || importErr.Problems[0].Line != 4 || importErr.Problems[1].Line != 3 {
+		t.Fatalf("unexpected problems %v", importErr.Problems)
+	}
+	if !errors.Is(importErr.Problems[1], ErrUnknownCurrency) {
+		t.Errorf("expected error %v, got %v", ErrUnknownCurrency, importErr.Problems[1])
+	}
+}
+
+func TestService_Import_JSONHeader(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		err     error
+	}{
+		{"newer version", `{"kind":"accounts","version":2,"records":[]}`, ErrDumpVersion},
+		{"wrong kind", `{"kind":"payments","version":1,"records":[]}`, nil},
+		{"not an object", `[{"id":1}]`, nil},
+		{"truncated", `{"kind":"accounts","version":1,"records":[{"id":1}`, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeDumpFile(t, dir, "accounts.json", tt.content)
+			_, err := (&Service{}).ImportWithOptions(dir, ImportOptions{Format: FormatJSON})
+			if err == nil || (tt.err != nil && !errors.Is(err, tt.err)) {
+				t.Errorf("expected error %v, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestService_HistoryFromFiles(t *testing.T) {
+	s := &Service{}
+	account, _ := s.RegisterAccount("+992000000001")
+	s.Deposit(account.ID, 1000)
+	for i := 0; i < 5; i++ {
+		s.Pay(account.ID, types.Money(10+i), "food")
+	}
+	history, _ := s.ExportAccountHistory(account.ID)
+
+	for _, format := range []FileFormat{FormatDump, FormatJSON, FormatJSONL} {
+		for _, records := range []int{2, 10} {
+			dir := t.TempDir()
+			if err := s.HistoryToFilesWithOptions(history, dir, records, ExportOptions{Format: format}); err != nil {
+				t.Fatalf("HistoryToFilesWithOptions failed: %v", err)
+			}
+			got, err := s.HistoryFromFiles(dir, format)
+			if err != nil {
+				t.Fatalf("HistoryFromFiles failed: %v", err)
+			}
+			if !reflect.DeepEqual(history, got) {
+				t.Errorf("%v by %d: expected %v, got %v", format, records, history, got)
+			}
+		}
+	}
+}
+
+func TestParseFileFormat(t *testing.T) {
+	for _, format := range []FileFormat{FormatDump, FormatJSON, FormatJSONL} {
+		if got, err := ParseFileFormat(format.String()); err != nil || got != format {
+			t.Errorf("expected %v, got %v, %v", format, got, err)
+		}
+	}
+	if _, err := ParseFileFormat("xml"); !errors.Is(err, ErrInvalidFileFormat) {
+		t.Errorf("expected error %v, got %v", ErrInvalidFileFormat, err)
+	}
+}
@@ -0,0 +1,229 @@
+package wallet
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/akmalsulaymonov/alif-wallet/pkg/types"
+	"github.com/google/uuid"
+)
+
+var ErrHoldNotFound = errors.New("hold not found")
+var ErrHoldNotActive = errors.New("hold is not active")
+var ErrHoldExpired = errors.New("hold expired")
+var ErrCaptureExceedsHold = errors.New("capture amount exceeds hold")
+var ErrInvalidHoldStatus = errors.New("invalid hold status")
+
+// DefaultHoldTTL - срок блокировки, если при Authorize он не указан.
+const DefaultHoldTTL = 7 * 24 * time.Hour
+
+// Authorize блокирует amount в валюте аккаунта на срок ttl (0 - DefaultHoldTTL).
+// Блокировка уменьшает доступный баланс, но деньги не списываются
+// и проводки не создаются, пока блокировка не будет списана Capture.
+func (s *Service) Authorize(accountID int64, amount types.Money, category types.PaymentCategory, ttl time.Duration) (*types.Hold, error) {
+	if amount <= 0 {
+		return nil, ErrAmountMustBePositive
+	}
+	if ttl <= 0 {
+		ttl = DefaultHoldTTL
+	}
+
+	unlock, err := s.lockAccount(accountID)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	var hold *types.Hold
+	err = s.repo().Update(func(tx Tx) error {
+		account, err := tx.Account(accountID)
+		if err != nil {
+			return err
+		}
+		if account.Available() < amount {
+			return ErrNotEnoughBalance
+		}
+
+		now := s.stamp()
+		account.Held += amount
+		account.UpdatedAt = now
+		tx.PutAccount(account)
+
+		hold = &types.Hold{
+			ID:        uuid.New().String(),
+			AccountID: accountID,
+			Amount:    amount,
+			Currency:  account.Currency,
+			Category:  category,
+			Status:    types.HoldStatusActive,
+			ExpiresAt: now.Add(ttl),
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+		tx.PutHold(hold)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return hold, nil
+}
+
+// Capture списывает по блокировке amount, не больше заблокированной суммы,
+// и освобождает остаток. Списание оформляется платежом в статусе OK
+// с категорией блокировки. Истёкшую блокировку списать нельзя.
+func (s *Service) Capture(holdID string, amount types.Money) (*types.Payment, error) {
+	if amount <= 0 {
+		return nil, ErrAmountMustBePositive
+	}
+
+	var payment *types.Payment
+	err := s.updateHold(holdID, func(tx Tx, hold *types.Hold, account *types.Account, now time.Time) error {
+		if amount > hold.Amount {
+			return fmt.Errorf("%w: %v > %v", ErrCaptureExceedsHold, amount, hold.Amount)
+		}
+		if !now.Before(hold.ExpiresAt) {
+			return ErrHoldExpired
+		}
+
+		// Сначала освобождается вся блокировка, иначе debit не пропустит списание
+		account.Held -= hold.Amount
+		var err error
+		payment, err = s.charge(tx, account, types.Amount{Value: amount, Currency: hold.Currency}, hold.Category)
+		if err != nil {
+			return err
+		}
+		if err := setStatus(payment, types.PaymentStatusOk, now); err != nil {
+			return err
+		}
+		tx.PutPayment(payment)
+
+		hold.Status = types.HoldStatusCaptured
+		hold.CapturedAmount = amount
+		hold.PaymentID = payment.ID
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return payment, nil
+}
+
+// Void отменяет блокировку и освобождает всю её сумму.
+func (s *Service) Void(holdID string) error {
+	return s.updateHold(holdID, func(tx Tx, hold *types.Hold, account *types.Account, now time.Time) error {
+		account.Held -= hold.Amount
+		hold.Status = types.HoldStatusVoided
+		return nil
+	})
+}
+
+// ExpireHolds переводит в EXPIRED активные блокировки, срок которых
+// истёк по часам Service, и освобождает их суммы.
+func (s *Service) ExpireHolds() ([]types.Hold, error) {
+	now := s.stamp()
+
+	var stale []string
+	s.repo().View(func(tx Tx) error {
+		for _, hold := range tx.Holds() {
+			if hold.Status == types.HoldStatusActive && !now.Before(hold.ExpiresAt) {
+				stale = append(stale, hold.ID)
+			}
+		}
+		return nil
+	})
+
+	var expired []types.Hold
+	for _, holdID := range stale {
+		var result types.Hold
+		err := s.updateHold(holdID, func(tx Tx, hold *types.Hold, account *types.Account, _ time.Time) error {
+			account.Held -= hold.Amount
+			hold.Status = types.HoldStatusExpired
+			result = *hold
+			return nil
+		})
+		if errors.Is(err, ErrHoldNotActive) || errors.Is(err, ErrHoldNotFound) {
+			// Блокировку успели списать или отменить
+			continue
+		}
+		if err != nil {
+			return expired, err
+		}
+		expired = append(expired, result)
+	}
+	return expired, nil
+}
+
+// updateHold выполняет fn над активной блокировкой и её аккаунтом
+// под блокировкой аккаунта и сохраняет оба.
+func (s *Service) updateHold(holdID string, fn func(tx Tx, hold *types.Hold, account *types.Account, now time.Time) error) error {
+	var accountID int64
+	err := s.repo().View(func(tx Tx) error {
+		hold, err := tx.Hold(holdID)
+		if err != nil {
+			return err
+		}
+		accountID = hold.AccountID
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	unlock, err := s.lockAccount(accountID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return s.repo().Update(func(tx Tx) error {
+		hold, err := tx.Hold(holdID)
+		if err != nil {
+			return err
+		}
+		if hold.Status != types.HoldStatusActive {
+			return fmt.Errorf("%w: hold %s is %s", ErrHoldNotActive, hold.ID, hold.Status)
+		}
+		account, err := tx.Account(hold.AccountID)
+		if err != nil {
+			return err
+		}
+
+		now := s.stamp()
+		if err := fn(tx, hold, account, now); err != nil {
+			return err
+		}
+		hold.UpdatedAt = now
+		account.UpdatedAt = now
+		tx.PutHold(hold)
+		tx.PutAccount(account)
+		return nil
+	})
+}
+
+// FindHoldByID возвращает копию блокировки, см. FindAccountByID.
+func (s *Service) FindHoldByID(holdID string) (*types.Hold, error) {
+	return findCopy(s, func(tx Tx) (*types.Hold, error) { return tx.Hold(holdID) })
+}
+
+// AccountHolds возвращает копии блокировок аккаунта во всех статусах.
+func (s *Service) AccountHolds(accountID int64) ([]types.Hold, error) {
+	var holds []types.Hold
+	err := s.repo().View(func(tx Tx) error {
+		if _, err := tx.Account(accountID); err != nil {
+			return err
+		}
+		for _, hold := range tx.AccountHolds(accountID) {
+			holds = append(holds, *hold)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return holds, nil
+}
@@ -0,0 +1,113 @@
+package wallet
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/akmalsulaymonov/alif-wallet/pkg/types"
+)
+
+func TestService_Capture(t *testing.T) {
+	clock := newTestClock()
+	s := &Service{}
+	s.SetClock(clock.Now)
+	account, _ := s.RegisterAccount("+992000000001")
+	s.Deposit(account.ID, 1000)
+
+	hold, err := s.Authorize(account.ID, 600, "hotel", 0)
+	if err != nil {
+		t.Fatalf("Authorize failed: %v", err)
+	}
+	if !hold.ExpiresAt.Equal(clock.Now().Add(DefaultHoldTTL)) {
+		t.Errorf("expected expiry %v, got %v", clock.Now().Add(DefaultHoldTTL), hold.ExpiresAt)
+	}
+	if got, _ := s.FindAccountByID(account.ID); got.Balance != 1000 || got.Available() != 400 {
+		t.Errorf("expected balance 1000 and available 400, got %v and %v", got.Balance, got.Available())
+	}
+
+	// Заблокированные деньги нельзя потратить
+	if _, err := s.Pay(account.ID, 500, "food"); !errors.Is(err, ErrNotEnoughBalance) {
+		t.Errorf("expected error %v, got %v", ErrNotEnoughBalance, err)
+	}
+	if _, err := s.Authorize(account.ID, 500, "food", 0); !errors.Is(err, ErrNotEnoughBalance) {
+		t.Errorf("expected error %v, got %v", ErrNotEnoughBalance, err)
+	}
+	if _, err := s.Capture(hold.ID, 700); !errors.Is(err, ErrCaptureExceedsHold) {
+		t.Errorf("expected error %v, got %v", ErrCaptureExceedsHold, err)
+	}
+
+	payment, err := s.Capture(hold.ID, 450)
+	if err != nil {
+		t.Fatalf("Capture failed: %v", err)
+	}
+	if payment.Amount != 450 || payment.Status != types.PaymentStatusOk || payment.Category != "hotel" {
+		t.Errorf("unexpected payment %+v", payment)
+	}
+	if got, _ := s.FindAccountByID(account.ID); got.Balance != 550 || got.Held != 0 {
+		t.Errorf("expected balance 550 and nothing held, got %v and %v", got.Balance, got.Held)
+	}
+	got, _ := s.FindHoldByID(hold.ID)
+	if got.Status != types.HoldStatusCaptured || got.CapturedAmount != 450 || got.PaymentID != payment.ID {
+		t.Errorf("unexpected hold %+v", got)
+	}
+
+	if _, err := s.Capture(hold.ID, 100); !errors.Is(err, ErrHoldNotActive) {
+		t.Errorf("expected error %v, got %v", ErrHoldNotActive, err)
+	}
+	if _, err := s.Capture("unknown", 100); !errors.Is(err, ErrHoldNotFound) {
+		t.Errorf("expected error %v, got %v", ErrHoldNotFound, err)
+	}
+	if report := s.CheckLedger(); !report.OK() {
+		t.Errorf("ledger is inconsistent: %+v", report)
+	}
+}
+
+func TestService_Void(t *testing.T) {
+	s := &Service{}
+	account, _ := s.RegisterAccount("+992000000001")
+	s.Deposit(account.ID, 1000)
+	hold, _ := s.Authorize(account.ID, 300, "taxi", time.Hour)
+
+	if err := s.Void(hold.ID); err != nil {
+		t.Fatalf("Void failed: %v", err)
+	}
+	if got, _ := s.FindAccountByID(account.ID); got.Available() != 1000 {
+		t.Errorf("expected available 1000, got %v", got.Available())
+	}
+	if err := s.Void(hold.ID); !errors.Is(err, ErrHoldNotActive) {
+		t.Errorf("expected error %v, got %v", ErrHoldNotActive, err)
+	}
+	holds, _ := s.AccountHolds(account.ID)
+	if len(holds) != 1 || holds[0].Status != types.HoldStatusVoided {
+		t.Errorf("unexpected holds %+v", holds)
+	}
+}
+
+func TestService_ExpireHolds(t *testing.T) {
+	clock := newTestClock()
+	s := &Service{}
+	s.SetClock(clock.Now)
+	account, _ := s.RegisterAccount("+992000000001")
+	s.Deposit(account.ID, 1000)
+	stale, _ := s.Authorize(account.ID, 100, "taxi", time.Hour)
+	fresh, _ := s.Authorize(account.ID, 200, "food", 3*time.Hour)
+	clock.Advance(2 * time.Hour)
+
+	if _, err := s.Capture(stale.ID, 100); !errors.Is(err, ErrHoldExpired) {
+		t.Errorf("expected error %v, got %v", ErrHoldExpired, err)
+	}
+	expired, err := s.ExpireHolds()
+	if err != nil {
+		t.Fatalf("ExpireHolds failed: %v", err)
+	}
+	if len(expired) != 1 || expired[0].ID != stale.ID || expired[0].Status != types.HoldStatusExpired {
+		t.Errorf("expected %s expired, got %+v", stale.ID, expired)
+	}
+	if got, _ := s.FindAccountByID(account.ID); got.Held != fresh.Amount {
+		t.Errorf("expected held %v, got %v", fresh.Amount, got.Held)
+	}
+	if expired, _ := s.ExpireHolds(); len(expired) != 0 {
+		t.Errorf("expected nothing expired, got %+v", expired)
+	}
+}
@@ -0,0 +1,139 @@
+package wallet
+
+import (
+	"errors"
+	"time"
+
+	"github.com/akmalsulaymonov/alif-wallet/pkg/types"
+)
+
+var ErrIdempotencyConflict = errors.New("idempotency key reused with different parameters")
+
+var errIdempotencyKeyNotFound = errors.New("idempotency key not found")
+
+// Время жизни ключа идемпотентности по умолчанию.
+const DefaultIdempotencyWindow = 24 * time.Hour
+
+// SetIdempotencyWindow задаёт, сколько времени после первого запроса
+// ключ идемпотентности защищает от повторов. После этого ключ можно
+// использовать заново, как новый.
+func (s *Service) SetIdempotencyWindow(window time.Duration) {
+	s.global.Lock()
+	defer s.global.Unlock()
+
+	s.idempotencyWindow = window
+}
+
+func (s *Service) keyExpired(record *types.IdempotencyKey, now time.Time) bool {
+	window := s.idempotencyWindow
+	if window <= 0 {
+		window = DefaultIdempotencyWindow
+	}
+	return !now.Before(record.CreatedAt.Add(window))
+}
+
+// DepositWithKey пополняет аккаунт, как DepositAmount. Повтор с тем же key
+// и теми же параметрами ничего не меняет и возвращает nil, повтор с другими
+// параметрами возвращает ErrIdempotencyConflict. Пустой key отключает проверку.
+func (s *Service) DepositWithKey(key string, accountID int64, amount types.Amount) error {
+	if !amount.Currency.Valid() {
+		return ErrUnknownCurrency
+	}
+	return s.deposit(key, accountID, amount)
+}
+
+// PayWithKey создаёт платёж, как PayAmount. Повтор с тем же key и теми же
+// параметрами возвращает платёж из первого вызова, повтор с другими
+// параметрами возвращает ErrIdempotencyConflict. Пустой key отключает проверку.
+func (s *Service) PayWithKey(key string, accountID int64, amount types.Amount, category types.PaymentCategory) (*types.Payment, error) {
+	if !amount.Currency.Valid() {
+		return nil, ErrUnknownCurrency
+	}
+	return s.pay(key, accountID, amount, category)
+}
+
+// PayFromFavoriteWithKey создаёт платёж по избранному с ключом идемпотентности,
+// см. PayWithKey.
+func (s *Service) PayFromFavoriteWithKey(key string, favoriteID string) (*types.Payment, error) {
+	return s.payFromFavorite(key, favoriteID)
+}
+
+// idempotent выполняет op в транзакции и запоминает результат под ключом key
+// аккаунта accountID. request описывает операцию и её параметры, op возвращает
+// ID созданного платежа. Если ключ уже использован с тем же request и не
+// истёк, op не выполняется, а возвращается ID из первого вызова.
+//
+// Неудачная операция ключ не занимает: её можно повторить с тем же ключом.
+// Вызывается под блокировкой аккаунта, поэтому два запроса с одним ключом
+// не выполнятся одновременно.
+func (s *Service) idempotent(key string, accountID int64, request string, op func(tx Tx) (string, error)) (string, error) {
+	now := s.clock()
+
+	if key != "" {
+		var record *types.IdempotencyKey
+		s.repo().View(func(tx Tx) error {
+			found, err := tx.IdempotencyKey(accountID, key)
+			if err == nil && !s.keyExpired(found, now) {
+				copied := *found
+				record = &copied
+			}
+			return nil
+		})
+		if record != nil {
+			if record.Request != request {
+				return "", ErrIdempotencyConflict
+			}
+			return record.PaymentID, nil
+		}
+	}
+
+	var paymentID string
+	err := s.repo().Update(func(tx Tx) error {
+		id, err := op(tx)
+		if err != nil {
+			return err
+		}
+		paymentID = id
+
+		if key != "" {
+			tx.PutIdempotencyKey(&types.IdempotencyKey{
+				AccountID: accountID,
+				Key:       key,
+				Request:   request,
+				PaymentID: paymentID,
+				CreatedAt: now,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return paymentID, nil
+}
+
+// PurgeIdempotencyKeys удаляет истёкшие ключи идемпотентности
+// и возвращает их количество.
+func (s *Service) PurgeIdempotencyKeys() (int, error) {
+	s.global.Lock()
+	defer s.global.Unlock()
+
+	now := s.clock()
+	purged := 0
+	err := s.repo().Update(func(tx Tx) error {
+		purged = 0
+		for _, record := range tx.IdempotencyKeys() {
+			if s.keyExpired(record, now) {
+				tx.DeleteIdempotencyKey(record.AccountID, record.Key)
+				purged++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return purged, nil
+}
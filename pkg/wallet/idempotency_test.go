@@ -0,0 +1,228 @@
+package wallet
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/akmalsulaymonov/alif-wallet/pkg/types"
+)
+
+// testClock - часы, которые двигаются только вручную.
+type testClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newTestClock() *testClock {
+	return &testClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+}
+
+func (c *testClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *testClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func TestService_PayWithKey(t *testing.T) {
+	s := &Service{}
+	account, _ := s.RegisterAccount("+992000000001")
+	s.Deposit(account.ID, 1000)
+	amount := types.Amount{Value: 300, Currency: types.TJS}
+
+	payment, err := s.PayWithKey("k1", account.ID, amount, "food")
+	if err != nil {
+		t.Fatalf("failed to pay: %v", err)
+	}
+	retried, err := s.PayWithKey("k1", account.ID, amount, "food")
+	if err != nil {
+		t.Fatalf("failed to retry payment: %v", err)
+	}
+	if retried.ID != payment.ID {
+		t.Errorf("expected original payment %v, got %v", payment.ID, retried.ID)
+	}
+	if accountBalance(s, account.ID) != 700 || len(servicePayments(s)) != 1 {
+		t.Errorf("expected single debit, got balance %v and %d payments", accountBalance(s, account.ID), len(servicePayments(s)))
+	}
+
+	_, err = s.PayWithKey("k1", account.ID, types.Amount{Value: 400, Currency: types.TJS}, "food")
+	if !errors.Is(err, ErrIdempotencyConflict) {
+		t.Errorf("expected ErrIdempotencyConflict, got %v", err)
+	}
+
+	// Без ключа каждый вызов - новый платёж
+	s.PayWithKey("", account.ID, amount, "food")
+	s.PayWithKey("", account.ID, amount, "food")
+	if accountBalance(s, account.ID) != 100 {
+		t.Errorf("expected balance 100, got %v", accountBalance(s, account.ID))
+	}
+}
+
+func TestService_PayWithKey_FailedPaymentFreesKey(t *testing.T) {
+	s := &Service{}
+	account, _ := s.RegisterAccount("+992000000001")
+	amount := types.Amount{Value: 300, Currency: types.TJS}
+
+	if _, err := s.PayWithKey("k1", account.ID, amount, "food"); !errors.Is(err, ErrNotEnoughBalance) {
+		t.Fatalf("expected ErrNotEnoughBalance, got %v", err)
+	}
+
+	s.Deposit(account.ID, 1000)
+	if _, err := s.PayWithKey("k1", account.ID, amount, "food"); err != nil {
+		t.Errorf("failed to pay after deposit: %v", err)
+	}
+}
+
+func TestService_DepositWithKey(t *testing.T) {
+	s := &Service{}
+	account, _ := s.RegisterAccount("+992000000001")
+	amount := types.Amount{Value: 500, Currency: types.TJS}
+
+	for i := 0; i < 3; i++ {
+		if err := s.DepositWithKey("d1", account.ID, amount); err != nil {
+			t.Fatalf("failed to deposit: %v", err)
+		}
+	}
+	if accountBalance(s, account.ID) != 500 {
+		t.Errorf("expected balance 500, got %v", accountBalance(s, account.ID))
+	}
+
+	err := s.DepositWithKey("d1", account.ID, types.Amount{Value: 600, Currency: types.TJS})
+	if !errors.Is(err, ErrIdempotencyConflict) {
+		t.Errorf("expected ErrIdempotencyConflict, got %v", err)
+	}
+	// Тот же ключ для платежа - другой запрос
+	_, err = s.PayWithKey("d1", account.ID, amount, "food")
+	if !errors.Is(err, ErrIdempotencyConflict) {
+		t.Errorf("expected ErrIdempotencyConflict, got %v", err)
+	}
+}
+
+func TestService_PayFromFavoriteWithKey(t *testing.T) {
+	s := &Service{}
+	account, _ := s.RegisterAccount("+992000000001")
+	s.Deposit(account.ID, 1000)
+	payment, _ := s.Pay(account.ID, 100, "food")
+	first, _ := s.FavoritePayment(payment.ID, "обед")
+	second, _ := s.FavoritePayment(payment.ID, "ужин")
+
+	paid, err := s.PayFromFavoriteWithKey("f1", first.ID)
+	if err != nil {
+		t.Fatalf("failed to pay from favorite: %v", err)
+	}
+	retried, err := s.PayFromFavoriteWithKey("f1", first.ID)
+	if err != nil || retried.ID != paid.ID {
+		t.Errorf("expected original payment %v, got %v (%v)", paid.ID, retried, err)
+	}
+	if accountBalance(s, account.ID) != 800 {
+		t.Errorf("expected balance 800, got %v", accountBalance(s, account.ID))
+	}
+
+	if _, err := s.PayFromFavoriteWithKey("f1", second.ID); !errors.Is(err, ErrIdempotencyConflict) {
+		t.Errorf("expected ErrIdempotencyConflict, got %v", err)
+	}
+}
+
+func TestService_PayFromFavoriteWithKey_UpdatedFavorite(t *testing.T) {
+	s := &Service{}
+	account, _ := s.RegisterAccount("+992000000001")
+	s.Deposit(account.ID, 1000)
+	payment, _ := s.Pay(account.ID, 100, "food")
+	favorite, _ := s.FavoritePayment(payment.ID, "обед")
+
+	if _, err := s.PayFromFavoriteWithKey("f1", favorite.ID); err != nil {
+		t.Fatalf("failed to pay from favorite: %v", err)
+	}
+	amount := types.Money(300)
+	if _, err := s.UpdateFavorite(favorite.ID, FavoriteChanges{Amount: &amount}); err != nil {
+		t.Fatalf("failed to update favorite: %v", err)
+	}
+
+	// Повтор с тем же ключом - уже другой запрос
+	if _, err := s.PayFromFavoriteWithKey("f1", favorite.ID); !errors.Is(err, ErrIdempotencyConflict) {
+		t.Errorf("expected ErrIdempotencyConflict, got %v", err)
+	}
+	if accountBalance(s, account.ID) != 800 {
+		t.Errorf("expected balance 800, got %v", accountBalance(s, account.ID))
+	}
+}
+
+func TestService_IdempotencyWindow(t *testing.T) {
+	clock := newTestClock()
+	s := &Service{}
+	s.SetClock(clock.Now)
+	s.SetIdempotencyWindow(time.Hour)
+
+	account, _ := s.RegisterAccount("+992000000001")
+	s.Deposit(account.ID, 1000)
+	amount := types.Amount{Value: 100, Currency: types.TJS}
+
+	first, _ := s.PayWithKey("k1", account.ID, amount, "food")
+	clock.Advance(59 * time.Minute)
+	retried, _ := s.PayWithKey("k1", account.ID, amount, "food")
+	if retried.ID != first.ID {
+		t.Errorf("expected original payment within window")
+	}
+
+	// После окна ключ считается новым
+	clock.Advance(time.Minute)
+	again, err := s.PayWithKey("k1", account.ID, amount, "food")
+	if err != nil {
+		t.Fatalf("failed to pay: %v", err)
+	}
+	if again.ID == first.ID || accountBalance(s, account.ID) != 800 {
+		t.Errorf("expected new payment after window, got %v with balance %v", again.ID, accountBalance(s, account.ID))
+	}
+
+	s.PayWithKey("k2", account.ID, amount, "food")
+	clock.Advance(time.Hour)
+	purged, err := s.PurgeIdempotencyKeys()
+	if err != nil {
+		t.Fatalf("failed to purge keys: %v", err)
+	}
+	if purged != 2 {
+		t.Errorf("expected 2 purged keys, got %d", purged)
+	}
+}
+
+func TestService_IdempotencyKey_SurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+
+	store, s := openJournal(t, dir, 0)
+	account, _ := s.RegisterAccount("+992000000001")
+	s.Deposit(account.ID, 1000)
+	amount := types.Amount{Value: 100, Currency: types.TJS}
+	payment, _ := s.PayWithKey("k1", account.ID, amount, "food")
+	store.Close()
+
+	store, s = openJournal(t, dir, 0)
+	retried, err := s.PayWithKey("k1", account.ID, amount, "food")
+	if err != nil {
+		t.Fatalf("failed to retry payment: %v", err)
+	}
+	if retried.ID != payment.ID {
+		t.Errorf("expected original payment %v, got %v", payment.ID, retried.ID)
+	}
+
+	// Удаление ключа тоже восстанавливается из журнала
+	s.SetClock(func() time.Time { return time.Now().Add(DefaultIdempotencyWindow) })
+	if purged, _ := s.PurgeIdempotencyKeys(); purged != 1 {
+		t.Fatalf("expected 1 purged key, got %d", purged)
+	}
+	store.Close()
+
+	_, s = openJournal(t, dir, 0)
+	s.repo().View(func(tx Tx) error {
+		if keys := tx.IdempotencyKeys(); len(keys) != 0 {
+			t.Errorf("expected no keys after purge, got %v", keys)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,1233 @@
+package wallet
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/akmalsulaymonov/alif-wallet/pkg/types"
+)
+
+var ErrDuplicateRecord = errors.New("duplicate record id")
+var ErrNegativeBalance = errors.New("negative balance")
+var ErrImportConflict = errors.New("record differs from existing one")
+var ErrInvalidImportMode = errors.New("invalid import mode")
+
+// ImportMode - что Import делает с записями дампов, ID которых уже есть в Service.
+// Режим одинаково применяется к аккаунтам, платежам, избранному, переводам,
+// блокировкам, возвратам, расписаниям и их выполнениям.
+type ImportMode int
+
+const (
+	// ImportUpsert обновляет существующие записи и добавляет новые.
+	ImportUpsert ImportMode = iota
+	// ImportSkipExisting добавляет только записи с новыми ID.
+	ImportSkipExisting
+	// ImportFailOnConflict отклоняет импорт, если запись с тем же ID
+	// уже есть и отличается от записи дампа.
+	ImportFailOnConflict
+	// ImportReplace делает содержимое Service равным дампам:
+	// записи, которых нет в дампах, удаляются.
+	ImportReplace
+)
+
+var importModeNames = []string{
+	ImportUpsert:         "upsert",
+	ImportSkipExisting:   "skip-existing",
+	ImportFailOnConflict: "fail-on-conflict",
+	ImportReplace:        "replace",
+}
+
+func (m ImportMode) String() string {
+	if m < 0 || int(m) >= len(importModeNames) {
+		return fmt.Sprintf("ImportMode(%d)", int(m))
+	}
+	return importModeNames[m]
+}
+
+// ParseImportMode возвращает режим по имени: upsert, skip-existing,
+// fail-on-conflict или replace.
+func ParseImportMode(name string) (ImportMode, error) {
+	for mode, modeName := range importModeNames {
+		if modeName == name {
+			return ImportMode(mode), nil
+		}
+	}
+	return 0, fmt.Errorf("%w %q", ErrInvalidImportMode, name)
+}
+
+// ImportOptions - параметры ImportWithOptions.
+type ImportOptions struct {
+	Mode ImportMode
+	// Format - формат файлов, по умолчанию FormatDump.
+	Format FileFormat
+	// DryRun только проверяет дампы и сообщает, что изменится.
+	DryRun bool
+}
+
+// ImportAction - что Import делает с записью.
+type ImportAction string
+
+const (
+	ImportCreate    ImportAction = "create"
+	ImportUpdate    ImportAction = "update"
+	ImportUnchanged ImportAction = "unchanged"
+	ImportSkip      ImportAction = "skip"
+	ImportDelete    ImportAction = "delete"
+)
+
+// ImportChange - действие с одной записью. Kind - вид дампа:
+// accounts, payments, favorites, transfers, holds, refunds, schedules
+// или schedule_runs.
+type ImportChange struct {
+	Kind   string
+	ID     string
+	Action ImportAction
+}
+
+// ImportReport - действия Import со всеми записями дампов, а в режиме
+// ImportReplace и с удаляемыми записями Service.
+type ImportReport struct {
+	Changes []ImportChange
+}
+
+// Count возвращает число записей с действием action.
+func (r *ImportReport) Count(action ImportAction) int {
+	count := 0
+	for _, change := range r.Changes {
+		if change.Action == action {
+			count++
+		}
+	}
+	return count
+}
+
+func (r *ImportReport) add(kind dumpKind, id string, action ImportAction) {
+	r.Changes = append(r.Changes, ImportChange{Kind: kind.name, ID: id, Action: action})
+}
+
+// ImportProblem - ошибка в дампе: файл, номер строки (с 1) и причина.
+// Line 0 означает ошибку всего файла, например отказ в доступе.
+type ImportProblem struct {
+	File string
+	Line int
+	Err  error
+}
+
+func (p ImportProblem) Error() string {
+	if p.Line == 0 {
+		return fmt.Sprintf("%s: %v", p.File, p.Err)
+	}
+	return fmt.Sprintf("%s:%d: %v", p.File, p.Line, p.Err)
+}
+
+func (p ImportProblem) Unwrap() error {
+	return p.Err
+}
+
+// ImportError возвращается из Import, если дампы не прошли проверку.
+// Problems содержит все найденные ошибки; Service при этом не меняется.
+type ImportError struct {
+	Problems []ImportProblem
+}
+
+func (e *ImportError) Error() string {
+	messages := make([]string, 0, len(e.Problems))
+	for _, problem := range e.Problems {
+		messages = append(messages, problem.Error())
+	}
+	return fmt.Sprintf("import: %d problem(s): %s", len(e.Problems), strings.Join(messages, "; "))
+}
+
+// Unwrap позволяет проверять причины через errors.Is и errors.As.
+func (e *ImportError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Problems))
+	for _, problem := range e.Problems {
+		errs = append(errs, problem)
+	}
+	return errs
+}
+
+// importBatch - записи дампов, разобранные до изменения хранилища.
+type importBatch struct {
+	mode      ImportMode
+	format    FileFormat
+	accounts  []*types.Account
+	payments  []*types.Payment
+	favorites []*types.Favorite
+	transfers []*types.Transfer
+	holds     []*types.Hold
+	refunds   []*types.Refund
+	schedules []*types.Schedule
+	runs      []*types.ScheduleRun
+	lines     map[interface{}]int // строка файла, из которой прочитана запись
+	source    string              // файл всех записей, если они не из дампов (ImportFromFile)
+	problems  []ImportProblem
+
+	// Решения plan: записи дампов, которые не нужно сохранять,
+	// и записи хранилища, которые нужно удалить.
+	skip            map[interface{}]bool
+	deleteAccounts  []*types.Account
+	deletePayments  []string
+	deleteFavorites []string
+	deleteTransfers []string
+	deleteHolds     []string
+	deleteRefunds   []string
+	deleteSchedules []string
+	deleteRuns      []string
+}
+
+func (b *importBatch) problem(file string, line int, err error) {
+	b.problems = append(b.problems, ImportProblem{File: file, Line: line, Err: err})
+}
+
+// fieldParser разбирает поля записи и запоминает первую ошибку.
+type fieldParser struct {
+	fields []string
+	err    error
+}
+
+func (p *fieldParser) fail(err error) {
+	if p.err == nil {
+		p.err = err
+	}
+}
+
+func (p *fieldParser) int(i int, name string) int64 {
+	val, err := strconv.ParseInt(p.fields[i], 10, 64)
+	if err != nil {
+		p.fail(fmt.Errorf("invalid %s %q", name, p.fields[i]))
+	}
+	return val
+}
+
+func (p *fieldParser) currency(i int) types.Currency {
+	currency := types.Currency(p.fields[i])
+	if !currency.Valid() {
+		p.fail(fmt.Errorf("%w %q", ErrUnknownCurrency, p.fields[i]))
+	}
+	return currency
+}
+
+func (p *fieldParser) status(i int) types.PaymentStatus {
+	status := types.PaymentStatus(p.fields[i])
+	if !status.Valid() {
+		p.fail(fmt.Errorf("%w %q", ErrInvalidPaymentStatus, p.fields[i]))
+	}
+	return status
+}
+
+// time разбирает время в RFC 3339, пустое поле - нулевое время.
+func (p *fieldParser) time(i int, name string) time.Time {
+	if p.fields[i] == "" {
+		return time.Time{}
+	}
+	t, err := time.Parse(time.RFC3339Nano, p.fields[i])
+	if err != nil {
+		p.fail(fmt.Errorf("invalid %s time %q", name, p.fields[i]))
+	}
+	return t.UTC()
+}
+
+func (p *fieldParser) holdStatus(i int) types.HoldStatus {
+	status := types.HoldStatus(p.fields[i])
+	if !status.Valid() {
+		p.fail(fmt.Errorf("%w %q", ErrInvalidHoldStatus, p.fields[i]))
+	}
+	return status
+}
+
+func (p *fieldParser) scheduleKind(i int) types.ScheduleKind {
+	kind := types.ScheduleKind(p.fields[i])
+	if !kind.Valid() {
+		p.fail(fmt.Errorf("%w: kind %q", ErrInvalidSchedule, p.fields[i]))
+	}
+	return kind
+}
+
+func (p *fieldParser) runStatus(i int) types.ScheduleRunStatus {
+	status := types.ScheduleRunStatus(p.fields[i])
+	if !status.Valid() {
+		p.fail(fmt.Errorf("invalid schedule run status %q", p.fields[i]))
+	}
+	return status
+}
+
+func (p *fieldParser) bool(i int, name string) bool {
+	val, err := strconv.ParseBool(p.fields[i])
+	if err != nil {
+		p.fail(fmt.Errorf("invalid %s %q", name, p.fields[i]))
+	}
+	return val
+}
+
+// conversion разбирает поля конвертации targetAmount;targetCurrency;rate,
+// начиная с поля i. Без конвертации все три поля пусты.
+func (p *fieldParser) conversion(i int) (types.Money, types.Currency, string) {
+	amount, currency, rate := p.fields[i], p.fields[i+1], p.fields[i+2]
+	if currency == "" {
+		if amount != "" || rate != "" {
+			p.fail(errors.New("invalid conversion format"))
+		}
+		return 0, "", ""
+	}
+
+	val := p.int(i, "target amount")
+	target := p.currency(i + 1)
+	if _, err := parseRate(rate); err != nil {
+		p.fail(fmt.Errorf("%w %q", ErrInvalidRate, rate))
+	}
+	return types.Money(val), target, rate
+}
+
+// readImport читает и разбирает файлы каталога dir.
+func readImport(dir string, options ImportOptions) *importBatch {
+	batch := newImportBatch(options)
+	batch.readAccounts(filepath.Join(dir, accountsDump.file(options.Format)))
+	batch.readPayments(filepath.Join(dir, paymentsDump.file(options.Format)))
+	batch.readFavorites(filepath.Join(dir, favoritesDump.file(options.Format)))
+	batch.readTransfers(filepath.Join(dir, transfersDump.file(options.Format)))
+	batch.readHolds(filepath.Join(dir, holdsDump.file(options.Format)))
+	batch.readRefunds(filepath.Join(dir, refundsDump.file(options.Format)))
+	batch.readSchedules(filepath.Join(dir, schedulesDump.file(options.Format)))
+	batch.readRuns(filepath.Join(dir, scheduleRunsDump.file(options.Format)))
+	return batch
+}
+
+func newImportBatch(options ImportOptions) *importBatch {
+	return &importBatch{mode: options.Mode, format: options.Format, lines: make(map[interface{}]int)}
+}
+
+// file возвращает имя файла записей вида kind для сообщений об ошибках.
+func (b *importBatch) file(kind dumpKind) string {
+	if b.source != "" {
+		return b.source
+	}
+	return kind.file(b.format)
+}
+
+func (b *importBatch) readAccounts(path string) {
+	records, problems := readFile(path, accountsDump, b.format)
+	b.problems = append(b.problems, problems...)
+	for _, record := range records {
+		p := fieldParser{fields: record.fields}
+		account := &types.Account{
+			ID:        p.int(0, "account id"),
+			Phone:     types.Phone(record.fields[1]),
+			Balance:   types.Money(p.int(2, "balance")),
+			Currency:  p.currency(3),
+			CreatedAt: p.time(4, "created"),
+			UpdatedAt: p.time(5, "updated"),
+		}
+		if p.err != nil {
+			b.problem(filepath.Base(path), record.line, p.err)
+			continue
+		}
+		b.accounts = append(b.accounts, account)
+		b.lines[account] = record.line
+	}
+}
+
+// readAccountsFile разбирает файл ExportToFile с именем name: записи
+// id;phone;balance, каждая заканчивается '|'. Валюты и времени в файле
+// нет: у существующих аккаунтов они сохраняются, новые создаются
+// в DefaultCurrency со временем now. ExportToFile дописывает файл, поэтому
+// запись с повторяющимся ID заменяет предыдущую. Записи идут в одной
+// строке, и в ошибках Line - номер записи.
+func (b *importBatch) readAccountsFile(tx Tx, name string, data []byte, now time.Time) {
+	b.source = name
+	reader := bufio.NewReader(bytes.NewReader(data))
+	read := make(map[int64]int)
+	for n := 1; ; n++ {
+		fields, err := readRecord(reader, '|')
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			b.problem(name, n, err)
+			return
+		}
+		if len(fields) != 3 {
+			b.problem(name, n, errors.New("invalid export file format"))
+			continue
+		}
+
+		p := fieldParser{fields: fields}
+		account := &types.Account{
+			ID:        p.int(0, "account id"),
+			Phone:     types.Phone(fields[1]),
+			Balance:   types.Money(p.int(2, "balance")),
+			Currency:  types.DefaultCurrency,
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+		if p.err != nil {
+			b.problem(name, n, p.err)
+			continue
+		}
+		if existing, err := tx.Account(account.ID); err == nil {
+			account.Currency, account.CreatedAt = existing.Currency, existing.CreatedAt
+		}
+
+		if i, ok := read[account.ID]; ok {
+			delete(b.lines, b.accounts[i])
+			b.accounts[i] = account
+		} else {
+			read[account.ID] = len(b.accounts)
+			b.accounts = append(b.accounts, account)
+		}
+		b.lines[account] = n
+	}
+}
+
+func (b *importBatch) readPayments(path string) {
+	records, problems := readFile(path, paymentsDump, b.format)
+	b.problems = append(b.problems, problems...)
+	for _, record := range records {
+		p := fieldParser{fields: record.fields}
+		payment := &types.Payment{
+			ID:        record.fields[0],
+			AccountID: p.int(1, "account id"),
+			Amount:    types.Money(p.int(2, "amount")),
+			Category:  types.PaymentCategory(record.fields[3]),
+			Status:    p.status(4),
+			Currency:  p.currency(5),
+		}
+		payment.TargetAmount, payment.TargetCurrency, payment.Rate = p.conversion(6)
+		payment.CreatedAt = p.time(9, "created")
+		payment.UpdatedAt = p.time(10, "updated")
+		payment.StatusChangedAt = p.time(11, "status change")
+		if p.err != nil {
+			b.problem(filepath.Base(path), record.line, p.err)
+			continue
+		}
+		b.payments = append(b.payments, payment)
+		b.lines[payment] = record.line
+	}
+}
+
+func (b *importBatch) readFavorites(path string) {
+	records, problems := readFile(path, favoritesDump, b.format)
+	b.problems = append(b.problems, problems...)
+	for _, record := range records {
+		p := fieldParser{fields: record.fields}
+		favorite := &types.Favorite{
+			ID:        record.fields[0],
+			AccountID: p.int(1, "account id"),
+			Name:      record.fields[2],
+			Amount:    types.Money(p.int(3, "amount")),
+			Category:  types.PaymentCategory(record.fields[4]),
+			Currency:  p.currency(5),
+			CreatedAt: p.time(6, "created"),
+			UpdatedAt: p.time(7, "updated"),
+		}
+		if record.fields[8] != "" {
+			favorite.Position = int(p.int(8, "position"))
+		}
+		if p.err == nil && favorite.Position < 0 {
+			p.fail(fmt.Errorf("invalid position %d", favorite.Position))
+		}
+		if p.err != nil {
+			b.problem(filepath.Base(path), record.line, p.err)
+			continue
+		}
+		b.favorites = append(b.favorites, favorite)
+		b.lines[favorite] = record.line
+	}
+}
+
+func (b *importBatch) readTransfers(path string) {
+	records, problems := readFile(path, transfersDump, b.format)
+	b.problems = append(b.problems, problems...)
+	for _, record := range records {
+		p := fieldParser{fields: record.fields}
+		transfer := &types.Transfer{
+			ID:            record.fields[0],
+			FromAccountID: p.int(1, "sender account id"),
+			ToAccountID:   p.int(2, "recipient account id"),
+			Amount:        types.Money(p.int(3, "amount")),
+			Status:        p.status(4),
+			Currency:      p.currency(5),
+			Comment:       record.fields[9],
+			CreatedAt:     p.time(10, "created"),
+			UpdatedAt:     p.time(11, "updated"),
+		}
+		transfer.TargetAmount, transfer.TargetCurrency, transfer.Rate = p.conversion(6)
+		if p.err != nil {
+			b.problem(filepath.Base(path), record.line, p.err)
+			continue
+		}
+		b.transfers = append(b.transfers, transfer)
+		b.lines[transfer] = record.line
+	}
+}
+
+func (b *importBatch) readHolds(path string) {
+	records, problems := readFile(path, holdsDump, b.format)
+	b.problems = append(b.problems, problems...)
+	for _, record := range records {
+		p := fieldParser{fields: record.fields}
+		hold := &types.Hold{
+			ID:             record.fields[0],
+			AccountID:      p.int(1, "account id"),
+			Amount:         types.Money(p.int(2, "amount")),
+			Currency:       p.currency(3),
+			Category:       types.PaymentCategory(record.fields[4]),
+			Status:         p.holdStatus(5),
+			CapturedAmount: types.Money(p.int(6, "captured amount")),
+			PaymentID:      record.fields[7],
+			ExpiresAt:      p.time(8, "expiry"),
+			CreatedAt:      p.time(9, "created"),
+			UpdatedAt:      p.time(10, "updated"),
+		}
+		if p.err == nil && (hold.Amount <= 0 || hold.CapturedAmount < 0) {
+			p.fail(ErrAmountMustBePositive)
+		}
+		if p.err == nil && hold.CapturedAmount > hold.Amount {
+			p.fail(fmt.Errorf("%w: hold %s", ErrCaptureExceedsHold, hold.ID))
+		}
+		if p.err != nil {
+			b.problem(filepath.Base(path), record.line, p.err)
+			continue
+		}
+		b.holds = append(b.holds, hold)
+		b.lines[hold] = record.line
+	}
+}
+
+func (b *importBatch) readRefunds(path string) {
+	records, problems := readFile(path, refundsDump, b.format)
+	b.problems = append(b.problems, problems...)
+	for _, record := range records {
+		p := fieldParser{fields: record.fields}
+		refund := &types.Refund{
+			ID:        record.fields[0],
+			PaymentID: record.fields[1],
+			AccountID: p.int(2, "account id"),
+			Amount:    types.Money(p.int(3, "amount")),
+			Currency:  p.currency(4),
+			Reason:    record.fields[7],
+			CreatedAt: p.time(8, "created"),
+		}
+		if record.fields[6] != "" {
+			refund.TargetAmount = types.Money(p.int(5, "target amount"))
+			refund.TargetCurrency = p.currency(6)
+		} else if record.fields[5] != "" {
+			p.fail(errors.New("invalid conversion format"))
+		}
+		if p.err == nil && (refund.Amount <= 0 || refund.TargetAmount < 0) {
+			p.fail(ErrAmountMustBePositive)
+		}
+		if p.err != nil {
+			b.problem(filepath.Base(path), record.line, p.err)
+			continue
+		}
+		b.refunds = append(b.refunds, refund)
+		b.lines[refund] = record.line
+	}
+}
+
+func (b *importBatch) readSchedules(path string) {
+	records, problems := readFile(path, schedulesDump, b.format)
+	b.problems = append(b.problems, problems...)
+	for _, record := range records {
+		p := fieldParser{fields: record.fields}
+		schedule := &types.Schedule{
+			ID:         record.fields[0],
+			FavoriteID: record.fields[1],
+			AccountID:  p.int(2, "account id"),
+			Kind:       p.scheduleKind(3),
+			Start:      p.time(4, "start"),
+			Cron:       record.fields[5],
+			Active:     p.bool(6, "active flag"),
+			DueAt:      p.time(7, "due"),
+			RetryAt:    p.time(8, "retry"),
+			Attempts:   int(p.int(9, "attempts")),
+			CreatedAt:  p.time(10, "created"),
+			UpdatedAt:  p.time(11, "updated"),
+		}
+		if p.err == nil && schedule.Kind == types.ScheduleCron {
+			if _, err := parseCron(schedule.Cron); err != nil {
+				p.fail(err)
+			}
+		}
+		if p.err != nil {
+			b.problem(filepath.Base(path), record.line, p.err)
+			continue
+		}
+		b.schedules = append(b.schedules, schedule)
+		b.lines[schedule] = record.line
+	}
+}
+
+func (b *importBatch) readRuns(path string) {
+	records, problems := readFile(path, scheduleRunsDump, b.format)
+	b.problems = append(b.problems, problems...)
+	for _, record := range records {
+		p := fieldParser{fields: record.fields}
+		run := &types.ScheduleRun{
+			ID:         record.fields[0],
+			ScheduleID: record.fields[1],
+			AccountID:  p.int(2, "account id"),
+			DueAt:      p.time(3, "due"),
+			Attempt:    int(p.int(4, "attempt")),
+			Status:     p.runStatus(5),
+			PaymentID:  record.fields[6],
+			Error:      record.fields[7],
+			At:         p.time(8, "run"),
+		}
+		if p.err != nil {
+			b.problem(filepath.Base(path), record.line, p.err)
+			continue
+		}
+		b.runs = append(b.runs, run)
+		b.lines[run] = record.line
+	}
+}
+
+// sameAccount сравнивает аккаунты без Held: в дампах его нет,
+// он пересчитывается по блокировкам.
+func sameAccount(existing, imported *types.Account) bool {
+	account := *existing
+	account.Held = imported.Held
+	return account == *imported
+}
+
+// sameFavorite сравнивает избранное без места, если в дампе его нет:
+// такие записи остаются на своём месте.
+func sameFavorite(existing, imported *types.Favorite) bool {
+	favorite := *existing
+	if imported.Position == 0 {
+		favorite.Position = 0
+	}
+	return favorite == *imported
+}
+
+// validate проверяет записи между собой и против содержимого хранилища.
+func (b *importBatch) validate(tx Tx) {
+	known := make(map[int64]bool)
+	phones := make(map[types.Phone]bool)
+	// Валюта и баланс аккаунтов после импорта
+	currencies := make(map[int64]types.Currency)
+	balances := make(map[int64]types.Money)
+	for _, account := range b.accounts {
+		line := b.lines[account]
+		problem := func(err error) { b.problem(b.file(accountsDump), line, err) }
+
+		if known[account.ID] {
+			problem(fmt.Errorf("%w: account %d", ErrDuplicateRecord, account.ID))
+		} else if phones[account.Phone] {
+			problem(fmt.Errorf("%w %s", ErrPhoneRegistered, account.Phone))
+		}
+		if account.Balance < 0 {
+			problem(fmt.Errorf("%w: account %d", ErrNegativeBalance, account.ID))
+		}
+		known[account.ID] = true
+		phones[account.Phone] = true
+		currencies[account.ID] = account.Currency
+		balances[account.ID] = account.Balance
+
+		// В ImportReplace аккаунты хранилища, которых нет в дампе, удаляются,
+		// поэтому проверяются только записи дампа между собой
+		if b.mode == ImportReplace {
+			continue
+		}
+		existing, err := tx.Account(account.ID)
+		if err == nil {
+			switch {
+			case b.mode == ImportSkipExisting:
+				currencies[account.ID] = existing.Currency
+				balances[account.ID] = existing.Balance
+				continue
+			case b.mode == ImportFailOnConflict && !sameAccount(existing, account):
+				problem(fmt.Errorf("%w: account %d", ErrImportConflict, account.ID))
+			case existing.Currency != account.Currency:
+				problem(fmt.Errorf("%w: account %d is in %s", ErrCurrencyMismatch, account.ID, existing.Currency))
+			}
+		}
+		if existing, err := tx.AccountByPhone(account.Phone); err == nil && existing.ID != account.ID {
+			problem(fmt.Errorf("%w %s to account %d", ErrPhoneRegistered, account.Phone, existing.ID))
+		}
+	}
+
+	exists := func(accountID int64) bool {
+		if known[accountID] {
+			return true
+		}
+		if b.mode == ImportReplace {
+			return false
+		}
+		_, err := tx.Account(accountID)
+		return err == nil
+	}
+	conflict := func(exists, same bool) bool {
+		return b.mode == ImportFailOnConflict && exists && !same
+	}
+	currencyOf := func(accountID int64) (types.Currency, bool) {
+		if currency, ok := currencies[accountID]; ok {
+			return currency, true
+		}
+		if b.mode == ImportReplace {
+			return "", false
+		}
+		account, err := tx.Account(accountID)
+		if err != nil {
+			return "", false
+		}
+		return account.Currency, true
+	}
+	balanceOf := func(accountID int64) types.Money {
+		if balance, ok := balances[accountID]; ok {
+			return balance
+		}
+		account, err := tx.Account(accountID)
+		if err != nil {
+			return 0
+		}
+		return account.Balance
+	}
+
+	seen := make(map[string]bool)
+	for _, payment := range b.payments {
+		line := b.lines[payment]
+		if seen[payment.ID] {
+			b.problem(b.file(paymentsDump), line, fmt.Errorf("%w: payment %s", ErrDuplicateRecord, payment.ID))
+		}
+		seen[payment.ID] = true
+		if payment.Amount <= 0 {
+			b.problem(b.file(paymentsDump), line, fmt.Errorf("%w: payment %s", ErrAmountMustBePositive, payment.ID))
+		}
+		if !exists(payment.AccountID) {
+			b.problem(b.file(paymentsDump), line, fmt.Errorf("%w: %d", ErrAccountNotFound, payment.AccountID))
+		}
+		existing, err := tx.Payment(payment.ID)
+		if conflict(err == nil, err == nil && *existing == *payment) {
+			b.problem(b.file(paymentsDump), line, fmt.Errorf("%w: payment %s", ErrImportConflict, payment.ID))
+		}
+		if err == nil && b.mode == ImportSkipExisting {
+			continue
+		}
+		if currency, ok := currencyOf(payment.AccountID); ok && payment.Currency != currency {
+			b.problem(b.file(paymentsDump), line, fmt.Errorf("%w: payment %s is in %s, account %d is in %s",
+				ErrCurrencyMismatch, payment.ID, payment.Currency, payment.AccountID, currency))
+		}
+	}
+	payments := seen
+
+	// Записи избранного, которые импорт сохранит: в ImportSkipExisting
+	// существующие записи остаются как есть
+	written := make(map[string]bool)
+	for _, favorite := range b.favorites {
+		if _, err := tx.Favorite(favorite.ID); err != nil || b.mode != ImportSkipExisting {
+			written[favorite.ID] = true
+		}
+	}
+	// Избранное аккаунтов после импорта для проверки имён: оставшиеся
+	// записи хранилища и уже проверенные записи дампа
+	named := make(map[int64][]*types.Favorite)
+	namedOf := func(accountID int64) []*types.Favorite {
+		favorites, ok := named[accountID]
+		if !ok && b.mode != ImportReplace {
+			for _, favorite := range tx.AccountFavorites(accountID) {
+				if !written[favorite.ID] {
+					favorites = append(favorites, favorite)
+				}
+			}
+		}
+		return favorites
+	}
+
+	seen = make(map[string]bool)
+	for _, favorite := range b.favorites {
+		line := b.lines[favorite]
+		if seen[favorite.ID] {
+			b.problem(b.file(favoritesDump), line, fmt.Errorf("%w: favorite %s", ErrDuplicateRecord, favorite.ID))
+		}
+		seen[favorite.ID] = true
+		if favorite.Amount <= 0 {
+			b.problem(b.file(favoritesDump), line, fmt.Errorf("%w: favorite %s", ErrAmountMustBePositive, favorite.ID))
+		}
+		if !exists(favorite.AccountID) {
+			b.problem(b.file(favoritesDump), line, fmt.Errorf("%w: %d", ErrAccountNotFound, favorite.AccountID))
+		}
+		if existing, err := tx.Favorite(favorite.ID); conflict(err == nil, err == nil && sameFavorite(existing, favorite)) {
+			b.problem(b.file(favoritesDump), line, fmt.Errorf("%w: favorite %s", ErrImportConflict, favorite.ID))
+		}
+		if !written[favorite.ID] || !exists(favorite.AccountID) {
+			continue
+		}
+		if currency, ok := currencyOf(favorite.AccountID); ok && favorite.Currency != currency {
+			b.problem(b.file(favoritesDump), line, fmt.Errorf("%w: favorite %s is in %s, account %d is in %s",
+				ErrCurrencyMismatch, favorite.ID, favorite.Currency, favorite.AccountID, currency))
+		}
+		favorites := namedOf(favorite.AccountID)
+		if err := checkFavoriteName(favorites, favorite.ID, favorite.Name); err != nil {
+			b.problem(b.file(favoritesDump), line, fmt.Errorf("%w in account %d", err, favorite.AccountID))
+		}
+		named[favorite.AccountID] = append(favorites, favorite)
+	}
+	favorites := seen
+
+	seen = make(map[string]bool)
+	for _, transfer := range b.transfers {
+		line := b.lines[transfer]
+		if seen[transfer.ID] {
+			b.problem(b.file(transfersDump), line, fmt.Errorf("%w: transfer %s", ErrDuplicateRecord, transfer.ID))
+		}
+		seen[transfer.ID] = true
+		if transfer.Amount <= 0 {
+			b.problem(b.file(transfersDump), line, fmt.Errorf("%w: transfer %s", ErrAmountMustBePositive, transfer.ID))
+		}
+		for _, accountID := range []int64{transfer.FromAccountID, transfer.ToAccountID} {
+			if !exists(accountID) {
+				b.problem(b.file(transfersDump), line, fmt.Errorf("%w: %d", ErrAccountNotFound, accountID))
+			}
+		}
+		if existing, err := tx.Transfer(transfer.ID); conflict(err == nil, err == nil && *existing == *transfer) {
+			b.problem(b.file(transfersDump), line, fmt.Errorf("%w: transfer %s", ErrImportConflict, transfer.ID))
+		}
+	}
+
+	seen = make(map[string]bool)
+	for _, hold := range b.holds {
+		line := b.lines[hold]
+		if seen[hold.ID] {
+			b.problem(b.file(holdsDump), line, fmt.Errorf("%w: hold %s", ErrDuplicateRecord, hold.ID))
+		}
+		seen[hold.ID] = true
+		if hold.Amount <= 0 {
+			b.problem(b.file(holdsDump), line, fmt.Errorf("%w: hold %s", ErrAmountMustBePositive, hold.ID))
+		}
+		if !exists(hold.AccountID) {
+			b.problem(b.file(holdsDump), line, fmt.Errorf("%w: %d", ErrAccountNotFound, hold.AccountID))
+		}
+		if existing, err := tx.Hold(hold.ID); conflict(err == nil, err == nil && *existing == *hold) {
+			b.problem(b.file(holdsDump), line, fmt.Errorf("%w: hold %s", ErrImportConflict, hold.ID))
+		}
+	}
+	b.validateHeld(tx, exists, balanceOf)
+
+	seen = make(map[string]bool)
+	for _, refund := range b.refunds {
+		line := b.lines[refund]
+		if seen[refund.ID] {
+			b.problem(b.file(refundsDump), line, fmt.Errorf("%w: refund %s", ErrDuplicateRecord, refund.ID))
+		}
+		seen[refund.ID] = true
+		if refund.Amount <= 0 {
+			b.problem(b.file(refundsDump), line, fmt.Errorf("%w: refund %s", ErrAmountMustBePositive, refund.ID))
+		}
+		if !exists(refund.AccountID) {
+			b.problem(b.file(refundsDump), line, fmt.Errorf("%w: %d", ErrAccountNotFound, refund.AccountID))
+		}
+		if !payments[refund.PaymentID] {
+			if _, err := tx.Payment(refund.PaymentID); err != nil || b.mode == ImportReplace {
+				b.problem(b.file(refundsDump), line, fmt.Errorf("%w: %s", ErrPaymentNotFound, refund.PaymentID))
+			}
+		}
+		if existing, err := tx.Refund(refund.ID); conflict(err == nil, err == nil && *existing == *refund) {
+			b.problem(b.file(refundsDump), line, fmt.Errorf("%w: refund %s", ErrImportConflict, refund.ID))
+		}
+	}
+
+	seen = make(map[string]bool)
+	for _, schedule := range b.schedules {
+		line := b.lines[schedule]
+		if seen[schedule.ID] {
+			b.problem(b.file(schedulesDump), line, fmt.Errorf("%w: schedule %s", ErrDuplicateRecord, schedule.ID))
+		}
+		seen[schedule.ID] = true
+		if !exists(schedule.AccountID) {
+			b.problem(b.file(schedulesDump), line, fmt.Errorf("%w: %d", ErrAccountNotFound, schedule.AccountID))
+		}
+		if !favorites[schedule.FavoriteID] {
+			if _, err := tx.Favorite(schedule.FavoriteID); err != nil || b.mode == ImportReplace {
+				b.problem(b.file(schedulesDump), line, fmt.Errorf("%w: %s", ErrFavoriteNotFound, schedule.FavoriteID))
+			}
+		}
+		if existing, err := tx.Schedule(schedule.ID); conflict(err == nil, err == nil && *existing == *schedule) {
+			b.problem(b.file(schedulesDump), line, fmt.Errorf("%w: schedule %s", ErrImportConflict, schedule.ID))
+		}
+	}
+	schedules := seen
+
+	seen = make(map[string]bool)
+	for _, run := range b.runs {
+		line := b.lines[run]
+		if seen[run.ID] {
+			b.problem(b.file(scheduleRunsDump), line, fmt.Errorf("%w: schedule run %s", ErrDuplicateRecord, run.ID))
+		}
+		seen[run.ID] = true
+		if !exists(run.AccountID) {
+			b.problem(b.file(scheduleRunsDump), line, fmt.Errorf("%w: %d", ErrAccountNotFound, run.AccountID))
+		}
+		if !schedules[run.ScheduleID] {
+			if _, err := tx.Schedule(run.ScheduleID); err != nil || b.mode == ImportReplace {
+				b.problem(b.file(scheduleRunsDump), line, fmt.Errorf("%w: %s", ErrScheduleNotFound, run.ScheduleID))
+			}
+		}
+		if existing, err := tx.ScheduleRun(run.ID); conflict(err == nil, err == nil && *existing == *run) {
+			b.problem(b.file(scheduleRunsDump), line, fmt.Errorf("%w: schedule run %s", ErrImportConflict, run.ID))
+		}
+	}
+}
+
+// validateHeld проверяет, что активные блокировки после импорта не превышают
+// баланс аккаунта. Проверяются аккаунты, которых касаются записи дампа.
+func (b *importBatch) validateHeld(tx Tx, exists func(int64) bool, balanceOf func(int64) types.Money) {
+	// Блокировки аккаунтов после импорта: записи дампа заменяют записи
+	// хранилища с тем же ID, кроме существующих в ImportSkipExisting
+	holds := make(map[int64]map[string]*types.Hold)
+	holdsOf := func(accountID int64) map[string]*types.Hold {
+		if accountHolds, ok := holds[accountID]; ok {
+			return accountHolds
+		}
+		accountHolds := make(map[string]*types.Hold)
+		if b.mode != ImportReplace {
+			for _, hold := range tx.AccountHolds(accountID) {
+				accountHolds[hold.ID] = hold
+			}
+		}
+		holds[accountID] = accountHolds
+		return accountHolds
+	}
+	// Первая запись дампа аккаунта: на ней сообщается о превышении
+	first := make(map[int64]ImportProblem)
+	for _, account := range b.accounts {
+		holdsOf(account.ID)
+		first[account.ID] = ImportProblem{File: b.file(accountsDump), Line: b.lines[account]}
+	}
+	for _, hold := range b.holds {
+		if !exists(hold.AccountID) {
+			continue
+		}
+		accountHolds := holdsOf(hold.AccountID)
+		if _, err := tx.Hold(hold.ID); err == nil && b.mode == ImportSkipExisting {
+			continue
+		}
+		accountHolds[hold.ID] = hold
+		if _, ok := first[hold.AccountID]; !ok {
+			first[hold.AccountID] = ImportProblem{File: b.file(holdsDump), Line: b.lines[hold]}
+		}
+	}
+
+	accountIDs := make([]int64, 0, len(holds))
+	for accountID := range holds {
+		accountIDs = append(accountIDs, accountID)
+	}
+	sort.Slice(accountIDs, func(i, j int) bool { return accountIDs[i] < accountIDs[j] })
+	for _, accountID := range accountIDs {
+		held := types.Money(0)
+		for _, hold := range holds[accountID] {
+			if hold.Status == types.HoldStatusActive {
+				held += hold.Amount
+			}
+		}
+		if balance := balanceOf(accountID); held > 0 && held > balance {
+			at := first[accountID]
+			b.problem(at.File, at.Line, fmt.Errorf("%w: account %d holds %d of balance %d", ErrNotEnoughBalance, accountID, held, balance))
+		}
+	}
+}
+
+// plan сравнивает проверенные записи с хранилищем и решает, что с ними
+// сделает apply.
+func (b *importBatch) plan(tx Tx) *ImportReport {
+	report := &ImportReport{}
+	b.skip = make(map[interface{}]bool)
+	decide := func(kind dumpKind, id string, record interface{}, exists, same bool) {
+		action := ImportCreate
+		switch {
+		case exists && same:
+			action = ImportUnchanged
+		case exists && b.mode == ImportSkipExisting:
+			action = ImportSkip
+		case exists:
+			action = ImportUpdate
+		}
+		if action == ImportUnchanged || action == ImportSkip {
+			b.skip[record] = true
+		}
+		report.add(kind, id, action)
+	}
+
+	accounts := make(map[int64]bool)
+	for _, account := range b.accounts {
+		accounts[account.ID] = true
+		existing, err := tx.Account(account.ID)
+		decide(accountsDump, strconv.FormatInt(account.ID, 10), account, err == nil, err == nil && sameAccount(existing, account))
+	}
+	if b.mode == ImportReplace {
+		for _, account := range tx.Accounts() {
+			if !accounts[account.ID] {
+				b.deleteAccounts = append(b.deleteAccounts, account)
+				report.add(accountsDump, strconv.FormatInt(account.ID, 10), ImportDelete)
+			}
+		}
+	}
+
+	payments := make(map[string]bool)
+	for _, payment := range b.payments {
+		payments[payment.ID] = true
+		existing, err := tx.Payment(payment.ID)
+		decide(paymentsDump, payment.ID, payment, err == nil, err == nil && *existing == *payment)
+	}
+	if b.mode == ImportReplace {
+		for _, payment := range tx.Payments() {
+			if !payments[payment.ID] {
+				b.deletePayments = append(b.deletePayments, payment.ID)
+				report.add(paymentsDump, payment.ID, ImportDelete)
+			}
+		}
+	}
+
+	favorites := make(map[string]bool)
+	for _, favorite := range b.favorites {
+		favorites[favorite.ID] = true
+		existing, err := tx.Favorite(favorite.ID)
+		decide(favoritesDump, favorite.ID, favorite, err == nil, err == nil && sameFavorite(existing, favorite))
+	}
+	if b.mode == ImportReplace {
+		for _, favorite := range tx.Favorites() {
+			if !favorites[favorite.ID] {
+				b.deleteFavorites = append(b.deleteFavorites, favorite.ID)
+				report.add(favoritesDump, favorite.ID, ImportDelete)
+			}
+		}
+	}
+
+	transfers := make(map[string]bool)
+	for _, transfer := range b.transfers {
+		transfers[transfer.ID] = true
+		existing, err := tx.Transfer(transfer.ID)
+		decide(transfersDump, transfer.ID, transfer, err == nil, err == nil && *existing == *transfer)
+	}
+	if b.mode == ImportReplace {
+		for _, transfer := range tx.Transfers() {
+			if !transfers[transfer.ID] {
+				b.deleteTransfers = append(b.deleteTransfers, transfer.ID)
+				report.add(transfersDump, transfer.ID, ImportDelete)
+			}
+		}
+	}
+
+	holds := make(map[string]bool)
+	for _, hold := range b.holds {
+		holds[hold.ID] = true
+		existing, err := tx.Hold(hold.ID)
+		decide(holdsDump, hold.ID, hold, err == nil, err == nil && *existing == *hold)
+	}
+	if b.mode == ImportReplace {
+		for _, hold := range tx.Holds() {
+			if !holds[hold.ID] {
+				b.deleteHolds = append(b.deleteHolds, hold.ID)
+				report.add(holdsDump, hold.ID, ImportDelete)
+			}
+		}
+	}
+
+	refunds := make(map[string]bool)
+	for _, refund := range b.refunds {
+		refunds[refund.ID] = true
+		existing, err := tx.Refund(refund.ID)
+		decide(refundsDump, refund.ID, refund, err == nil, err == nil && *existing == *refund)
+	}
+	if b.mode == ImportReplace {
+		for _, refund := range tx.Refunds() {
+			if !refunds[refund.ID] {
+				b.deleteRefunds = append(b.deleteRefunds, refund.ID)
+				report.add(refundsDump, refund.ID, ImportDelete)
+			}
+		}
+	}
+
+	schedules := make(map[string]bool)
+	for _, schedule := range b.schedules {
+		schedules[schedule.ID] = true
+		existing, err := tx.Schedule(schedule.ID)
+		decide(schedulesDump, schedule.ID, schedule, err == nil, err == nil && *existing == *schedule)
+	}
+	if b.mode == ImportReplace {
+		for _, schedule := range tx.Schedules() {
+			if !schedules[schedule.ID] {
+				b.deleteSchedules = append(b.deleteSchedules, schedule.ID)
+				report.add(schedulesDump, schedule.ID, ImportDelete)
+			}
+		}
+	}
+
+	runs := make(map[string]bool)
+	for _, run := range b.runs {
+		runs[run.ID] = true
+		existing, err := tx.ScheduleRun(run.ID)
+		decide(scheduleRunsDump, run.ID, run, err == nil, err == nil && *existing == *run)
+	}
+	if b.mode == ImportReplace {
+		for _, run := range tx.ScheduleRuns() {
+			if !runs[run.ID] {
+				b.deleteRuns = append(b.deleteRuns, run.ID)
+				report.add(scheduleRunsDump, run.ID, ImportDelete)
+			}
+		}
+	}
+
+	return report
+}
+
+// apply выполняет решения plan.
+func (b *importBatch) apply(tx Tx) {
+	for _, id := range b.deleteRuns {
+		tx.DeleteScheduleRun(id)
+	}
+	for _, id := range b.deleteSchedules {
+		tx.DeleteSchedule(id)
+	}
+	for _, id := range b.deleteRefunds {
+		tx.DeleteRefund(id)
+	}
+	for _, id := range b.deleteHolds {
+		tx.DeleteHold(id)
+	}
+	for _, id := range b.deleteTransfers {
+		tx.DeleteTransfer(id)
+	}
+	// Аккаунты, избранное которых меняется: только их нумеруем заново
+	renumber := make(map[int64]bool)
+	for _, id := range b.deleteFavorites {
+		if existing, err := tx.Favorite(id); err == nil {
+			renumber[existing.AccountID] = true
+		}
+		tx.DeleteFavorite(id)
+	}
+	deletedPayments := make(map[string]bool)
+	for _, id := range b.deletePayments {
+		tx.DeletePayment(id)
+		deletedPayments[id] = true
+	}
+	deletedAccounts := make(map[int64]bool)
+	for _, account := range b.deleteAccounts {
+		// Баланс списывается проводкой, чтобы проводки удалённого аккаунта сошлись в ноль
+		adjustBalance(tx, account, 0)
+		tx.DeleteAccount(account.ID)
+		deletedAccounts[account.ID] = true
+	}
+	for _, record := range tx.IdempotencyKeys() {
+		if deletedAccounts[record.AccountID] || deletedPayments[record.PaymentID] {
+			tx.DeleteIdempotencyKey(record.AccountID, record.Key)
+		}
+	}
+
+	for _, imported := range b.accounts {
+		if b.skip[imported] {
+			continue
+		}
+		account, err := tx.Account(imported.ID)
+		if err != nil {
+			account = &types.Account{ID: imported.ID, Currency: imported.Currency}
+		}
+		if account.Currency != imported.Currency {
+			// Только в ImportReplace: баланс в старой валюте списывается
+			adjustBalance(tx, account, 0)
+			account.Currency = imported.Currency
+		}
+		account.Phone = imported.Phone
+		account.CreatedAt, account.UpdatedAt = imported.CreatedAt, imported.UpdatedAt
+		adjustBalance(tx, account, imported.Balance)
+		tx.PutAccount(account)
+	}
+	for _, payment := range b.payments {
+		if !b.skip[payment] {
+			tx.PutPayment(payment)
+		}
+	}
+	for _, favorite := range b.favorites {
+		if b.skip[favorite] {
+			continue
+		}
+		if existing, err := tx.Favorite(favorite.ID); err == nil {
+			if favorite.Position == 0 {
+				favorite.Position = existing.Position
+			}
+			renumber[existing.AccountID] = true
+		}
+		renumber[favorite.AccountID] = true
+		tx.PutFavorite(favorite)
+	}
+	for _, transfer := range b.transfers {
+		if !b.skip[transfer] {
+			tx.PutTransfer(transfer)
+		}
+	}
+	for _, hold := range b.holds {
+		if !b.skip[hold] {
+			tx.PutHold(hold)
+		}
+	}
+	for _, refund := range b.refunds {
+		if !b.skip[refund] {
+			tx.PutRefund(refund)
+		}
+	}
+	for _, schedule := range b.schedules {
+		if !b.skip[schedule] {
+			tx.PutSchedule(schedule)
+		}
+	}
+	for _, run := range b.runs {
+		if !b.skip[run] {
+			tx.PutScheduleRun(run)
+		}
+	}
+
+	// Избранное затронутых аккаунтов нумеруется заново: места из разных
+	// источников могут совпадать, а у записей старых дампов их нет
+	accountIDs := make([]int64, 0, len(renumber))
+	for accountID := range renumber {
+		accountIDs = append(accountIDs, accountID)
+	}
+	sort.Slice(accountIDs, func(i, j int) bool { return accountIDs[i] < accountIDs[j] })
+	for _, accountID := range accountIDs {
+		renumberFavorites(tx, accountFavorites(tx, accountID))
+	}
+
+	// Held пересчитывается по активным блокировкам после всех изменений
+	for _, account := range tx.Accounts() {
+		held := types.Money(0)
+		for _, hold := range tx.AccountHolds(account.ID) {
+			if hold.Status == types.HoldStatusActive {
+				held += hold.Amount
+			}
+		}
+		if account.Held != held {
+			account.Held = held
+			tx.PutAccount(account)
+		}
+	}
+}
+
+// importDir загружает дампы каталога dir. С options.DryRun хранилище
+// не меняется, и tx может быть транзакцией только для чтения.
+func importDir(tx Tx, dir string, options ImportOptions) (*ImportReport, error) {
+	return readImport(dir, options).run(tx, options.DryRun)
+}
+
+// run проверяет прочитанные записи и, если ошибок нет, выполняет их
+// загрузку. С dryRun только строит отчёт.
+func (b *importBatch) run(tx Tx, dryRun bool) (*ImportReport, error) {
+	b.validate(tx)
+	if len(b.problems) > 0 {
+		return nil, &ImportError{Problems: b.problems}
+	}
+	report := b.plan(tx)
+	if !dryRun {
+		b.apply(tx)
+	}
+	return report, nil
+}
@@ -0,0 +1,429 @@
+package wallet
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/akmalsulaymonov/alif-wallet/pkg/types"
+)
+
+func TestService_Import_Report(t *testing.T) {
+	dir := t.TempDir()
+	writeDumpFile(t, dir, "accounts.dump", "#wallet-dump;accounts;3;4\n"+
+		"1;+992000000001;1000;TJS\n"+
+		"x;+992000000002;1000;TJS\n"+
+		"1;+992000000003;1000;TJS\n"+
+		"4;+992000000004;-5;TJS\n")
+	writeDumpFile(t, dir, "payments.dump", "#wallet-dump;payments;3;3\n"+
+		"p1;1;100;food;OK;TJS;;;\n"+
+		"p1;1;100;food;OK;TJS;;;\n"+
+		"p2;42;100;food;DONE;TJS;;;\n")
+	writeDumpFile(t, dir, "favorites.dump", "#wallet-dump;favorites;3;1\n"+
+		"f1;42;\"Обед\nна работе\";100;food;TJS\n")
+	writeDumpFile(t, dir, "transfers.dump", "#wallet-dump;transfers;3;1\n"+
+		"t1;1;42;100;OK;TJS;;;;\n")
+
+	s := &Service{}
+	err := s.Import(dir)
+
+	var importErr *ImportError
+	if !errors.As(err, &importErr) {
+		t.Fatalf("expected *ImportError, got %v", err)
+	}
+	want := []struct {
+		file string
+		line int
+		err  error
+	}{
+		{"accounts.dump", 3, nil},
+		{"payments.dump", 4, ErrInvalidPaymentStatus},
+		{"accounts.dump", 4, ErrDuplicateRecord},
+		{"accounts.dump", 5, ErrNegativeBalance},
+		{"payments.dump", 3, ErrDuplicateRecord},
+		{"favorites.dump", 2, ErrAccountNotFound},
+		{"transfers.dump", 2, ErrAccountNotFound},
+	}
+	if len(importErr.Problems) != len(want) {
+		t.Fatalf("expected %d problems, got %v", len(want), importErr.Problems)
+	}
+	for i, w := range want {
+		got := importErr.Problems[i]
+		if got.File != w.file || got.Line != w.line || (w.err != nil && !errors.Is(got, w.err)) {
+			t.Errorf("expected problem %s:%d %v, got %v", w.file, w.line, w.err, got)
+		}
+	}
+	if !errors.Is(err, ErrNegativeBalance) {
+		t.Errorf("expected errors.Is to find %v in %v", ErrNegativeBalance, err)
+	}
+
+	if len(serviceAccounts(s)) != 0 || len(servicePayments(s)) != 0 {
+		t.Errorf("expected nothing imported, got %v, %v", serviceAccounts(s), servicePayments(s))
+	}
+}
+
+func TestService_Import_ReferencesExistingAccounts(t *testing.T) {
+	s := &Service{}
+	account, _ := s.RegisterAccount("+992000000001")
+	s.Deposit(account.ID, 1000)
+
+	dir := t.TempDir()
+	writeDumpFile(t, dir, "payments.dump", "#wallet-dump;payments;3;1\np1;1;100;food;OK;TJS;;;\n")
+	if err := s.Import(dir); err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+	if _, err := s.FindPaymentByID("p1"); err != nil {
+		t.Errorf("expected imported payment, got %v", err)
+	}
+}
+
+func TestService_Import_ConflictsWithService(t *testing.T) {
+	s := &Service{}
+	s.RegisterAccountWithCurrency("+992000000001", "USD")
+
+	dir := t.TempDir()
+	writeDumpFile(t, dir, "accounts.dump", "#wallet-dump;accounts;3;2\n"+
+		"1;+992000000001;1000;TJS\n"+
+		"2;+992000000001;1000;TJS\n")
+
+	err := s.Import(dir)
+	if !errors.Is(err, ErrCurrencyMismatch) || !errors.Is(err, ErrPhoneRegistered) {
+		t.Errorf("expected currency and phone problems, got %v", err)
+	}
+}
+
+func TestService_Import_FavoriteNamesAndCurrency(t *testing.T) {
+	s := &Service{}
+	account, _ := s.RegisterAccount("+992000000001")
+	s.Deposit(account.ID, 1000)
+	payment, _ := s.Pay(account.ID, 100, "food")
+	s.FavoritePayment(payment.ID, "Обед")
+
+	dir := t.TempDir()
+	writeDumpFile(t, dir, "accounts.dump", "#wallet-dump;accounts;3;1\n"+
+		"2;+992000000002;1000;TJS\n")
+	writeDumpFile(t, dir, "favorites.dump", "#wallet-dump;favorites;3;5\n"+
+		"f1;1;обед;100;food;TJS\n"+
+		"f2;2;Обед;100;food;TJS\n"+
+		"f3;2;Дом;100;food;TJS\n"+
+		"f4;2;ДОМ;50;food;TJS\n"+
+		"f5;2;Такси;30;car;USD\n")
+
+	err := s.Import(dir)
+	var importErr *ImportError
+	if !errors.As(err, &importErr) {
+		t.Fatalf("expected *ImportError, got %v", err)
+	}
+	want := []struct {
+		line int
+		err  error
+	}{
+		{2, ErrFavoriteNameTaken},
+		{5, ErrFavoriteNameTaken},
+		{6, ErrCurrencyMismatch},
+	}
+	if len(importErr.Problems) != len(want) {
+		t.Fatalf("expected %d problems, got %v", len(want), importErr.Problems)
+	}
+	for i, w := range want {
+		got := importErr.Problems[i]
+		if got.File != "favorites.dump" || got.Line != w.line || !errors.Is(got, w.err) {
+			t.Errorf("expected problem favorites.dump:%d %v, got %v", w.line, w.err, got)
+		}
+	}
+
+	// Переименование записи с тем же ID - не конфликт
+	favorites, _ := s.AccountFavorites(account.ID)
+	writeDumpFile(t, dir, "accounts.dump", "#wallet-dump;accounts;3;0\n")
+	writeDumpFile(t, dir, "favorites.dump", "#wallet-dump;favorites;3;1\n"+
+		favorites[0].ID+";1;ОБЕД;100;food;TJS\n")
+	if err := s.Import(dir); err != nil {
+		t.Errorf("Import failed: %v", err)
+	}
+}
+
+func TestService_ImportFromFile_Problems(t *testing.T) {
+	s := &Service{}
+	account, _ := s.RegisterAccount("+992000000001")
+
+	dir := t.TempDir()
+	writeDumpFile(t, dir, "accounts.txt", "x;+992000000005;10|2;+992000000002;-5|3;+992000000001;100|")
+	err := s.ImportFromFile(filepath.Join(dir, "accounts.txt"))
+
+	var importErr *ImportError
+	if !errors.As(err, &importErr) {
+		t.Fatalf("expected *ImportError, got %v", err)
+	}
+	want := []struct {
+		line int
+		err  error
+	}{
+		{1, nil},
+		{2, ErrNegativeBalance},
+		{3, ErrPhoneRegistered},
+	}
+	if len(importErr.Problems) != len(want) {
+		t.Fatalf("expected %d problems, got %v", len(want), importErr.Problems)
+	}
+	for i, w := range want {
+		got := importErr.Problems[i]
+		if got.File != "accounts.txt" || got.Line != w.line || (w.err != nil && !errors.Is(got, w.err)) {
+			t.Errorf("expected problem accounts.txt:%d %v, got %v", w.line, w.err, got)
+		}
+	}
+	if accounts := serviceAccounts(s); len(accounts) != 1 || accounts[0].ID != account.ID {
+		t.Errorf("expected nothing imported, got %v", accounts)
+	}
+
+	// Файл дописывается ExportToFile: последняя запись с тем же ID заменяет прежние
+	writeDumpFile(t, dir, "accounts.txt", "2;+992000000002;10|2;+992000000002;20|")
+	if err := s.ImportFromFile(filepath.Join(dir, "accounts.txt")); err != nil {
+		t.Fatalf("ImportFromFile failed: %v", err)
+	}
+	if balance := accountBalance(s, 2); balance != 20 {
+		t.Errorf("expected balance 20, got %v", balance)
+	}
+}
+
+// mergeFixture возвращает Service с аккаунтами 1 и 2 и каталог дампа,
+// который меняет аккаунт 1 и платёж p1 и добавляет аккаунт 3 и платёж p3.
+func mergeFixture(t *testing.T) (*Service, string) {
+	t.Helper()
+	base := t.TempDir()
+	writeDumpFile(t, base, "accounts.dump", "#wallet-dump;accounts;3;2\n"+
+		"1;+992000000001;1000;TJS\n"+
+		"2;+992000000002;200;TJS\n")
+	writeDumpFile(t, base, "payments.dump", "#wallet-dump;payments;3;2\n"+
+		"p1;1;100;food;OK;TJS;;;\n"+
+		"p2;2;50;car;OK;TJS;;;\n")
+	writeDumpFile(t, base, "favorites.dump", "#wallet-dump;favorites;3;1\n"+
+		"f1;1;Обед;100;food;TJS\n")
+
+	s := &Service{}
+	if err := s.Import(base); err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+
+	dir := t.TempDir()
+	writeDumpFile(t, dir, "accounts.dump", "#wallet-dump;accounts;3;2\n"+
+		"1;+992000000001;500;TJS\n"+
+		"3;+992000000003;300;TJS\n")
+	writeDumpFile(t, dir, "payments.dump", "#wallet-dump;payments;3;2\n"+
+		"p1;1;100;food;FAIL;TJS;;;\n"+
+		"p3;3;30;food;OK;TJS;;;\n")
+	writeDumpFile(t, dir, "favorites.dump", "#wallet-dump;favorites;3;1\n"+
+		"f1;1;Обед;100;food;TJS\n")
+	return s, dir
+}
+
+func TestService_Import_AmountsCurrencyAndHolds(t *testing.T) {
+	const at = "2026-01-01T00:00:00Z"
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		err     error
+	}{
+		{"payment amount", "payments.dump", "#wallet-dump;payments;6;1\n" +
+			"p1;1;0;food;OK;TJS;;;;" + at + ";" + at + ";" + at + "\n", ErrAmountMustBePositive},
+		{"favorite amount", "favorites.dump", "#wallet-dump;favorites;6;1\n" +
+			"f1;1;Обед;-5;food;TJS;" + at + ";" + at + ";1\n", ErrAmountMustBePositive},
+		{"transfer amount", "transfers.dump", "#wallet-dump;transfers;6;1\n" +
+			"t1;1;1;0;OK;TJS;;;;;" + at + ";" + at + "\n", ErrAmountMustBePositive},
+		{"hold amount", "holds.dump", "#wallet-dump;holds;6;1\n" +
+			"h1;1;0;TJS;hotel;ACTIVE;0;;" + at + ";" + at + ";" + at + "\n", ErrAmountMustBePositive},
+		{"refund amount", "refunds.dump", "#wallet-dump;refunds;6;1\n" +
+			"r1;{payment};1;0;TJS;;;;" + at + "\n", ErrAmountMustBePositive},
+		{"payment currency", "payments.dump", "#wallet-dump;payments;6;1\n" +
+			"p1;1;100;food;OK;USD;;;;" + at + ";" + at + ";" + at + "\n", ErrCurrencyMismatch},
+		{"holds over balance", "holds.dump", "#wallet-dump;holds;6;1\n" +
+			"h1;1;500;TJS;hotel;ACTIVE;0;;" + at + ";" + at + ";" + at + "\n", ErrNotEnoughBalance},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Service{}
+			account, _ := s.RegisterAccount("+992000000001")
+			s.Deposit(account.ID, 1000)
+			payment, _ := s.Pay(account.ID, 100, "food")
+			s.Confirm(payment.ID)
+			// Блокировка хранилища вместе с блокировкой дампа превышает баланс 900
+			s.Authorize(account.ID, 600, "hotel", time.Hour)
+
+			dir := t.TempDir()
+			writeDumpFile(t, dir, tt.file, strings.ReplaceAll(tt.content, "{payment}", payment.ID))
+			err := s.Import(dir)
+			var importErr *ImportError
+			if !errors.As(err, &importErr) {
+				t.Fatalf("expected *ImportError, got %v", err)
+			}
+			if len(importErr.Problems) != 1 {
+				t.Fatalf("expected 1 problem, got %v", importErr.Problems)
+			}
+			if got := importErr.Problems[0]; got.File != tt.file || got.Line != 2 || !errors.Is(got, tt.err) {
+				t.Errorf("expected problem %s:2 %v, got %v", tt.file, tt.err, got)
+			}
+		})
+	}
+}
+
+func TestService_ImportWithOptions_Modes(t *testing.T) {
+	tests := []struct {
+		mode     ImportMode
+		actions  map[ImportAction]int
+		balance  types.Money // баланс аккаунта 1 после импорта
+		status   types.PaymentStatus
+		accounts int
+		payments int
+	}{
+		{ImportUpsert, map[ImportAction]int{ImportCreate: 2, ImportUpdate: 2, ImportUnchanged: 1}, 500, types.PaymentStatusFail, 3, 3},
+		{ImportSkipExisting, map[ImportAction]int{ImportCreate: 2, ImportSkip: 2, ImportUnchanged: 1}, 1000, types.PaymentStatusOk, 3, 3},
+		{ImportReplace, map[ImportAction]int{ImportCreate: 2, ImportUpdate: 2, ImportUnchanged: 1, ImportDelete: 2}, 500, types.PaymentStatusFail, 2, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mode.String(), func(t *testing.T) {
+			s, dir := mergeFixture(t)
+
+			planned, err := s.ImportWithOptions(dir, ImportOptions{Mode: tt.mode, DryRun: true})
+			if err != nil {
+				t.Fatalf("dry run failed: %v", err)
+			}
+			if account, _ := s.FindAccountByID(1); account.Balance != 1000 || len(serviceAccounts(s)) != 2 {
+				t.Fatalf("dry run changed the service: %v", serviceAccounts(s))
+			}
+
+			report, err := s.ImportWithOptions(dir, ImportOptions{Mode: tt.mode})
+			if err != nil {
+				t.Fatalf("ImportWithOptions failed: %v", err)
+			}
+			if !reflect.DeepEqual(planned, report) {
+				t.Errorf("dry run reported %v, import did %v", planned.Changes, report.Changes)
+			}
+			for _, action := range []ImportAction{ImportCreate, ImportUpdate, ImportUnchanged, ImportSkip, ImportDelete} {
+				if got := report.Count(action); got != tt.actions[action] {
+					t.Errorf("expected %d %s, got %d in %v", tt.actions[action], action, got, report.Changes)
+				}
+			}
+
+			account, _ := s.FindAccountByID(1)
+			payment, _ := s.FindPaymentByID("p1")
+			if account.Balance != tt.balance || payment.Status != tt.status {
+				t.Errorf("expected balance %v and status %v, got %v and %v", tt.balance, tt.status, account.Balance, payment.Status)
+			}
+			if len(serviceAccounts(s)) != tt.accounts || len(servicePayments(s)) != tt.payments {
+				t.Errorf("expected %d accounts and %d payments, got %v, %v", tt.accounts, tt.payments, serviceAccounts(s), servicePayments(s))
+			}
+			if report := s.CheckLedger(); !report.OK() {
+				t.Errorf("ledger mismatch after import: %+v", report)
+			}
+		})
+	}
+}
+
+func TestService_ImportWithOptions_FailOnConflict(t *testing.T) {
+	s, dir := mergeFixture(t)
+
+	_, err := s.ImportWithOptions(dir, ImportOptions{Mode: ImportFailOnConflict})
+	var importErr *ImportError
+	if !errors.As(err, &importErr) || !errors.Is(err, ErrImportConflict) {
+		t.Fatalf("expected %v, got %v", ErrImportConflict, err)
+	}
+	// Аккаунт 1 и платёж p1 отличаются, избранное f1 совпадает
+	if len(importErr.Problems) != 2 {
+		t.Errorf("expected 2 conflicts, got %v", importErr.Problems)
+	}
+	if len(serviceAccounts(s)) != 2 || len(servicePayments(s)) != 2 {
+		t.Errorf("expected nothing imported, got %v, %v", serviceAccounts(s), servicePayments(s))
+	}
+}
+
+func TestService_Import_Twice(t *testing.T) {
+	s, dir := mergeFixture(t)
+	s.Import(dir)
+
+	report, err := s.ImportWithOptions(dir, ImportOptions{Mode: ImportFailOnConflict})
+	if err != nil {
+		t.Fatalf("repeated import failed: %v", err)
+	}
+	if report.Count(ImportUnchanged) != len(report.Changes) {
+		t.Errorf("expected every record unchanged, got %v", report.Changes)
+	}
+	if len(servicePayments(s)) != 3 {
+		t.Errorf("expected 3 payments, got %v", servicePayments(s))
+	}
+}
+
+func TestService_ImportReplace_ExportEmpty(t *testing.T) {
+	clock := newTestClock()
+	s := &Service{}
+	s.SetClock(clock.Now)
+	account, _ := s.RegisterAccount("+992000000001")
+	other, _ := s.RegisterAccount("+992000000002")
+	s.Deposit(account.ID, 1000)
+	payment, _ := s.Pay(account.ID, 100, "food")
+	s.Confirm(payment.ID)
+	s.RefundAmount(payment.ID, 30, "")
+	s.Transfer(account.ID, other.ID, 50, "")
+	s.Authorize(account.ID, 20, "hotel", time.Hour)
+	favorite, _ := s.FavoritePayment(payment.ID, "обед")
+	s.ScheduleFavorite(favorite.ID, types.ScheduleDaily, clock.Now(), "")
+	if runs, err := s.RunSchedules(RetryPolicy{}); err != nil || len(runs) != 1 {
+		t.Fatalf("expected 1 run, got %+v (%v)", runs, err)
+	}
+
+	dir := t.TempDir()
+	if err := s.Export(dir); err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+
+	// Replace оставляет только аккаунты, и это переживает экспорт
+	replace := t.TempDir()
+	writeDumpFile(t, replace, "accounts.dump", "#wallet-dump;accounts;6;2\n"+
+		"1;+992000000001;500;TJS;;\n"+
+		"2;+992000000002;0;TJS;;\n")
+	if _, err := s.ImportWithOptions(replace, ImportOptions{Mode: ImportReplace}); err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+	if err := s.Export(dir); err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+
+	loaded := &Service{}
+	if err := loaded.Import(dir); err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+	loaded.repo().View(func(tx Tx) error {
+		counts := map[string]int{
+			"payments":  len(tx.Payments()),
+			"favorites": len(tx.Favorites()),
+			"transfers": len(tx.Transfers()),
+			"holds":     len(tx.Holds()),
+			"refunds":   len(tx.Refunds()),
+			"schedules": len(tx.Schedules()),
+			"runs":      len(tx.ScheduleRuns()),
+		}
+		for kind, count := range counts {
+			if count != 0 {
+				t.Errorf("expected no %s after replace, got %d", kind, count)
+			}
+		}
+		if accounts := tx.Accounts(); len(accounts) != 2 {
+			t.Errorf("expected 2 accounts, got %d", len(accounts))
+		}
+		return nil
+	})
+}
+
+func TestParseImportMode(t *testing.T) {
+	for _, mode := range []ImportMode{ImportUpsert, ImportSkipExisting, ImportFailOnConflict, ImportReplace} {
+		if got, err := ParseImportMode(mode.String()); err != nil || got != mode {
+			t.Errorf("expected %v, got %v, %v", mode, got, err)
+		}
+	}
+	if _, err := ParseImportMode("merge"); !errors.Is(err, ErrInvalidImportMode) {
+		t.Errorf("expected error %v, got %v", ErrInvalidImportMode, err)
+	}
+}
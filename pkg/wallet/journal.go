@@ -0,0 +1,198 @@
+package wallet
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+)
+
+var ErrJournalCorrupted = errors.New("journal corrupted")
+
+const (
+	journalFile  = "journal.log"
+	snapshotFile = "snapshot.json"
+
+	// Заголовок записи журнала: длина данных и их CRC32.
+	journalHeaderSize = 8
+)
+
+// JournalStore хранит состояние в памяти и перед применением каждой
+// транзакции дописывает изменённые записи в журнал и делает fsync.
+// Поэтому метод Service, вернувший nil, уже переживёт падение процесса.
+//
+// В каталоге хранятся snapshot.json (полное состояние) и journal.log
+// (транзакции после снимка). Запись журнала содержит итоговые значения
+// записей, поэтому повторное применение безопасно.
+type JournalStore struct {
+	*MemoryStore
+	dir           string
+	log           *os.File
+	size          int64 // размер журнала без недописанных записей
+	commits       int   // транзакций с последнего снимка
+	snapshotEvery int
+}
+
+// OpenJournalStore восстанавливает состояние из каталога dir: загружает снимок
+// и применяет журнал. Недописанная запись в конце журнала (падение во время
+// записи) отбрасывается. Если snapshotEvery > 0, снимок делается автоматически
+// каждые snapshotEvery транзакций, а журнал очищается.
+func OpenJournalStore(dir string, snapshotEvery int) (*JournalStore, error) {
+	store := &JournalStore{
+		MemoryStore:   NewMemoryStore(),
+		dir:           dir,
+		snapshotEvery: snapshotEvery,
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, snapshotFile))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		var snapshot changeSet
+		if err := json.Unmarshal(data, &snapshot); err != nil {
+			return nil, err
+		}
+		store.load(&snapshot)
+	}
+
+	store.log, err = os.OpenFile(filepath.Join(dir, journalFile), os.O_CREATE|os.O_RDWR, 0666)
+	if err != nil {
+		return nil, err
+	}
+	if err := store.replay(); err != nil {
+		store.log.Close()
+		return nil, err
+	}
+
+	store.commitHook = store.appendLocked
+	return store, nil
+}
+
+// replay применяет записи журнала и обрезает его после последней целой записи.
+func (s *JournalStore) replay() error {
+	data, err := os.ReadFile(s.log.Name())
+	if err != nil {
+		return err
+	}
+
+	offset := 0
+	for offset < len(data) {
+		if len(data)-offset < journalHeaderSize {
+			break
+		}
+		size := int(binary.LittleEndian.Uint32(data[offset:]))
+		sum := binary.LittleEndian.Uint32(data[offset+4:])
+		end := offset + journalHeaderSize + size
+		if end > len(data) {
+			break
+		}
+
+		payload := data[offset+journalHeaderSize : end]
+		if crc32.ChecksumIEEE(payload) != sum {
+			// Испорченной может быть только последняя запись
+			if end == len(data) {
+				break
+			}
+			return ErrJournalCorrupted
+		}
+
+		var changes changeSet
+		if err := json.Unmarshal(payload, &changes); err != nil {
+			return ErrJournalCorrupted
+		}
+		s.load(&changes)
+		s.commits++
+		offset = end
+	}
+
+	return s.truncate(int64(offset))
+}
+
+func (s *JournalStore) truncate(size int64) error {
+	if err := s.log.Truncate(size); err != nil {
+		return err
+	}
+	if _, err := s.log.Seek(size, 0); err != nil {
+		return err
+	}
+	s.size = size
+	return s.log.Sync()
+}
+
+// appendLocked записывает изменения транзакции в журнал.
+// Вызывается MemoryStore под блокировкой перед применением транзакции.
+func (s *JournalStore) appendLocked(tx *memTx) error {
+	payload, err := json.Marshal(tx.changes())
+	if err != nil {
+		return err
+	}
+
+	record := make([]byte, journalHeaderSize+len(payload))
+	binary.LittleEndian.PutUint32(record, uint32(len(payload)))
+	binary.LittleEndian.PutUint32(record[4:], crc32.ChecksumIEEE(payload))
+	copy(record[journalHeaderSize:], payload)
+
+	_, err = s.log.Write(record)
+	if err == nil {
+		err = s.log.Sync()
+	}
+	if err != nil {
+		// Убираем частично записанную запись, чтобы за ней можно было писать дальше
+		s.truncate(s.size)
+		return err
+	}
+
+	s.size += int64(len(record))
+	s.commits++
+	return nil
+}
+
+func (s *JournalStore) Update(fn func(tx Tx) error) error {
+	if err := s.MemoryStore.Update(fn); err != nil {
+		return err
+	}
+
+	if s.snapshotEvery > 0 {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.commits >= s.snapshotEvery {
+			// Транзакция уже в журнале, поэтому неудачный снимок не ошибка:
+			// он будет повторён после следующей транзакции.
+			s.snapshotLocked()
+		}
+	}
+	return nil
+}
+
+// Snapshot сохраняет полное состояние в snapshot.json и очищает журнал.
+func (s *JournalStore) Snapshot() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.snapshotLocked()
+}
+
+func (s *JournalStore) snapshotLocked() error {
+	// Пустая транзакция даёт текущее состояние хранилища
+	tx := newMemTx(s.MemoryStore, true)
+	if err := writeFileAtomic(filepath.Join(s.dir, snapshotFile), tx.snapshotLocked()); err != nil {
+		return err
+	}
+
+	if err := s.truncate(0); err != nil {
+		return err
+	}
+	s.commits = 0
+	return nil
+}
+
+// Close закрывает файл журнала.
+func (s *JournalStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.log.Close()
+}
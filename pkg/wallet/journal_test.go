@@ -0,0 +1,215 @@
+package wallet
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func openJournal(t *testing.T, dir string, snapshotEvery int) (*JournalStore, *Service) {
+	t.Helper()
+	store, err := OpenJournalStore(dir, snapshotEvery)
+	if err != nil {
+		t.Fatalf("OpenJournalStore failed: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store, NewService(store)
+}
+
+func assertSameState(t *testing.T, want, got *Service) {
+	t.Helper()
+	if !reflect.DeepEqual(serviceAccounts(want), serviceAccounts(got)) {
+		t.Errorf("Accounts mismatch")
+	}
+	if !reflect.DeepEqual(servicePayments(want), servicePayments(got)) {
+		t.Errorf("Payments mismatch")
+	}
+	if !reflect.DeepEqual(serviceFavorites(want), serviceFavorites(got)) {
+		t.Errorf("Favorites mismatch")
+	}
+}
+
+func TestJournalStore_Recover(t *testing.T) {
+	dir := t.TempDir()
+	_, service := openJournal(t, dir, 0)
+
+	acc, _ := service.RegisterAccount("+123456789")
+	service.Deposit(acc.ID, 1000)
+	pay, _ := service.Pay(acc.ID, 100, "Food")
+	service.FavoritePayment(pay.ID, "Lunch")
+	service.Reject(pay.ID)
+
+	// Файл не закрыт - как при падении процесса
+	_, recovered := openJournal(t, dir, 0)
+	assertSameState(t, service, recovered)
+
+	if accountBalance(recovered, acc.ID) != 1000 {
+		t.Errorf("expected balance %v, got %v", 1000, accountBalance(recovered, acc.ID))
+	}
+}
+
+func TestJournalStore_TornTail(t *testing.T) {
+	for _, cut := range []int{1, journalHeaderSize - 1, journalHeaderSize, journalHeaderSize + 3} {
+		dir := t.TempDir()
+		_, before := openJournal(t, dir, 0)
+		acc, _ := before.RegisterAccount("+123456789")
+		before.Deposit(acc.ID, 1000)
+
+		logPath := filepath.Join(dir, journalFile)
+		info, _ := os.Stat(logPath)
+		validSize := info.Size()
+
+		// Последняя запись записана не полностью
+		before.Pay(acc.ID, 100, "Food")
+		info, _ = os.Stat(logPath)
+		os.Truncate(logPath, validSize+int64(cut))
+		if info.Size() <= validSize+int64(cut) {
+			t.Fatalf("cut %d is larger than the record", cut)
+		}
+
+		_, recovered := openJournal(t, dir, 0)
+		got, err := recovered.FindAccountByID(acc.ID)
+		if err != nil {
+			t.Fatalf("cut %d: expected nil error, got %v", cut, err)
+		}
+		if got.Balance != 1000 {
+			t.Errorf("cut %d: expected balance %v, got %v", cut, 1000, got.Balance)
+		}
+		if payments := servicePayments(recovered); len(payments) != 0 {
+			t.Errorf("cut %d: expected no payments, got %d", cut, len(payments))
+		}
+
+		// Журнал обрезан до целых записей и пригоден для дальнейшей записи
+		info, _ = os.Stat(logPath)
+		if info.Size() != validSize {
+			t.Errorf("cut %d: expected journal size %d, got %d", cut, validSize, info.Size())
+		}
+		recovered.Deposit(acc.ID, 1)
+		_, again := openJournal(t, dir, 0)
+		assertSameState(t, recovered, again)
+	}
+}
+
+func TestJournalStore_CorruptedTailChecksum(t *testing.T) {
+	dir := t.TempDir()
+	_, service := openJournal(t, dir, 0)
+	acc, _ := service.RegisterAccount("+123456789")
+	service.Deposit(acc.ID, 1000)
+
+	// Последний байт последней записи испорчен
+	logPath := filepath.Join(dir, journalFile)
+	data, _ := os.ReadFile(logPath)
+	data[len(data)-1] ^= 0xff
+	os.WriteFile(logPath, data, 0666)
+
+	_, recovered := openJournal(t, dir, 0)
+	got, _ := recovered.FindAccountByID(acc.ID)
+	if got == nil || got.Balance != 0 {
+		t.Errorf("expected account with balance 0, got %v", got)
+	}
+}
+
+func TestJournalStore_CorruptedMiddle(t *testing.T) {
+	dir := t.TempDir()
+	_, service := openJournal(t, dir, 0)
+	acc, _ := service.RegisterAccount("+123456789")
+	service.Deposit(acc.ID, 1000)
+
+	logPath := filepath.Join(dir, journalFile)
+	data, _ := os.ReadFile(logPath)
+	data[journalHeaderSize] ^= 0xff
+	os.WriteFile(logPath, data, 0666)
+
+	if _, err := OpenJournalStore(dir, 0); err != ErrJournalCorrupted {
+		t.Errorf("expected error %v, got %v", ErrJournalCorrupted, err)
+	}
+}
+
+func TestJournalStore_Snapshot(t *testing.T) {
+	dir := t.TempDir()
+	store, service := openJournal(t, dir, 0)
+	acc, _ := service.RegisterAccount("+123456789")
+	service.Deposit(acc.ID, 1000)
+
+	if err := store.Snapshot(); err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	info, _ := os.Stat(filepath.Join(dir, journalFile))
+	if info.Size() != 0 {
+		t.Errorf("expected empty journal after snapshot, got %d bytes", info.Size())
+	}
+
+	service.Pay(acc.ID, 100, "Food")
+
+	_, recovered := openJournal(t, dir, 0)
+	assertSameState(t, service, recovered)
+}
+
+func TestJournalStore_SnapshotEvery(t *testing.T) {
+	dir := t.TempDir()
+	_, service := openJournal(t, dir, 3)
+	acc, _ := service.RegisterAccount("+123456789")
+	service.Deposit(acc.ID, 1000)
+	service.Pay(acc.ID, 100, "Food")
+
+	// Третья транзакция вызвала снимок
+	if _, err := os.Stat(filepath.Join(dir, snapshotFile)); err != nil {
+		t.Errorf("expected snapshot file, got %v", err)
+	}
+	info, _ := os.Stat(filepath.Join(dir, journalFile))
+	if info.Size() != 0 {
+		t.Errorf("expected empty journal after snapshot, got %d bytes", info.Size())
+	}
+
+	service.Pay(acc.ID, 50, "Food")
+	_, recovered := openJournal(t, dir, 3)
+	assertSameState(t, service, recovered)
+}
+
+func TestJournalStore_CrashBeforeTruncate(t *testing.T) {
+	dir := t.TempDir()
+	store, service := openJournal(t, dir, 0)
+	acc, _ := service.RegisterAccount("+123456789")
+	service.Deposit(acc.ID, 1000)
+	service.Pay(acc.ID, 100, "Food")
+
+	// Снимок записан, но журнал очистить не успели: журнал применяется повторно
+	store.mu.Lock()
+	err := writeFileAtomic(filepath.Join(dir, snapshotFile), newMemTx(store.MemoryStore, true).snapshotLocked())
+	store.mu.Unlock()
+	if err != nil {
+		t.Fatalf("writeFileAtomic failed: %v", err)
+	}
+
+	_, recovered := openJournal(t, dir, 0)
+	assertSameState(t, service, recovered)
+}
+
+func TestJournalStore_RecoverDeletions(t *testing.T) {
+	dir := t.TempDir()
+	_, service := openJournal(t, dir, 0)
+	acc, _ := service.RegisterAccount("+123456789")
+	service.Deposit(acc.ID, 1000)
+	service.Pay(acc.ID, 100, "Food")
+	other, _ := service.RegisterAccount("+987654321")
+
+	dump := t.TempDir()
+	writeDumpFile(t, dump, "accounts.dump", "#wallet-dump;accounts;3;1\n2;+123456789;500;TJS\n")
+	if _, err := service.ImportWithOptions(dump, ImportOptions{Mode: ImportReplace}); err != nil {
+		t.Fatalf("ImportWithOptions failed: %v", err)
+	}
+
+	_, recovered := openJournal(t, dir, 0)
+	assertSameState(t, service, recovered)
+	if _, err := recovered.FindAccountByID(acc.ID); err != ErrAccountNotFound {
+		t.Errorf("expected error %v, got %v", ErrAccountNotFound, err)
+	}
+	// Телефон удалённого аккаунта перешёл к аккаунту из дампа
+	if account, _ := recovered.FindAccountByID(other.ID); account.Phone != "+123456789" {
+		t.Errorf("expected phone %v, got %v", "+123456789", account.Phone)
+	}
+	if _, err := recovered.RegisterAccount("+987654321"); err != nil {
+		t.Errorf("old phone should be free, got %v", err)
+	}
+}
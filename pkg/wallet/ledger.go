@@ -0,0 +1,196 @@
+package wallet
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/akmalsulaymonov/alif-wallet/pkg/types"
+	"github.com/google/uuid"
+)
+
+// Системные счета двойной записи.
+const (
+	// Деньги, поступившие в кошелёк извне (Deposit).
+	LedgerCashIn types.LedgerAccount = "cash:in"
+	// Расчёты с получателями платежей (Pay, Reject).
+	LedgerMerchantClearing types.LedgerAccount = "merchant:clearing"
+	// Корректировки балансов при импорте из файлов.
+	LedgerImportAdjustment types.LedgerAccount = "import:adjustment"
+	// Конвертация валют в платежах и переводах.
+	LedgerExchange types.LedgerAccount = "fx:exchange"
+)
+
+// CustomerLedgerAccount возвращает счёт двойной записи аккаунта кошелька.
+func CustomerLedgerAccount(accountID int64) types.LedgerAccount {
+	return types.LedgerAccount(fmt.Sprintf("customer:%d", accountID))
+}
+
+// postEntries записывает операцию: дебет счёта debitAccount и кредит счёта
+// creditAccount на сумму amount. Счёт аккаунта кошелька - пассив: кредит
+// увеличивает баланс.
+func postEntries(tx Tx, debitAccount, creditAccount types.LedgerAccount, amount types.Amount, reference string) {
+	transactionID := uuid.New().String()
+
+	putEntry(tx, transactionID, debitAccount, types.EntryDebit, amount, reference)
+	putEntry(tx, transactionID, creditAccount, types.EntryCredit, amount, reference)
+}
+
+// postExchange записывает операцию с конвертацией: source уходит со счёта
+// debitAccount на счёт конвертации, target приходит с него на счёт
+// creditAccount. Каждая валюта операции остаётся сбалансированной.
+func postExchange(tx Tx, debitAccount, creditAccount types.LedgerAccount, source, target types.Amount, reference string) {
+	if source.Currency == target.Currency {
+		postEntries(tx, debitAccount, creditAccount, source, reference)
+		return
+	}
+
+	transactionID := uuid.New().String()
+
+	putEntry(tx, transactionID, debitAccount, types.EntryDebit, source, reference)
+	putEntry(tx, transactionID, LedgerExchange, types.EntryCredit, source, reference)
+	putEntry(tx, transactionID, LedgerExchange, types.EntryDebit, target, reference)
+	putEntry(tx, transactionID, creditAccount, types.EntryCredit, target, reference)
+}
+
+func putEntry(tx Tx, transactionID string, account types.LedgerAccount, side types.EntrySide, amount types.Amount, reference string) {
+	tx.PutEntry(&types.LedgerEntry{
+		ID:            uuid.New().String(),
+		TransactionID: transactionID,
+		Account:       account,
+		Side:          side,
+		Amount:        amount.Value,
+		Currency:      amount.Currency,
+		Reference:     reference,
+	})
+}
+
+// adjustBalance устанавливает баланс аккаунта, проводя разницу
+// через счёт корректировок импорта.
+func adjustBalance(tx Tx, account *types.Account, balance types.Money) {
+	customer := CustomerLedgerAccount(account.ID)
+	diff := balance - account.Balance
+
+	if diff > 0 {
+		postEntries(tx, LedgerImportAdjustment, customer, types.Amount{Value: diff, Currency: account.Currency}, "")
+	} else if diff < 0 {
+		postEntries(tx, customer, LedgerImportAdjustment, types.Amount{Value: -diff, Currency: account.Currency}, "")
+	}
+	account.Balance = balance
+}
+
+// ledgerBalance возвращает баланс аккаунта кошелька по проводкам
+// отдельно для каждой валюты.
+func ledgerBalance(entries []*types.LedgerEntry) map[types.Currency]types.Money {
+	balance := map[types.Currency]types.Money{}
+	for _, entry := range entries {
+		if entry.Side == types.EntryCredit {
+			balance[entry.Currency] += entry.Amount
+		} else {
+			balance[entry.Currency] -= entry.Amount
+		}
+	}
+	return balance
+}
+
+// LedgerEntries возвращает проводки по счёту аккаунта в порядке записи.
+func (s *Service) LedgerEntries(accountID int64) ([]types.LedgerEntry, error) {
+	var entries []types.LedgerEntry
+	err := s.repo().View(func(tx Tx) error {
+		if _, err := tx.Account(accountID); err != nil {
+			return err
+		}
+		for _, entry := range tx.LedgerEntries(CustomerLedgerAccount(accountID)) {
+			entries = append(entries, *entry)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// LedgerMismatch - аккаунт, баланс которого расходится с проводками.
+// Проводки в валюте, отличной от валюты аккаунта, тоже расхождение:
+// для них Balance равен нулю.
+type LedgerMismatch struct {
+	AccountID int64
+	Currency  types.Currency
+	Balance   types.Money // Account.Balance
+	Ledger    types.Money // баланс по проводкам
+}
+
+// LedgerReport - результат сверки баланса с проводками.
+type LedgerReport struct {
+	Mismatches []LedgerMismatch
+	// Операции, у которых сумма дебетов не равна сумме кредитов
+	// хотя бы в одной валюте.
+	Unbalanced []string
+}
+
+func (r LedgerReport) OK() bool {
+	return len(r.Mismatches) == 0 && len(r.Unbalanced) == 0
+}
+
+// CheckLedger сверяет балансы всех аккаунтов с проводками
+// и проверяет, что каждая операция сбалансирована.
+func (s *Service) CheckLedger() LedgerReport {
+	s.global.Lock()
+	defer s.global.Unlock()
+
+	report := LedgerReport{}
+	s.repo().View(func(tx Tx) error {
+		for _, account := range tx.Accounts() {
+			ledger := ledgerBalance(tx.LedgerEntries(CustomerLedgerAccount(account.ID)))
+			if ledger[account.Currency] != account.Balance {
+				report.Mismatches = append(report.Mismatches, LedgerMismatch{
+					AccountID: account.ID,
+					Currency:  account.Currency,
+					Balance:   account.Balance,
+					Ledger:    ledger[account.Currency],
+				})
+			}
+
+			var foreign []types.Currency
+			for currency, value := range ledger {
+				if currency != account.Currency && value != 0 {
+					foreign = append(foreign, currency)
+				}
+			}
+			sort.Slice(foreign, func(i, j int) bool { return foreign[i] < foreign[j] })
+			for _, currency := range foreign {
+				report.Mismatches = append(report.Mismatches, LedgerMismatch{
+					AccountID: account.ID,
+					Currency:  currency,
+					Ledger:    ledger[currency],
+				})
+			}
+		}
+
+		type key struct {
+			transactionID string
+			currency      types.Currency
+		}
+		totals := map[key]types.Money{}
+		for _, entry := range tx.Entries() {
+			k := key{entry.TransactionID, entry.Currency}
+			if entry.Side == types.EntryDebit {
+				totals[k] += entry.Amount
+			} else {
+				totals[k] -= entry.Amount
+			}
+		}
+		unbalanced := map[string]bool{}
+		for k, total := range totals {
+			if total != 0 && !unbalanced[k.transactionID] {
+				unbalanced[k.transactionID] = true
+				report.Unbalanced = append(report.Unbalanced, k.transactionID)
+			}
+		}
+		sort.Strings(report.Unbalanced)
+		return nil
+	})
+
+	return report
+}
@@ -0,0 +1,107 @@
+package wallet
+
+import (
+	"testing"
+
+	"github.com/akmalsulaymonov/alif-wallet/pkg/types"
+)
+
+func TestService_Ledger_Operations(t *testing.T) {
+	s := &Service{}
+	account, _ := s.RegisterAccount("+992000000001")
+
+	if err := s.Deposit(account.ID, 1000); err != nil {
+		t.Fatalf("failed to deposit money: %v", err)
+	}
+	payment, err := s.Pay(account.ID, 300, "food")
+	if err != nil {
+		t.Fatalf("failed to create payment: %v", err)
+	}
+	favorite, _ := s.FavoritePayment(payment.ID, "Lunch")
+	s.PayFromFavorite(favorite.ID)
+	s.Reject(payment.ID)
+
+	entries, err := s.LedgerEntries(account.ID)
+	if err != nil {
+		t.Fatalf("LedgerEntries failed: %v", err)
+	}
+	// Deposit, Pay, PayFromFavorite, Reject - по одной проводке на счёте аккаунта
+	if len(entries) != 4 {
+		t.Fatalf("expected 4 entries, got %d", len(entries))
+	}
+	if entries[0].Side != types.EntryCredit || entries[0].Amount != 1000 {
+		t.Errorf("unexpected deposit entry %v", entries[0])
+	}
+	if entries[1].Side != types.EntryDebit || entries[1].Reference != payment.ID {
+		t.Errorf("unexpected payment entry %v", entries[1])
+	}
+	if entries[3].Side != types.EntryCredit || entries[3].Reference != payment.ID {
+		t.Errorf("unexpected reject entry %v", entries[3])
+	}
+
+	if report := s.CheckLedger(); !report.OK() {
+		t.Errorf("expected consistent ledger, got %+v", report)
+	}
+	if ledgerBalance(serviceEntries(s, CustomerLedgerAccount(account.ID)))[account.Currency] != accountBalance(s, account.ID) {
+		t.Errorf("ledger balance differs from %v", accountBalance(s, account.ID))
+	}
+}
+
+func TestService_Ledger_Import(t *testing.T) {
+	dir := t.TempDir()
+
+	source := &Service{}
+	account, _ := source.RegisterAccount("+992000000001")
+	source.Deposit(account.ID, 1000)
+	source.Export(dir)
+
+	s := &Service{}
+	existing, _ := s.RegisterAccount("+992000000001")
+	s.Deposit(existing.ID, 5000)
+
+	// Баланс понижается с 5000 до 1000 корректирующей проводкой
+	if err := s.Import(dir); err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+	if accountBalance(s, existing.ID) != 1000 {
+		t.Errorf("expected balance %v, got %v", 1000, accountBalance(s, existing.ID))
+	}
+	if report := s.CheckLedger(); !report.OK() {
+		t.Errorf("expected consistent ledger, got %+v", report)
+	}
+}
+
+func TestService_CheckLedger_Mismatch(t *testing.T) {
+	s := &Service{}
+	account, _ := s.RegisterAccount("+992000000001")
+	s.Deposit(account.ID, 1000)
+
+	// Баланс изменён в обход проводок
+	s.repo().Update(func(tx Tx) error {
+		acc, _ := tx.Account(account.ID)
+		acc.Balance += 1
+		tx.PutAccount(acc)
+		tx.PutEntry(&types.LedgerEntry{ID: "lonely", TransactionID: "broken", Account: LedgerCashIn, Side: types.EntryDebit, Amount: 1})
+		return nil
+	})
+
+	report := s.CheckLedger()
+	if len(report.Mismatches) != 1 {
+		t.Fatalf("expected 1 mismatch, got %d", len(report.Mismatches))
+	}
+	want := LedgerMismatch{AccountID: account.ID, Currency: types.TJS, Balance: 1001, Ledger: 1000}
+	if report.Mismatches[0] != want {
+		t.Errorf("expected mismatch %+v, got %+v", want, report.Mismatches[0])
+	}
+	if len(report.Unbalanced) != 1 || report.Unbalanced[0] != "broken" {
+		t.Errorf("expected unbalanced transaction broken, got %v", report.Unbalanced)
+	}
+}
+
+func serviceEntries(s *Service, account types.LedgerAccount) (entries []*types.LedgerEntry) {
+	s.repo().View(func(tx Tx) error {
+		entries = tx.LedgerEntries(account)
+		return nil
+	})
+	return entries
+}
@@ -0,0 +1,933 @@
+package wallet
+
+import (
+	"sync"
+
+	"github.com/akmalsulaymonov/alif-wallet/pkg/types"
+)
+
+// MemoryStore хранит состояние в памяти.
+//
+// Таблицы сохраняют порядок добавления (он нужен для Export),
+// а хеш-индексы дают поиск за O(1).
+type MemoryStore struct {
+	mu           sync.RWMutex
+	maxAccountID int64
+
+	accounts  *table[int64, types.Account]
+	payments  *table[string, types.Payment]
+	favorites *table[string, types.Favorite]
+	entries   *table[string, types.LedgerEntry]
+	transfers *table[string, types.Transfer]
+	keys      *table[keyID, types.IdempotencyKey]
+	holds     *table[string, types.Hold]
+	refunds   *table[string, types.Refund]
+	schedules *table[string, types.Schedule]
+	runs      *table[string, types.ScheduleRun]
+
+	accountsByPhone    *index[types.Phone, int64, types.Account]
+	paymentsByAccount  *index[int64, string, types.Payment]
+	favoritesByAccount *index[int64, string, types.Favorite]
+	entriesByAccount   *index[types.LedgerAccount, string, types.LedgerEntry]
+	transfersByAccount *index[int64, string, types.Transfer]
+	holdsByAccount     *index[int64, string, types.Hold]
+	refundsByAccount   *index[int64, string, types.Refund]
+	refundsByPayment   *index[string, string, types.Refund]
+	runsBySchedule     *index[string, string, types.ScheduleRun]
+
+	// commitHook вызывается под блокировкой перед применением транзакции.
+	// Ошибка хука отменяет транзакцию.
+	commitHook func(tx *memTx) error
+}
+
+func NewMemoryStore() *MemoryStore {
+	s := &MemoryStore{
+		accounts:  newTable(func(account *types.Account) int64 { return account.ID }),
+		payments:  newTable(func(payment *types.Payment) string { return payment.ID }),
+		favorites: newTable(func(favorite *types.Favorite) string { return favorite.ID }),
+		entries:   newTable(func(entry *types.LedgerEntry) string { return entry.ID }),
+		transfers: newTable(func(transfer *types.Transfer) string { return transfer.ID }),
+		keys:      newTable(idOfKey),
+		holds:     newTable(func(hold *types.Hold) string { return hold.ID }),
+		refunds:   newTable(func(refund *types.Refund) string { return refund.ID }),
+		schedules: newTable(func(schedule *types.Schedule) string { return schedule.ID }),
+		runs:      newTable(func(run *types.ScheduleRun) string { return run.ID }),
+	}
+
+	s.accountsByPhone = addIndex(s.accounts, func(account *types.Account) []types.Phone {
+		return []types.Phone{account.Phone}
+	})
+	s.paymentsByAccount = addIndex(s.payments, func(payment *types.Payment) []int64 {
+		return []int64{payment.AccountID}
+	})
+	s.favoritesByAccount = addIndex(s.favorites, func(favorite *types.Favorite) []int64 {
+		return []int64{favorite.AccountID}
+	})
+	s.entriesByAccount = addIndex(s.entries, func(entry *types.LedgerEntry) []types.LedgerAccount {
+		return []types.LedgerAccount{entry.Account}
+	})
+	s.transfersByAccount = addIndex(s.transfers, func(transfer *types.Transfer) []int64 {
+		if transfer.FromAccountID == transfer.ToAccountID {
+			return []int64{transfer.FromAccountID}
+		}
+		return []int64{transfer.FromAccountID, transfer.ToAccountID}
+	})
+	s.holdsByAccount = addIndex(s.holds, func(hold *types.Hold) []int64 {
+		return []int64{hold.AccountID}
+	})
+	s.refundsByAccount = addIndex(s.refunds, func(refund *types.Refund) []int64 {
+		return []int64{refund.AccountID}
+	})
+	s.refundsByPayment = addIndex(s.refunds, func(refund *types.Refund) []string {
+		return []string{refund.PaymentID}
+	})
+	s.runsBySchedule = addIndex(s.runs, func(run *types.ScheduleRun) []string {
+		return []string{run.ScheduleID}
+	})
+	return s
+}
+
+// keyID - ключ идемпотентности уникален в пределах аккаунта.
+type keyID struct {
+	accountID int64
+	key       string
+}
+
+func idOfKey(record *types.IdempotencyKey) keyID {
+	return keyID{record.AccountID, record.Key}
+}
+
+func (s *MemoryStore) View(fn func(tx Tx) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return fn(newMemTx(s, false))
+}
+
+func (s *MemoryStore) Update(fn func(tx Tx) error) error {
+	tx := newMemTx(s, true)
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.commitHook != nil {
+		if err := s.commitHook(tx); err != nil {
+			return err
+		}
+	}
+	tx.apply()
+
+	return nil
+}
+
+// load добавляет записи в хранилище в обход commitHook.
+func (s *MemoryStore) load(data *changeSet) {
+	tx := newMemTx(s, true)
+	tx.putChanges(data)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tx.apply()
+}
+
+// table - записи одного типа: слайс в порядке добавления, индекс по ID
+// и вторичные индексы, которые put и delete поддерживают в актуальном виде.
+type table[K comparable, V any] struct {
+	id      func(*V) K
+	records []*V
+	byID    map[K]*V
+	slots   map[K]int // место записи в records
+	indexes []tableIndex[K, V]
+}
+
+// tableIndex - вторичный индекс таблицы. update переносит запись id
+// с ключей old на ключи record; old равен nil для новой записи,
+// record - для удалённой.
+type tableIndex[K comparable, V any] interface {
+	update(id K, old, record *V)
+}
+
+func newTable[K comparable, V any](id func(*V) K) *table[K, V] {
+	return &table[K, V]{
+		id:    id,
+		byID:  make(map[K]*V),
+		slots: make(map[K]int),
+	}
+}
+
+func (t *table[K, V]) get(id K) *V {
+	return t.byID[id]
+}
+
+// lookup возвращает записи с ID ids.
+func (t *table[K, V]) lookup(ids []K) []*V {
+	records := make([]*V, 0, len(ids))
+	for _, id := range ids {
+		records = append(records, t.byID[id])
+	}
+	return records
+}
+
+// put добавляет запись в конец таблицы или заменяет сохранённую запись
+// с тем же ID, перенося её в индексах, если изменились её ключи.
+// Сохранённые записи не изменяются, поэтому их можно читать без блокировки
+// хранилища после того, как View вернул их.
+func (t *table[K, V]) put(record *V) {
+	id := t.id(record)
+	stored := t.byID[id]
+	if stored == nil {
+		t.slots[id] = len(t.records)
+		t.records = append(t.records, record)
+	} else {
+		t.records[t.slots[id]] = record
+	}
+	t.byID[id] = record
+	for _, index := range t.indexes {
+		index.update(id, stored, record)
+	}
+}
+
+// delete удаляет записи deleted, отсутствующие записи пропускаются.
+func (t *table[K, V]) delete(deleted *deleteSet[K]) {
+	first := len(t.records)
+	for _, id := range deleted.ids {
+		stored := t.byID[id]
+		if stored == nil {
+			continue
+		}
+		for _, index := range t.indexes {
+			index.update(id, stored, nil)
+		}
+		if slot := t.slots[id]; slot < first {
+			first = slot
+		}
+		delete(t.byID, id)
+		delete(t.slots, id)
+	}
+	if first == len(t.records) {
+		return
+	}
+
+	kept := without(t.records[first:], func(record *V) bool { return deleted.has(t.id(record)) })
+	t.records = t.records[:first+len(kept)]
+	for i := first; i < len(t.records); i++ {
+		t.slots[t.id(t.records[i])] = i
+	}
+}
+
+// index - вторичный индекс: ID записей по ключу в порядке таблицы.
+// У записи может быть несколько ключей (у перевода - оба аккаунта).
+type index[I comparable, K comparable, V any] struct {
+	table *table[K, V]
+	keys  func(*V) []I
+	ids   map[I][]K
+}
+
+// addIndex добавляет к таблице t индекс по ключам keys.
+func addIndex[I comparable, K comparable, V any](t *table[K, V], keys func(*V) []I) *index[I, K, V] {
+	x := &index[I, K, V]{table: t, keys: keys, ids: make(map[I][]K)}
+	t.indexes = append(t.indexes, x)
+	return x
+}
+
+// lookup возвращает записи с ключом key.
+func (x *index[I, K, V]) lookup(key I) []*V {
+	return x.table.lookup(x.ids[key])
+}
+
+func (x *index[I, K, V]) update(id K, old, record *V) {
+	var from, to []I
+	if old != nil {
+		from = x.keys(old)
+	}
+	if record != nil {
+		to = x.keys(record)
+	}
+	for _, key := range from {
+		if !contains(to, key) {
+			x.remove(key, id)
+		}
+	}
+	for _, key := range to {
+		if !contains(from, key) {
+			x.insert(key, id)
+		}
+	}
+}
+
+// insert добавляет id к ключу key, сохраняя порядок таблицы. Новые записи
+// стоят в конце таблицы, поэтому обычно id просто дописывается.
+func (x *index[I, K, V]) insert(key I, id K) {
+	ids, slots := x.ids[key], x.table.slots
+	slot := slots[id]
+	i := len(ids)
+	for i > 0 && slots[ids[i-1]] > slot {
+		i--
+	}
+	ids = append(ids, id)
+	copy(ids[i+1:], ids[i:])
+	ids[i] = id
+	x.ids[key] = ids
+}
+
+func (x *index[I, K, V]) remove(key I, id K) {
+	ids := x.ids[key]
+	for i := range ids {
+		if ids[i] == id {
+			ids = append(ids[:i], ids[i+1:]...)
+			break
+		}
+	}
+	if len(ids) == 0 {
+		delete(x.ids, key)
+		return
+	}
+	x.ids[key] = ids
+}
+
+func contains[T comparable](values []T, value T) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// staged - записи одного типа, изменённые транзакцией, в порядке
+// первого изменения, и ID удалённых записей.
+type staged[K comparable, V any] struct {
+	records []*V
+	byID    map[K]*V
+	deleted deleteSet[K]
+}
+
+func (s *staged[K, V]) put(id K, record *V) {
+	s.deleted.remove(id)
+	if _, ok := s.byID[id]; ok {
+		for i := range s.records {
+			if s.byID[id] == s.records[i] {
+				s.records[i] = record
+				break
+			}
+		}
+	} else {
+		if s.byID == nil {
+			s.byID = make(map[K]*V)
+		}
+		s.records = append(s.records, record)
+	}
+	s.byID[id] = record
+}
+
+func (s *staged[K, V]) delete(id K) {
+	if staged, ok := s.byID[id]; ok {
+		delete(s.byID, id)
+		for i := range s.records {
+			if s.records[i] == staged {
+				s.records = append(s.records[:i], s.records[i+1:]...)
+				break
+			}
+		}
+	}
+	s.deleted.add(id)
+}
+
+// deleteSet - ID записей, удалённых транзакцией, в порядке удаления.
+type deleteSet[K comparable] struct {
+	ids     []K
+	deleted map[K]bool
+}
+
+func (d *deleteSet[K]) add(id K) {
+	if d.deleted == nil {
+		d.deleted = make(map[K]bool)
+	}
+	if !d.deleted[id] {
+		d.deleted[id] = true
+		d.ids = append(d.ids, id)
+	}
+}
+
+// remove отменяет удаление: запись снова сохранена в транзакции.
+func (d *deleteSet[K]) remove(id K) {
+	if !d.deleted[id] {
+		return
+	}
+	delete(d.deleted, id)
+	for i := range d.ids {
+		if d.ids[i] == id {
+			d.ids = append(d.ids[:i], d.ids[i+1:]...)
+			break
+		}
+	}
+}
+
+func (d *deleteSet[K]) has(id K) bool {
+	return d.deleted[id]
+}
+
+// memTx - транзакция MemoryStore. Транзакция на запись накапливает
+// изменённые записи и применяет их в apply.
+type memTx struct {
+	store    *MemoryStore
+	writable bool
+
+	accounts  staged[int64, types.Account]
+	payments  staged[string, types.Payment]
+	favorites staged[string, types.Favorite]
+	entries   staged[string, types.LedgerEntry]
+	transfers staged[string, types.Transfer]
+	keys      staged[keyID, types.IdempotencyKey]
+	holds     staged[string, types.Hold]
+	refunds   staged[string, types.Refund]
+	schedules staged[string, types.Schedule]
+	runs      staged[string, types.ScheduleRun]
+}
+
+func newMemTx(store *MemoryStore, writable bool) *memTx {
+	return &memTx{store: store, writable: writable}
+}
+
+// rlock берёт блокировку хранилища на чтение. Транзакция только для чтения
+// уже держит её всё время выполнения.
+func (tx *memTx) rlock() func() {
+	if !tx.writable {
+		return func() {}
+	}
+	tx.store.mu.RLock()
+	return tx.store.mu.RUnlock
+}
+
+func (tx *memTx) mustBeWritable() {
+	if !tx.writable {
+		panic("wallet: write in read-only transaction")
+	}
+}
+
+// own возвращает сохранённую запись для транзакции: транзакция
+// на запись получает копию, которую можно менять.
+func own[V any](tx *memTx, record *V) *V {
+	if !tx.writable {
+		return record
+	}
+	clone := *record
+	return &clone
+}
+
+// find возвращает запись id с учётом изменений транзакции.
+func find[K comparable, V any](tx *memTx, t *table[K, V], s *staged[K, V], id K, notFound error) (*V, error) {
+	if record, ok := s.byID[id]; ok {
+		return record, nil
+	}
+	if s.deleted.has(id) {
+		return nil, notFound
+	}
+
+	defer tx.rlock()()
+	record := t.get(id)
+	if record == nil {
+		return nil, notFound
+	}
+	return own(tx, record), nil
+}
+
+// merge накладывает изменения транзакции на сохранённые записи stored
+// и добавляет изменённые транзакцией записи, для которых match вернула
+// true. Вызывается под блокировкой хранилища на чтение.
+func merge[K comparable, V any](tx *memTx, t *table[K, V], s *staged[K, V], stored []*V, match func(*V) bool) []*V {
+	result := make([]*V, 0, len(stored))
+	for _, record := range stored {
+		id := t.id(record)
+		if staged, ok := s.byID[id]; ok {
+			// Транзакция могла перенести запись на другой ключ
+			if match(staged) {
+				result = append(result, staged)
+			}
+		} else if !s.deleted.has(id) {
+			result = append(result, own(tx, record))
+		}
+	}
+	for _, record := range s.records {
+		if stored := t.get(t.id(record)); match(record) && (stored == nil || !match(stored)) {
+			result = append(result, record)
+		}
+	}
+	return result
+}
+
+// all возвращает все записи таблицы с изменениями транзакции.
+// Вызывается под блокировкой хранилища на чтение.
+func all[K comparable, V any](tx *memTx, t *table[K, V], s *staged[K, V]) []*V {
+	return merge(tx, t, s, t.records, func(*V) bool { return true })
+}
+
+func (tx *memTx) NextAccountID() int64 {
+	defer tx.rlock()()
+
+	max := tx.store.maxAccountID
+	for _, account := range tx.accounts.records {
+		if account.ID > max {
+			max = account.ID
+		}
+	}
+	return max + 1
+}
+
+func (tx *memTx) Account(accountID int64) (*types.Account, error) {
+	return find(tx, tx.store.accounts, &tx.accounts, accountID, ErrAccountNotFound)
+}
+
+func (tx *memTx) AccountByPhone(phone types.Phone) (*types.Account, error) {
+	for _, account := range tx.accounts.records {
+		if account.Phone == phone {
+			return account, nil
+		}
+	}
+
+	defer tx.rlock()()
+	for _, account := range tx.store.accountsByPhone.lookup(phone) {
+		// Телефон мог смениться в этой же транзакции
+		if _, ok := tx.accounts.byID[account.ID]; ok || tx.accounts.deleted.has(account.ID) {
+			continue
+		}
+		return own(tx, account), nil
+	}
+	return nil, ErrAccountNotFound
+}
+
+func (tx *memTx) Accounts() []*types.Account {
+	defer tx.rlock()()
+	return all(tx, tx.store.accounts, &tx.accounts)
+}
+
+func (tx *memTx) PutAccount(account *types.Account) {
+	tx.mustBeWritable()
+	tx.accounts.put(account.ID, account)
+}
+
+func (tx *memTx) DeleteAccount(accountID int64) {
+	tx.mustBeWritable()
+	tx.accounts.delete(accountID)
+}
+
+func (tx *memTx) Payment(paymentID string) (*types.Payment, error) {
+	return find(tx, tx.store.payments, &tx.payments, paymentID, ErrPaymentNotFound)
+}
+
+func (tx *memTx) Payments() []*types.Payment {
+	defer tx.rlock()()
+	return all(tx, tx.store.payments, &tx.payments)
+}
+
+func (tx *memTx) AccountPayments(accountID int64) []*types.Payment {
+	defer tx.rlock()()
+	return merge(tx, tx.store.payments, &tx.payments, tx.store.paymentsByAccount.lookup(accountID), func(payment *types.Payment) bool {
+		return payment.AccountID == accountID
+	})
+}
+
+func (tx *memTx) PutPayment(payment *types.Payment) {
+	tx.mustBeWritable()
+	tx.payments.put(payment.ID, payment)
+}
+
+func (tx *memTx) DeletePayment(paymentID string) {
+	tx.mustBeWritable()
+	tx.payments.delete(paymentID)
+}
+
+func (tx *memTx) Favorite(favoriteID string) (*types.Favorite, error) {
+	return find(tx, tx.store.favorites, &tx.favorites, favoriteID, ErrFavoriteNotFound)
+}
+
+func (tx *memTx) Favorites() []*types.Favorite {
+	defer tx.rlock()()
+	return all(tx, tx.store.favorites, &tx.favorites)
+}
+
+func (tx *memTx) AccountFavorites(accountID int64) []*types.Favorite {
+	defer tx.rlock()()
+	return merge(tx, tx.store.favorites, &tx.favorites, tx.store.favoritesByAccount.lookup(accountID), func(favorite *types.Favorite) bool {
+		return favorite.AccountID == accountID
+	})
+}
+
+func (tx *memTx) PutFavorite(favorite *types.Favorite) {
+	tx.mustBeWritable()
+	tx.favorites.put(favorite.ID, favorite)
+}
+
+func (tx *memTx) DeleteFavorite(favoriteID string) {
+	tx.mustBeWritable()
+	tx.favorites.delete(favoriteID)
+}
+
+func (tx *memTx) Transfer(transferID string) (*types.Transfer, error) {
+	return find(tx, tx.store.transfers, &tx.transfers, transferID, ErrTransferNotFound)
+}
+
+func (tx *memTx) Transfers() []*types.Transfer {
+	defer tx.rlock()()
+	return all(tx, tx.store.transfers, &tx.transfers)
+}
+
+func (tx *memTx) AccountTransfers(accountID int64) []*types.Transfer {
+	defer tx.rlock()()
+	return merge(tx, tx.store.transfers, &tx.transfers, tx.store.transfersByAccount.lookup(accountID), func(transfer *types.Transfer) bool {
+		return transfer.FromAccountID == accountID || transfer.ToAccountID == accountID
+	})
+}
+
+func (tx *memTx) PutTransfer(transfer *types.Transfer) {
+	tx.mustBeWritable()
+	tx.transfers.put(transfer.ID, transfer)
+}
+
+func (tx *memTx) DeleteTransfer(transferID string) {
+	tx.mustBeWritable()
+	tx.transfers.delete(transferID)
+}
+
+func (tx *memTx) Hold(holdID string) (*types.Hold, error) {
+	return find(tx, tx.store.holds, &tx.holds, holdID, ErrHoldNotFound)
+}
+
+func (tx *memTx) Holds() []*types.Hold {
+	defer tx.rlock()()
+	return all(tx, tx.store.holds, &tx.holds)
+}
+
+func (tx *memTx) AccountHolds(accountID int64) []*types.Hold {
+	defer tx.rlock()()
+	return merge(tx, tx.store.holds, &tx.holds, tx.store.holdsByAccount.lookup(accountID), func(hold *types.Hold) bool {
+		return hold.AccountID == accountID
+	})
+}
+
+func (tx *memTx) PutHold(hold *types.Hold) {
+	tx.mustBeWritable()
+	tx.holds.put(hold.ID, hold)
+}
+
+func (tx *memTx) DeleteHold(holdID string) {
+	tx.mustBeWritable()
+	tx.holds.delete(holdID)
+}
+
+func (tx *memTx) Refund(refundID string) (*types.Refund, error) {
+	return find(tx, tx.store.refunds, &tx.refunds, refundID, ErrRefundNotFound)
+}
+
+func (tx *memTx) Refunds() []*types.Refund {
+	defer tx.rlock()()
+	return all(tx, tx.store.refunds, &tx.refunds)
+}
+
+func (tx *memTx) AccountRefunds(accountID int64) []*types.Refund {
+	defer tx.rlock()()
+	return merge(tx, tx.store.refunds, &tx.refunds, tx.store.refundsByAccount.lookup(accountID), func(refund *types.Refund) bool {
+		return refund.AccountID == accountID
+	})
+}
+
+func (tx *memTx) PaymentRefunds(paymentID string) []*types.Refund {
+	defer tx.rlock()()
+	return merge(tx, tx.store.refunds, &tx.refunds, tx.store.refundsByPayment.lookup(paymentID), func(refund *types.Refund) bool {
+		return refund.PaymentID == paymentID
+	})
+}
+
+func (tx *memTx) PutRefund(refund *types.Refund) {
+	tx.mustBeWritable()
+	tx.refunds.put(refund.ID, refund)
+}
+
+func (tx *memTx) DeleteRefund(refundID string) {
+	tx.mustBeWritable()
+	tx.refunds.delete(refundID)
+}
+
+func (tx *memTx) Schedule(scheduleID string) (*types.Schedule, error) {
+	return find(tx, tx.store.schedules, &tx.schedules, scheduleID, ErrScheduleNotFound)
+}
+
+func (tx *memTx) Schedules() []*types.Schedule {
+	defer tx.rlock()()
+	return all(tx, tx.store.schedules, &tx.schedules)
+}
+
+func (tx *memTx) PutSchedule(schedule *types.Schedule) {
+	tx.mustBeWritable()
+	tx.schedules.put(schedule.ID, schedule)
+}
+
+func (tx *memTx) DeleteSchedule(scheduleID string) {
+	tx.mustBeWritable()
+	tx.schedules.delete(scheduleID)
+}
+
+func (tx *memTx) ScheduleRun(runID string) (*types.ScheduleRun, error) {
+	return find(tx, tx.store.runs, &tx.runs, runID, ErrScheduleRunNotFound)
+}
+
+func (tx *memTx) ScheduleRuns() []*types.ScheduleRun {
+	defer tx.rlock()()
+	return all(tx, tx.store.runs, &tx.runs)
+}
+
+func (tx *memTx) RunsOfSchedule(scheduleID string) []*types.ScheduleRun {
+	defer tx.rlock()()
+	return merge(tx, tx.store.runs, &tx.runs, tx.store.runsBySchedule.lookup(scheduleID), func(run *types.ScheduleRun) bool {
+		return run.ScheduleID == scheduleID
+	})
+}
+
+func (tx *memTx) PutScheduleRun(run *types.ScheduleRun) {
+	tx.mustBeWritable()
+	tx.runs.put(run.ID, run)
+}
+
+func (tx *memTx) DeleteScheduleRun(runID string) {
+	tx.mustBeWritable()
+	tx.runs.delete(runID)
+}
+
+func (tx *memTx) Entries() []*types.LedgerEntry {
+	defer tx.rlock()()
+	return tx.mergeEntries(tx.store.entries.records, func(*types.LedgerEntry) bool { return true })
+}
+
+func (tx *memTx) LedgerEntries(account types.LedgerAccount) []*types.LedgerEntry {
+	defer tx.rlock()()
+	return tx.mergeEntries(tx.store.entriesByAccount.lookup(account), func(entry *types.LedgerEntry) bool {
+		return entry.Account == account
+	})
+}
+
+// mergeEntries не копирует проводки: они не изменяются после записи.
+func (tx *memTx) mergeEntries(stored []*types.LedgerEntry, match func(*types.LedgerEntry) bool) []*types.LedgerEntry {
+	result := make([]*types.LedgerEntry, len(stored), len(stored)+len(tx.entries.records))
+	copy(result, stored)
+	for _, entry := range tx.entries.records {
+		if tx.store.entries.get(entry.ID) == nil && match(entry) {
+			result = append(result, entry)
+		}
+	}
+	return result
+}
+
+func (tx *memTx) PutEntry(entry *types.LedgerEntry) {
+	tx.mustBeWritable()
+
+	if _, ok := tx.entries.byID[entry.ID]; ok {
+		return
+	}
+	tx.entries.put(entry.ID, entry)
+}
+
+func (tx *memTx) IdempotencyKey(accountID int64, key string) (*types.IdempotencyKey, error) {
+	return find(tx, tx.store.keys, &tx.keys, keyID{accountID, key}, errIdempotencyKeyNotFound)
+}
+
+func (tx *memTx) IdempotencyKeys() []*types.IdempotencyKey {
+	defer tx.rlock()()
+	return all(tx, tx.store.keys, &tx.keys)
+}
+
+func (tx *memTx) PutIdempotencyKey(record *types.IdempotencyKey) {
+	tx.mustBeWritable()
+	tx.keys.put(idOfKey(record), record)
+}
+
+func (tx *memTx) DeleteIdempotencyKey(accountID int64, key string) {
+	tx.mustBeWritable()
+	tx.keys.delete(keyID{accountID, key})
+}
+
+// values возвращает копии записей records.
+func values[V any](records []*V) []V {
+	if len(records) == 0 {
+		return nil
+	}
+	result := make([]V, 0, len(records))
+	for _, record := range records {
+		result = append(result, *record)
+	}
+	return result
+}
+
+// changes возвращает записи, изменённые транзакцией.
+func (tx *memTx) changes() *changeSet {
+	data := &changeSet{
+		Accounts:  values(tx.accounts.records),
+		Payments:  values(tx.payments.records),
+		Favorites: values(tx.favorites.records),
+		Entries:   values(tx.entries.records),
+		Transfers: values(tx.transfers.records),
+		Keys:      values(tx.keys.records),
+		Holds:     values(tx.holds.records),
+		Refunds:   values(tx.refunds.records),
+		Schedules: values(tx.schedules.records),
+		Runs:      values(tx.runs.records),
+
+		DeletedAccounts:  tx.accounts.deleted.ids,
+		DeletedPayments:  tx.payments.deleted.ids,
+		DeletedFavorites: tx.favorites.deleted.ids,
+		DeletedTransfers: tx.transfers.deleted.ids,
+		DeletedHolds:     tx.holds.deleted.ids,
+		DeletedRefunds:   tx.refunds.deleted.ids,
+		DeletedSchedules: tx.schedules.deleted.ids,
+		DeletedRuns:      tx.runs.deleted.ids,
+	}
+	for _, id := range tx.keys.deleted.ids {
+		data.DeletedKeys = append(data.DeletedKeys, types.IdempotencyKey{AccountID: id.accountID, Key: id.key})
+	}
+	return data
+}
+
+// snapshotLocked возвращает состояние хранилища после применения транзакции.
+// Вызывается под блокировкой хранилища.
+func (tx *memTx) snapshotLocked() *changeSet {
+	s := tx.store
+	return &changeSet{
+		Accounts:  values(all(tx, s.accounts, &tx.accounts)),
+		Payments:  values(all(tx, s.payments, &tx.payments)),
+		Favorites: values(all(tx, s.favorites, &tx.favorites)),
+		Entries:   values(tx.mergeEntries(s.entries.records, func(*types.LedgerEntry) bool { return true })),
+		Transfers: values(all(tx, s.transfers, &tx.transfers)),
+		Keys:      values(all(tx, s.keys, &tx.keys)),
+		Holds:     values(all(tx, s.holds, &tx.holds)),
+		Refunds:   values(all(tx, s.refunds, &tx.refunds)),
+		Schedules: values(all(tx, s.schedules, &tx.schedules)),
+		Runs:      values(all(tx, s.runs, &tx.runs)),
+	}
+}
+
+// putChanges помещает в транзакцию копии записей из data.
+func (tx *memTx) putChanges(data *changeSet) {
+	for _, id := range data.DeletedAccounts {
+		tx.DeleteAccount(id)
+	}
+	for _, id := range data.DeletedPayments {
+		tx.DeletePayment(id)
+	}
+	for _, id := range data.DeletedFavorites {
+		tx.DeleteFavorite(id)
+	}
+	for _, id := range data.DeletedTransfers {
+		tx.DeleteTransfer(id)
+	}
+	for _, id := range data.DeletedHolds {
+		tx.DeleteHold(id)
+	}
+	for _, id := range data.DeletedRefunds {
+		tx.DeleteRefund(id)
+	}
+	for _, id := range data.DeletedSchedules {
+		tx.DeleteSchedule(id)
+	}
+	for _, id := range data.DeletedRuns {
+		tx.DeleteScheduleRun(id)
+	}
+	for i := range data.Accounts {
+		account := data.Accounts[i]
+		tx.PutAccount(&account)
+	}
+	for i := range data.Payments {
+		payment := data.Payments[i]
+		tx.PutPayment(&payment)
+	}
+	for i := range data.Favorites {
+		favorite := data.Favorites[i]
+		tx.PutFavorite(&favorite)
+	}
+	for i := range data.Entries {
+		entry := data.Entries[i]
+		tx.PutEntry(&entry)
+	}
+	for i := range data.Transfers {
+		transfer := data.Transfers[i]
+		tx.PutTransfer(&transfer)
+	}
+	for i := range data.Holds {
+		hold := data.Holds[i]
+		tx.PutHold(&hold)
+	}
+	for i := range data.Refunds {
+		refund := data.Refunds[i]
+		tx.PutRefund(&refund)
+	}
+	for i := range data.Schedules {
+		schedule := data.Schedules[i]
+		tx.PutSchedule(&schedule)
+	}
+	for i := range data.Runs {
+		run := data.Runs[i]
+		tx.PutScheduleRun(&run)
+	}
+	for _, record := range data.DeletedKeys {
+		tx.DeleteIdempotencyKey(record.AccountID, record.Key)
+	}
+	for i := range data.Keys {
+		record := data.Keys[i]
+		tx.PutIdempotencyKey(&record)
+	}
+}
+
+// apply применяет изменения к хранилищу. Вызывается под блокировкой.
+// Удаления применяются до сохранения записей, чтобы освобождённый телефон
+// мог занять другой аккаунт. Хранилище сохраняет копии записей, переданных
+// в Put*: вызывающий может и дальше менять свои указатели.
+func (tx *memTx) apply() {
+	s := tx.store
+
+	for _, account := range tx.accounts.records {
+		if account.ID > s.maxAccountID {
+			s.maxAccountID = account.ID
+		}
+	}
+	applyStaged(s.accounts, &tx.accounts)
+	applyStaged(s.payments, &tx.payments)
+	applyStaged(s.favorites, &tx.favorites)
+	applyStaged(s.transfers, &tx.transfers)
+	applyStaged(s.keys, &tx.keys)
+	applyStaged(s.holds, &tx.holds)
+	applyStaged(s.refunds, &tx.refunds)
+	applyStaged(s.schedules, &tx.schedules)
+	applyStaged(s.runs, &tx.runs)
+
+	for _, entry := range tx.entries.records {
+		// Повторное применение журнала не дублирует проводки
+		if s.entries.get(entry.ID) == nil {
+			clone := *entry
+			s.entries.put(&clone)
+		}
+	}
+}
+
+func applyStaged[K comparable, V any](t *table[K, V], s *staged[K, V]) {
+	t.delete(&s.deleted)
+	for _, record := range s.records {
+		clone := *record
+		t.put(&clone)
+	}
+}
+
+// without убирает из records удалённые записи, не выделяя память.
+// Хвост обнуляется, чтобы удалённые записи не удерживались в памяти.
+func without[T any](records []*T, deleted func(*T) bool) []*T {
+	kept := records[:0]
+	for _, record := range records {
+		if !deleted(record) {
+			kept = append(kept, record)
+		}
+	}
+	for i := len(kept); i < len(records); i++ {
+		records[i] = nil
+	}
+	return kept
+}
@@ -0,0 +1,305 @@
+package wallet
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/akmalsulaymonov/alif-wallet/pkg/types"
+)
+
+func TestService_Indexes_Import(t *testing.T) {
+	dir := t.TempDir()
+
+	service := &Service{}
+	acc, _ := service.RegisterAccount("+123456789")
+	service.Deposit(acc.ID, 1000)
+	pay, _ := service.Pay(acc.ID, 100, "Food")
+	fav, _ := service.FavoritePayment(pay.ID, "Lunch")
+
+	if err := service.Export(dir); err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+
+	newService := &Service{}
+	if err := newService.Import(dir); err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+
+	if _, err := newService.FindAccountByID(acc.ID); err != nil {
+		t.Errorf("account not indexed after import: %v", err)
+	}
+	if _, err := newService.FindPaymentByID(pay.ID); err != nil {
+		t.Errorf("payment not indexed after import: %v", err)
+	}
+	if _, err := newService.PayFromFavorite(fav.ID); err != nil {
+		t.Errorf("favorite not indexed after import: %v", err)
+	}
+
+	// Телефон из импорта занят, новый аккаунт получает следующий ID
+	if _, err := newService.RegisterAccount("+123456789"); err != ErrPhoneRegistered {
+		t.Errorf("expected error %v, got %v", ErrPhoneRegistered, err)
+	}
+	next, err := newService.RegisterAccount("+987654321")
+	if err != nil {
+		t.Fatalf("failed to register account: %v", err)
+	}
+	if next.ID != acc.ID+1 {
+		t.Errorf("expected account ID %v, got %v", acc.ID+1, next.ID)
+	}
+
+	history, _ := newService.ExportAccountHistory(acc.ID)
+	if len(history) != 2 {
+		t.Errorf("expected 2 payments in history, got %d", len(history))
+	}
+}
+
+func TestService_Indexes_ImportFromFile(t *testing.T) {
+	path := t.TempDir() + "/export.txt"
+
+	service := &Service{}
+	service.RegisterAccount("+992000000001")
+	service.RegisterAccount("+992000000002")
+	if err := service.ExportToFile(path); err != nil {
+		t.Fatalf("ExportToFile failed: %v", err)
+	}
+
+	newService := &Service{}
+	if err := newService.ImportFromFile(path); err != nil {
+		t.Fatalf("ImportFromFile failed: %v", err)
+	}
+	if _, err := newService.FindAccountByID(2); err != nil {
+		t.Errorf("account not indexed after import: %v", err)
+	}
+	if _, err := newService.RegisterAccount("+992000000001"); err != ErrPhoneRegistered {
+		t.Errorf("expected error %v, got %v", ErrPhoneRegistered, err)
+	}
+}
+
+func TestService_Indexes_ImportMovesRecords(t *testing.T) {
+	base := t.TempDir()
+	writeDumpFile(t, base, "accounts.dump", "#wallet-dump;accounts;3;2\n"+
+		"1;+992000000001;1000;TJS\n"+
+		"2;+992000000002;1000;TJS\n")
+	writeDumpFile(t, base, "payments.dump", "#wallet-dump;payments;3;2\n"+
+		"p1;1;100;food;OK;TJS;;;\n"+
+		"p2;1;50;car;OK;TJS;;;\n")
+	s := &Service{}
+	if err := s.Import(base); err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+
+	// Upsert переносит p1 на аккаунт 2
+	dir := t.TempDir()
+	writeDumpFile(t, dir, "payments.dump", "#wallet-dump;payments;3;1\n"+
+		"p1;2;100;food;OK;TJS;;;\n")
+	if err := s.Import(dir); err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+
+	first, _ := s.ExportAccountHistory(1)
+	if len(first) != 1 || first[0].ID != "p2" {
+		t.Errorf("expected only p2 for account 1, got %+v", first)
+	}
+	second, _ := s.ExportAccountHistory(2)
+	if len(second) != 1 || second[0].ID != "p1" || second[0].AccountID != 2 {
+		t.Errorf("expected p1 for account 2, got %+v", second)
+	}
+}
+
+func TestService_Indexes_ImportMovesFavorites(t *testing.T) {
+	base := t.TempDir()
+	writeDumpFile(t, base, "accounts.dump", "#wallet-dump;accounts;3;2\n"+
+		"1;+992000000001;1000;TJS\n"+
+		"2;+992000000002;1000;TJS\n")
+	writeDumpFile(t, base, "favorites.dump", "#wallet-dump;favorites;3;3\n"+
+		"f1;1;Дом;100;food;TJS\n"+
+		"f2;1;Обед;50;food;TJS\n"+
+		"f3;2;Такси;30;car;TJS\n")
+	s := &Service{}
+	if err := s.Import(base); err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+
+	// Upsert переносит f1 на аккаунт 2
+	dir := t.TempDir()
+	writeDumpFile(t, dir, "favorites.dump", "#wallet-dump;favorites;3;1\n"+
+		"f1;2;Дом;100;food;TJS\n")
+	if err := s.Import(dir); err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+
+	first, _ := s.AccountFavorites(1)
+	if len(first) != 1 || first[0].ID != "f2" || first[0].Position != 1 {
+		t.Errorf("expected only f2 at 1 for account 1, got %+v", first)
+	}
+	second, _ := s.AccountFavorites(2)
+	if len(second) != 2 {
+		t.Fatalf("expected 2 favorites for account 2, got %+v", second)
+	}
+	for i, favorite := range second {
+		if favorite.AccountID != 2 || favorite.Position != i+1 {
+			t.Errorf("unexpected favorite %d for account 2: %+v", i, favorite)
+		}
+	}
+}
+
+func TestMemoryStore_Update_Rollback(t *testing.T) {
+	store := NewMemoryStore()
+	service := NewService(store)
+	acc, _ := service.RegisterAccount("+123456789")
+	service.Deposit(acc.ID, 100)
+
+	failure := errors.New("failure")
+	err := store.Update(func(tx Tx) error {
+		account, err := tx.Account(acc.ID)
+		if err != nil {
+			return err
+		}
+		account.Balance = 0
+		tx.PutAccount(account)
+		tx.PutPayment(&types.Payment{ID: "payment", AccountID: acc.ID, Amount: 100})
+		return failure
+	})
+	if err != failure {
+		t.Fatalf("expected error %v, got %v", failure, err)
+	}
+
+	if accountBalance(service, acc.ID) != 100 {
+		t.Errorf("expected balance %v, got %v", 100, accountBalance(service, acc.ID))
+	}
+	if _, err := service.FindPaymentByID("payment"); err != ErrPaymentNotFound {
+		t.Errorf("expected error %v, got %v", ErrPaymentNotFound, err)
+	}
+}
+
+func TestMemoryStore_Update_Isolation(t *testing.T) {
+	store := NewMemoryStore()
+	service := NewService(store)
+	acc, _ := service.RegisterAccount("+123456789")
+
+	store.Update(func(tx Tx) error {
+		account, _ := tx.Account(acc.ID)
+		account.Balance = 500
+
+		// До Put* изменения не видны ни в хранилище, ни в транзакции
+		if accountBalance(service, acc.ID) != 0 {
+			t.Errorf("uncommitted change visible in store")
+		}
+		again, _ := tx.Account(acc.ID)
+		if again.Balance != 0 {
+			t.Errorf("unsaved change visible in transaction")
+		}
+
+		tx.PutAccount(account)
+		again, _ = tx.Account(acc.ID)
+		if again.Balance != 500 {
+			t.Errorf("expected balance %v in transaction, got %v", 500, again.Balance)
+		}
+		return nil
+	})
+
+	if accountBalance(service, acc.ID) != 500 {
+		t.Errorf("expected balance %v, got %v", 500, accountBalance(service, acc.ID))
+	}
+}
+
+func TestMemoryStore_PhoneChange(t *testing.T) {
+	store := NewMemoryStore()
+	service := NewService(store)
+	acc, _ := service.RegisterAccount("+111")
+
+	store.Update(func(tx Tx) error {
+		account, _ := tx.Account(acc.ID)
+		account.Phone = "+222"
+		tx.PutAccount(account)
+		return nil
+	})
+
+	if _, err := service.RegisterAccount("+111"); err != nil {
+		t.Errorf("old phone should be free, got %v", err)
+	}
+	if _, err := service.RegisterAccount("+222"); err != ErrPhoneRegistered {
+		t.Errorf("expected error %v, got %v", ErrPhoneRegistered, err)
+	}
+}
+
+// Линейный поиск, как он был реализован до появления индексов.
+func linearFindPayment(s *MemoryStore, paymentID string) *types.Payment {
+	for _, payment := range s.payments.records {
+		if payment.ID == paymentID {
+			return payment
+		}
+	}
+	return nil
+}
+
+func linearFindAccount(s *MemoryStore, accountID int64) *types.Account {
+	for _, account := range s.accounts.records {
+		if account.ID == accountID {
+			return account
+		}
+	}
+	return nil
+}
+
+var benchSizes = []int{10_000, 100_000, 1_000_000}
+
+func newBenchStore(b *testing.B, records int) *MemoryStore {
+	b.Helper()
+	data := &changeSet{}
+	for i := 1; i <= records; i++ {
+		data.Accounts = append(data.Accounts, types.Account{ID: int64(i), Phone: types.Phone(fmt.Sprint(i))})
+		data.Payments = append(data.Payments, types.Payment{ID: fmt.Sprint("payment-", i), AccountID: int64(i), Amount: 1})
+	}
+	store := NewMemoryStore()
+	store.load(data)
+	return store
+}
+
+func BenchmarkFindPaymentByID(b *testing.B) {
+	for _, size := range benchSizes {
+		store := newBenchStore(b, size)
+		s := NewService(store)
+		// Худший случай для линейного поиска - последний платёж
+		id := fmt.Sprint("payment-", size)
+
+		b.Run(fmt.Sprintf("linear/%d", size), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if linearFindPayment(store, id) == nil {
+					b.Fatal("payment not found")
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("indexed/%d", size), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := s.FindPaymentByID(id); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkFindAccountByID(b *testing.B) {
+	for _, size := range benchSizes {
+		store := newBenchStore(b, size)
+		s := NewService(store)
+		id := int64(size)
+
+		b.Run(fmt.Sprintf("linear/%d", size), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if linearFindAccount(store, id) == nil {
+					b.Fatal("account not found")
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("indexed/%d", size), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := s.FindAccountByID(id); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package wallet
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strings"
+)
+
+// Кодирование записей дампов. Поля разделяются ';', записи - символом
+// sep ('\n' в .dump файлах, '|' в ExportToFile). Поле, содержащее
+// разделители, кавычки или перевод строки, берётся в двойные кавычки,
+// а кавычки внутри него удваиваются, как в CSV. Разбор побайтовый,
+// поэтому без потерь проходит любая строка, в том числе не UTF-8.
+
+var errInvalidQuoting = errors.New("invalid quoting in dump record")
+
+// encodeRecord кодирует поля записи без завершающего разделителя.
+func encodeRecord(fields []string) string {
+	var b strings.Builder
+	for i, field := range fields {
+		if i > 0 {
+			b.WriteByte(';')
+		}
+		if !strings.ContainsAny(field, ";|\"\r\n") {
+			b.WriteString(field)
+			continue
+		}
+		b.WriteByte('"')
+		b.WriteString(strings.ReplaceAll(field, `"`, `""`))
+		b.WriteByte('"')
+	}
+	return b.String()
+}
+
+// readRecord читает одну запись, завершённую sep или концом файла.
+// В конце файла возвращает io.EOF.
+func readRecord(r *bufio.Reader, sep byte) ([]string, error) {
+	var fields []string
+	var field []byte
+	quoted := false // внутри кавычек
+	closed := false // кавычки поля закрыты, дальше только разделитель
+	read := false
+
+	for {
+		c, err := r.ReadByte()
+		if err == io.EOF {
+			if !read {
+				return nil, io.EOF
+			}
+			if quoted {
+				return nil, errInvalidQuoting
+			}
+			return append(fields, string(field)), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		read = true
+
+		switch {
+		case quoted && c == '"':
+			next, err := r.ReadByte()
+			if err == nil && next == '"' {
+				field = append(field, '"')
+				continue
+			}
+			if err == nil {
+				r.UnreadByte()
+			}
+			quoted, closed = false, true
+		case quoted:
+			field = append(field, c)
+		case c == ';':
+			fields = append(fields, string(field))
+			field, closed = nil, false
+		case c == sep:
+			return append(fields, string(field)), nil
+		case closed:
+			return nil, errInvalidQuoting
+		case c == '"' && len(field) == 0:
+			quoted = true
+		default:
+			field = append(field, c)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package wallet
+
+import (
+	"bufio"
+	"io"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/akmalsulaymonov/alif-wallet/pkg/types"
+)
+
+// Строки, которые ломали формат без кодирования.
+var trickyStrings = []string{
+	"",
+	"Rent; flat 2",
+	"line\nbreak",
+	"crlf\r\n",
+	`"quoted"`,
+	`say "hi"; bye`,
+	"pipe|separated",
+	"\"",
+	"Аренда; кв. 2\nэтаж 3",
+	"\xff\xfe not utf-8",
+}
+
+func TestEncodeRecord_RoundTrip(t *testing.T) {
+	for _, sep := range []byte{'\n', '|'} {
+		var b strings.Builder
+		for _, s := range trickyStrings {
+			b.WriteString(encodeRecord([]string{s, "x", s}))
+			b.WriteByte(sep)
+		}
+
+		reader := bufio.NewReader(strings.NewReader(b.String()))
+		for _, s := range trickyStrings {
+			fields, err := readRecord(reader, sep)
+			if err != nil {
+				t.Fatalf("failed to read record %q: %v", s, err)
+			}
+			if want := []string{s, "x", s}; !reflect.DeepEqual(fields, want) {
+				t.Errorf("expected %q, got %q", want, fields)
+			}
+		}
+		if _, err := readRecord(reader, sep); err != io.EOF {
+			t.Errorf("expected EOF, got %v", err)
+		}
+	}
+}
+
+func TestReadRecord_InvalidQuoting(t *testing.T) {
+	for _, input := range []string{`"unterminated`, `"ab"c;d`} {
+		reader := bufio.NewReader(strings.NewReader(input))
+		if _, err := readRecord(reader, '\n'); err != errInvalidQuoting {
+			t.Errorf("expected error %v for %q, got %v", errInvalidQuoting, input, err)
+		}
+	}
+}
+
+func FuzzEncodeRecord(f *testing.F) {
+	for _, s := range trickyStrings {
+		f.Add(s, s)
+	}
+
+	f.Fuzz(func(t *testing.T, a, b string) {
+		for _, sep := range []byte{'\n', '|'} {
+			encoded := encodeRecord([]string{a, b}) + string(sep) + encodeRecord([]string{b}) + string(sep)
+			reader := bufio.NewReader(strings.NewReader(encoded))
+
+			first, err := readRecord(reader, sep)
+			if err != nil || !reflect.DeepEqual(first, []string{a, b}) {
+				t.Fatalf("expected %q, got %q, %v", []string{a, b}, first, err)
+			}
+			second, err := readRecord(reader, sep)
+			if err != nil || !reflect.DeepEqual(second, []string{b}) {
+				t.Fatalf("expected %q, got %q, %v", []string{b}, second, err)
+			}
+		}
+	})
+}
+
+func FuzzService_ExportImport_Names(f *testing.F) {
+	for _, s := range trickyStrings {
+		f.Add(s, s)
+	}
+
+	f.Fuzz(func(t *testing.T, name, category string) {
+		s := &Service{}
+		account, _ := s.RegisterAccount(types.Phone("+992" + category))
+		s.Deposit(account.ID, 1000)
+		payment, err := s.Pay(account.ID, 100, types.PaymentCategory(category))
+		if err != nil {
+			t.Fatalf("failed to pay: %v", err)
+		}
+		s.FavoritePayment(payment.ID, name)
+		other, _ := s.RegisterAccount(types.Phone("+993" + name))
+		if _, err := s.Transfer(account.ID, other.ID, 1, name); err != nil {
+			t.Fatalf("failed to transfer: %v", err)
+		}
+
+		dir := t.TempDir()
+		if err := s.Export(dir); err != nil {
+			t.Fatalf("Export failed: %v", err)
+		}
+		imported := &Service{}
+		if err := imported.Import(dir); err != nil {
+			t.Fatalf("Import failed: %v", err)
+		}
+		if !reflect.DeepEqual(serviceAccounts(s), serviceAccounts(imported)) {
+			t.Errorf("accounts differ: %v, %v", serviceAccounts(s), serviceAccounts(imported))
+		}
+		if !reflect.DeepEqual(servicePayments(s), servicePayments(imported)) {
+			t.Errorf("payments differ: %v, %v", servicePayments(s), servicePayments(imported))
+		}
+		if !reflect.DeepEqual(serviceFavorites(s), serviceFavorites(imported)) {
+			t.Errorf("favorites differ: %v, %v", serviceFavorites(s), serviceFavorites(imported))
+		}
+		if !reflect.DeepEqual(serviceTransfers(s), serviceTransfers(imported)) {
+			t.Errorf("transfers differ: %v, %v", serviceTransfers(s), serviceTransfers(imported))
+		}
+
+		path := filepath.Join(t.TempDir(), "export.txt")
+		if err := s.ExportToFile(path); err != nil {
+			t.Fatalf("ExportToFile failed: %v", err)
+		}
+		fromFile := &Service{}
+		if err := fromFile.ImportFromFile(path); err != nil {
+			t.Fatalf("ImportFromFile failed: %v", err)
+		}
+		if !reflect.DeepEqual(untimedAccounts(s), untimedAccounts(fromFile)) {
+			t.Errorf("accounts differ: %v, %v", untimedAccounts(s), untimedAccounts(fromFile))
+		}
+	})
+}
+
+// untimedAccounts возвращает аккаунты без времени: формат ExportToFile его не хранит.
+func untimedAccounts(s *Service) []types.Account {
+	var accounts []types.Account
+	for _, account := range serviceAccounts(s) {
+		account.CreatedAt, account.UpdatedAt = time.Time{}, time.Time{}
+		accounts = append(accounts, *account)
+	}
+	return accounts
+}
+
+func TestService_HistoryToFiles_Escaping(t *testing.T) {
+	s := &Service{}
+	account, _ := s.RegisterAccount("+992000000001")
+	s.Deposit(account.ID, 1000)
+	for _, category := range trickyStrings {
+		s.Pay(account.ID, 10, types.PaymentCategory(category))
+	}
+
+	dir := t.TempDir()
+	history, _ := s.ExportAccountHistory(account.ID)
+	if err := s.HistoryToFiles(history, dir, len(history)); err != nil {
+		t.Fatalf("HistoryToFiles failed: %v", err)
+	}
+
+	records, problems := readDump(filepath.Join(dir, "payments.dump"), paymentsDump)
+	if len(problems) > 0 {
+		t.Fatalf("failed to read dump: %v", problems)
+	}
+	for i, record := range records {
+		if record.fields[3] != trickyStrings[i] {
+			t.Errorf("expected category %q, got %q", trickyStrings[i], record.fields[3])
+		}
+	}
+}
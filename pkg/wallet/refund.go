@@ -0,0 +1,172 @@
+package wallet
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/akmalsulaymonov/alif-wallet/pkg/types"
+	"github.com/google/uuid"
+)
+
+var ErrRefundNotFound = errors.New("refund not found")
+var ErrRefundExceedsPayment = errors.New("refund amount exceeds payment")
+
+// RefundCategory - категория, под которой возвраты попадают в историю аккаунта.
+const RefundCategory types.PaymentCategory = "refund"
+
+// Refund возвращает деньги по подтверждённому платежу: всю сумму
+// или её остаток после частичных возвратов RefundAmount.
+func (s *Service) Refund(paymentID string) error {
+	_, err := s.refund(paymentID, 0, "")
+	return err
+}
+
+// RefundAmount возвращает на аккаунт часть amount подтверждённого платежа
+// в валюте платежа. Возвратов по платежу может быть несколько, но в сумме
+// не больше суммы платежа. Каждый возврат сохраняется отдельной записью
+// с причиной reason; когда возвращена вся сумма, платёж переходит в REFUNDED.
+func (s *Service) RefundAmount(paymentID string, amount types.Money, reason string) (*types.Refund, error) {
+	if amount <= 0 {
+		return nil, ErrAmountMustBePositive
+	}
+	return s.refund(paymentID, amount, reason)
+}
+
+// refund возвращает amount по платежу, 0 - весь остаток.
+func (s *Service) refund(paymentID string, amount types.Money, reason string) (*types.Refund, error) {
+	accountID, err := s.paymentAccountID(paymentID)
+	if err != nil {
+		return nil, ErrPaymentNotFound
+	}
+
+	unlock, err := s.lockAccount(accountID)
+	if err != nil {
+		return nil, ErrAccountNotFound
+	}
+	defer unlock()
+
+	var refund *types.Refund
+	err = s.repo().Update(func(tx Tx) error {
+		payment, err := tx.Payment(paymentID)
+		if err != nil {
+			return ErrPaymentNotFound
+		}
+		if payment.Status != types.PaymentStatusOk {
+			return &TransitionError{PaymentID: payment.ID, From: payment.Status, To: types.PaymentStatusRefunded}
+		}
+		account, err := tx.Account(payment.AccountID)
+		if err != nil {
+			return ErrAccountNotFound
+		}
+
+		// Остаток платежа и доли получателя после прошлых возвратов
+		remaining, remainingTarget := payment.Amount, payment.TargetAmount
+		for _, previous := range tx.PaymentRefunds(payment.ID) {
+			remaining -= previous.Amount
+			remainingTarget -= previous.TargetAmount
+		}
+		if amount == 0 {
+			amount = remaining
+		}
+		if amount > remaining {
+			return fmt.Errorf("%w: %v > %v", ErrRefundExceedsPayment, amount, remaining)
+		}
+
+		now := s.stamp()
+		refund = &types.Refund{
+			ID:        uuid.New().String(),
+			PaymentID: payment.ID,
+			AccountID: account.ID,
+			Amount:    amount,
+			Currency:  payment.Currency,
+			Reason:    reason,
+			CreatedAt: now,
+		}
+		source := types.Amount{Value: amount, Currency: payment.Currency}
+		target := source
+		if payment.TargetCurrency != "" {
+			target.Currency = payment.TargetCurrency
+			if amount == remaining {
+				// Последний возврат забирает остаток, чтобы не копить ошибку округления
+				target.Value = remainingTarget
+			} else {
+				target.Value = share(payment.TargetAmount, amount, payment.Amount)
+			}
+			refund.TargetAmount = target.Value
+			refund.TargetCurrency = target.Currency
+		}
+
+		if err := credit(account, source); err != nil {
+			return err
+		}
+		account.UpdatedAt = now
+		tx.PutAccount(account)
+		postExchange(tx, LedgerMerchantClearing, CustomerLedgerAccount(account.ID), target, source, refund.ID)
+		tx.PutRefund(refund)
+
+		if amount == remaining {
+			if err := setStatus(payment, types.PaymentStatusRefunded, now); err != nil {
+				return err
+			}
+		} else {
+			payment.UpdatedAt = now
+		}
+		tx.PutPayment(payment)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return refund, nil
+}
+
+// PaymentRefunds возвращает копии возвратов по платежу в порядке создания.
+func (s *Service) PaymentRefunds(paymentID string) ([]types.Refund, error) {
+	var refunds []types.Refund
+	err := s.repo().View(func(tx Tx) error {
+		if _, err := tx.Payment(paymentID); err != nil {
+			return ErrPaymentNotFound
+		}
+		for _, refund := range tx.PaymentRefunds(paymentID) {
+			refunds = append(refunds, *refund)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return refunds, nil
+}
+
+// refundHistory представляет возвраты аккаунта записями истории
+// с категорией RefundCategory и статусом OK. ID записи совпадает с ID возврата.
+func refundHistory(refunds []*types.Refund) []types.Payment {
+	var history []types.Payment
+	for _, refund := range refunds {
+		history = append(history, types.Payment{
+			ID:        refund.ID,
+			AccountID: refund.AccountID,
+			Amount:    refund.Amount,
+			Category:  RefundCategory,
+			Status:    types.PaymentStatusOk,
+			Currency:  refund.Currency,
+
+			CreatedAt:       refund.CreatedAt,
+			UpdatedAt:       refund.CreatedAt,
+			StatusChangedAt: refund.CreatedAt,
+		})
+	}
+	return history
+}
+
+// share возвращает долю part/whole от total с округлением вниз, как при
+// зачислении в quoteSource. Произведение считается в big.Int, поэтому
+// не переполняется; результат не больше total.
+func share(total, part, whole types.Money) types.Money {
+	value := new(big.Int).Mul(big.NewInt(int64(total)), big.NewInt(int64(part)))
+	value.Quo(value, big.NewInt(int64(whole)))
+	return types.Money(value.Int64())
+}
@@ -0,0 +1,119 @@
+package wallet
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/akmalsulaymonov/alif-wallet/pkg/types"
+)
+
+func TestService_RefundAmount(t *testing.T) {
+	s := &Service{}
+	account, _ := s.RegisterAccount("+992000000001")
+	s.Deposit(account.ID, 1000)
+	payment, _ := s.Pay(account.ID, 300, "hotel")
+
+	if _, err := s.RefundAmount(payment.ID, 100, ""); !errors.Is(err, ErrIllegalTransition) {
+		t.Errorf("expected error %v for payment in progress, got %v", ErrIllegalTransition, err)
+	}
+	s.Confirm(payment.ID)
+
+	first, err := s.RefundAmount(payment.ID, 100, "мини-бар")
+	if err != nil {
+		t.Fatalf("RefundAmount failed: %v", err)
+	}
+	if first.PaymentID != payment.ID || first.Amount != 100 || first.Reason != "мини-бар" {
+		t.Errorf("unexpected refund %+v", first)
+	}
+	if _, err := s.RefundAmount(payment.ID, 250, ""); !errors.Is(err, ErrRefundExceedsPayment) {
+		t.Errorf("expected error %v, got %v", ErrRefundExceedsPayment, err)
+	}
+	if got, _ := s.FindPaymentByID(payment.ID); got.Status != types.PaymentStatusOk {
+		t.Errorf("expected payment still OK, got %v", got.Status)
+	}
+
+	// Refund возвращает остаток
+	if err := s.Refund(payment.ID); err != nil {
+		t.Fatalf("Refund failed: %v", err)
+	}
+	if got, _ := s.FindPaymentByID(payment.ID); got.Status != types.PaymentStatusRefunded {
+		t.Errorf("expected payment %v, got %v", types.PaymentStatusRefunded, got.Status)
+	}
+	if got, _ := s.FindAccountByID(account.ID); got.Balance != 1000 {
+		t.Errorf("expected balance 1000, got %v", got.Balance)
+	}
+	refunds, _ := s.PaymentRefunds(payment.ID)
+	if len(refunds) != 2 || refunds[0].ID != first.ID || refunds[1].Amount != 200 {
+		t.Errorf("unexpected refunds %+v", refunds)
+	}
+	if _, err := s.RefundAmount(payment.ID, 1, ""); !errors.Is(err, ErrIllegalTransition) {
+		t.Errorf("expected error %v for refunded payment, got %v", ErrIllegalTransition, err)
+	}
+
+	// Каждый возврат - отдельная запись истории
+	history, _ := s.ExportAccountHistory(account.ID)
+	if len(history) != 3 || history[1].ID != first.ID || history[1].Category != RefundCategory || history[2].Amount != 200 {
+		t.Errorf("unexpected history %+v", history)
+	}
+	statement, _ := s.Statement(account.ID)
+	if last := statement.Lines[len(statement.Lines)-1]; last.Balance != 1000 || statement.Lines[0].Balance != 700 {
+		t.Errorf("unexpected statement %+v", statement.Lines)
+	}
+	if report := s.CheckLedger(); !report.OK() {
+		t.Errorf("ledger is inconsistent: %+v", report)
+	}
+}
+
+func TestService_RefundAmount_Conversion(t *testing.T) {
+	s, account, _ := newExchangeService(t)
+
+	// 10.01 USD = 109.61 TJS
+	payment, _ := s.PayAmount(account.ID, types.Amount{Value: 1001, Currency: types.USD}, "shop")
+	s.Confirm(payment.ID)
+
+	refund, err := s.RefundAmount(payment.ID, 3333, "")
+	if err != nil {
+		t.Fatalf("RefundAmount failed: %v", err)
+	}
+	if refund.TargetAmount != 1001*3333/10961 || refund.TargetCurrency != types.USD {
+		t.Errorf("unexpected refund %+v", refund)
+	}
+	if err := s.Refund(payment.ID); err != nil {
+		t.Fatalf("Refund failed: %v", err)
+	}
+	refunds, _ := s.PaymentRefunds(payment.ID)
+	if refunds[0].TargetAmount+refunds[1].TargetAmount != 1001 {
+		t.Errorf("expected 10.01 USD returned in total, got %+v", refunds)
+	}
+	if got, _ := s.FindAccountByID(account.ID); got.Balance != 100_000 {
+		t.Errorf("expected balance 100000, got %v", got.Balance)
+	}
+	if report := s.CheckLedger(); !report.OK() {
+		t.Errorf("ledger is inconsistent: %+v", report)
+	}
+}
+
+func TestService_RefundAmount_Conversion_LargeAmounts(t *testing.T) {
+	s, account, _ := newExchangeService(t)
+	if err := s.Deposit(account.ID, 9_000_000_000_000_000_000); err != nil {
+		t.Fatalf("Deposit failed: %v", err)
+	}
+
+	// 8e15 USD = 8.76e16 TJS: произведение сумм не помещается в int64
+	payment, err := s.PayAmount(account.ID, types.Amount{Value: 800_000_000_000_000_000, Currency: types.USD}, "shop")
+	if err != nil {
+		t.Fatalf("PayAmount failed: %v", err)
+	}
+	s.Confirm(payment.ID)
+
+	refund, err := s.RefundAmount(payment.ID, payment.Amount/2, "")
+	if err != nil {
+		t.Fatalf("RefundAmount failed: %v", err)
+	}
+	if refund.TargetAmount != 400_000_000_000_000_000 {
+		t.Errorf("expected target amount 400000000000000000, got %v", refund.TargetAmount)
+	}
+	if report := s.CheckLedger(); !report.OK() {
+		t.Errorf("ledger is inconsistent: %+v", report)
+	}
+}
@@ -0,0 +1,410 @@
+package wallet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/akmalsulaymonov/alif-wallet/pkg/types"
+	"github.com/google/uuid"
+)
+
+var ErrScheduleNotFound = errors.New("schedule not found")
+var ErrScheduleRunNotFound = errors.New("schedule run not found")
+var ErrInvalidSchedule = errors.New("invalid schedule")
+var ErrSchedulerRunning = errors.New("scheduler already running")
+
+// DefaultScheduleInterval - период проверок Scheduler по умолчанию.
+const DefaultScheduleInterval = time.Minute
+
+// RetryPolicy - повторы платежа по расписанию, который не прошёл из-за
+// нехватки денег: через Delay, всего не больше MaxAttempts попыток.
+// После последней неудачной попытки платёж пропускается до следующего
+// по расписанию.
+type RetryPolicy struct {
+	Delay       time.Duration
+	MaxAttempts int
+}
+
+// DefaultRetryPolicy - повторы по умолчанию, если поля RetryPolicy не заданы.
+var DefaultRetryPolicy = RetryPolicy{Delay: time.Hour, MaxAttempts: 3}
+
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.Delay <= 0 {
+		p.Delay = DefaultRetryPolicy.Delay
+	}
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = DefaultRetryPolicy.MaxAttempts
+	}
+	return p
+}
+
+// ScheduleFavorite создаёт расписание платежей по избранному. Для ONCE
+// start - время платежа, для DAILY, WEEKLY и MONTHLY - время первого
+// платежа (нулевое - сейчас). Если start в прошлом, первым будет ближайший
+// платёж по расписанию не раньше текущего времени. Для CRON start не
+// используется, а cron - выражение из пяти полей в UTC, например
+// "0 9 5 * *" - 5 числа каждого месяца в 09:00. Число месяца MONTHLY,
+// которого нет в коротком месяце, заменяется последним днём месяца.
+func (s *Service) ScheduleFavorite(favoriteID string, kind types.ScheduleKind, start time.Time, cron string) (*types.Schedule, error) {
+	if !kind.Valid() {
+		return nil, fmt.Errorf("%w: kind %q", ErrInvalidSchedule, kind)
+	}
+	if (kind == types.ScheduleCron) != (cron != "") {
+		return nil, fmt.Errorf("%w: cron expression is only for %s", ErrInvalidSchedule, types.ScheduleCron)
+	}
+	if kind == types.ScheduleCron {
+		if _, err := parseCron(cron); err != nil {
+			return nil, err
+		}
+	}
+
+	var accountID int64
+	err := s.repo().View(func(tx Tx) error {
+		favorite, err := tx.Favorite(favoriteID)
+		if err != nil {
+			return err
+		}
+		accountID = favorite.AccountID
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	unlock, err := s.lockAccount(accountID)
+	if err != nil {
+		return nil, ErrAccountNotFound
+	}
+	defer unlock()
+
+	var schedule *types.Schedule
+	err = s.repo().Update(func(tx Tx) error {
+		favorite, err := tx.Favorite(favoriteID)
+		if err != nil {
+			return err
+		}
+
+		now := s.stamp()
+		if start.IsZero() || kind == types.ScheduleCron {
+			start = now
+		}
+		schedule = &types.Schedule{
+			ID:         uuid.New().String(),
+			FavoriteID: favorite.ID,
+			AccountID:  favorite.AccountID,
+			Kind:       kind,
+			Start:      start.UTC(),
+			Cron:       cron,
+			Active:     true,
+			CreatedAt:  now,
+			UpdatedAt:  now,
+		}
+		if kind == types.ScheduleOnce {
+			schedule.DueAt = schedule.Start
+		} else {
+			after := now
+			if schedule.Start.After(after) {
+				after = schedule.Start
+			}
+			schedule.DueAt = nextOccurrence(schedule, after.Add(-time.Nanosecond))
+		}
+		if schedule.DueAt.IsZero() {
+			return fmt.Errorf("%w: no payments due", ErrInvalidSchedule)
+		}
+		tx.PutSchedule(schedule)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return schedule, nil
+}
+
+// nextOccurrence возвращает время первого платежа по расписанию после after
+// или нулевое время, если платежей больше нет.
+func nextOccurrence(schedule *types.Schedule, after time.Time) time.Time {
+	start := schedule.Start
+	switch schedule.Kind {
+	case types.ScheduleOnce:
+		if start.After(after) {
+			return start
+		}
+	case types.ScheduleDaily, types.ScheduleWeekly:
+		period := 24 * time.Hour
+		if schedule.Kind == types.ScheduleWeekly {
+			period *= 7
+		}
+		if start.After(after) {
+			return start
+		}
+		return start.Add((after.Sub(start)/period + 1) * period)
+	case types.ScheduleMonthly:
+		months := (after.Year()-start.Year())*12 + int(after.Month()-start.Month()) - 1
+		if months < 0 {
+			months = 0
+		}
+		for ; ; months++ {
+			if t := addMonths(start, months); t.After(after) {
+				return t
+			}
+		}
+	case types.ScheduleCron:
+		cron, err := parseCron(schedule.Cron)
+		if err == nil {
+			return cron.next(after)
+		}
+	}
+	return time.Time{}
+}
+
+// addMonths сдвигает t на months месяцев, заменяя отсутствующее
+// в месяце число последним днём месяца.
+func addMonths(t time.Time, months int) time.Time {
+	first := time.Date(t.Year(), t.Month()+time.Month(months), 1, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+	day := t.Day()
+	if last := first.AddDate(0, 1, -1).Day(); day > last {
+		day = last
+	}
+	return first.AddDate(0, 0, day-1)
+}
+
+// CancelSchedule отменяет расписание. Выполнения остаются в истории.
+func (s *Service) CancelSchedule(scheduleID string) error {
+	return s.updateSchedule(scheduleID, func(tx Tx, schedule *types.Schedule) {
+		schedule.Active = false
+		schedule.RetryAt = time.Time{}
+	})
+}
+
+// updateSchedule выполняет fn над расписанием под блокировкой его аккаунта.
+func (s *Service) updateSchedule(scheduleID string, fn func(tx Tx, schedule *types.Schedule)) error {
+	schedule, err := s.FindScheduleByID(scheduleID)
+	if err != nil {
+		return err
+	}
+
+	unlock, err := s.lockAccount(schedule.AccountID)
+	if err != nil {
+		return ErrAccountNotFound
+	}
+	defer unlock()
+
+	return s.repo().Update(func(tx Tx) error {
+		schedule, err := tx.Schedule(scheduleID)
+		if err != nil {
+			return err
+		}
+		fn(tx, schedule)
+		schedule.UpdatedAt = s.stamp()
+		tx.PutSchedule(schedule)
+		return nil
+	})
+}
+
+// FindScheduleByID возвращает копию расписания, см. FindAccountByID.
+func (s *Service) FindScheduleByID(scheduleID string) (*types.Schedule, error) {
+	return findCopy(s, func(tx Tx) (*types.Schedule, error) { return tx.Schedule(scheduleID) })
+}
+
+// AccountSchedules возвращает копии расписаний аккаунта, включая неактивные.
+func (s *Service) AccountSchedules(accountID int64) ([]types.Schedule, error) {
+	var schedules []types.Schedule
+	err := s.repo().View(func(tx Tx) error {
+		if _, err := tx.Account(accountID); err != nil {
+			return err
+		}
+		for _, schedule := range tx.Schedules() {
+			if schedule.AccountID == accountID {
+				schedules = append(schedules, *schedule)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return schedules, nil
+}
+
+// ScheduleRuns возвращает копии выполнений расписания в порядке записи.
+func (s *Service) ScheduleRuns(scheduleID string) ([]types.ScheduleRun, error) {
+	var runs []types.ScheduleRun
+	err := s.repo().View(func(tx Tx) error {
+		if _, err := tx.Schedule(scheduleID); err != nil {
+			return err
+		}
+		for _, run := range tx.RunsOfSchedule(scheduleID) {
+			runs = append(runs, *run)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return runs, nil
+}
+
+// RunSchedules выполняет платежи по расписаниям, время которых наступило
+// по часам Service, и возвращает записи о выполнении. Платёж создаётся
+// через PayFromFavoriteWithKey с ключом, уникальным для расписания и
+// времени платежа, поэтому даже при параллельных вызовах он проходит
+// один раз. Платёж, не прошедший из-за ErrNotEnoughBalance, повторяется
+// по retry; любая другая ошибка пропускает его, а если избранное или
+// аккаунт удалены, расписание становится неактивным.
+//
+// Если проверки долго не выполнялись, пропущенные платежи сводятся к одному.
+func (s *Service) RunSchedules(retry RetryPolicy) ([]types.ScheduleRun, error) {
+	retry = retry.withDefaults()
+	now := s.stamp()
+
+	var due []types.Schedule
+	s.repo().View(func(tx Tx) error {
+		for _, schedule := range tx.Schedules() {
+			if schedule.Active && !now.Before(schedule.DueAt) && !now.Before(schedule.RetryAt) {
+				due = append(due, *schedule)
+			}
+		}
+		return nil
+	})
+	sort.Slice(due, func(i, j int) bool { return due[i].DueAt.Before(due[j].DueAt) })
+
+	var runs []types.ScheduleRun
+	for _, schedule := range due {
+		key := fmt.Sprintf("schedule:%s:%d", schedule.ID, schedule.DueAt.UnixNano())
+		payment, err := s.PayFromFavoriteWithKey(key, schedule.FavoriteID)
+
+		run, recorded, recordErr := s.recordRun(schedule, payment, err, retry, now)
+		if recordErr != nil {
+			return runs, recordErr
+		}
+		if recorded {
+			runs = append(runs, run)
+		}
+	}
+	return runs, nil
+}
+
+// recordRun сохраняет результат попытки и сдвигает расписание. Если
+// расписание успели изменить (другой RunSchedules, отмена), ничего
+// не записывается и recorded = false.
+func (s *Service) recordRun(due types.Schedule, payment *types.Payment, payErr error, retry RetryPolicy, now time.Time) (run types.ScheduleRun, recorded bool, err error) {
+	unlock, err := s.lockAccount(due.AccountID)
+	if err != nil {
+		return run, false, nil
+	}
+	defer unlock()
+
+	err = s.repo().Update(func(tx Tx) error {
+		schedule, err := tx.Schedule(due.ID)
+		if err != nil {
+			return nil
+		}
+		if !schedule.Active || !schedule.DueAt.Equal(due.DueAt) || schedule.Attempts != due.Attempts {
+			return nil
+		}
+
+		run = types.ScheduleRun{
+			ID:         uuid.New().String(),
+			ScheduleID: schedule.ID,
+			AccountID:  schedule.AccountID,
+			DueAt:      schedule.DueAt,
+			Attempt:    schedule.Attempts + 1,
+			Status:     types.ScheduleRunOk,
+			At:         now,
+		}
+		switch {
+		case payErr == nil:
+			run.PaymentID = payment.ID
+		case errors.Is(payErr, ErrNotEnoughBalance) && run.Attempt < retry.MaxAttempts:
+			run.Status = types.ScheduleRunRetry
+			run.Error = payErr.Error()
+		default:
+			run.Status = types.ScheduleRunFailed
+			run.Error = payErr.Error()
+		}
+
+		if run.Status == types.ScheduleRunRetry {
+			schedule.Attempts = run.Attempt
+			schedule.RetryAt = now.Add(retry.Delay)
+		} else {
+			after := schedule.DueAt
+			if now.After(after) {
+				after = now
+			}
+			schedule.Attempts = 0
+			schedule.RetryAt = time.Time{}
+			schedule.DueAt = nextOccurrence(schedule, after)
+			if schedule.DueAt.IsZero() || errors.Is(payErr, ErrFavoriteNotFound) || errors.Is(payErr, ErrAccountNotFound) {
+				schedule.Active = false
+			}
+		}
+		schedule.UpdatedAt = now
+		tx.PutSchedule(schedule)
+		tx.PutScheduleRun(&run)
+		recorded = true
+		return nil
+	})
+	return run, recorded, err
+}
+
+// SchedulerOptions - параметры Scheduler.
+type SchedulerOptions struct {
+	// Interval - период проверок, 0 - DefaultScheduleInterval.
+	Interval time.Duration
+	// Retry - повторы платежей, не прошедших из-за нехватки денег.
+	Retry RetryPolicy
+	// OnRun вызывается после прохода, в котором выполнено хотя бы
+	// одно расписание.
+	OnRun func([]types.ScheduleRun)
+	// OnError вызывается, если проход завершился ошибкой.
+	// Scheduler при этом продолжает работу.
+	OnError func(error)
+}
+
+// Scheduler в фоне периодически вызывает RunSchedules. Время платежей
+// сравнивается с часами Service (см. SetClock), период проверок
+// отсчитывается по реальному времени.
+type Scheduler struct {
+	svc     *Service
+	options SchedulerOptions
+	loop    loop
+}
+
+// NewScheduler создаёт Scheduler для сервиса. Проверки начинаются после Start.
+func (s *Service) NewScheduler(options SchedulerOptions) *Scheduler {
+	if options.Interval <= 0 {
+		options.Interval = DefaultScheduleInterval
+	}
+	return &Scheduler{svc: s, options: options}
+}
+
+// Start запускает проверки: первую сразу, следующие через Interval.
+// Проверки прекращаются при отмене ctx или вызове Stop. Повторный Start
+// до остановки возвращает ErrSchedulerRunning.
+func (r *Scheduler) Start(ctx context.Context) error {
+	if !r.loop.start(ctx, r.options.Interval, r.check) {
+		return ErrSchedulerRunning
+	}
+	return nil
+}
+
+// Stop останавливает проверки и дожидается завершения текущей.
+func (r *Scheduler) Stop() {
+	r.loop.stop()
+}
+
+func (r *Scheduler) check() {
+	runs, err := r.svc.RunSchedules(r.options.Retry)
+	if err != nil && r.options.OnError != nil {
+		r.options.OnError(err)
+	}
+	if len(runs) > 0 && r.options.OnRun != nil {
+		r.options.OnRun(runs)
+	}
+}
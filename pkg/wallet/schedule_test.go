@@ -0,0 +1,229 @@
+package wallet
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/akmalsulaymonov/alif-wallet/pkg/types"
+)
+
+// newScheduleService возвращает сервис с часами clock и избранным
+// на 100 дирамов с аккаунта без денег.
+func newScheduleService(t *testing.T, clock *testClock) (*Service, *types.Account, *types.Favorite) {
+	s := &Service{}
+	s.SetClock(clock.Now)
+	account, _ := s.RegisterAccount("+992000000001")
+	s.Deposit(account.ID, 100)
+	payment, _ := s.Pay(account.ID, 100, "internet")
+	favorite, err := s.FavoritePayment(payment.ID, "Интернет")
+	if err != nil {
+		t.Fatalf("FavoritePayment failed: %v", err)
+	}
+	return s, account, favorite
+}
+
+func TestService_RunSchedules_Monthly(t *testing.T) {
+	clock := newTestClock()
+	s, account, favorite := newScheduleService(t, clock)
+	s.Deposit(account.ID, 1000)
+
+	start := time.Date(2024, 1, 31, 9, 0, 0, 0, time.UTC)
+	schedule, err := s.ScheduleFavorite(favorite.ID, types.ScheduleMonthly, start, "")
+	if err != nil {
+		t.Fatalf("ScheduleFavorite failed: %v", err)
+	}
+	if !schedule.DueAt.Equal(start) {
+		t.Errorf("expected first payment at %v, got %v", start, schedule.DueAt)
+	}
+
+	if runs, _ := s.RunSchedules(RetryPolicy{}); len(runs) != 0 {
+		t.Errorf("expected nothing due, got %+v", runs)
+	}
+	clock.Advance(start.Sub(clock.Now()))
+	runs, err := s.RunSchedules(RetryPolicy{})
+	if err != nil {
+		t.Fatalf("RunSchedules failed: %v", err)
+	}
+	if len(runs) != 1 || runs[0].Status != types.ScheduleRunOk || runs[0].PaymentID == "" {
+		t.Fatalf("unexpected runs %+v", runs)
+	}
+	if payment, _ := s.FindPaymentByID(runs[0].PaymentID); payment.Amount != favorite.Amount || payment.Category != favorite.Category {
+		t.Errorf("unexpected payment %+v", payment)
+	}
+
+	// В феврале 2024 нет 31 числа: платёж в последний день месяца
+	got, _ := s.FindScheduleByID(schedule.ID)
+	if february := time.Date(2024, 2, 29, 9, 0, 0, 0, time.UTC); !got.DueAt.Equal(february) {
+		t.Errorf("expected next payment at %v, got %v", february, got.DueAt)
+	}
+	if runs, _ := s.RunSchedules(RetryPolicy{}); len(runs) != 0 {
+		t.Errorf("expected payment made once, got %+v", runs)
+	}
+
+	// Пропущенные платежи сводятся к одному
+	clock.Advance(70 * 24 * time.Hour)
+	if runs, _ := s.RunSchedules(RetryPolicy{}); len(runs) != 1 {
+		t.Errorf("expected one run, got %+v", runs)
+	}
+	got, _ = s.FindScheduleByID(schedule.ID)
+	if april := time.Date(2024, 4, 30, 9, 0, 0, 0, time.UTC); !got.DueAt.Equal(april) {
+		t.Errorf("expected next payment at %v, got %v", april, got.DueAt)
+	}
+	if history, _ := s.ScheduleRuns(schedule.ID); len(history) != 2 {
+		t.Errorf("expected 2 runs recorded, got %+v", history)
+	}
+}
+
+func TestService_RunSchedules_Retry(t *testing.T) {
+	clock := newTestClock()
+	s, account, favorite := newScheduleService(t, clock)
+	schedule, _ := s.ScheduleFavorite(favorite.ID, types.ScheduleDaily, time.Time{}, "")
+	due := schedule.DueAt
+	retry := RetryPolicy{Delay: time.Hour, MaxAttempts: 2}
+
+	runs, _ := s.RunSchedules(retry)
+	if len(runs) != 1 || runs[0].Status != types.ScheduleRunRetry || runs[0].Attempt != 1 {
+		t.Fatalf("unexpected runs %+v", runs)
+	}
+	if runs, _ := s.RunSchedules(retry); len(runs) != 0 {
+		t.Errorf("expected no retry before delay, got %+v", runs)
+	}
+
+	// Повтор после пополнения проходит
+	s.Deposit(account.ID, 100)
+	clock.Advance(time.Hour)
+	runs, _ = s.RunSchedules(retry)
+	if len(runs) != 1 || runs[0].Status != types.ScheduleRunOk || runs[0].Attempt != 2 || !runs[0].DueAt.Equal(due) {
+		t.Fatalf("unexpected runs %+v", runs)
+	}
+
+	// После MaxAttempts неудач платёж пропускается до следующего дня
+	clock.Advance(23 * time.Hour)
+	s.RunSchedules(retry)
+	clock.Advance(time.Hour)
+	runs, _ = s.RunSchedules(retry)
+	if len(runs) != 1 || runs[0].Status != types.ScheduleRunFailed || runs[0].Error == "" {
+		t.Fatalf("unexpected runs %+v", runs)
+	}
+	got, _ := s.FindScheduleByID(schedule.ID)
+	if next := due.Add(48 * time.Hour); !got.Active || !got.DueAt.Equal(next) || got.Attempts != 0 || !got.RetryAt.IsZero() {
+		t.Errorf("expected next payment at %v, got %+v", next, got)
+	}
+	if report := s.CheckLedger(); !report.OK() {
+		t.Errorf("ledger is inconsistent: %+v", report)
+	}
+}
+
+func TestService_ScheduleFavorite(t *testing.T) {
+	clock := newTestClock()
+	s, account, favorite := newScheduleService(t, clock)
+	s.Deposit(account.ID, 1000)
+
+	if _, err := s.ScheduleFavorite(favorite.ID, "HOURLY", time.Time{}, ""); !errors.Is(err, ErrInvalidSchedule) {
+		t.Errorf("expected error %v, got %v", ErrInvalidSchedule, err)
+	}
+	if _, err := s.ScheduleFavorite(favorite.ID, types.ScheduleCron, time.Time{}, "61 * * * *"); !errors.Is(err, ErrInvalidCron) {
+		t.Errorf("expected error %v, got %v", ErrInvalidCron, err)
+	}
+	if _, err := s.ScheduleFavorite("unknown", types.ScheduleDaily, time.Time{}, ""); !errors.Is(err, ErrFavoriteNotFound) {
+		t.Errorf("expected error %v, got %v", ErrFavoriteNotFound, err)
+	}
+
+	// 1 января 2024 - понедельник, 12:00: ближайший будний день в 09:00 - вторник
+	cron, err := s.ScheduleFavorite(favorite.ID, types.ScheduleCron, time.Time{}, "0 9 * * 1-5")
+	if err != nil {
+		t.Fatalf("ScheduleFavorite failed: %v", err)
+	}
+	if tuesday := time.Date(2024, 1, 2, 9, 0, 0, 0, time.UTC); !cron.DueAt.Equal(tuesday) {
+		t.Errorf("expected first payment at %v, got %v", tuesday, cron.DueAt)
+	}
+
+	once, _ := s.ScheduleFavorite(favorite.ID, types.ScheduleOnce, clock.Now().Add(time.Hour), "")
+	if err := s.CancelSchedule(cron.ID); err != nil {
+		t.Fatalf("CancelSchedule failed: %v", err)
+	}
+	clock.Advance(7 * 24 * time.Hour)
+	runs, _ := s.RunSchedules(RetryPolicy{})
+	if len(runs) != 1 || runs[0].ScheduleID != once.ID {
+		t.Fatalf("expected only one-off schedule run, got %+v", runs)
+	}
+	if got, _ := s.FindScheduleByID(once.ID); got.Active {
+		t.Errorf("expected one-off schedule inactive, got %+v", got)
+	}
+	schedules, _ := s.AccountSchedules(account.ID)
+	if len(schedules) != 2 {
+		t.Errorf("expected 2 schedules, got %+v", schedules)
+	}
+	if _, err := s.ScheduleRuns("unknown"); !errors.Is(err, ErrScheduleNotFound) {
+		t.Errorf("expected error %v, got %v", ErrScheduleNotFound, err)
+	}
+}
+
+func TestParseCron(t *testing.T) {
+	after := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		expr string
+		next time.Time
+	}{
+		{"* * * * *", time.Date(2024, 1, 1, 12, 1, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2024, 1, 1, 12, 15, 0, 0, time.UTC)},
+		{"30 8 1,15 * *", time.Date(2024, 1, 15, 8, 30, 0, 0, time.UTC)},
+		{"0 0 * * 0", time.Date(2024, 1, 7, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2024, 1, 7, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 13 * 5", time.Date(2024, 1, 5, 0, 0, 0, 0, time.UTC)},
+		// Поле с "*" и шагом не ограничено: нечётное число и понедельник
+		{"0 9 */2 * 1", time.Date(2024, 1, 15, 9, 0, 0, 0, time.UTC)},
+		{"0 0 13 * */2", time.Date(2024, 1, 13, 0, 0, 0, 0, time.UTC)},
+		{"0 0 31 2 *", time.Time{}},
+	}
+	for _, test := range tests {
+		cron, err := parseCron(test.expr)
+		if err != nil {
+			t.Errorf("parseCron(%q) failed: %v", test.expr, err)
+			continue
+		}
+		if got := cron.next(after); !got.Equal(test.next) {
+			t.Errorf("next(%q) = %v, expected %v", test.expr, got, test.next)
+		}
+	}
+
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "a * * * *"} {
+		if _, err := parseCron(expr); !errors.Is(err, ErrInvalidCron) {
+			t.Errorf("parseCron(%q): expected error %v, got %v", expr, ErrInvalidCron, err)
+		}
+	}
+}
+
+func TestScheduler(t *testing.T) {
+	clock := newTestClock()
+	s, account, favorite := newScheduleService(t, clock)
+	s.Deposit(account.ID, 1000)
+	schedule, _ := s.ScheduleFavorite(favorite.ID, types.ScheduleDaily, time.Time{}, "")
+
+	ran := make(chan []types.ScheduleRun, 1)
+	scheduler := s.NewScheduler(SchedulerOptions{
+		Interval: time.Millisecond,
+		OnRun:    func(runs []types.ScheduleRun) { ran <- runs },
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := scheduler.Start(ctx); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	if err := scheduler.Start(ctx); !errors.Is(err, ErrSchedulerRunning) {
+		t.Errorf("expected error %v, got %v", ErrSchedulerRunning, err)
+	}
+
+	select {
+	case runs := <-ran:
+		if len(runs) != 1 || runs[0].ScheduleID != schedule.ID {
+			t.Errorf("unexpected runs %+v", runs)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("schedule did not run")
+	}
+	scheduler.Stop()
+}
@@ -1,14 +1,14 @@
 package wallet
 
 import (
-	"bufio"
 	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
-	"strconv"
-	"strings"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/akmalsulaymonov/alif-wallet/pkg/types"
 	"github.com/google/uuid"
@@ -19,150 +19,469 @@ var ErrAmountMustBePositive = errors.New("amount must be > 0")
 var ErrAccountNotFound = errors.New("account not found")
 var ErrNotEnoughBalance = errors.New("not enough balance in wallet")
 var ErrPaymentNotFound = errors.New("payment not found")
-
+var ErrInvalidRecordsPerFile = errors.New("records per file must be > 0")
+
+var ErrCurrencyMismatch = types.ErrCurrencyMismatch
+var ErrUnknownCurrency = types.ErrUnknownCurrency
+
+// Service безопасен для одновременного использования из нескольких горутин.
+//
+// Порядок захвата блокировок: global -> блокировка аккаунта -> блокировки Store.
+// Операции над одним аккаунтом берут global на чтение и сериализуются
+// блокировкой аккаунта, поэтому разные аккаунты не мешают друг другу.
+// Операции над всем сервисом (Export, Import) берут global на запись.
+//
+// Нулевое значение Service готово к работе и хранит данные в MemoryStore.
 type Service struct {
-	nextAccountID int64
-	accounts      []*types.Account
-	payments      []*types.Payment
-	favorites     []*types.Favorite // Список избранных платежей
+	global     sync.RWMutex
+	registerMu sync.Mutex // сериализует проверку уникальности телефона
+	locksMu    sync.Mutex
+	locks      map[int64]*sync.Mutex
+	once       sync.Once
+	store      Store
+	rates      ExchangeRateProvider
+	now        func() time.Time
+
+	// Время жизни ключей идемпотентности, 0 - DefaultIdempotencyWindow.
+	idempotencyWindow time.Duration
 }
 
-func (s *Service) RegisterAccount(phone types.Phone) (*types.Account, error) {
-	for _, account := range s.accounts {
-		if account.Phone == phone {
-			return nil, ErrPhoneRegistered
+// NewService создаёт сервис поверх хранилища store.
+func NewService(store Store) *Service {
+	return &Service{store: store}
+}
+
+// SetClock подменяет источник текущего времени, по умолчанию time.Now.
+func (s *Service) SetClock(now func() time.Time) {
+	s.global.Lock()
+	defer s.global.Unlock()
+
+	s.now = now
+}
+
+func (s *Service) clock() time.Time {
+	if s.now == nil {
+		return time.Now()
+	}
+	return s.now()
+}
+
+// stamp возвращает время для CreatedAt и UpdatedAt записей. Время
+// приводится к UTC и теряет показания монотонных часов, поэтому запись
+// после Export и Import совпадает с исходной.
+func (s *Service) stamp() time.Time {
+	return s.clock().UTC()
+}
+
+func (s *Service) repo() Store {
+	s.once.Do(func() {
+		if s.store == nil {
+			s.store = NewMemoryStore()
+		}
+	})
+	return s.store
+}
+
+// accountMutex возвращает блокировку аккаунта, создавая её при необходимости.
+func (s *Service) accountMutex(accountID int64) *sync.Mutex {
+	s.locksMu.Lock()
+	defer s.locksMu.Unlock()
+
+	if s.locks == nil {
+		s.locks = make(map[int64]*sync.Mutex)
+	}
+	m, ok := s.locks[accountID]
+	if !ok {
+		m = &sync.Mutex{}
+		s.locks[accountID] = m
+	}
+	return m
+}
+
+// lockAccount проверяет, что аккаунт существует, и блокирует его для изменения
+// баланса и статусов его платежей. Вызывающий обязан вызвать unlock.
+func (s *Service) lockAccount(accountID int64) (func(), error) {
+	return s.lockAccounts(accountID)
+}
+
+// lockAccounts блокирует несколько аккаунтов. Блокировки берутся
+// в порядке возрастания ID, чтобы встречные операции не зависли.
+func (s *Service) lockAccounts(accountIDs ...int64) (func(), error) {
+	ids := append([]int64(nil), accountIDs...)
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	s.global.RLock()
+
+	var locked []*sync.Mutex
+	for i, accountID := range ids {
+		if i > 0 && ids[i-1] == accountID {
+			continue
 		}
+		_, err := s.FindAccountByID(accountID)
+		if err != nil {
+			for _, m := range locked {
+				m.Unlock()
+			}
+			s.global.RUnlock()
+			return nil, err
+		}
+
+		m := s.accountMutex(accountID)
+		m.Lock()
+		locked = append(locked, m)
+	}
+
+	return func() {
+		for _, m := range locked {
+			m.Unlock()
+		}
+		s.global.RUnlock()
+	}, nil
+}
+
+// RegisterAccount регистрирует аккаунт в валюте по умолчанию (TJS).
+func (s *Service) RegisterAccount(phone types.Phone) (*types.Account, error) {
+	return s.RegisterAccountWithCurrency(phone, types.DefaultCurrency)
+}
+
+// RegisterAccountWithCurrency регистрирует аккаунт, баланс которого
+// ведётся в валюте currency.
+func (s *Service) RegisterAccountWithCurrency(phone types.Phone, currency types.Currency) (*types.Account, error) {
+	if !currency.Valid() {
+		return nil, ErrUnknownCurrency
 	}
 
-	s.nextAccountID++
+	s.global.RLock()
+	defer s.global.RUnlock()
+	s.registerMu.Lock()
+	defer s.registerMu.Unlock()
+
+	var account *types.Account
+	err := s.repo().Update(func(tx Tx) error {
+		if _, err := tx.AccountByPhone(phone); err == nil {
+			return ErrPhoneRegistered
+		}
 
-	account := &types.Account{
-		ID:      s.nextAccountID,
-		Phone:   phone,
-		Balance: 0,
+		now := s.stamp()
+		account = &types.Account{
+			ID:        tx.NextAccountID(),
+			Phone:     phone,
+			Balance:   0,
+			Currency:  currency,
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+		tx.PutAccount(account)
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
-	s.accounts = append(s.accounts, account)
 
 	return account, nil
 }
 
+// Deposit пополняет аккаунт на amount в валюте аккаунта.
 func (s *Service) Deposit(accountID int64, amount types.Money) error {
-	if amount <= 0 {
+	return s.deposit("", accountID, types.Amount{Value: amount})
+}
+
+// DepositAmount пополняет аккаунт. Валюта суммы должна совпадать
+// с валютой аккаунта, иначе возвращается ErrCurrencyMismatch.
+func (s *Service) DepositAmount(accountID int64, amount types.Amount) error {
+	if !amount.Currency.Valid() {
+		return ErrUnknownCurrency
+	}
+	return s.deposit("", accountID, amount)
+}
+
+// deposit пополняет аккаунт. Пустая валюта суммы означает валюту аккаунта,
+// непустой key делает пополнение идемпотентным.
+func (s *Service) deposit(key string, accountID int64, amount types.Amount) error {
+	if amount.Value <= 0 {
 		return ErrAmountMustBePositive
 	}
 
-	var account *types.Account
-	for _, acc := range s.accounts {
-		if acc.ID == accountID {
-			account = acc
-			break
-		}
+	unlock, err := s.lockAccount(accountID)
+	if err != nil {
+		return err
 	}
+	defer unlock()
 
-	if account == nil {
-		return ErrAccountNotFound
+	amount, err = s.accountAmount(accountID, amount)
+	if err != nil {
+		return err
 	}
 
-	account.Balance += amount
+	request := fmt.Sprintf("deposit;%d;%d;%s", accountID, amount.Value, amount.Currency)
+	_, err = s.idempotent(key, accountID, request, func(tx Tx) (string, error) {
+		account, err := tx.Account(accountID)
+		if err != nil {
+			return "", err
+		}
 
-	return nil
+		if err := credit(account, amount); err != nil {
+			return "", err
+		}
+		account.UpdatedAt = s.stamp()
+		tx.PutAccount(account)
+		postEntries(tx, LedgerCashIn, CustomerLedgerAccount(accountID), amount, "")
+		return "", nil
+	})
+	return err
 }
 
+// accountAmount подставляет валюту аккаунта в сумму без валюты.
+func (s *Service) accountAmount(accountID int64, amount types.Amount) (types.Amount, error) {
+	if amount.Currency != "" {
+		return amount, nil
+	}
+	account, err := s.FindAccountByID(accountID)
+	if err != nil {
+		return types.Amount{}, err
+	}
+	amount.Currency = account.Currency
+	return amount, nil
+}
+
+// Pay создаёт платёж на amount в валюте аккаунта.
 func (s *Service) Pay(accountID int64, amount types.Money, category types.PaymentCategory) (*types.Payment, error) {
-	if amount <= 0 {
+	return s.pay("", accountID, types.Amount{Value: amount}, category)
+}
+
+// PayAmount создаёт платёж на amount. Если валюта суммы не совпадает
+// с валютой аккаунта, сумма конвертируется по курсу из ExchangeRateProvider:
+// получатель получает ровно amount, а с аккаунта списывается эквивалент,
+// округлённый вверх. Без источника курсов возвращается ErrCurrencyMismatch.
+func (s *Service) PayAmount(accountID int64, amount types.Amount, category types.PaymentCategory) (*types.Payment, error) {
+	if !amount.Currency.Valid() {
+		return nil, ErrUnknownCurrency
+	}
+	return s.pay("", accountID, amount, category)
+}
+
+// pay создаёт платёж. Пустая валюта суммы означает валюту аккаунта,
+// непустой key делает платёж идемпотентным.
+func (s *Service) pay(key string, accountID int64, amount types.Amount, category types.PaymentCategory) (*types.Payment, error) {
+	if amount.Value <= 0 {
 		return nil, ErrAmountMustBePositive
 	}
 
-	var account *types.Account
-	for _, acc := range s.accounts {
-		if acc.ID == accountID {
-			account = acc
-			break
-		}
+	unlock, err := s.lockAccount(accountID)
+	if err != nil {
+		return nil, err
 	}
+	defer unlock()
 
-	if account == nil {
-		return nil, ErrAccountNotFound
+	amount, err = s.accountAmount(accountID, amount)
+	if err != nil {
+		return nil, err
 	}
 
-	if account.Balance < amount {
-		return nil, ErrNotEnoughBalance
+	var payment *types.Payment
+	request := fmt.Sprintf("pay;%d;%d;%s;%s", accountID, amount.Value, amount.Currency, category)
+	paymentID, err := s.idempotent(key, accountID, request, func(tx Tx) (string, error) {
+		account, err := tx.Account(accountID)
+		if err != nil {
+			return "", err
+		}
+
+		payment, err = s.charge(tx, account, amount, category)
+		if err != nil {
+			return "", err
+		}
+		return payment.ID, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if payment == nil {
+		return s.FindPaymentByID(paymentID)
 	}
 
-	account.Balance -= amount
+	return payment, nil
 
-	paymentID := uuid.New().String()
+}
 
+// charge списывает с аккаунта сумму, эквивалентную amount, и создаёт платёж
+// в статусе INPROGRESS. Вызывается под блокировкой аккаунта.
+func (s *Service) charge(tx Tx, account *types.Account, amount types.Amount, category types.PaymentCategory) (*types.Payment, error) {
+	ex, err := s.quoteTarget(account.Currency, amount)
+	if err != nil {
+		return nil, err
+	}
+	if err := debit(account, ex.source); err != nil {
+		return nil, err
+	}
+	now := s.stamp()
+	account.UpdatedAt = now
+	tx.PutAccount(account)
+
+	targetAmount, targetCurrency, rate := ex.recorded()
 	payment := &types.Payment{
-		ID:        paymentID,
-		AccountID: accountID,
-		Amount:    amount,
-		Category:  category,
-		Status:    types.PaymentStatusInProgress,
+		ID:              uuid.New().String(),
+		AccountID:       account.ID,
+		Amount:          ex.source.Value,
+		Category:        category,
+		Status:          types.PaymentStatusInProgress,
+		Currency:        ex.source.Currency,
+		TargetAmount:    targetAmount,
+		TargetCurrency:  targetCurrency,
+		Rate:            rate,
+		CreatedAt:       now,
+		UpdatedAt:       now,
+		StatusChangedAt: now,
+	}
+	tx.PutPayment(payment)
+	postExchange(tx, CustomerLedgerAccount(account.ID), LedgerMerchantClearing, ex.source, ex.target, payment.ID)
+	return payment, nil
+}
+
+// paymentTarget возвращает сумму, которую получил получатель платежа.
+func paymentTarget(payment *types.Payment) types.Amount {
+	if payment.TargetCurrency == "" {
+		return types.Amount{Value: payment.Amount, Currency: payment.Currency}
 	}
+	return types.Amount{Value: payment.TargetAmount, Currency: payment.TargetCurrency}
+}
 
-	s.payments = append(s.payments, payment)
+// balanceOf возвращает баланс аккаунта вместе с его валютой.
+func balanceOf(account *types.Account) types.Amount {
+	return types.Amount{Value: account.Balance, Currency: account.Currency}
+}
 
-	return payment, nil
+// debit списывает amount с баланса аккаунта. Заблокированную
+// сумму (Account.Held) списать нельзя.
+func debit(account *types.Account, amount types.Amount) error {
+	available := types.Amount{Value: account.Available(), Currency: account.Currency}
+	cmp, err := available.Cmp(amount)
+	if err != nil {
+		return err
+	}
+	if cmp < 0 {
+		return ErrNotEnoughBalance
+	}
 
+	balance, _ := balanceOf(account).Sub(amount)
+	account.Balance = balance.Value
+	return nil
 }
 
-func (s *Service) FindAccountByID(accountID int64) (*types.Account, error) {
-	for _, account := range s.accounts {
-		if account.ID == accountID {
-			return account, nil
-		}
+// credit зачисляет amount на баланс аккаунта.
+func credit(account *types.Account, amount types.Amount) error {
+	balance, err := balanceOf(account).Add(amount)
+	if err != nil {
+		return err
 	}
+	account.Balance = balance.Value
+	return nil
+}
 
-	return nil, ErrAccountNotFound
+// FindAccountByID возвращает копию аккаунта: её можно читать,
+// пока другие горутины меняют аккаунт.
+func (s *Service) FindAccountByID(accountID int64) (*types.Account, error) {
+	return findCopy(s, func(tx Tx) (*types.Account, error) { return tx.Account(accountID) })
 }
 
+// FindPaymentByID возвращает копию платежа, см. FindAccountByID.
 func (s *Service) FindPaymentByID(paymentID string) (*types.Payment, error) {
-	for _, payment := range s.payments {
-		if payment.ID == paymentID {
-			return payment, nil
+	return findCopy(s, func(tx Tx) (*types.Payment, error) { return tx.Payment(paymentID) })
+}
+
+// findCopy возвращает копию записи, которую find находит в транзакции.
+func findCopy[T any](s *Service, find func(tx Tx) (*T, error)) (*T, error) {
+	var record *T
+	err := s.repo().View(func(tx Tx) error {
+		found, err := find(tx)
+		if err != nil {
+			return err
 		}
+		copied := *found
+		record = &copied
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	return nil, ErrPaymentNotFound
+	return record, nil
+}
+
+// paymentAccountID возвращает ID аккаунта, которому принадлежит платёж.
+func (s *Service) paymentAccountID(paymentID string) (int64, error) {
+	var accountID int64
+	err := s.repo().View(func(tx Tx) error {
+		payment, err := tx.Payment(paymentID)
+		if err != nil {
+			return err
+		}
+		accountID = payment.AccountID
+		return nil
+	})
+	return accountID, err
 }
 
+// Reject отклоняет платёж в обработке и возвращает деньги на аккаунт.
 func (s *Service) Reject(paymentID string) error {
+	return s.changeStatus(paymentID, types.PaymentStatusFail)
+}
+
+// Confirm подтверждает платёж в обработке.
+func (s *Service) Confirm(paymentID string) error {
+	return s.changeStatus(paymentID, types.PaymentStatusOk)
+}
+
+// Cancel отменяет платёж в обработке по инициативе клиента
+// и возвращает деньги на аккаунт.
+func (s *Service) Cancel(paymentID string) error {
+	return s.changeStatus(paymentID, types.PaymentStatusCancelled)
+}
+
+func (s *Service) changeStatus(paymentID string, to types.PaymentStatus) error {
 	// find payment by ID
-	payment, err := s.FindPaymentByID(paymentID)
+	accountID, err := s.paymentAccountID(paymentID)
 	if err != nil {
 		return ErrPaymentNotFound
 	}
 
-	// find account by ID
-	account, err := s.FindAccountByID(payment.AccountID)
+	// lock account by ID
+	unlock, err := s.lockAccount(accountID)
 	if err != nil {
 		return ErrAccountNotFound
 	}
+	defer unlock()
 
-	// return to account
-	account.Balance += payment.Amount
-
-	// update payment status
-	payment.Status = types.PaymentStatusFail
-
-	return nil
+	return s.repo().Update(func(tx Tx) error {
+		payment, err := tx.Payment(paymentID)
+		if err != nil {
+			return ErrPaymentNotFound
+		}
+		return transition(tx, payment, to, s.stamp())
+	})
 }
 
 func (s *Service) Repeat(paymentID string) (*types.Payment, error) {
 	// find payment by ID
-	payment, err := s.FindPaymentByID(paymentID)
+	var payment types.Payment
+	err := s.repo().View(func(tx Tx) error {
+		found, err := tx.Payment(paymentID)
+		if err != nil {
+			return ErrPaymentNotFound
+		}
+		payment = *found
+		return nil
+	})
 	if err != nil {
-		return nil, ErrPaymentNotFound
+		return nil, err
 	}
 
-	// find account by ID
-	account, err := s.FindAccountByID(payment.AccountID)
-	if err != nil {
-		return nil, ErrAccountNotFound
+	// Повторить можно платёж в любом известном статусе
+	if !payment.Status.Valid() {
+		return nil, ErrInvalidPaymentStatus
 	}
 
-	result, err := s.Pay(account.ID, payment.Amount, payment.Category)
+	// Получатель получает ту же сумму, списание пересчитывается по текущему курсу
+	result, err := s.pay("", payment.AccountID, paymentTarget(&payment), payment.Category)
 	if err != nil {
 		return nil, err
 	}
@@ -170,72 +489,74 @@ func (s *Service) Repeat(paymentID string) (*types.Payment, error) {
 	return result, nil
 }
 
-func (s *Service) FavoritePayment(paymentID string, name string) (*types.Favorite, error) {
-	// Находим существующий платёж
-	payment, err := s.FindPaymentByID(paymentID)
-	if err != nil {
-		return nil, ErrPaymentNotFound
-	}
-
-	// Создаём новый элемент избранного
-	favorite := &types.Favorite{
-		ID:        uuid.New().String(),
-		AccountID: payment.AccountID,
-		Name:      name,
-		Amount:    payment.Amount,
-		Category:  payment.Category,
-	}
-
-	// Добавляем в список избранного
-	s.favorites = append(s.favorites, favorite)
-
-	return favorite, nil
+func (s *Service) PayFromFavorite(favoriteID string) (*types.Payment, error) {
+	return s.payFromFavorite("", favoriteID)
 }
 
-func (s *Service) PayFromFavorite(favoriteID string) (*types.Payment, error) {
+func (s *Service) payFromFavorite(key string, favoriteID string) (*types.Payment, error) {
 	// Находим элемент избранного
-	var favorite *types.Favorite
-	for _, f := range s.favorites {
-		if f.ID == favoriteID {
-			favorite = f
-			break
+	var accountID int64
+	err := s.repo().View(func(tx Tx) error {
+		favorite, err := tx.Favorite(favoriteID)
+		if err != nil {
+			return err
 		}
-	}
-	if favorite == nil {
-		return nil, errors.New("favorite not found")
+		accountID = favorite.AccountID
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	// Проверяем аккаунт
-	account, err := s.FindAccountByID(favorite.AccountID)
+	// Проверяем и блокируем аккаунт
+	unlock, err := s.lockAccount(accountID)
 	if err != nil {
 		return nil, ErrAccountNotFound
 	}
+	defer unlock()
 
-	// Проверяем баланс
-	if account.Balance < favorite.Amount {
-		return nil, ErrNotEnoughBalance
-	}
-
-	// Создаём платёж
-	payment := &types.Payment{
-		ID:        uuid.New().String(),
-		AccountID: favorite.AccountID,
-		Amount:    favorite.Amount,
-		Category:  favorite.Category,
-		Status:    types.PaymentStatusInProgress,
+	// Запрос строим по избранному под блокировкой аккаунта: UpdateFavorite
+	// берёт ту же блокировку, поэтому повтор с тем же ключом после изменения
+	// избранного даёт ErrIdempotencyConflict
+	favorite, err := s.FindFavoriteByID(favoriteID)
+	if err != nil {
+		return nil, err
 	}
+	request := fmt.Sprintf("favorite;%s;%d;%s;%s", favoriteID, favorite.Amount, favorite.Currency, favorite.Category)
 
-	// Обновляем баланс
-	account.Balance -= favorite.Amount
+	var payment *types.Payment
+	paymentID, err := s.idempotent(key, accountID, request, func(tx Tx) (string, error) {
+		favorite, err := tx.Favorite(favoriteID)
+		if err != nil {
+			return "", err
+		}
+		account, err := tx.Account(favorite.AccountID)
+		if err != nil {
+			return "", ErrAccountNotFound
+		}
 
-	// Добавляем платёж в список
-	s.payments = append(s.payments, payment)
+		// Проверяем баланс, списываем и создаём платёж
+		amount := types.Amount{Value: favorite.Amount, Currency: favorite.Currency}
+		payment, err = s.charge(tx, account, amount, favorite.Category)
+		if err != nil {
+			return "", err
+		}
+		return payment.ID, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if payment == nil {
+		return s.FindPaymentByID(paymentID)
+	}
 
 	return payment, nil
 }
 
 // Method for export Account to file
 func (s *Service) ExportToFile(path string) error {
+	s.global.Lock()
+	defer s.global.Unlock()
 
 	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
 	if err != nil {
@@ -244,9 +565,12 @@ func (s *Service) ExportToFile(path string) error {
 	defer file.Close()
 
 	var str string
-	for _, v := range s.accounts {
-		str += fmt.Sprint(v.ID) + ";" + string(v.Phone) + ";" + fmt.Sprint(v.Balance) + "|"
-	}
+	s.repo().View(func(tx Tx) error {
+		for _, v := range tx.Accounts() {
+			str += encodeRecord([]string{fmt.Sprint(v.ID), string(v.Phone), fmt.Sprint(v.Balance)}) + "|"
+		}
+		return nil
+	})
 	_, err = file.WriteString(str)
 
 	if err != nil {
@@ -256,293 +580,221 @@ func (s *Service) ExportToFile(path string) error {
 	return nil
 }
 
+// ImportFromFile загружает аккаунты из файла ExportToFile. Записи
+// проверяются так же, как в Import: при ошибках возвращается *ImportError
+// со всеми найденными проблемами, и Service не меняется.
 func (s *Service) ImportFromFile(path string) error {
-
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return err
 	}
 
-	str := strings.Split(string(data), "|")
-
-	for i := 0; i < len(str)-1; i++ {
-		str_item := strings.Split(str[i], ";")
-		id, _ := strconv.ParseInt(str_item[0], 10, 64)
-		balance, _ := strconv.ParseInt(str_item[2], 10, 64)
-		phone := (str_item[1])
+	s.global.Lock()
+	defer s.global.Unlock()
 
-		s.accounts = append(s.accounts, &types.Account{
-			ID:      id,
-			Phone:   types.Phone(phone),
-			Balance: types.Money(balance),
-		})
-	}
-
-	return nil
+	now := s.stamp()
+	return s.repo().Update(func(tx Tx) error {
+		batch := newImportBatch(ImportOptions{Mode: ImportUpsert})
+		batch.readAccountsFile(tx, filepath.Base(path), data, now)
+		_, err := batch.run(tx, false)
+		return err
+	})
 }
 
 // Метод Export сохраняет данные accounts, payments и favorites в файлы, если они существуют.
 func (s *Service) Export(dir string) error {
-	// Экспорт аккаунтов
-	if len(s.accounts) > 0 {
-		file, err := os.Create(filepath.Join(dir, "accounts.dump"))
-		if err != nil {
-			return err
-		}
-		defer file.Close()
-
-		for _, account := range s.accounts {
-			_, err := fmt.Fprintf(file, "%d;%s;%d\n", account.ID, account.Phone, account.Balance)
-			if err != nil {
-				return err
-			}
-		}
-	}
-
-	// Экспорт платежей
-	if len(s.payments) > 0 {
-		file, err := os.Create(filepath.Join(dir, "payments.dump"))
-		if err != nil {
-			return err
-		}
-		defer file.Close()
-
-		for _, payment := range s.payments {
-			_, err := fmt.Fprintf(file, "%s;%d;%d;%s;%s\n", payment.ID, payment.AccountID, payment.Amount, payment.Category, payment.Status)
-			if err != nil {
-				return err
-			}
-		}
-	}
+	return s.ExportWithOptions(dir, ExportOptions{Format: FormatDump})
+}
 
-	// Экспорт избранного
-	if len(s.favorites) > 0 {
-		file, err := os.Create(filepath.Join(dir, "favorites.dump"))
-		if err != nil {
-			return err
-		}
-		defer file.Close()
+// ExportOptions - параметры ExportWithOptions и HistoryToFilesWithOptions.
+type ExportOptions struct {
+	Format FileFormat
+}
 
-		for _, favorite := range s.favorites {
-			_, err := fmt.Fprintf(file, "%s;%d;%s;%d;%s\n", favorite.ID, favorite.AccountID, favorite.Name, favorite.Amount, favorite.Category)
-			if err != nil {
-				return err
-			}
-		}
-	}
+// ExportWithOptions сохраняет данные, как Export, в формате options.Format.
+func (s *Service) ExportWithOptions(dir string, options ExportOptions) error {
+	s.global.Lock()
+	defer s.global.Unlock()
 
-	return nil
+	return s.repo().View(func(tx Tx) error {
+		return exportDir(tx, dir, options.Format)
+	})
 }
 
 // Метод Import загружает данные из файлов, обновляя существующие записи и добавляя новые.
+// Сначала все файлы разбираются и проверяются; если найдены ошибки, возвращается
+// *ImportError со списком всех ошибок и ничего не меняется. Иначе записи
+// применяются одной транзакцией.
 func (s *Service) Import(dir string) error {
-	// Импорт аккаунтов
-	file, err := os.Open(filepath.Join(dir, "accounts.dump"))
-	if err == nil {
-		defer file.Close()
-		scanner := bufio.NewScanner(file)
-
-		for scanner.Scan() {
-			var id int64
-			var phone types.Phone
-			var balance types.Money
-
-			parts := strings.Split(scanner.Text(), ";")
-			if len(parts) != 3 {
-				return errors.New("invalid accounts file format")
-			}
-
-			id, _ = strconv.ParseInt(parts[0], 10, 64)
-			phone = types.Phone(parts[1])
-			val, err := strconv.ParseInt(parts[2], 10, 64)
-			if err != nil {
-				return err // обработка ошибки
-			}
-			balance = types.Money(val) // Явное приведение типа
-
-			account, err := s.FindAccountByID(id)
-			if err == ErrAccountNotFound {
-				s.accounts = append(s.accounts, &types.Account{ID: id, Phone: phone, Balance: balance})
-			} else {
-				account.Balance = balance
-			}
-
-			if id > s.nextAccountID {
-				s.nextAccountID = id
-			}
-		}
-	}
-
-	// Импорт платежей
-	file, err = os.Open(filepath.Join(dir, "payments.dump"))
-	if err == nil {
-		defer file.Close()
-		scanner := bufio.NewScanner(file)
-
-		for scanner.Scan() {
-			var id string
-			var accountID int64
-			var amount types.Money
-			var category types.PaymentCategory
-			var status types.PaymentStatus
-
-			parts := strings.Split(scanner.Text(), ";")
-			if len(parts) != 5 {
-				return errors.New("invalid payments file format")
-			}
+	_, err := s.ImportWithOptions(dir, ImportOptions{Mode: ImportUpsert})
+	return err
+}
 
-			id = parts[0]
-			accountID, _ = strconv.ParseInt(parts[1], 10, 64)
+// ImportWithOptions загружает данные из файлов, как Import, в режиме options.Mode
+// и возвращает отчёт о том, что сделано с каждой записью. С options.DryRun
+// отчёт строится, но Service не меняется.
+func (s *Service) ImportWithOptions(dir string, options ImportOptions) (*ImportReport, error) {
+	s.global.Lock()
+	defer s.global.Unlock()
 
-			val, err := strconv.ParseInt(parts[2], 10, 64)
-			if err != nil {
-				return err // обработка ошибки
-			}
-			amount = types.Money(val) // Явное приведение типа
-
-			category = types.PaymentCategory(parts[3])
-			status = types.PaymentStatus(parts[4])
-
-			s.payments = append(s.payments, &types.Payment{
-				ID:        id,
-				AccountID: accountID,
-				Amount:    amount,
-				Category:  category,
-				Status:    status,
-			})
-		}
+	run := s.repo().Update
+	if options.DryRun {
+		run = s.repo().View
 	}
 
-	// Импорт избранного
-	file, err = os.Open(filepath.Join(dir, "favorites.dump"))
-	if err == nil {
-		defer file.Close()
-		scanner := bufio.NewScanner(file)
-
-		for scanner.Scan() {
-			var id string
-			var accountID int64
-			var name string
-			var amount types.Money
-			var category types.PaymentCategory
-
-			parts := strings.Split(scanner.Text(), ";")
-			if len(parts) != 5 {
-				return errors.New("invalid favorites file format")
-			}
-
-			id = parts[0]
-			accountID, _ = strconv.ParseInt(parts[1], 10, 64)
-			name = parts[2]
-
-			val, err := strconv.ParseInt(parts[2], 10, 64)
-			if err != nil {
-				return err // обработка ошибки
-			}
-			amount = types.Money(val) // Явное приведение типа
-
-			category = types.PaymentCategory(parts[4])
-
-			s.favorites = append(s.favorites, &types.Favorite{
-				ID:        id,
-				AccountID: accountID,
-				Name:      name,
-				Amount:    amount,
-				Category:  category,
-			})
-		}
+	var report *ImportReport
+	err := run(func(tx Tx) error {
+		var err error
+		report, err = importDir(tx, dir, options)
+		return err
+	})
+	if err != nil {
+		return nil, err
 	}
-
-	return nil
+	return report, nil
 }
 
 // Этот метод получает историю платежей конкретного аккаунта.
+// Переводы попадают в историю после платежей, возвраты - после переводов,
+// см. transferHistory и refundHistory.
+// Записи несут время создания, изменения и смены статуса.
 func (s *Service) ExportAccountHistory(accountID int64) ([]types.Payment, error) {
-	account, err := s.FindAccountByID(accountID)
+	unlock, err := s.lockAccount(accountID)
 	if err != nil {
 		return nil, ErrAccountNotFound
 	}
+	defer unlock()
 
 	var history []types.Payment
-	for _, payment := range s.payments {
-		if payment.AccountID == account.ID {
-			history = append(history, *payment)
-		}
-	}
+	s.repo().View(func(tx Tx) error {
+		history = accountHistory(tx, accountID)
+		return nil
+	})
 
 	return history, nil
 }
 
+func accountHistory(tx Tx, accountID int64) []types.Payment {
+	var history []types.Payment
+	for _, payment := range tx.AccountPayments(accountID) {
+		history = append(history, *payment)
+	}
+	history = append(history, transferHistory(accountID, tx.AccountTransfers(accountID))...)
+	return append(history, refundHistory(tx.AccountRefunds(accountID))...)
+}
+
 // Метод сохраняет историю платежей в файлы с разделением на части.
 func (s *Service) HistoryToFiles(payments []types.Payment, dir string, records int) error {
+	return s.HistoryToFilesWithOptions(payments, dir, records, ExportOptions{Format: FormatDump})
+}
+
+// HistoryToFilesWithOptions сохраняет историю, как HistoryToFiles, в формате options.Format.
+// При records <= 0 возвращается ErrInvalidRecordsPerFile, и файлы не создаются.
+func (s *Service) HistoryToFilesWithOptions(payments []types.Payment, dir string, records int, options ExportOptions) error {
+	if records <= 0 {
+		return ErrInvalidRecordsPerFile
+	}
 	if len(payments) == 0 {
 		return nil
 	}
 
-	fileCount := 1
-	var file *os.File
-	var err error
-	var writer *bufio.Writer
-
-	for i, payment := range payments {
-		if i%records == 0 {
-			if file != nil {
-				writer.Flush()
-				file.Close()
-			}
-
-			filename := filepath.Join(dir, fmt.Sprintf("payments%d.dump", fileCount))
-			if fileCount == 1 && len(payments) <= records {
-				filename = filepath.Join(dir, "payments.dump")
-			}
+	for start, part := 0, 1; start < len(payments); start, part = start+records, part+1 {
+		end := start + records
+		if end > len(payments) {
+			end = len(payments)
+		}
 
-			file, err = os.Create(filename)
-			if err != nil {
-				return err
-			}
-			writer = bufio.NewWriter(file)
-			fileCount++
+		filename := filepath.Join(dir, historyPart(part, options.Format))
+		if len(payments) <= records {
+			filename = filepath.Join(dir, paymentsDump.file(options.Format))
 		}
 
-		_, err := writer.WriteString(fmt.Sprintf("%s;%d;%d;%s;%s\n",
-			payment.ID, payment.AccountID, payment.Amount, payment.Category, payment.Status))
-		if err != nil {
+		records := make([][]string, 0, end-start)
+		for i := range payments[start:end] {
+			records = append(records, paymentRecord(&payments[start+i]))
+		}
+		if err := writeFile(filename, paymentsDump, options.Format, records); err != nil {
 			return err
 		}
 	}
 
-	if file != nil {
-		writer.Flush()
-		file.Close()
+	return nil
+}
+
+// historyPart возвращает имя файла части истории с номером part.
+func historyPart(part int, format FileFormat) string {
+	return fmt.Sprintf("payments%d.%s", part, format)
+}
+
+// HistoryFromFiles читает историю, сохранённую HistoryToFilesWithOptions
+// в формате format. Записи проверяются так же, как в Import; при ошибках
+// возвращается *ImportError.
+func (s *Service) HistoryFromFiles(dir string, format FileFormat) ([]types.Payment, error) {
+	batch := newImportBatch(ImportOptions{Format: format})
+
+	single := filepath.Join(dir, paymentsDump.file(format))
+	if _, err := os.Stat(single); !errors.Is(err, fs.ErrNotExist) {
+		batch.readPayments(single)
+	} else {
+		for part := 1; ; part++ {
+			path := filepath.Join(dir, historyPart(part, format))
+			if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+				break
+			}
+			batch.readPayments(path)
+		}
+	}
+	if len(batch.problems) > 0 {
+		return nil, &ImportError{Problems: batch.problems}
+	}
+
+	history := make([]types.Payment, 0, len(batch.payments))
+	for _, payment := range batch.payments {
+		history = append(history, *payment)
 	}
+	return history, nil
+}
 
-	return nil
+// snapshotPayments возвращает копии всех платежей.
+func (s *Service) snapshotPayments() []types.Payment {
+	var payments []types.Payment
+	s.repo().View(func(tx Tx) error {
+		for _, payment := range tx.Payments() {
+			payments = append(payments, *payment)
+		}
+		return nil
+	})
+	return payments
 }
 
 // Method Gorountin SumPAyments
-func (s *Service) SumPayments(gorountines int) types.Money {
+// Суммы платежей в разных валютах не складываются: результат - сумма по каждой валюте.
+func (s *Service) SumPayments(gorountines int) map[types.Currency]types.Money {
+	all := s.snapshotPayments()
 	wg := sync.WaitGroup{}
 	mu := sync.Mutex{}
-	sum := int64(0)
+	sum := make(map[types.Currency]types.Money)
 	qnt := 0
 	i := 0
 
 	if gorountines == 0 {
-		qnt = len(s.payments)
+		qnt = len(all)
 	} else {
-		qnt = int(len(s.payments) / gorountines)
+		qnt = int(len(all) / gorountines)
 	}
 	for i = 0; i < gorountines-1; i++ {
 		wg.Add(1)
 		go func(index int) {
 			defer wg.Done()
-			val := int64(0)
-			payments := s.payments[index*qnt : (index+1)*qnt]
+			val := make(map[types.Currency]types.Money)
+			payments := all[index*qnt : (index+1)*qnt]
 			for _, payment := range payments {
-				val += int64(payment.Amount)
+				val[payment.Currency] += payment.Amount
 			}
 			mu.Lock()
-			sum += val
+			for currency, amount := range val {
+				sum[currency] += amount
+			}
 			mu.Unlock()
 		}(i)
 	}
@@ -551,53 +803,50 @@ func (s *Service) SumPayments(gorountines int) types.Money {
 
 	go func() {
 		defer wg.Done()
-		val := int64(0)
-		payments := s.payments[i*qnt:]
+		val := make(map[types.Currency]types.Money)
+		payments := all[i*qnt:]
 		for _, payment := range payments {
-			val += int64(payment.Amount)
+			val[payment.Currency] += payment.Amount
 		}
 		mu.Lock()
-		sum += val
+		for currency, amount := range val {
+			sum[currency] += amount
+		}
 		mu.Unlock()
 	}()
 	wg.Wait()
 
-	return types.Money(sum)
+	return sum
 
 }
 
 // Method Groroutines Filter
 func (s *Service) FilterPayments(accountID int64, gorountines int) ([]types.Payment, error) {
-	_, err := s.FindAccountByID(accountID)
+	unlock, err := s.lockAccount(accountID)
 	if err != nil {
 		return nil, ErrAccountNotFound
 	}
+	defer unlock()
+	all := s.snapshotPayments()
 	wg := sync.WaitGroup{}
 	mu := sync.Mutex{}
 	paymentAccount := []types.Payment{}
 	qnt := 0
 	i := 0
 	if gorountines == 0 {
-		qnt = len(s.payments)
+		qnt = len(all)
 	} else {
-		qnt = int(len(s.payments) / gorountines)
+		qnt = int(len(all) / gorountines)
 	}
 	for i = 0; i < gorountines-1; i++ {
 		wg.Add(1)
 		go func(index int) {
 			defer wg.Done()
-			payments := s.payments[index*qnt : (index+1)*qnt]
+			payments := all[index*qnt : (index+1)*qnt]
 			for _, payment := range payments {
 				mu.Lock()
 				if accountID == payment.AccountID {
-
-					paymentAccount = append(paymentAccount, types.Payment{
-						ID:        payment.ID,
-						AccountID: payment.AccountID,
-						Amount:    payment.Amount,
-						Category:  payment.Category,
-						Status:    payment.Status,
-					})
+					paymentAccount = append(paymentAccount, payment)
 				}
 				mu.Unlock()
 			}
@@ -606,17 +855,11 @@ func (s *Service) FilterPayments(accountID int64, gorountines int) ([]types.Paym
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		payments := s.payments[i*qnt:]
+		payments := all[i*qnt:]
 		for _, payment := range payments {
 			mu.Lock()
 			if accountID == payment.AccountID {
-				paymentAccount = append(paymentAccount, types.Payment{
-					ID:        payment.ID,
-					AccountID: payment.AccountID,
-					Amount:    payment.Amount,
-					Category:  payment.Category,
-					Status:    payment.Status,
-				})
+				paymentAccount = append(paymentAccount, payment)
 			}
 			mu.Unlock()
 		}
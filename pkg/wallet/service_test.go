@@ -1,15 +1,93 @@
 package wallet
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/akmalsulaymonov/alif-wallet/pkg/types"
 )
 
+// Вспомогательные функции для доступа к содержимому хранилища сервиса.
+func serviceAccounts(s *Service) (accounts []*types.Account) {
+	s.repo().View(func(tx Tx) error {
+		accounts = tx.Accounts()
+		return nil
+	})
+	return accounts
+}
+
+func servicePayments(s *Service) (payments []*types.Payment) {
+	s.repo().View(func(tx Tx) error {
+		payments = tx.Payments()
+		return nil
+	})
+	return payments
+}
+
+func serviceFavorites(s *Service) (favorites []*types.Favorite) {
+	s.repo().View(func(tx Tx) error {
+		favorites = tx.Favorites()
+		return nil
+	})
+	return favorites
+}
+
+func serviceTransfers(s *Service) (transfers []*types.Transfer) {
+	s.repo().View(func(tx Tx) error {
+		transfers = tx.Transfers()
+		return nil
+	})
+	return transfers
+}
+
+func serviceRefunds(s *Service) (refunds []*types.Refund) {
+	s.repo().View(func(tx Tx) error {
+		refunds = tx.Refunds()
+		return nil
+	})
+	return refunds
+}
+
+func serviceSchedules(s *Service) (schedules []*types.Schedule, runs []*types.ScheduleRun) {
+	s.repo().View(func(tx Tx) error {
+		schedules = tx.Schedules()
+		runs = tx.ScheduleRuns()
+		return nil
+	})
+	return schedules, runs
+}
+
+func serviceHolds(s *Service) (holds []*types.Hold) {
+	s.repo().View(func(tx Tx) error {
+		holds = tx.Holds()
+		return nil
+	})
+	return holds
+}
+
+// accountBalance и paymentStatus читают текущее состояние записей:
+// методы Service возвращают копии, которые не меняются вместе с сервисом.
+func accountBalance(s *Service, accountID int64) types.Money {
+	account, err := s.FindAccountByID(accountID)
+	if err != nil {
+		return 0
+	}
+	return account.Balance
+}
+
+func paymentStatus(s *Service, paymentID string) types.PaymentStatus {
+	payment, err := s.FindPaymentByID(paymentID)
+	if err != nil {
+		return ""
+	}
+	return payment.Status
+}
+
 func TestService_FindAccountByID_Success(t *testing.T) {
 	// Инициализация сервиса
 	s := &Service{}
@@ -26,10 +104,16 @@ func TestService_FindAccountByID_Success(t *testing.T) {
 		t.Fatalf("expected nil error, got %v", err)
 	}
 
-	// Проверка, что указатель на аккаунт совпадает
-	if foundAccount != account {
+	// Проверка, что найден тот же аккаунт
+	if *foundAccount != *account {
 		t.Errorf("expected account %v, got %v", account, foundAccount)
 	}
+
+	// Найденный аккаунт - копия, её изменения не попадают в сервис
+	foundAccount.Balance = 100
+	if again, _ := s.FindAccountByID(account.ID); again.Balance != 0 {
+		t.Errorf("expected balance %v, got %v", 0, again.Balance)
+	}
 }
 
 func TestService_FindAccountByID_NotFound(t *testing.T) {
@@ -77,11 +161,13 @@ func TestService_Reject_Success(t *testing.T) {
 	}
 
 	// Проверяем статус платежа
+	payment, _ = s.FindPaymentByID(payment.ID)
 	if payment.Status != types.PaymentStatusFail {
 		t.Errorf("expected payment status %v, got %v", types.PaymentStatusFail, payment.Status)
 	}
 
 	// Проверяем баланс аккаунта
+	account, _ = s.FindAccountByID(account.ID)
 	if account.Balance != 1000 {
 		t.Errorf("expected account balance %v, got %v", 1000, account.Balance)
 	}
@@ -220,6 +306,32 @@ func TestService_PayFromFavorite(t *testing.T) {
 	}
 }
 
+func TestService_AccountFavorites(t *testing.T) {
+	s := &Service{}
+
+	first, _ := s.RegisterAccount("12345")
+	second, _ := s.RegisterAccount("54321")
+	s.Deposit(first.ID, 1000)
+	s.Deposit(second.ID, 1000)
+
+	payment, _ := s.Pay(first.ID, 500, "food")
+	other, _ := s.Pay(second.ID, 300, "car")
+	favorite, _ := s.FavoritePayment(payment.ID, "Favorite")
+	s.FavoritePayment(other.ID, "Other")
+
+	favorites, err := s.AccountFavorites(first.ID)
+	if err != nil {
+		t.Fatalf("failed to list favorites: %v", err)
+	}
+	if len(favorites) != 1 || favorites[0] != *favorite {
+		t.Errorf("expected favorites [%v], got %v", *favorite, favorites)
+	}
+
+	if _, err := s.AccountFavorites(999); err != ErrAccountNotFound {
+		t.Errorf("expected error %v, got %v", ErrAccountNotFound, err)
+	}
+}
+
 func TestService_Export_success_user(t *testing.T) {
 	s := &Service{}
 
@@ -257,11 +369,12 @@ func TestService_ExportImport(t *testing.T) {
 
 	service := &Service{}
 	acc, _ := service.RegisterAccount("+123456789")
+	service.Deposit(acc.ID, 1000)
 	pay, _ := service.Pay(acc.ID, 100, "Food")
 	service.FavoritePayment(pay.ID, "Lunch")
 
 	err := service.Export(dir)
-	if len(service.accounts) == 0 {
+	if len(serviceAccounts(service)) == 0 {
 		t.Fatalf("no accounts to export")
 	}
 	if err != nil {
@@ -274,13 +387,13 @@ func TestService_ExportImport(t *testing.T) {
 		t.Errorf("Import failed: %v", err)
 	}
 
-	if !reflect.DeepEqual(service.accounts, newService.accounts) {
+	if !reflect.DeepEqual(serviceAccounts(service), serviceAccounts(newService)) {
 		t.Errorf("Accounts mismatch")
 	}
-	if !reflect.DeepEqual(service.payments, newService.payments) {
+	if !reflect.DeepEqual(servicePayments(service), servicePayments(newService)) {
 		t.Errorf("Payments mismatch")
 	}
-	if !reflect.DeepEqual(service.favorites, newService.favorites) {
+	if !reflect.DeepEqual(serviceFavorites(service), serviceFavorites(newService)) {
 		t.Errorf("Favorites mismatch")
 	}
 }
@@ -288,6 +401,7 @@ func TestService_ExportImport(t *testing.T) {
 func TestService_ExportAccountHistory(t *testing.T) {
 	service := &Service{}
 	acc, _ := service.RegisterAccount("+123456789")
+	service.Deposit(acc.ID, 1000)
 	service.Pay(acc.ID, 100, "Food")
 	service.Pay(acc.ID, 200, "Transport")
 
@@ -307,6 +421,7 @@ func TestService_HistoryToFiles(t *testing.T) {
 
 	service := &Service{}
 	acc, _ := service.RegisterAccount("+123456789")
+	service.Deposit(acc.ID, 1000)
 	service.Pay(acc.ID, 100, "Food")
 	service.Pay(acc.ID, 200, "Transport")
 
@@ -322,6 +437,26 @@ func TestService_HistoryToFiles(t *testing.T) {
 	}
 }
 
+func TestService_HistoryToFiles_InvalidRecords(t *testing.T) {
+	service := &Service{}
+	acc, _ := service.RegisterAccount("+123456789")
+	service.Deposit(acc.ID, 1000)
+	service.Pay(acc.ID, 100, "Food")
+	service.Pay(acc.ID, 200, "Transport; \"такси\"")
+	history, _ := service.ExportAccountHistory(acc.ID)
+
+	for _, records := range []int{0, -1} {
+		dir := t.TempDir()
+		err := service.HistoryToFiles(history, dir, records)
+		if !errors.Is(err, ErrInvalidRecordsPerFile) {
+			t.Errorf("records %d: expected ErrInvalidRecordsPerFile, got %v", records, err)
+		}
+		if files, _ := os.ReadDir(dir); len(files) != 0 {
+			t.Errorf("records %d: expected no files, got %d", records, len(files))
+		}
+	}
+}
+
 func BenchmarkSumPayment_success(b *testing.B) {
 
 	var svc Service
@@ -335,7 +470,7 @@ func BenchmarkSumPayment_success(b *testing.B) {
 	_, _ = svc.Pay(account.ID, 30, "ALif")
 
 	want := 60
-	got := svc.SumPayments(2)
+	got := svc.SumPayments(2)[types.TJS]
 	if want != int(got) {
 		b.Errorf("Error,want=>%v got=> %v", want, got)
 	}
@@ -364,3 +499,78 @@ func BenchmarkFilterPayment_success(b *testing.B) {
 	}
 
 }
+
+func TestService_FilterPayments(t *testing.T) {
+	clock := newTestClock()
+	s := &Service{}
+	s.SetClock(clock.Now)
+	account, _ := s.RegisterAccountWithCurrency("+992000000001", types.USD)
+	other, _ := s.RegisterAccount("+992000000002")
+	s.Deposit(account.ID, 1000)
+	s.Deposit(other.ID, 1000)
+	payment, _ := s.Pay(account.ID, 100, "food")
+	s.Pay(other.ID, 100, "food")
+
+	got, err := s.FilterPayments(account.ID, 2)
+	if err != nil {
+		t.Fatalf("FilterPayments failed: %v", err)
+	}
+	// Фильтр возвращает платежи целиком, с валютой и временем
+	if len(got) != 1 || got[0] != *payment {
+		t.Errorf("expected %+v, got %+v", *payment, got)
+	}
+}
+
+func TestService_Timestamps(t *testing.T) {
+	clock := newTestClock()
+	s := &Service{}
+	s.SetClock(clock.Now)
+	start := clock.Now()
+
+	account, _ := s.RegisterAccount("+992000000001")
+	other, _ := s.RegisterAccount("+992000000002")
+	clock.Advance(time.Minute)
+	s.Deposit(account.ID, 1000)
+	s.Deposit(other.ID, 100)
+	clock.Advance(time.Minute)
+	payment, _ := s.Pay(account.ID, 100, "food")
+	favorite, _ := s.FavoritePayment(payment.ID, "Обед")
+	clock.Advance(time.Minute)
+	s.Confirm(payment.ID)
+	clock.Advance(time.Minute)
+	transfer, _ := s.Transfer(other.ID, account.ID, 50, "")
+
+	minute := func(n int) time.Time { return start.Add(time.Duration(n) * time.Minute) }
+	got, _ := s.FindAccountByID(account.ID)
+	if !got.CreatedAt.Equal(minute(0)) || !got.UpdatedAt.Equal(minute(4)) {
+		t.Errorf("unexpected account times %v, %v", got.CreatedAt, got.UpdatedAt)
+	}
+	paid, _ := s.FindPaymentByID(payment.ID)
+	if !paid.CreatedAt.Equal(minute(2)) || !paid.UpdatedAt.Equal(minute(3)) || !paid.StatusChangedAt.Equal(minute(3)) {
+		t.Errorf("unexpected payment times %v, %v, %v", paid.CreatedAt, paid.UpdatedAt, paid.StatusChangedAt)
+	}
+	if !favorite.CreatedAt.Equal(minute(2)) {
+		t.Errorf("unexpected favorite time %v", favorite.CreatedAt)
+	}
+
+	history, _ := s.ExportAccountHistory(account.ID)
+	if len(history) != 2 || !history[0].StatusChangedAt.Equal(minute(3)) || !history[1].CreatedAt.Equal(transfer.CreatedAt) {
+		t.Errorf("unexpected history %+v", history)
+	}
+
+	// Время переживает Export и Import
+	dir := t.TempDir()
+	if err := s.Export(dir); err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	imported := &Service{}
+	if err := imported.Import(dir); err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+	if !reflect.DeepEqual(servicePayments(s), servicePayments(imported)) {
+		t.Errorf("payments differ: %v, %v", servicePayments(s), servicePayments(imported))
+	}
+	if restored, _ := imported.FindAccountByID(account.ID); *restored != *got {
+		t.Errorf("expected account %+v, got %+v", *got, *restored)
+	}
+}
@@ -0,0 +1,233 @@
+package wallet
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/akmalsulaymonov/alif-wallet/pkg/types"
+)
+
+var ErrUnknownLocale = errors.New("unknown locale")
+
+// StatementLine - операция выписки.
+type StatementLine struct {
+	Payment types.Payment
+	// Сумма со знаком: списания отрицательны, зачисления положительны.
+	// Для платежей, деньги по которым вернулись (FAIL, REFUNDED и т.д.),
+	// баланс не меняется, но сумма показывается. Исключение - платежи
+	// с возвратами RefundAmount: они списываются, а возвраты идут
+	// отдельными строками.
+	Amount types.Money
+	// Баланс после операции.
+	Balance types.Money
+}
+
+// Statement - выписка по аккаунту: история ExportAccountHistory с балансом
+// после каждой операции. Пополнения в историю не попадают, но меняют баланс
+// между операциями, поэтому Opening - баланс до первой операции истории,
+// Closing - текущий баланс.
+type Statement struct {
+	AccountID int64
+	Currency  types.Currency
+	Opening   types.Money
+	Closing   types.Money
+	Lines     []StatementLine
+}
+
+// Statement строит выписку по аккаунту. Балансы берутся из проводок по счёту
+// аккаунта в порядке записи: операция стоит на месте своей первой проводки
+// и меняет баланс на сумму всех своих проводок, так что возврат денег
+// по платежу (Reject, Cancel и т.д.) учитывается в строке платежа.
+// Платежи без проводок (импортированные) идут первыми и баланс не меняют:
+// он пришёл корректировкой импорта.
+func (s *Service) Statement(accountID int64) (*Statement, error) {
+	unlock, err := s.lockAccount(accountID)
+	if err != nil {
+		return nil, ErrAccountNotFound
+	}
+	defer unlock()
+
+	// Баланс, история и проводки читаются в одной транзакции, чтобы выписка сошлась
+	var account types.Account
+	var history []types.Payment
+	var entries []types.LedgerEntry
+	err = s.repo().View(func(tx Tx) error {
+		found, err := tx.Account(accountID)
+		if err != nil {
+			return err
+		}
+		account = *found
+		history = accountHistory(tx, accountID)
+		for _, entry := range tx.LedgerEntries(CustomerLedgerAccount(accountID)) {
+			entries = append(entries, *entry)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	lines := make(map[string]*StatementLine, len(history))
+	for _, payment := range history {
+		amount := -payment.Amount
+		if payment.Category == TransferInCategory || payment.Category == RefundCategory {
+			amount = payment.Amount
+		}
+		lines[payment.ID] = &StatementLine{Payment: payment, Amount: amount}
+	}
+
+	// Изменение баланса по каждой операции истории
+	changes := make(map[string]types.Money)
+	total := types.Money(0)
+	for _, entry := range entries {
+		amount := entry.Amount
+		if entry.Side == types.EntryDebit {
+			amount = -amount
+		}
+		if lines[entry.Reference] != nil {
+			changes[entry.Reference] += amount
+		}
+		total += amount
+	}
+
+	statement := &Statement{
+		AccountID: account.ID,
+		Currency:  account.Currency,
+		Closing:   account.Balance,
+	}
+	// Баланс, не объяснённый проводками (например, у старых данных без
+	// них), считаем начальным, чтобы выписка сошлась с текущим балансом
+	balance := account.Balance - total
+	add := func(line *StatementLine) {
+		if len(statement.Lines) == 0 {
+			statement.Opening = balance
+		}
+		balance += changes[line.Payment.ID]
+		line.Balance = balance
+		statement.Lines = append(statement.Lines, *line)
+	}
+
+	for _, payment := range history {
+		if _, posted := changes[payment.ID]; !posted {
+			add(lines[payment.ID])
+		}
+	}
+	added := make(map[string]bool)
+	for _, entry := range entries {
+		line := lines[entry.Reference]
+		if line == nil {
+			// Пополнение, корректировка импорта или проводка удалённой записи
+			if entry.Side == types.EntryDebit {
+				balance -= entry.Amount
+			} else {
+				balance += entry.Amount
+			}
+			continue
+		}
+		if !added[entry.Reference] {
+			added[entry.Reference] = true
+			add(line)
+		}
+	}
+	if len(statement.Lines) == 0 {
+		statement.Opening = balance
+	}
+	return statement, nil
+}
+
+// StatementOptions - параметры CSV-выписки.
+type StatementOptions struct {
+	// Locale - язык в виде "ru" или "ru-RU", по умолчанию "en". Задаёт
+	// десятичный разделитель сумм и разделитель полей по умолчанию.
+	Locale string
+	// Comma - разделитель полей вместо разделителя локали.
+	Comma rune
+	// BOM добавляет в начало метку UTF-8, без неё Excel
+	// показывает кириллицу неверно.
+	BOM bool
+}
+
+// statementLocale - разделители CSV для языка. Excel с десятичной
+// запятой ожидает поля через ';'.
+type statementLocale struct {
+	decimal rune
+	comma   rune
+}
+
+var statementLocales = map[string]statementLocale{
+	"en": {'.', ','},
+	"ru": {',', ';'},
+	"tg": {',', ';'},
+	"uz": {',', ';'},
+	"kk": {',', ';'},
+	"de": {',', ';'},
+	"fr": {',', ';'},
+	"es": {',', ';'},
+	"it": {',', ';'},
+	"tr": {',', ';'},
+}
+
+func lookupLocale(locale string) (statementLocale, error) {
+	if locale == "" {
+		return statementLocales["en"], nil
+	}
+	language, _, _ := strings.Cut(strings.ToLower(locale), "-")
+	language, _, _ = strings.Cut(language, "_")
+	format, ok := statementLocales[language]
+	if !ok {
+		return statementLocale{}, fmt.Errorf("%w %q", ErrUnknownLocale, locale)
+	}
+	return format, nil
+}
+
+// WriteCSV пишет выписку в CSV: строку заголовка, начальный баланс,
+// операции с балансом после каждой и конечный баланс. Суммы записываются
+// в единицах валюты, например "12.34" или "12,34".
+func (st *Statement) WriteCSV(w io.Writer, options StatementOptions) error {
+	locale, err := lookupLocale(options.Locale)
+	if err != nil {
+		return err
+	}
+	if options.BOM {
+		if _, err := io.WriteString(w, "\ufeff"); err != nil {
+			return err
+		}
+	}
+
+	writer := csv.NewWriter(w)
+	writer.Comma = locale.comma
+	if options.Comma != 0 {
+		writer.Comma = options.Comma
+	}
+	format := func(amount types.Money) string {
+		return strings.Replace(st.Currency.Format(amount), ".", string(locale.decimal), 1)
+	}
+	currency := string(st.Currency)
+
+	rows := [][]string{
+		{"ID", "Category", "Status", "Amount", "Currency", "Balance"},
+		{"Opening balance", "", "", "", currency, format(st.Opening)},
+	}
+	for _, line := range st.Lines {
+		rows = append(rows, []string{
+			line.Payment.ID,
+			string(line.Payment.Category),
+			string(line.Payment.Status),
+			format(line.Amount),
+			currency,
+			format(line.Balance),
+		})
+	}
+	rows = append(rows, []string{"Closing balance", "", "", "", currency, format(st.Closing)})
+
+	for _, row := range rows {
+		if err := writer.Write(row); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
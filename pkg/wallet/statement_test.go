@@ -0,0 +1,152 @@
+package wallet
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/akmalsulaymonov/alif-wallet/pkg/types"
+)
+
+func statementFixture(t *testing.T) (*Service, int64) {
+	t.Helper()
+	s := &Service{}
+	account, _ := s.RegisterAccount("+992000000001")
+	other, _ := s.RegisterAccount("+992000000002")
+	s.Deposit(account.ID, 1000)
+	s.Deposit(other.ID, 1000)
+	s.Pay(account.ID, 100, "food")
+	failed, _ := s.Pay(account.ID, 200, "Аренда; кв. 2")
+	s.Reject(failed.ID)
+	if _, err := s.Transfer(other.ID, account.ID, 50, ""); err != nil {
+		t.Fatalf("failed to transfer: %v", err)
+	}
+	if _, err := s.Transfer(account.ID, other.ID, 30, ""); err != nil {
+		t.Fatalf("failed to transfer: %v", err)
+	}
+	return s, account.ID
+}
+
+func TestService_Statement(t *testing.T) {
+	s, accountID := statementFixture(t)
+
+	statement, err := s.Statement(accountID)
+	if err != nil {
+		t.Fatalf("Statement failed: %v", err)
+	}
+	if statement.Opening != 1000 || statement.Closing != 920 {
+		t.Errorf("expected balances 1000 -> 920, got %v -> %v", statement.Opening, statement.Closing)
+	}
+
+	want := []struct {
+		amount  types.Money
+		balance types.Money
+	}{{-100, 900}, {-200, 900}, {50, 950}, {-30, 920}}
+	if len(statement.Lines) != len(want) {
+		t.Fatalf("expected %d lines, got %+v", len(want), statement.Lines)
+	}
+	for i, w := range want {
+		if line := statement.Lines[i]; line.Amount != w.amount || line.Balance != w.balance {
+			t.Errorf("line %d: expected %v/%v, got %v/%v", i, w.amount, w.balance, line.Amount, line.Balance)
+		}
+	}
+
+	if _, err := s.Statement(42); err != ErrAccountNotFound {
+		t.Errorf("expected error %v, got %v", ErrAccountNotFound, err)
+	}
+}
+
+func TestService_Statement_DepositsBetweenPayments(t *testing.T) {
+	s := &Service{}
+	account, _ := s.RegisterAccount("+992000000001")
+	s.Deposit(account.ID, 1000)
+	first, _ := s.Pay(account.ID, 300, "food")
+	s.Deposit(account.ID, 500)
+	second, _ := s.Pay(account.ID, 100, "food")
+	failed, _ := s.Pay(account.ID, 200, "food")
+	s.Deposit(account.ID, 50)
+	// Возврат денег по платежу учитывается в его строке
+	s.Reject(failed.ID)
+
+	statement, err := s.Statement(account.ID)
+	if err != nil {
+		t.Fatalf("Statement failed: %v", err)
+	}
+	if statement.Opening != 1000 || statement.Closing != 1150 {
+		t.Errorf("expected balances 1000 -> 1150, got %v -> %v", statement.Opening, statement.Closing)
+	}
+
+	want := []struct {
+		id      string
+		amount  types.Money
+		balance types.Money
+	}{{first.ID, -300, 700}, {second.ID, -100, 1100}, {failed.ID, -200, 1100}}
+	if len(statement.Lines) != len(want) {
+		t.Fatalf("expected %d lines, got %+v", len(want), statement.Lines)
+	}
+	for i, w := range want {
+		if line := statement.Lines[i]; line.Payment.ID != w.id || line.Amount != w.amount || line.Balance != w.balance {
+			t.Errorf("line %d: expected %v %v/%v, got %v %v/%v", i, w.id, w.amount, w.balance, line.Payment.ID, line.Amount, line.Balance)
+		}
+	}
+}
+
+func TestStatement_WriteCSV(t *testing.T) {
+	s, accountID := statementFixture(t)
+	statement, _ := s.Statement(accountID)
+	failedID := statement.Lines[1].Payment.ID
+
+	tests := []struct {
+		name    string
+		options StatementOptions
+		lines   []string
+	}{
+		{"default", StatementOptions{}, []string{
+			"ID,Category,Status,Amount,Currency,Balance",
+			"Opening balance,,,,TJS,10.00",
+			failedID + ",Аренда; кв. 2,FAIL,-2.00,TJS,9.00",
+			"Closing balance,,,,TJS,9.20",
+		}},
+		{"ru", StatementOptions{Locale: "ru_RU"}, []string{
+			"ID;Category;Status;Amount;Currency;Balance",
+			"Opening balance;;;;TJS;10,00",
+			failedID + ";\"Аренда; кв. 2\";FAIL;-2,00;TJS;9,00",
+			"Closing balance;;;;TJS;9,20",
+		}},
+		{"comma", StatementOptions{Locale: "de", Comma: '\t'}, []string{
+			"ID\tCategory\tStatus\tAmount\tCurrency\tBalance",
+			"Opening balance\t\t\t\tTJS\t10,00",
+			failedID + "\tАренда; кв. 2\tFAIL\t-2,00\tTJS\t9,00",
+			"Closing balance\t\t\t\tTJS\t9,20",
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b bytes.Buffer
+			if err := statement.WriteCSV(&b, tt.options); err != nil {
+				t.Fatalf("WriteCSV failed: %v", err)
+			}
+			lines := strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n")
+			if len(lines) != 7 {
+				t.Fatalf("expected 7 lines, got %q", lines)
+			}
+			got := []string{lines[0], lines[1], lines[3], lines[6]}
+			for i := range got {
+				if got[i] != tt.lines[i] {
+					t.Errorf("expected %q, got %q", tt.lines[i], got[i])
+				}
+			}
+		})
+	}
+
+	var b bytes.Buffer
+	statement.WriteCSV(&b, StatementOptions{BOM: true})
+	if !strings.HasPrefix(b.String(), "\ufeffID,") {
+		t.Errorf("expected BOM, got %q", b.String()[:10])
+	}
+	if err := statement.WriteCSV(&b, StatementOptions{Locale: "xx"}); !errors.Is(err, ErrUnknownLocale) {
+		t.Errorf("expected error %v, got %v", ErrUnknownLocale, err)
+	}
+}
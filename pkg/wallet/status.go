@@ -0,0 +1,76 @@
+package wallet
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/akmalsulaymonov/alif-wallet/pkg/types"
+)
+
+var ErrIllegalTransition = errors.New("illegal payment status transition")
+var ErrInvalidPaymentStatus = errors.New("invalid payment status")
+
+// TransitionError - попытка перевести платёж в недопустимый статус.
+// errors.Is(err, ErrIllegalTransition) для неё возвращает true.
+type TransitionError struct {
+	PaymentID string
+	From      types.PaymentStatus
+	To        types.PaymentStatus
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("payment %s: cannot change status from %s to %s", e.PaymentID, e.From, e.To)
+}
+
+func (e *TransitionError) Unwrap() error {
+	return ErrIllegalTransition
+}
+
+// Статусы, при переходе в которые сумма платежа возвращается на аккаунт.
+var refundStatuses = map[types.PaymentStatus]bool{
+	types.PaymentStatusFail:      true,
+	types.PaymentStatusCancelled: true,
+	types.PaymentStatusRefunded:  true,
+	types.PaymentStatusExpired:   true,
+}
+
+// setStatus переводит платёж в статус to в момент now, если переход разрешён.
+func setStatus(payment *types.Payment, to types.PaymentStatus, now time.Time) error {
+	if !payment.Status.CanTransitionTo(to) {
+		return &TransitionError{PaymentID: payment.ID, From: payment.Status, To: to}
+	}
+	payment.Status = to
+	payment.StatusChangedAt = now
+	payment.UpdatedAt = now
+	return nil
+}
+
+// transition переводит платёж в статус to и, если это возврат,
+// возвращает сумму платежа на аккаунт. Вызывается в транзакции
+// под блокировкой аккаунта платежа.
+func transition(tx Tx, payment *types.Payment, to types.PaymentStatus, now time.Time) error {
+	if err := setStatus(payment, to, now); err != nil {
+		return err
+	}
+	tx.PutPayment(payment)
+
+	if !refundStatuses[to] {
+		return nil
+	}
+
+	account, err := tx.Account(payment.AccountID)
+	if err != nil {
+		return ErrAccountNotFound
+	}
+	// Возвращается ровно списанная сумма, конвертация проводится обратно
+	amount := types.Amount{Value: payment.Amount, Currency: payment.Currency}
+	if err := credit(account, amount); err != nil {
+		return err
+	}
+	account.UpdatedAt = now
+	tx.PutAccount(account)
+	postExchange(tx, LedgerMerchantClearing, CustomerLedgerAccount(account.ID), paymentTarget(payment), amount, payment.ID)
+
+	return nil
+}
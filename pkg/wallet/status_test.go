@@ -0,0 +1,152 @@
+package wallet
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/akmalsulaymonov/alif-wallet/pkg/types"
+)
+
+func newPaidService(t *testing.T) (*Service, *types.Account, *types.Payment) {
+	t.Helper()
+	s := &Service{}
+	account, err := s.RegisterAccount("12345")
+	if err != nil {
+		t.Fatalf("failed to register account: %v", err)
+	}
+	s.Deposit(account.ID, 1000)
+	payment, err := s.Pay(account.ID, 500, "food")
+	if err != nil {
+		t.Fatalf("failed to create payment: %v", err)
+	}
+	return s, account, payment
+}
+
+func TestService_Reject_Twice(t *testing.T) {
+	s, account, payment := newPaidService(t)
+
+	if err := s.Reject(payment.ID); err != nil {
+		t.Fatalf("failed to reject payment: %v", err)
+	}
+
+	// Повторный Reject не возвращает деньги второй раз
+	err := s.Reject(payment.ID)
+	if !errors.Is(err, ErrIllegalTransition) {
+		t.Fatalf("expected error %v, got %v", ErrIllegalTransition, err)
+	}
+	var transitionErr *TransitionError
+	if !errors.As(err, &transitionErr) {
+		t.Fatalf("expected *TransitionError, got %T", err)
+	}
+	if transitionErr.From != types.PaymentStatusFail || transitionErr.To != types.PaymentStatusFail {
+		t.Errorf("unexpected transition %v -> %v", transitionErr.From, transitionErr.To)
+	}
+	if accountBalance(s, account.ID) != 1000 {
+		t.Errorf("expected account balance %v, got %v", 1000, accountBalance(s, account.ID))
+	}
+}
+
+func TestService_Confirm(t *testing.T) {
+	s, account, payment := newPaidService(t)
+
+	if err := s.Confirm(payment.ID); err != nil {
+		t.Fatalf("failed to confirm payment: %v", err)
+	}
+	if paymentStatus(s, payment.ID) != types.PaymentStatusOk {
+		t.Errorf("expected payment status %v, got %v", types.PaymentStatusOk, paymentStatus(s, payment.ID))
+	}
+
+	// Подтверждённый платёж нельзя отклонить или отменить
+	if err := s.Reject(payment.ID); !errors.Is(err, ErrIllegalTransition) {
+		t.Errorf("expected error %v, got %v", ErrIllegalTransition, err)
+	}
+	if err := s.Cancel(payment.ID); !errors.Is(err, ErrIllegalTransition) {
+		t.Errorf("expected error %v, got %v", ErrIllegalTransition, err)
+	}
+	if accountBalance(s, account.ID) != 500 {
+		t.Errorf("expected account balance %v, got %v", 500, accountBalance(s, account.ID))
+	}
+}
+
+func TestService_Refund(t *testing.T) {
+	s, account, payment := newPaidService(t)
+
+	// Вернуть можно только подтверждённый платёж
+	if err := s.Refund(payment.ID); !errors.Is(err, ErrIllegalTransition) {
+		t.Errorf("expected error %v, got %v", ErrIllegalTransition, err)
+	}
+
+	s.Confirm(payment.ID)
+	if err := s.Refund(payment.ID); err != nil {
+		t.Fatalf("failed to refund payment: %v", err)
+	}
+	if paymentStatus(s, payment.ID) != types.PaymentStatusRefunded {
+		t.Errorf("expected payment status %v, got %v", types.PaymentStatusRefunded, paymentStatus(s, payment.ID))
+	}
+	if accountBalance(s, account.ID) != 1000 {
+		t.Errorf("expected account balance %v, got %v", 1000, accountBalance(s, account.ID))
+	}
+
+	if err := s.Refund(payment.ID); !errors.Is(err, ErrIllegalTransition) {
+		t.Errorf("expected error %v, got %v", ErrIllegalTransition, err)
+	}
+	if report := s.CheckLedger(); !report.OK() {
+		t.Errorf("expected consistent ledger, got %+v", report)
+	}
+}
+
+func TestService_Cancel(t *testing.T) {
+	s, account, payment := newPaidService(t)
+
+	if err := s.Cancel(payment.ID); err != nil {
+		t.Fatalf("failed to cancel payment: %v", err)
+	}
+	if paymentStatus(s, payment.ID) != types.PaymentStatusCancelled {
+		t.Errorf("expected payment status %v, got %v", types.PaymentStatusCancelled, paymentStatus(s, payment.ID))
+	}
+	if accountBalance(s, account.ID) != 1000 {
+		t.Errorf("expected account balance %v, got %v", 1000, accountBalance(s, account.ID))
+	}
+	if err := s.Confirm(payment.ID); !errors.Is(err, ErrIllegalTransition) {
+		t.Errorf("expected error %v, got %v", ErrIllegalTransition, err)
+	}
+}
+
+func TestService_Repeat_InvalidStatus(t *testing.T) {
+	s, _, payment := newPaidService(t)
+
+	s.repo().Update(func(tx Tx) error {
+		p, _ := tx.Payment(payment.ID)
+		p.Status = "UNKNOWN"
+		tx.PutPayment(p)
+		return nil
+	})
+
+	if _, err := s.Repeat(payment.ID); err != ErrInvalidPaymentStatus {
+		t.Errorf("expected error %v, got %v", ErrInvalidPaymentStatus, err)
+	}
+}
+
+func TestPaymentStatus_Transitions(t *testing.T) {
+	terminal := []types.PaymentStatus{
+		types.PaymentStatusFail,
+		types.PaymentStatusCancelled,
+		types.PaymentStatusRefunded,
+		types.PaymentStatusExpired,
+	}
+	all := append([]types.PaymentStatus{types.PaymentStatusInProgress, types.PaymentStatusOk}, terminal...)
+
+	for _, from := range terminal {
+		for _, to := range all {
+			if from.CanTransitionTo(to) {
+				t.Errorf("terminal status %v must not change to %v", from, to)
+			}
+		}
+	}
+	if !types.PaymentStatusInProgress.CanTransitionTo(types.PaymentStatusExpired) {
+		t.Errorf("expected INPROGRESS -> EXPIRED to be allowed")
+	}
+	if types.PaymentStatus("UNKNOWN").Valid() {
+		t.Errorf("expected unknown status to be invalid")
+	}
+}
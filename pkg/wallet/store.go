@@ -0,0 +1,111 @@
+package wallet
+
+import "github.com/akmalsulaymonov/alif-wallet/pkg/types"
+
+// Store - хранилище состояния кошелька, на котором построен Service.
+//
+// Store отвечает за атомарность транзакций, но не за их изоляцию:
+// Service сам сериализует изменения одного аккаунта своими блокировками.
+type Store interface {
+	// View выполняет fn в транзакции только для чтения. Методы Tx возвращают
+	// сами хранимые записи, изменять их нельзя.
+	View(fn func(tx Tx) error) error
+
+	// Update выполняет fn в транзакции на запись. Методы Tx возвращают копии
+	// записей, изменения сохраняются через Put*. Если fn вернула ошибку,
+	// ни одно изменение не применяется.
+	Update(fn func(tx Tx) error) error
+}
+
+// Tx - операции над записями внутри транзакции.
+type Tx interface {
+	// NextAccountID возвращает ID для нового аккаунта.
+	NextAccountID() int64
+
+	Account(accountID int64) (*types.Account, error)
+	AccountByPhone(phone types.Phone) (*types.Account, error)
+	Accounts() []*types.Account
+	PutAccount(account *types.Account)
+	// Delete* удаляют запись, отсутствующая запись пропускается.
+	// Проводки удалённых записей сохраняются.
+	DeleteAccount(accountID int64)
+
+	Payment(paymentID string) (*types.Payment, error)
+	Payments() []*types.Payment
+	AccountPayments(accountID int64) []*types.Payment
+	PutPayment(payment *types.Payment)
+	DeletePayment(paymentID string)
+
+	Favorite(favoriteID string) (*types.Favorite, error)
+	Favorites() []*types.Favorite
+	AccountFavorites(accountID int64) []*types.Favorite
+	PutFavorite(favorite *types.Favorite)
+	DeleteFavorite(favoriteID string)
+
+	Transfer(transferID string) (*types.Transfer, error)
+	Transfers() []*types.Transfer
+	// AccountTransfers возвращает входящие и исходящие переводы аккаунта.
+	AccountTransfers(accountID int64) []*types.Transfer
+	PutTransfer(transfer *types.Transfer)
+	DeleteTransfer(transferID string)
+
+	Hold(holdID string) (*types.Hold, error)
+	Holds() []*types.Hold
+	AccountHolds(accountID int64) []*types.Hold
+	PutHold(hold *types.Hold)
+	DeleteHold(holdID string)
+
+	Refund(refundID string) (*types.Refund, error)
+	Refunds() []*types.Refund
+	AccountRefunds(accountID int64) []*types.Refund
+	PaymentRefunds(paymentID string) []*types.Refund
+	PutRefund(refund *types.Refund)
+	DeleteRefund(refundID string)
+
+	Schedule(scheduleID string) (*types.Schedule, error)
+	Schedules() []*types.Schedule
+	PutSchedule(schedule *types.Schedule)
+	DeleteSchedule(scheduleID string)
+
+	ScheduleRun(runID string) (*types.ScheduleRun, error)
+	ScheduleRuns() []*types.ScheduleRun
+	// Выполнения расписания в порядке записи.
+	RunsOfSchedule(scheduleID string) []*types.ScheduleRun
+	PutScheduleRun(run *types.ScheduleRun)
+	DeleteScheduleRun(runID string)
+
+	// Проводки только добавляются, повторная запись с тем же ID игнорируется.
+	Entries() []*types.LedgerEntry
+	LedgerEntries(account types.LedgerAccount) []*types.LedgerEntry
+	PutEntry(entry *types.LedgerEntry)
+
+	IdempotencyKey(accountID int64, key string) (*types.IdempotencyKey, error)
+	IdempotencyKeys() []*types.IdempotencyKey
+	PutIdempotencyKey(record *types.IdempotencyKey)
+	DeleteIdempotencyKey(accountID int64, key string)
+}
+
+// changeSet - записи, изменённые транзакцией, в порядке первого изменения.
+type changeSet struct {
+	Accounts  []types.Account        `json:"accounts,omitempty"`
+	Payments  []types.Payment        `json:"payments,omitempty"`
+	Favorites []types.Favorite       `json:"favorites,omitempty"`
+	Entries   []types.LedgerEntry    `json:"entries,omitempty"`
+	Transfers []types.Transfer       `json:"transfers,omitempty"`
+	Keys      []types.IdempotencyKey `json:"keys,omitempty"`
+	Holds     []types.Hold           `json:"holds,omitempty"`
+	Refunds   []types.Refund         `json:"refunds,omitempty"`
+	Schedules []types.Schedule       `json:"schedules,omitempty"`
+	Runs      []types.ScheduleRun    `json:"schedule_runs,omitempty"`
+	// Удалённые ключи: заполнены только AccountID и Key.
+	DeletedKeys []types.IdempotencyKey `json:"deleted_keys,omitempty"`
+	// ID удалённых записей.
+	DeletedAccounts  []int64  `json:"deleted_accounts,omitempty"`
+	DeletedPayments  []string `json:"deleted_payments,omitempty"`
+	DeletedFavorites []string `json:"deleted_favorites,omitempty"`
+	DeletedTransfers []string `json:"deleted_transfers,omitempty"`
+	DeletedHolds     []string `json:"deleted_holds,omitempty"`
+	DeletedRefunds   []string `json:"deleted_refunds,omitempty"`
+	DeletedSchedules []string `json:"deleted_schedules,omitempty"`
+	DeletedRuns      []string `json:"deleted_schedule_runs,omitempty"`
+}
@@ -0,0 +1,223 @@
+package wallet
+
+import (
+	"errors"
+
+	"github.com/akmalsulaymonov/alif-wallet/pkg/types"
+	"github.com/google/uuid"
+)
+
+var ErrTransferNotFound = errors.New("transfer not found")
+var ErrSameAccount = errors.New("cannot transfer to the same account")
+
+// Категории, под которыми переводы попадают в историю аккаунта.
+const (
+	TransferOutCategory types.PaymentCategory = "transfer:out"
+	TransferInCategory  types.PaymentCategory = "transfer:in"
+)
+
+// Transfer переводит amount с аккаунта fromAccountID на аккаунт toAccountID.
+// Списание и зачисление выполняются одной транзакцией. Сумма указывается
+// в валюте отправителя. Если валюта получателя другая, сумма конвертируется
+// по курсу из ExchangeRateProvider с округлением зачисления вниз.
+func (s *Service) Transfer(fromAccountID int64, toAccountID int64, amount types.Money, comment string) (*types.Transfer, error) {
+	if amount <= 0 {
+		return nil, ErrAmountMustBePositive
+	}
+	if fromAccountID == toAccountID {
+		return nil, ErrSameAccount
+	}
+
+	unlock, err := s.lockAccounts(fromAccountID, toAccountID)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	var transfer *types.Transfer
+	err = s.repo().Update(func(tx Tx) error {
+		from, err := tx.Account(fromAccountID)
+		if err != nil {
+			return err
+		}
+		to, err := tx.Account(toAccountID)
+		if err != nil {
+			return err
+		}
+
+		ex, err := s.quoteSource(types.Amount{Value: amount, Currency: from.Currency}, to.Currency)
+		if err != nil {
+			return err
+		}
+		if err := debit(from, ex.source); err != nil {
+			return err
+		}
+		if err := credit(to, ex.target); err != nil {
+			return err
+		}
+		now := s.stamp()
+		from.UpdatedAt, to.UpdatedAt = now, now
+		tx.PutAccount(from)
+		tx.PutAccount(to)
+
+		targetAmount, targetCurrency, rate := ex.recorded()
+		transfer = &types.Transfer{
+			ID:             uuid.New().String(),
+			FromAccountID:  fromAccountID,
+			ToAccountID:    toAccountID,
+			Amount:         amount,
+			Currency:       ex.source.Currency,
+			Comment:        comment,
+			Status:         types.PaymentStatusOk,
+			TargetAmount:   targetAmount,
+			TargetCurrency: targetCurrency,
+			Rate:           rate,
+			CreatedAt:      now,
+			UpdatedAt:      now,
+		}
+		tx.PutTransfer(transfer)
+		postExchange(tx, CustomerLedgerAccount(fromAccountID), CustomerLedgerAccount(toAccountID), ex.source, ex.target, transfer.ID)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return transfer, nil
+}
+
+// TransferByPhone переводит amount аккаунту с номером телефона toPhone.
+func (s *Service) TransferByPhone(fromAccountID int64, toPhone types.Phone, amount types.Money, comment string) (*types.Transfer, error) {
+	var toAccountID int64
+	err := s.repo().View(func(tx Tx) error {
+		to, err := tx.AccountByPhone(toPhone)
+		if err != nil {
+			return err
+		}
+		toAccountID = to.ID
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.Transfer(fromAccountID, toAccountID, amount, comment)
+}
+
+// FindTransferByID возвращает копию перевода, см. FindAccountByID.
+func (s *Service) FindTransferByID(transferID string) (*types.Transfer, error) {
+	return findCopy(s, func(tx Tx) (*types.Transfer, error) { return tx.Transfer(transferID) })
+}
+
+// ReverseTransfer отменяет перевод: сумма списывается с получателя
+// и возвращается отправителю. Перевод можно отменить один раз и только
+// если у получателя достаточно денег. Суммы берутся из перевода,
+// поэтому курс на момент отмены не важен.
+func (s *Service) ReverseTransfer(transferID string) error {
+	var fromAccountID, toAccountID int64
+	err := s.repo().View(func(tx Tx) error {
+		transfer, err := tx.Transfer(transferID)
+		if err != nil {
+			return err
+		}
+		fromAccountID, toAccountID = transfer.FromAccountID, transfer.ToAccountID
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	unlock, err := s.lockAccounts(fromAccountID, toAccountID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return s.repo().Update(func(tx Tx) error {
+		transfer, err := tx.Transfer(transferID)
+		if err != nil {
+			return err
+		}
+		if !transfer.Status.CanTransitionTo(types.PaymentStatusRefunded) {
+			return &TransitionError{PaymentID: transfer.ID, From: transfer.Status, To: types.PaymentStatusRefunded}
+		}
+
+		from, err := tx.Account(transfer.FromAccountID)
+		if err != nil {
+			return err
+		}
+		to, err := tx.Account(transfer.ToAccountID)
+		if err != nil {
+			return err
+		}
+		source := types.Amount{Value: transfer.Amount, Currency: transfer.Currency}
+		target := transferTarget(transfer)
+		if err := debit(to, target); err != nil {
+			return err
+		}
+		if err := credit(from, source); err != nil {
+			return err
+		}
+		now := s.stamp()
+		from.UpdatedAt, to.UpdatedAt = now, now
+		tx.PutAccount(from)
+		tx.PutAccount(to)
+
+		transfer.Status = types.PaymentStatusRefunded
+		transfer.UpdatedAt = now
+		tx.PutTransfer(transfer)
+		postExchange(tx, CustomerLedgerAccount(to.ID), CustomerLedgerAccount(from.ID), target, source, transfer.ID)
+		return nil
+	})
+}
+
+// transferTarget возвращает сумму, зачисленную получателю перевода.
+func transferTarget(transfer *types.Transfer) types.Amount {
+	if transfer.TargetCurrency == "" {
+		return types.Amount{Value: transfer.Amount, Currency: transfer.Currency}
+	}
+	return types.Amount{Value: transfer.TargetAmount, Currency: transfer.TargetCurrency}
+}
+
+// transferHistory представляет переводы аккаунта записями истории:
+// исходящие с категорией TransferOutCategory, входящие - TransferInCategory.
+// ID записи совпадает с ID перевода. Исходящие записи несут данные
+// конвертации, входящие - только зачисленную сумму. Статус перевода
+// меняется только при отмене, поэтому StatusChangedAt - это UpdatedAt.
+func transferHistory(accountID int64, transfers []*types.Transfer) []types.Payment {
+	var history []types.Payment
+	for _, transfer := range transfers {
+		if transfer.ToAccountID == accountID {
+			target := transferTarget(transfer)
+			history = append(history, types.Payment{
+				ID:        transfer.ID,
+				AccountID: accountID,
+				Amount:    target.Value,
+				Category:  TransferInCategory,
+				Status:    transfer.Status,
+				Currency:  target.Currency,
+
+				CreatedAt:       transfer.CreatedAt,
+				UpdatedAt:       transfer.UpdatedAt,
+				StatusChangedAt: transfer.UpdatedAt,
+			})
+			continue
+		}
+		history = append(history, types.Payment{
+			ID:             transfer.ID,
+			AccountID:      accountID,
+			Amount:         transfer.Amount,
+			Category:       TransferOutCategory,
+			Status:         transfer.Status,
+			Currency:       transfer.Currency,
+			TargetAmount:   transfer.TargetAmount,
+			TargetCurrency: transfer.TargetCurrency,
+			Rate:           transfer.Rate,
+
+			CreatedAt:       transfer.CreatedAt,
+			UpdatedAt:       transfer.UpdatedAt,
+			StatusChangedAt: transfer.UpdatedAt,
+		})
+	}
+	return history
+}
@@ -0,0 +1,171 @@
+package wallet
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/akmalsulaymonov/alif-wallet/pkg/types"
+)
+
+func newTransferService(t *testing.T) (*Service, *types.Account, *types.Account) {
+	t.Helper()
+	s := &Service{}
+	from, err := s.RegisterAccount("+992000000001")
+	if err != nil {
+		t.Fatalf("failed to register account: %v", err)
+	}
+	to, err := s.RegisterAccount("+992000000002")
+	if err != nil {
+		t.Fatalf("failed to register account: %v", err)
+	}
+	s.Deposit(from.ID, 1000)
+	return s, from, to
+}
+
+func TestService_Transfer(t *testing.T) {
+	s, from, to := newTransferService(t)
+
+	transfer, err := s.TransferByPhone(from.ID, to.Phone, 300, "за обед")
+	if err != nil {
+		t.Fatalf("failed to transfer: %v", err)
+	}
+	if accountBalance(s, from.ID) != 700 || accountBalance(s, to.ID) != 300 {
+		t.Errorf("expected balances 700/300, got %v/%v", accountBalance(s, from.ID), accountBalance(s, to.ID))
+	}
+	if transfer.ToAccountID != to.ID || transfer.Comment != "за обед" {
+		t.Errorf("unexpected transfer %+v", transfer)
+	}
+
+	found, err := s.FindTransferByID(transfer.ID)
+	if err != nil || *found != *transfer {
+		t.Errorf("expected transfer %v, got %v (%v)", transfer, found, err)
+	}
+
+	// Перевод виден в истории обоих аккаунтов
+	fromHistory, _ := s.ExportAccountHistory(from.ID)
+	toHistory, _ := s.ExportAccountHistory(to.ID)
+	if len(fromHistory) != 1 || fromHistory[0].ID != transfer.ID || fromHistory[0].Category != TransferOutCategory {
+		t.Errorf("unexpected sender history %v", fromHistory)
+	}
+	if len(toHistory) != 1 || toHistory[0].ID != transfer.ID || toHistory[0].Category != TransferInCategory {
+		t.Errorf("unexpected recipient history %v", toHistory)
+	}
+
+	if report := s.CheckLedger(); !report.OK() {
+		t.Errorf("expected consistent ledger, got %+v", report)
+	}
+}
+
+func TestService_Transfer_Errors(t *testing.T) {
+	s, from, to := newTransferService(t)
+
+	if _, err := s.Transfer(from.ID, to.ID, 2000, ""); err != ErrNotEnoughBalance {
+		t.Errorf("expected error %v, got %v", ErrNotEnoughBalance, err)
+	}
+	if _, err := s.Transfer(from.ID, from.ID, 10, ""); err != ErrSameAccount {
+		t.Errorf("expected error %v, got %v", ErrSameAccount, err)
+	}
+	if _, err := s.Transfer(from.ID, to.ID, 0, ""); err != ErrAmountMustBePositive {
+		t.Errorf("expected error %v, got %v", ErrAmountMustBePositive, err)
+	}
+	if _, err := s.Transfer(from.ID, 999, 10, ""); err != ErrAccountNotFound {
+		t.Errorf("expected error %v, got %v", ErrAccountNotFound, err)
+	}
+	if _, err := s.TransferByPhone(from.ID, "+000", 10, ""); err != ErrAccountNotFound {
+		t.Errorf("expected error %v, got %v", ErrAccountNotFound, err)
+	}
+	if accountBalance(s, from.ID) != 1000 || accountBalance(s, to.ID) != 0 {
+		t.Errorf("expected balances 1000/0, got %v/%v", accountBalance(s, from.ID), accountBalance(s, to.ID))
+	}
+}
+
+func TestService_ReverseTransfer(t *testing.T) {
+	s, from, to := newTransferService(t)
+	transfer, _ := s.Transfer(from.ID, to.ID, 300, "")
+
+	if err := s.ReverseTransfer(transfer.ID); err != nil {
+		t.Fatalf("failed to reverse transfer: %v", err)
+	}
+	if accountBalance(s, from.ID) != 1000 || accountBalance(s, to.ID) != 0 {
+		t.Errorf("expected balances 1000/0, got %v/%v", accountBalance(s, from.ID), accountBalance(s, to.ID))
+	}
+	if transfer, _ = s.FindTransferByID(transfer.ID); transfer.Status != types.PaymentStatusRefunded {
+		t.Errorf("expected status %v, got %v", types.PaymentStatusRefunded, transfer.Status)
+	}
+
+	if err := s.ReverseTransfer(transfer.ID); !errors.Is(err, ErrIllegalTransition) {
+		t.Errorf("expected error %v, got %v", ErrIllegalTransition, err)
+	}
+	if err := s.ReverseTransfer("nonexistent-id"); err != ErrTransferNotFound {
+		t.Errorf("expected error %v, got %v", ErrTransferNotFound, err)
+	}
+	if report := s.CheckLedger(); !report.OK() {
+		t.Errorf("expected consistent ledger, got %+v", report)
+	}
+}
+
+func TestService_ReverseTransfer_RecipientSpent(t *testing.T) {
+	s, from, to := newTransferService(t)
+	transfer, _ := s.Transfer(from.ID, to.ID, 300, "")
+	s.Pay(to.ID, 200, "food")
+
+	if err := s.ReverseTransfer(transfer.ID); err != ErrNotEnoughBalance {
+		t.Errorf("expected error %v, got %v", ErrNotEnoughBalance, err)
+	}
+	if transfer, _ = s.FindTransferByID(transfer.ID); transfer.Status != types.PaymentStatusOk {
+		t.Errorf("expected status %v, got %v", types.PaymentStatusOk, transfer.Status)
+	}
+}
+
+func TestService_Transfer_Concurrent(t *testing.T) {
+	s := &Service{}
+	a, _ := s.RegisterAccount("+992000000001")
+	b, _ := s.RegisterAccount("+992000000002")
+	aID, bID := a.ID, b.ID
+	s.Deposit(aID, 1000)
+	s.Deposit(bID, 1000)
+
+	// Встречные переводы не должны взаимно блокироваться
+	wg := sync.WaitGroup{}
+	for i := 0; i < 100; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			s.Transfer(aID, bID, 7, "")
+		}()
+		go func() {
+			defer wg.Done()
+			s.Transfer(bID, aID, 5, "")
+		}()
+	}
+	wg.Wait()
+
+	if accountBalance(s, aID)+accountBalance(s, bID) != 2000 {
+		t.Errorf("expected total 2000, got %v", accountBalance(s, aID)+accountBalance(s, bID))
+	}
+	if report := s.CheckLedger(); !report.OK() {
+		t.Errorf("expected consistent ledger, got %+v", report)
+	}
+}
+
+func TestService_Transfer_ExportImport(t *testing.T) {
+	dir := t.TempDir()
+	s, from, to := newTransferService(t)
+	s.Transfer(from.ID, to.ID, 300, "rent; flat 2")
+
+	if err := s.Export(dir); err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	imported := &Service{}
+	if err := imported.Import(dir); err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+
+	want, _ := s.ExportAccountHistory(to.ID)
+	got, _ := imported.ExportAccountHistory(to.ID)
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("expected history %v, got %v", want, got)
+	}
+}
@@ -0,0 +1,4 @@
+// Пакет walletpb - сгенерированный код протокола gRPC из wallet.proto.
+package walletpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative wallet.proto
@@ -0,0 +1,626 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             v4.25.0
+// source: wallet.proto
+
+// Протокол gRPC API кошелька, см. пакет grpcapi.
+//
+// Суммы передаются целыми числами в минимальных единицах валюты (дирамы,
+// центы). Пустая валюта в запросе означает валюту аккаунта.
+
+package walletpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Wallet_RegisterAccount_FullMethodName = "/wallet.v1.Wallet/RegisterAccount"
+	Wallet_GetAccount_FullMethodName      = "/wallet.v1.Wallet/GetAccount"
+	Wallet_Deposit_FullMethodName         = "/wallet.v1.Wallet/Deposit"
+	Wallet_Pay_FullMethodName             = "/wallet.v1.Wallet/Pay"
+	Wallet_GetPayment_FullMethodName      = "/wallet.v1.Wallet/GetPayment"
+	Wallet_RejectPayment_FullMethodName   = "/wallet.v1.Wallet/RejectPayment"
+	Wallet_ConfirmPayment_FullMethodName  = "/wallet.v1.Wallet/ConfirmPayment"
+	Wallet_CancelPayment_FullMethodName   = "/wallet.v1.Wallet/CancelPayment"
+	Wallet_RefundPayment_FullMethodName   = "/wallet.v1.Wallet/RefundPayment"
+	Wallet_RepeatPayment_FullMethodName   = "/wallet.v1.Wallet/RepeatPayment"
+	Wallet_FavoritePayment_FullMethodName = "/wallet.v1.Wallet/FavoritePayment"
+	Wallet_PayFromFavorite_FullMethodName = "/wallet.v1.Wallet/PayFromFavorite"
+	Wallet_ListFavorites_FullMethodName   = "/wallet.v1.Wallet/ListFavorites"
+	Wallet_AccountHistory_FullMethodName  = "/wallet.v1.Wallet/AccountHistory"
+)
+
+// WalletClient is the client API for Wallet service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type WalletClient interface {
+	RegisterAccount(ctx context.Context, in *RegisterAccountRequest, opts ...grpc.CallOption) (*Account, error)
+	GetAccount(ctx context.Context, in *GetAccountRequest, opts ...grpc.CallOption) (*Account, error)
+	Deposit(ctx context.Context, in *DepositRequest, opts ...grpc.CallOption) (*Account, error)
+	Pay(ctx context.Context, in *PayRequest, opts ...grpc.CallOption) (*Payment, error)
+	GetPayment(ctx context.Context, in *PaymentRequest, opts ...grpc.CallOption) (*Payment, error)
+	RejectPayment(ctx context.Context, in *PaymentRequest, opts ...grpc.CallOption) (*Payment, error)
+	ConfirmPayment(ctx context.Context, in *PaymentRequest, opts ...grpc.CallOption) (*Payment, error)
+	CancelPayment(ctx context.Context, in *PaymentRequest, opts ...grpc.CallOption) (*Payment, error)
+	RefundPayment(ctx context.Context, in *PaymentRequest, opts ...grpc.CallOption) (*Payment, error)
+	RepeatPayment(ctx context.Context, in *PaymentRequest, opts ...grpc.CallOption) (*Payment, error)
+	FavoritePayment(ctx context.Context, in *FavoritePaymentRequest, opts ...grpc.CallOption) (*Favorite, error)
+	PayFromFavorite(ctx context.Context, in *PayFromFavoriteRequest, opts ...grpc.CallOption) (*Payment, error)
+	ListFavorites(ctx context.Context, in *ListFavoritesRequest, opts ...grpc.CallOption) (*ListFavoritesResponse, error)
+	// История платежей аккаунта, по одному платежу в сообщении.
+	AccountHistory(ctx context.Context, in *AccountHistoryRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Payment], error)
+}
+
+type walletClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewWalletClient(cc grpc.ClientConnInterface) WalletClient {
+	return &walletClient{cc}
+}
+
+func (c *walletClient) RegisterAccount(ctx context.Context, in *RegisterAccountRequest, opts ...grpc.CallOption) (*Account, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Account)
+	err := c.cc.Invoke(ctx, Wallet_RegisterAccount_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *walletClient) GetAccount(ctx context.Context, in *GetAccountRequest, opts ...grpc.CallOption) (*Account, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Account)
+	err := c.cc.Invoke(ctx, Wallet_GetAccount_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *walletClient) Deposit(ctx context.Context, in *DepositRequest, opts ...grpc.CallOption) (*Account, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Account)
+	err := c.cc.Invoke(ctx, Wallet_Deposit_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *walletClient) Pay(ctx context.Context, in *PayRequest, opts ...grpc.CallOption) (*Payment, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Payment)
+	err := c.cc.Invoke(ctx, Wallet_Pay_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *walletClient) GetPayment(ctx context.Context, in *PaymentRequest, opts ...grpc.CallOption) (*Payment, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Payment)
+	err := c.cc.Invoke(ctx, Wallet_GetPayment_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *walletClient) RejectPayment(ctx context.Context, in *PaymentRequest, opts ...grpc.CallOption) (*Payment, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Payment)
+	err := c.cc.Invoke(ctx, Wallet_RejectPayment_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *walletClient) ConfirmPayment(ctx context.Context, in *PaymentRequest, opts ...grpc.CallOption) (*Payment, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Payment)
+	err := c.cc.Invoke(ctx, Wallet_ConfirmPayment_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *walletClient) CancelPayment(ctx context.Context, in *PaymentRequest, opts ...grpc.CallOption) (*Payment, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Payment)
+	err := c.cc.Invoke(ctx, Wallet_CancelPayment_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *walletClient) RefundPayment(ctx context.Context, in *PaymentRequest, opts ...grpc.CallOption) (*Payment, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Payment)
+	err := c.cc.Invoke(ctx, Wallet_RefundPayment_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *walletClient) RepeatPayment(ctx context.Context, in *PaymentRequest, opts ...grpc.CallOption) (*Payment, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Payment)
+	err := c.cc.Invoke(ctx, Wallet_RepeatPayment_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *walletClient) FavoritePayment(ctx context.Context, in *FavoritePaymentRequest, opts ...grpc.CallOption) (*Favorite, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Favorite)
+	err := c.cc.Invoke(ctx, Wallet_FavoritePayment_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *walletClient) PayFromFavorite(ctx context.Context, in *PayFromFavoriteRequest, opts ...grpc.CallOption) (*Payment, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Payment)
+	err := c.cc.Invoke(ctx, Wallet_PayFromFavorite_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *walletClient) ListFavorites(ctx context.Context, in *ListFavoritesRequest, opts ...grpc.CallOption) (*ListFavoritesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListFavoritesResponse)
+	err := c.cc.Invoke(ctx, Wallet_ListFavorites_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *walletClient) AccountHistory(ctx context.Context, in *AccountHistoryRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Payment], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Wallet_ServiceDesc.Streams[0], Wallet_AccountHistory_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[AccountHistoryRequest, Payment]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Wallet_AccountHistoryClient = grpc.ServerStreamingClient[Payment]
+
+// WalletServer is the server API for Wallet service.
+// All implementations must embed UnimplementedWalletServer
+// for forward compatibility.
+type WalletServer interface {
+	RegisterAccount(context.Context, *RegisterAccountRequest) (*Account, error)
+	GetAccount(context.Context, *GetAccountRequest) (*Account, error)
+	Deposit(context.Context, *DepositRequest) (*Account, error)
+	Pay(context.Context, *PayRequest) (*Payment, error)
+	GetPayment(context.Context, *PaymentRequest) (*Payment, error)
+	RejectPayment(context.Context, *PaymentRequest) (*Payment, error)
+	ConfirmPayment(context.Context, *PaymentRequest) (*Payment, error)
+	CancelPayment(context.Context, *PaymentRequest) (*Payment, error)
+	RefundPayment(context.Context, *PaymentRequest) (*Payment, error)
+	RepeatPayment(context.Context, *PaymentRequest) (*Payment, error)
+	FavoritePayment(context.Context, *FavoritePaymentRequest) (*Favorite, error)
+	PayFromFavorite(context.Context, *PayFromFavoriteRequest) (*Payment, error)
+	ListFavorites(context.Context, *ListFavoritesRequest) (*ListFavoritesResponse, error)
+	// История платежей аккаунта, по одному платежу в сообщении.
+	AccountHistory(*AccountHistoryRequest, grpc.ServerStreamingServer[Payment]) error
+	mustEmbedUnimplementedWalletServer()
+}
+
+// UnimplementedWalletServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedWalletServer struct{}
+
+func (UnimplementedWalletServer) RegisterAccount(context.Context, *RegisterAccountRequest) (*Account, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RegisterAccount not implemented")
+}
+func (UnimplementedWalletServer) GetAccount(context.Context, *GetAccountRequest) (*Account, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetAccount not implemented")
+}
+func (UnimplementedWalletServer) Deposit(context.Context, *DepositRequest) (*Account, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Deposit not implemented")
+}
+func (UnimplementedWalletServer) Pay(context.Context, *PayRequest) (*Payment, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Pay not implemented")
+}
+func (UnimplementedWalletServer) GetPayment(context.Context, *PaymentRequest) (*Payment, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetPayment not implemented")
+}
+func (UnimplementedWalletServer) RejectPayment(context.Context, *PaymentRequest) (*Payment, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RejectPayment not implemented")
+}
+func (UnimplementedWalletServer) ConfirmPayment(context.Context, *PaymentRequest) (*Payment, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ConfirmPayment not implemented")
+}
+func (UnimplementedWalletServer) CancelPayment(context.Context, *PaymentRequest) (*Payment, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CancelPayment not implemented")
+}
+func (UnimplementedWalletServer) RefundPayment(context.Context, *PaymentRequest) (*Payment, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RefundPayment not implemented")
+}
+func (UnimplementedWalletServer) RepeatPayment(context.Context, *PaymentRequest) (*Payment, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RepeatPayment not implemented")
+}
+func (UnimplementedWalletServer) FavoritePayment(context.Context, *FavoritePaymentRequest) (*Favorite, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method FavoritePayment not implemented")
+}
+func (UnimplementedWalletServer) PayFromFavorite(context.Context, *PayFromFavoriteRequest) (*Payment, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PayFromFavorite not implemented")
+}
+func (UnimplementedWalletServer) ListFavorites(context.Context, *ListFavoritesRequest) (*ListFavoritesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListFavorites not implemented")
+}
+func (UnimplementedWalletServer) AccountHistory(*AccountHistoryRequest, grpc.ServerStreamingServer[Payment]) error {
+	return status.Errorf(codes.Unimplemented, "method AccountHistory not implemented")
+}
+func (UnimplementedWalletServer) mustEmbedUnimplementedWalletServer() {}
+func (UnimplementedWalletServer) testEmbeddedByValue()                {}
+
+// UnsafeWalletServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to WalletServer will
+// result in compilation errors.
+type UnsafeWalletServer interface {
+	mustEmbedUnimplementedWalletServer()
+}
+
+func RegisterWalletServer(s grpc.ServiceRegistrar, srv WalletServer) {
+	// If the following call pancis, it indicates UnimplementedWalletServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Wallet_ServiceDesc, srv)
+}
+
+func _Wallet_RegisterAccount_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RegisterAccountRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WalletServer).RegisterAccount(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Wallet_RegisterAccount_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WalletServer).RegisterAccount(ctx, req.(*RegisterAccountRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Wallet_GetAccount_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetAccountRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WalletServer).GetAccount(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Wallet_GetAccount_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WalletServer).GetAccount(ctx, req.(*GetAccountRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Wallet_Deposit_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DepositRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WalletServer).Deposit(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Wallet_Deposit_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WalletServer).Deposit(ctx, req.(*DepositRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Wallet_Pay_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PayRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WalletServer).Pay(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Wallet_Pay_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WalletServer).Pay(ctx, req.(*PayRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Wallet_GetPayment_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PaymentRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WalletServer).GetPayment(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Wallet_GetPayment_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WalletServer).GetPayment(ctx, req.(*PaymentRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Wallet_RejectPayment_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PaymentRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WalletServer).RejectPayment(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Wallet_RejectPayment_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WalletServer).RejectPayment(ctx, req.(*PaymentRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Wallet_ConfirmPayment_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PaymentRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WalletServer).ConfirmPayment(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Wallet_ConfirmPayment_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WalletServer).ConfirmPayment(ctx, req.(*PaymentRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Wallet_CancelPayment_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PaymentRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WalletServer).CancelPayment(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Wallet_CancelPayment_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WalletServer).CancelPayment(ctx, req.(*PaymentRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Wallet_RefundPayment_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PaymentRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WalletServer).RefundPayment(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Wallet_RefundPayment_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WalletServer).RefundPayment(ctx, req.(*PaymentRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Wallet_RepeatPayment_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PaymentRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WalletServer).RepeatPayment(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Wallet_RepeatPayment_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WalletServer).RepeatPayment(ctx, req.(*PaymentRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Wallet_FavoritePayment_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(FavoritePaymentRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WalletServer).FavoritePayment(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Wallet_FavoritePayment_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WalletServer).FavoritePayment(ctx, req.(*FavoritePaymentRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Wallet_PayFromFavorite_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PayFromFavoriteRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WalletServer).PayFromFavorite(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Wallet_PayFromFavorite_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WalletServer).PayFromFavorite(ctx, req.(*PayFromFavoriteRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Wallet_ListFavorites_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListFavoritesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WalletServer).ListFavorites(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Wallet_ListFavorites_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WalletServer).ListFavorites(ctx, req.(*ListFavoritesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Wallet_AccountHistory_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(AccountHistoryRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(WalletServer).AccountHistory(m, &grpc.GenericServerStream[AccountHistoryRequest, Payment]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Wallet_AccountHistoryServer = grpc.ServerStreamingServer[Payment]
+
+// Wallet_ServiceDesc is the grpc.ServiceDesc for Wallet service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Wallet_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "wallet.v1.Wallet",
+	HandlerType: (*WalletServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "RegisterAccount",
+			Handler:    _Wallet_RegisterAccount_Handler,
+		},
+		{
+			MethodName: "GetAccount",
+			Handler:    _Wallet_GetAccount_Handler,
+		},
+		{
+			MethodName: "Deposit",
+			Handler:    _Wallet_Deposit_Handler,
+		},
+		{
+			MethodName: "Pay",
+			Handler:    _Wallet_Pay_Handler,
+		},
+		{
+			MethodName: "GetPayment",
+			Handler:    _Wallet_GetPayment_Handler,
+		},
+		{
+			MethodName: "RejectPayment",
+			Handler:    _Wallet_RejectPayment_Handler,
+		},
+		{
+			MethodName: "ConfirmPayment",
+			Handler:    _Wallet_ConfirmPayment_Handler,
+		},
+		{
+			MethodName: "CancelPayment",
+			Handler:    _Wallet_CancelPayment_Handler,
+		},
+		{
+			MethodName: "RefundPayment",
+			Handler:    _Wallet_RefundPayment_Handler,
+		},
+		{
+			MethodName: "RepeatPayment",
+			Handler:    _Wallet_RepeatPayment_Handler,
+		},
+		{
+			MethodName: "FavoritePayment",
+			Handler:    _Wallet_FavoritePayment_Handler,
+		},
+		{
+			MethodName: "PayFromFavorite",
+			Handler:    _Wallet_PayFromFavorite_Handler,
+		},
+		{
+			MethodName: "ListFavorites",
+			Handler:    _Wallet_ListFavorites_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "AccountHistory",
+			Handler:       _Wallet_AccountHistory_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "wallet.proto",
+}